@@ -24,11 +24,30 @@ const AppContextProvider = (props) => {
         return age
     }
 
+    // Number of decimals of a currency's minor unit eg. ( INR => 2, JPY => 0 )
+    const currencyDigits = (code) => new Intl.NumberFormat('en', { style: 'currency', currency: code }).resolvedOptions().maximumFractionDigits
+
+    // Function to convert an amount to major units eg. ( { amount: 1999, currency: 'INR' } => 19.99 )
+    const moneyToDecimal = (money) => {
+        if (!money || !money.currency) return ''
+        const digits = currencyDigits(money.currency)
+        return (money.amount / 10 ** digits).toFixed(digits)
+    }
+
+    // Function to format an amount, or a list of amounts in different currencies, for display
+    const formatMoney = (money) => {
+        if (Array.isArray(money)) return money.length ? money.map(formatMoney).join(' + ') : formatMoney(null)
+        if (!money || !money.currency) return `${currency ?? ''} 0`.trim()
+        return new Intl.NumberFormat(undefined, { style: 'currency', currency: money.currency }).format(moneyToDecimal(money))
+    }
+
     const value = {
         backendUrl,
         currency,
         slotDateFormat,
         calculateAge,
+        moneyToDecimal,
+        formatMoney,
     }
 
     return (
@@ -7,7 +7,7 @@ import { AppContext } from '../../context/AppContext'
 const AllAppointments = () => {
 
   const { aToken, appointments, cancelAppointment, getAllAppointments } = useContext(AdminContext)
-  const { slotDateFormat, calculateAge, currency } = useContext(AppContext)
+  const { slotDateFormat, calculateAge, formatMoney } = useContext(AppContext)
 
   useEffect(() => {
     if (aToken) {
@@ -41,7 +41,7 @@ const AllAppointments = () => {
             <div className='flex items-center gap-2'>
               <img src={item.docData.image} className='w-8 rounded-full bg-gray-200' alt="" /> <p>{item.docData.name}</p>
             </div>
-            <p>{currency}{item.amount}</p>
+            <p>{formatMoney(item.amount)}</p>
             {item.cancelled ? <p className='text-red-400 text-xs font-medium'>Cancelled</p> : item.isCompleted ? <p className='text-green-500 text-xs font-medium'>Completed</p> : <img onClick={() => cancelAppointment(item._id)} className='w-10 cursor-pointer' src={assets.cancel_icon} alt="" />}
           </div>
         ))}
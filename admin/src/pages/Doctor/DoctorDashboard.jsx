@@ -8,7 +8,7 @@ import { AppContext } from '../../context/AppContext'
 const DoctorDashboard = () => {
 
   const { dToken, dashData, getDashData, cancelAppointment, completeAppointment } = useContext(DoctorContext)
-  const { slotDateFormat, currency } = useContext(AppContext)
+  const { slotDateFormat, formatMoney } = useContext(AppContext)
 
 
   useEffect(() => {
@@ -26,7 +26,7 @@ const DoctorDashboard = () => {
         <div className='flex items-center gap-2 bg-white p-4 min-w-52 rounded border-2 border-gray-100 cursor-pointer hover:scale-105 transition-all'>
           <img className='w-14' src={assets.earning_icon} alt="" />
           <div>
-            <p className='text-xl font-semibold text-gray-600'>{currency} {dashData.earnings}</p>
+            <p className='text-xl font-semibold text-gray-600'>{formatMoney(dashData.earnings)}</p>
             <p className='text-gray-400'>Earnings</p>
           </div>
         </div>
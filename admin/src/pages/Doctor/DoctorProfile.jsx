@@ -7,8 +7,10 @@ import axios from 'axios'
 const DoctorProfile = () => {
 
     const { dToken, profileData, setProfileData, getProfileData } = useContext(DoctorContext)
-    const { currency, backendUrl } = useContext(AppContext)
+    const { backendUrl, moneyToDecimal, formatMoney } = useContext(AppContext)
     const [isEdit, setIsEdit] = useState(false)
+    // Fee being edited, in major units of the doctor's currency
+    const [fees, setFees] = useState('')
 
     const updateProfile = async () => {
 
@@ -16,7 +18,7 @@ const DoctorProfile = () => {
 
             const updateData = {
                 address: profileData.address,
-                fees: profileData.fees,
+                fees: fees,
                 about: profileData.about,
                 available: profileData.available
             }
@@ -76,7 +78,7 @@ const DoctorProfile = () => {
                     </div>
 
                     <p className='text-gray-600 font-medium mt-4'>
-                        Appointment fee: <span className='text-gray-800'>{currency} {isEdit ? <input type='number' onChange={(e) => setProfileData(prev => ({ ...prev, fees: e.target.value }))} value={profileData.fees} /> : profileData.fees}</span>
+                        Appointment fee: <span className='text-gray-800'>{isEdit ? <>{profileData.fees?.currency} <input type='number' step='any' onChange={(e) => setFees(e.target.value)} value={fees} /></> : formatMoney(profileData.fees)}</span>
                     </p>
 
                     <div className='flex gap-2 py-2'>
@@ -96,7 +98,7 @@ const DoctorProfile = () => {
                     {
                         isEdit
                             ? <button onClick={updateProfile} className='px-4 py-1 border border-primary text-sm rounded-full mt-5 hover:bg-primary hover:text-white transition-all'>Save</button>
-                            : <button onClick={() => setIsEdit(prev => !prev)} className='px-4 py-1 border border-primary text-sm rounded-full mt-5 hover:bg-primary hover:text-white transition-all'>Edit</button>
+                            : <button onClick={() => { setFees(moneyToDecimal(profileData.fees)); setIsEdit(true) }} className='px-4 py-1 border border-primary text-sm rounded-full mt-5 hover:bg-primary hover:text-white transition-all'>Edit</button>
                     }
 
                 </div>
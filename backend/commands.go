@@ -0,0 +1,97 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"os"
+
+	"prescripto-go/config"
+	"prescripto-go/utils"
+)
+
+// runCommand runs a maintenance command given on the command line instead
+// of starting the server, e.g. `./main create-admin -name Ops -email ops@example.com`
+func runCommand(args []string) {
+	switch args[0] {
+	case "create-admin":
+		createAdmin(args[1:])
+	case "rotate-keys":
+		rotateKeys()
+	case "normalize-emails":
+		normalizeEmails()
+	case "mock-oidc":
+		mockOIDC(args[1:])
+	default:
+		log.Fatalf("Unknown command %q", args[0])
+	}
+}
+
+// createAdmin bootstraps the first admin account. The password is read from
+// the -password flag or the ADMIN_PASSWORD environment variable.
+func createAdmin(args []string) {
+	flags := flag.NewFlagSet("create-admin", flag.ExitOnError)
+	name := flags.String("name", "Admin", "admin display name")
+	email := flags.String("email", os.Getenv("ADMIN_EMAIL"), "admin email")
+	password := flags.String("password", os.Getenv("ADMIN_PASSWORD"), "admin password")
+	flags.Parse(args)
+
+	if !utils.IsValidEmail(*email) {
+		log.Fatal("A valid -email is required")
+	}
+
+	config.ConnectMongoDB()
+	config.EnsureIndexes()
+	utils.EnsureDefaultRoles()
+
+	if err := utils.ValidatePassword(*password, *name, *email); err != nil {
+		log.Fatal(err)
+	}
+
+	count, err := utils.CountAdmins()
+	if err != nil {
+		log.Fatal(err)
+	}
+	if count > 0 {
+		log.Fatal("Admins already exist; invite new admins from the admin panel")
+	}
+
+	admin, err := utils.CreateAdmin(*name, *email, *password)
+	if err != nil {
+		log.Fatal(err)
+	}
+	fmt.Printf("Created admin %s <%s>\n", admin.Name, admin.Email)
+}
+
+// rotateKeys makes a new token signing key active. Tokens signed with the
+// previous key stay valid until SIGNING_KEY_GRACE_HOURS after the rotation,
+// and running servers pick up the new key within a minute.
+func rotateKeys() {
+	config.ConnectMongoDB()
+	config.EnsureIndexes()
+
+	key, err := utils.RotateSigningKey()
+	if err != nil {
+		log.Fatal(err)
+	}
+	fmt.Printf("Signing with new key %s\n", key.Kid)
+}
+
+// normalizeEmails lower-cases the emails of existing users and doctors. Run it
+// before starting a server that creates the unique email indexes; accounts
+// sharing an email are listed and have to be merged first.
+func normalizeEmails() {
+	config.ConnectMongoDB()
+
+	updated, conflicts, err := utils.NormalizeStoredEmails()
+	if err != nil {
+		log.Fatal(err)
+	}
+	fmt.Printf("Normalized %d emails\n", updated)
+	for _, conflict := range conflicts {
+		fmt.Printf("Email already taken, not changed: %s\n", conflict)
+	}
+	if len(conflicts) > 0 {
+		os.Exit(1)
+	}
+}
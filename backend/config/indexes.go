@@ -0,0 +1,162 @@
+package config
+
+import (
+	"context"
+	"log"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// EnsureIndexes creates the indexes the application relies on
+func EnsureIndexes() {
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	indexes := map[string][]mongo.IndexModel{
+		"payments": {
+			{
+				// Only entries that carry a key take part in idempotency checks
+				Keys: bson.D{{Key: "idempotencyKey", Value: 1}},
+				Options: options.Index().SetUnique(true).
+					SetPartialFilterExpression(bson.M{"idempotencyKey": bson.M{"$exists": true}}),
+			},
+			{Keys: bson.D{{Key: "appointmentId", Value: 1}, {Key: "createdAt", Value: -1}}},
+			{Keys: bson.D{{Key: "gateway", Value: 1}, {Key: "gatewayOrderId", Value: 1}}},
+		},
+		"invoices": {
+			{Keys: bson.D{{Key: "appointmentId", Value: 1}}, Options: options.Index().SetUnique(true)},
+			{
+				Keys: bson.D{{Key: "number", Value: 1}},
+				Options: options.Index().SetUnique(true).
+					SetPartialFilterExpression(bson.M{"sequence": bson.M{"$gt": 0}}),
+			},
+			{
+				// Invoice numbers are taken by setting the sequence under this index
+				Keys: bson.D{{Key: "sequence", Value: 1}},
+				Options: options.Index().SetUnique(true).
+					SetPartialFilterExpression(bson.M{"sequence": bson.M{"$gt": 0}}),
+			},
+			{Keys: bson.D{{Key: "userId", Value: 1}, {Key: "issuedAt", Value: -1}}},
+		},
+		"settlements": {
+			{Keys: bson.D{{Key: "docId", Value: 1}, {Key: "createdAt", Value: -1}}},
+		},
+		"coupons": {
+			{Keys: bson.D{{Key: "code", Value: 1}}, Options: options.Index().SetUnique(true)},
+		},
+		"coupon_redemptions": {
+			{Keys: bson.D{{Key: "couponId", Value: 1}, {Key: "userId", Value: 1}}},
+			{
+				// A user holds each redemption slot of a capped coupon at most once
+				Keys: bson.D{{Key: "couponId", Value: 1}, {Key: "userId", Value: 1}, {Key: "slot", Value: 1}},
+				Options: options.Index().SetUnique(true).
+					SetPartialFilterExpression(bson.M{"slot": bson.M{"$gt": 0}}),
+			},
+		},
+		"wallets": {
+			{Keys: bson.D{{Key: "userId", Value: 1}, {Key: "balance.currency", Value: 1}}, Options: options.Index().SetUnique(true)},
+		},
+		"wallet_transactions": {
+			{
+				Keys: bson.D{{Key: "idempotencyKey", Value: 1}},
+				Options: options.Index().SetUnique(true).
+					SetPartialFilterExpression(bson.M{"idempotencyKey": bson.M{"$exists": true}}),
+			},
+			{Keys: bson.D{{Key: "userId", Value: 1}, {Key: "createdAt", Value: -1}}},
+		},
+		"tax_rules": {
+			{Keys: bson.D{{Key: "active", Value: 1}, {Key: "jurisdiction", Value: 1}, {Key: "serviceType", Value: 1}}},
+		},
+		"doctors": {
+			{Keys: bson.D{{Key: "email", Value: 1}}, Options: options.Index().SetUnique(true)},
+		},
+		"admins": {
+			{Keys: bson.D{{Key: "email", Value: 1}}, Options: options.Index().SetUnique(true)},
+			{
+				Keys: bson.D{{Key: "inviteTokenHash", Value: 1}},
+				Options: options.Index().SetUnique(true).
+					SetPartialFilterExpression(bson.M{"inviteTokenHash": bson.M{"$exists": true}}),
+			},
+		},
+		"refresh_tokens": {
+			{Keys: bson.D{{Key: "tokenHash", Value: 1}}, Options: options.Index().SetUnique(true)},
+			{Keys: bson.D{{Key: "sessionId", Value: 1}}},
+			{Keys: bson.D{{Key: "role", Value: 1}, {Key: "principalId", Value: 1}}},
+		},
+		"password_resets": {
+			{Keys: bson.D{{Key: "tokenHash", Value: 1}}, Options: options.Index().SetUnique(true)},
+			{Keys: bson.D{{Key: "role", Value: 1}, {Key: "principalId", Value: 1}}},
+		},
+		"prescriptions": {
+			{Keys: bson.D{{Key: "appointmentId", Value: 1}, {Key: "version", Value: 1}}, Options: options.Index().SetUnique(true)},
+			{Keys: bson.D{{Key: "userId", Value: 1}, {Key: "status", Value: 1}, {Key: "signedAt", Value: -1}}},
+			{Keys: bson.D{{Key: "verificationCode", Value: 1}}, Options: options.Index().SetUnique(true).SetSparse(true)},
+		},
+		"login_codes": {
+			{Keys: bson.D{{Key: "codeHash", Value: 1}}},
+			{Keys: bson.D{{Key: "channel", Value: 1}, {Key: "destination", Value: 1}, {Key: "createdAt", Value: -1}}},
+			{Keys: bson.D{{Key: "ip", Value: 1}, {Key: "createdAt", Value: -1}}},
+		},
+		"users": {
+			{Keys: bson.D{{Key: "email", Value: 1}}, Options: options.Index().SetUnique(true)},
+			{
+				// A phone number logs in to the one account that verified it
+				Keys: bson.D{{Key: "verifiedPhone", Value: 1}},
+				Options: options.Index().SetUnique(true).
+					SetPartialFilterExpression(bson.M{"verifiedPhone": bson.M{"$exists": true}}),
+			},
+		},
+		"two_factor": {
+			{Keys: bson.D{{Key: "role", Value: 1}, {Key: "principalId", Value: 1}}, Options: options.Index().SetUnique(true)},
+		},
+		"oidc_states": {
+			{Keys: bson.D{{Key: "stateHash", Value: 1}}, Options: options.Index().SetUnique(true)},
+		},
+		"oidc_identities": {
+			{Keys: bson.D{{Key: "provider", Value: 1}, {Key: "subject", Value: 1}, {Key: "role", Value: 1}}, Options: options.Index().SetUnique(true)},
+		},
+		"signing_keys": {
+			{Keys: bson.D{{Key: "kid", Value: 1}}, Options: options.Index().SetUnique(true)},
+			{
+				// Only one key signs at a time
+				Keys: bson.D{{Key: "status", Value: 1}},
+				Options: options.Index().SetUnique(true).
+					SetPartialFilterExpression(bson.M{"status": "active"}),
+			},
+		},
+		"api_keys": {
+			{Keys: bson.D{{Key: "keyHash", Value: 1}}, Options: options.Index().SetUnique(true)},
+		},
+		"api_key_usage": {
+			{Keys: bson.D{{Key: "expiresAt", Value: 1}}, Options: options.Index().SetExpireAfterSeconds(0)},
+		},
+		"appointments": {
+			{
+				Keys: bson.D{{Key: "bookedBy", Value: 1}, {Key: "date", Value: -1}},
+				Options: options.Index().
+					SetPartialFilterExpression(bson.M{"bookedBy": bson.M{"$exists": true}}),
+			},
+		},
+		"sessions": {
+			{Keys: bson.D{{Key: "principalId", Value: 1}, {Key: "role", Value: 1}, {Key: "lastSeenAt", Value: -1}}},
+			{Keys: bson.D{{Key: "idleExpiresAt", Value: 1}}, Options: options.Index().SetExpireAfterSeconds(0)},
+		},
+		"roles": {
+			{Keys: bson.D{{Key: "name", Value: 1}}, Options: options.Index().SetUnique(true)},
+		},
+		"reconciliation_reports": {
+			{Keys: bson.D{{Key: "startedAt", Value: -1}}},
+		},
+	}
+
+	for name, models := range indexes {
+		if _, err := GetCollection(name).Indexes().CreateMany(ctx, models); err != nil {
+			log.Fatalf("Failed to create indexes for %s: %v", name, err)
+		}
+	}
+
+	log.Println("Database Indexes Ensured")
+}
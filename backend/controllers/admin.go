@@ -4,8 +4,6 @@ import (
 	"context"
 	"encoding/json"  // ADD THIS
 	"net/http"
-	"os"
-	"strconv"       // ADD THIS
 
 	"github.com/gin-gonic/gin"
 	"go.mongodb.org/mongo-driver/bson"
@@ -27,29 +25,22 @@ func LoginAdmin(c *gin.Context) {
 		return
 	}
 
-	adminEmail := os.Getenv("ADMIN_EMAIL")
-	adminPassword := os.Getenv("ADMIN_PASSWORD")
-
-	if req.Email == adminEmail && req.Password == adminPassword {
-		token, err := utils.GenerateAdminJWT(adminEmail + adminPassword)
-		if err != nil {
-			c.JSON(http.StatusInternalServerError, models.APIResponse{
-				Success: false,
-				Message: "Error generating token",
-			})
-			return
-		}
+	email := utils.NormalizeEmail(req.Email)
+	if loginLocked(c, utils.RoleAdmin, email) {
+		return
+	}
 
-		c.JSON(http.StatusOK, models.APIResponse{
-			Success: true,
-			Token:   token,
-		})
-	} else {
-		c.JSON(http.StatusBadRequest, models.APIResponse{
-			Success: false,
-			Message: "Invalid credentials",
-		})
+	var admin models.Admin
+	err := config.GetCollection("admins").FindOne(context.Background(), bson.M{
+		"email":  email,
+		"status": models.AdminStatusActive,
+	}).Decode(&admin)
+	if !utils.CheckPasswordHash(req.Password, admin.Password) || err != nil {
+		loginFailed(c, utils.RoleAdmin, email)
+		return
 	}
+
+	completeLogin(c, admin.ID.Hex(), utils.RoleAdmin)
 }
 
 // GetAllAppointments gets all appointments for admin
@@ -94,7 +85,17 @@ func CancelAppointmentAdmin(c *gin.Context) {
 	appointmentObjectID, _ := primitive.ObjectIDFromHex(req.AppointmentID)
 
 	appointmentCollection := config.GetCollection("appointments")
-	_, err := appointmentCollection.UpdateOne(
+	var appointment models.Appointment
+	err := appointmentCollection.FindOne(context.Background(), bson.M{"_id": appointmentObjectID}).Decode(&appointment)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, models.APIResponse{
+			Success: false,
+			Message: "Appointment not found",
+		})
+		return
+	}
+
+	_, err = appointmentCollection.UpdateOne(
 		context.Background(),
 		bson.M{"_id": appointmentObjectID},
 		bson.M{"$set": bson.M{"cancelled": true}},
@@ -107,6 +108,19 @@ func CancelAppointmentAdmin(c *gin.Context) {
 		return
 	}
 
+	utils.ReleaseAppointmentCoupon(&appointment)
+	utils.ReleaseAppointmentWallet(&appointment)
+
+	if req.RefundToWallet {
+		if err := utils.RefundCancellationToWallet(&appointment, "admin:"+c.GetString("adminId")); err != nil {
+			c.JSON(http.StatusBadRequest, models.APIResponse{
+				Success: false,
+				Message: "Appointment Cancelled, but the refund failed: " + err.Error(),
+			})
+			return
+		}
+	}
+
 	c.JSON(http.StatusOK, models.APIResponse{
 		Success: true,
 		Message: "Appointment Cancelled",
@@ -119,19 +133,15 @@ func AddDoctor(c *gin.Context) {
 	
 	// Parse form data
 	req.Name = c.PostForm("name")
-	req.Email = c.PostForm("email")
+	req.Email = utils.NormalizeEmail(c.PostForm("email"))
 	req.Password = c.PostForm("password")
 	req.Speciality = c.PostForm("speciality")
 	req.Degree = c.PostForm("degree")
 	req.Experience = c.PostForm("experience")
 	req.About = c.PostForm("about")
-	
-	// Parse fees
-	if feesStr := c.PostForm("fees"); feesStr != "" {
-		if fees, err := strconv.ParseFloat(feesStr, 64); err == nil {
-			req.Fees = fees
-		}
-	}
+	req.Fees = json.Number(c.PostForm("fees"))
+	req.Currency = c.DefaultPostForm("currency", models.DefaultCurrency())
+	req.RegistrationNumber = c.PostForm("registrationNumber")
 	
 	// Parse address JSON
 	if addressStr := c.PostForm("address"); addressStr != "" {
@@ -146,7 +156,7 @@ func AddDoctor(c *gin.Context) {
 
 	// Validate required fields
 	if req.Name == "" || req.Email == "" || req.Password == "" || req.Speciality == "" || 
-	   req.Degree == "" || req.Experience == "" || req.About == "" || req.Fees == 0 {
+	   req.Degree == "" || req.Experience == "" || req.About == "" || req.Fees == "" {
 		c.JSON(http.StatusBadRequest, models.APIResponse{
 			Success: false,
 			Message: "Missing Details",
@@ -154,6 +164,16 @@ func AddDoctor(c *gin.Context) {
 		return
 	}
 
+	// Parse fees in the doctor's currency
+	fees, err := models.ParseMoney(req.Fees.String(), req.Currency)
+	if err != nil || fees.Amount <= 0 {
+		c.JSON(http.StatusBadRequest, models.APIResponse{
+			Success: false,
+			Message: "Invalid fees",
+		})
+		return
+	}
+
 	// Validate email format
 	if !utils.IsValidEmail(req.Email) {
 		c.JSON(http.StatusBadRequest, models.APIResponse{
@@ -163,11 +183,15 @@ func AddDoctor(c *gin.Context) {
 		return
 	}
 
-	// Validate password length
-	if len(req.Password) < 8 {
-		c.JSON(http.StatusBadRequest, models.APIResponse{
+	// Validate password against the policy
+	if err := utils.ValidatePassword(req.Password, req.Name, req.Email); err != nil {
+		status := http.StatusInternalServerError
+		if _, ok := err.(*utils.PasswordPolicyError); ok {
+			status = http.StatusBadRequest
+		}
+		c.JSON(status, models.APIResponse{
 			Success: false,
-			Message: "Please enter a strong password",
+			Message: err.Error(),
 		})
 		return
 	}
@@ -212,8 +236,9 @@ func AddDoctor(c *gin.Context) {
 	doctor.Degree = req.Degree
 	doctor.Experience = req.Experience
 	doctor.About = req.About
-	doctor.Fees = req.Fees
+	doctor.Fees = fees
 	doctor.Address = req.Address
+	doctor.RegistrationNumber = req.RegistrationNumber
 
 	collection := config.GetCollection("doctors")
 	_, err = collection.InsertOne(context.Background(), doctor)
@@ -316,6 +341,18 @@ func GetAdminDashboard(c *gin.Context) {
 		return
 	}
 
+	// Calculate earnings per currency
+	earnings := models.MoneyTotals{}
+	tax := models.MoneyTotals{}
+	for _, appointment := range appointments {
+		if appointment.IsCompleted || appointment.Payment {
+			earnings.Add(appointment.Amount)
+			for _, line := range appointment.Tax {
+				tax.Add(line.Amount)
+			}
+		}
+	}
+
 	// Reverse appointments for latest first
 	for i, j := 0, len(appointments)-1; i < j; i, j = i+1, j-1 {
 		appointments[i], appointments[j] = appointments[j], appointments[i]
@@ -323,6 +360,8 @@ func GetAdminDashboard(c *gin.Context) {
 
 	dashData := models.DashboardData{
 		Doctors:            int(doctorCount),
+		Earnings:           earnings.List(),
+		Tax:                tax.List(),
 		Appointments:       len(appointments),
 		Patients:           int(userCount),
 		LatestAppointments: appointments,
@@ -0,0 +1,261 @@
+package controllers
+
+import (
+	"context"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"prescripto-go/config"
+	"prescripto-go/models"
+	"prescripto-go/utils"
+)
+
+// GetAdmins lists all admin accounts
+func GetAdmins(c *gin.Context) {
+	opts := options.Find().SetSort(bson.D{{Key: "createdAt", Value: 1}})
+	cursor, err := config.GetCollection("admins").Find(context.Background(), bson.M{}, opts)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, models.APIResponse{
+			Success: false,
+			Message: err.Error(),
+		})
+		return
+	}
+	defer cursor.Close(context.Background())
+
+	var admins []models.Admin
+	if err = cursor.All(context.Background(), &admins); err != nil {
+		c.JSON(http.StatusInternalServerError, models.APIResponse{
+			Success: false,
+			Message: err.Error(),
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, models.APIResponse{
+		Success: true,
+		Data:    admins,
+	})
+}
+
+// InviteAdmin creates an invited admin account. The invitation token is
+// returned once so it can be passed on to the new admin.
+func InviteAdmin(c *gin.Context) {
+	var req models.InviteAdminRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, models.APIResponse{
+			Success: false,
+			Message: "Missing Details",
+		})
+		return
+	}
+
+	if req.Roles == nil {
+		req.Roles = []string{}
+	}
+	if ok, err := utils.RolesExist(req.Roles); err != nil || !ok {
+		c.JSON(http.StatusBadRequest, models.APIResponse{
+			Success: false,
+			Message: "Unknown role",
+		})
+		return
+	}
+
+	if !canAssignRoles(c, req.Roles) {
+		c.JSON(http.StatusForbidden, models.APIResponse{
+			Success: false,
+			Message: "You cannot grant permissions you do not have",
+		})
+		return
+	}
+
+	admin, token, err := utils.InviteAdmin(req.Name, req.Email, req.Roles, c.GetString("adminId"))
+	if err != nil {
+		status := http.StatusInternalServerError
+		if err == utils.ErrAdminExists {
+			status = http.StatusBadRequest
+		}
+		c.JSON(status, models.APIResponse{
+			Success: false,
+			Message: err.Error(),
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, models.APIResponse{
+		Success: true,
+		Message: "Admin Invited",
+		Data: gin.H{
+			"admin":       admin,
+			"inviteToken": token,
+		},
+	})
+}
+
+// AcceptAdminInvite sets the password of an invited admin and activates the account
+func AcceptAdminInvite(c *gin.Context) {
+	var req models.AcceptAdminInviteRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, models.APIResponse{
+			Success: false,
+			Message: "Missing Details",
+		})
+		return
+	}
+
+	// The token can only be used once and before it expires
+	filter := bson.M{
+		"inviteTokenHash": utils.HashToken(req.Token),
+		"status":          models.AdminStatusInvited,
+		"inviteExpiresAt": bson.M{"$gt": time.Now().Unix()},
+	}
+
+	var admin models.Admin
+	if err := config.GetCollection("admins").FindOne(context.Background(), filter).Decode(&admin); err != nil {
+		c.JSON(http.StatusBadRequest, models.APIResponse{
+			Success: false,
+			Message: "Invalid or expired invitation",
+		})
+		return
+	}
+
+	if err := utils.ValidatePassword(req.Password, admin.Name, admin.Email); err != nil {
+		status := http.StatusInternalServerError
+		if _, ok := err.(*utils.PasswordPolicyError); ok {
+			status = http.StatusBadRequest
+		}
+		c.JSON(status, models.APIResponse{
+			Success: false,
+			Message: err.Error(),
+		})
+		return
+	}
+
+	hash, err := utils.HashPassword(req.Password)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, models.APIResponse{
+			Success: false,
+			Message: err.Error(),
+		})
+		return
+	}
+
+	result, err := config.GetCollection("admins").UpdateOne(
+		context.Background(),
+		filter,
+		bson.M{
+			"$set":   bson.M{"password": hash, "status": models.AdminStatusActive},
+			"$unset": bson.M{"inviteTokenHash": "", "inviteExpiresAt": ""},
+		},
+	)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, models.APIResponse{
+			Success: false,
+			Message: err.Error(),
+		})
+		return
+	}
+	if result.MatchedCount == 0 {
+		c.JSON(http.StatusBadRequest, models.APIResponse{
+			Success: false,
+			Message: "Invalid or expired invitation",
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, models.APIResponse{
+		Success: true,
+		Message: "Invitation Accepted",
+	})
+}
+
+// ChangeAdminStatus disables or re-enables another admin
+func ChangeAdminStatus(c *gin.Context) {
+	var req models.ChangeAdminStatusRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, models.APIResponse{
+			Success: false,
+			Message: "Missing Details",
+		})
+		return
+	}
+
+	if req.AdminID == c.GetString("adminId") {
+		c.JSON(http.StatusBadRequest, models.APIResponse{
+			Success: false,
+			Message: "You cannot change your own status",
+		})
+		return
+	}
+
+	adminObjectID, _ := primitive.ObjectIDFromHex(req.AdminID)
+	var admin models.Admin
+	if err := config.GetCollection("admins").FindOne(context.Background(), bson.M{"_id": adminObjectID}).Decode(&admin); err != nil {
+		c.JSON(http.StatusBadRequest, models.APIResponse{
+			Success: false,
+			Message: "Admin not found",
+		})
+		return
+	}
+
+	// Only admins holding every permission of the target may disable or enable it
+	if !canAssignRoles(c, admin.Roles) {
+		c.JSON(http.StatusForbidden, models.APIResponse{
+			Success: false,
+			Message: "You cannot manage an admin with permissions you do not have",
+		})
+		return
+	}
+
+	// Disabling also revokes a pending invitation; enabling an admin who never
+	// accepted their invitation restores the invitation
+	filter := bson.M{"_id": adminObjectID, "status": bson.M{"$in": []string{models.AdminStatusActive, models.AdminStatusInvited}}}
+	status := models.AdminStatusDisabled
+	if req.Active {
+		filter["status"] = models.AdminStatusDisabled
+		status = models.AdminStatusActive
+		if admin.InviteTokenHash != "" {
+			status = models.AdminStatusInvited
+		}
+	}
+
+	result, err := config.GetCollection("admins").UpdateOne(
+		context.Background(),
+		filter,
+		bson.M{"$set": bson.M{"status": status}},
+	)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, models.APIResponse{
+			Success: false,
+			Message: err.Error(),
+		})
+		return
+	}
+	if result.MatchedCount == 0 {
+		c.JSON(http.StatusBadRequest, models.APIResponse{
+			Success: false,
+			Message: "Admin not found or already " + status,
+		})
+		return
+	}
+
+	// A disabled admin is logged out of every device
+	if !req.Active {
+		if err := utils.RevokeAllSessions(req.AdminID, utils.RoleAdmin, "admin:"+c.GetString("adminId")); err != nil {
+			c.JSON(http.StatusInternalServerError, models.APIResponse{
+				Success: false,
+				Message: err.Error(),
+			})
+			return
+		}
+	}
+
+	c.JSON(http.StatusOK, models.APIResponse{
+		Success: true,
+		Message: "Admin Status Updated",
+	})
+}
@@ -0,0 +1,103 @@
+package controllers
+
+import (
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"prescripto-go/models"
+	"prescripto-go/utils"
+)
+
+// CreateAPIKey issues a partner API key. The key is returned once and only
+// its hash is kept.
+func CreateAPIKey(c *gin.Context) {
+	var req models.CreateAPIKeyRequest
+	if err := c.ShouldBindJSON(&req); err != nil || len(req.Scopes) == 0 {
+		c.JSON(http.StatusBadRequest, models.APIResponse{
+			Success: false,
+			Message: "Missing Details",
+		})
+		return
+	}
+
+	for _, scope := range req.Scopes {
+		if !utils.ValidScope(scope) {
+			c.JSON(http.StatusBadRequest, models.APIResponse{
+				Success: false,
+				Message: "Unknown scope " + scope,
+			})
+			return
+		}
+	}
+
+	key, plain, err := utils.CreateAPIKey(req.Name, req.Scopes, req.RateLimit, "admin:"+c.GetString("adminId"))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, models.APIResponse{
+			Success: false,
+			Message: err.Error(),
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, models.APIResponse{
+		Success: true,
+		Message: "API Key Created",
+		Data: gin.H{
+			"apiKey": key,
+			"key":    plain,
+		},
+	})
+}
+
+// GetAPIKeys lists the partner API keys
+func GetAPIKeys(c *gin.Context) {
+	keys, err := utils.ListAPIKeys()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, models.APIResponse{
+			Success: false,
+			Message: err.Error(),
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, models.APIResponse{
+		Success: true,
+		Data: gin.H{
+			"apiKeys": keys,
+			"scopes":  models.AllScopes,
+		},
+	})
+}
+
+// RevokeAPIKey stops a partner API key from working
+func RevokeAPIKey(c *gin.Context) {
+	var req models.RevokeAPIKeyRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, models.APIResponse{
+			Success: false,
+			Message: "Missing Details",
+		})
+		return
+	}
+
+	revoked, err := utils.RevokeAPIKey(req.KeyID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, models.APIResponse{
+			Success: false,
+			Message: err.Error(),
+		})
+		return
+	}
+	if !revoked {
+		c.JSON(http.StatusBadRequest, models.APIResponse{
+			Success: false,
+			Message: "API key not found or already revoked",
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, models.APIResponse{
+		Success: true,
+		Message: "API Key Revoked",
+	})
+}
@@ -0,0 +1,87 @@
+package controllers
+
+import (
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"prescripto-go/models"
+	"prescripto-go/utils"
+)
+
+// RefreshUserToken exchanges a user's refresh token for new tokens
+func RefreshUserToken(c *gin.Context) {
+	refreshTokens(c, utils.RoleUser)
+}
+
+// RefreshDoctorToken exchanges a doctor's refresh token for new tokens
+func RefreshDoctorToken(c *gin.Context) {
+	refreshTokens(c, utils.RoleDoctor)
+}
+
+// RefreshAdminToken exchanges an admin's refresh token for new tokens
+func RefreshAdminToken(c *gin.Context) {
+	refreshTokens(c, utils.RoleAdmin)
+}
+
+// refreshTokens rotates the refresh token of a role and returns the new pair
+func refreshTokens(c *gin.Context, role string) {
+	var req models.RefreshTokenRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, models.APIResponse{
+			Success: false,
+			Message: "Missing Details",
+		})
+		return
+	}
+
+	token, refresh, err := utils.RotateRefreshToken(req.RefreshToken, role)
+	if err != nil {
+		status := http.StatusInternalServerError
+		if err == utils.ErrInvalidToken || err == utils.ErrRefreshTokenReused {
+			status = http.StatusUnauthorized
+		}
+		c.JSON(status, models.APIResponse{
+			Success: false,
+			Message: err.Error(),
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, models.APIResponse{
+		Success:      true,
+		Token:        token,
+		RefreshToken: refresh,
+	})
+}
+
+// Logout revokes the session of the token used for the request
+func Logout(c *gin.Context) {
+	if err := utils.RevokeSession(c.GetString("sessionId"), ""); err != nil {
+		c.JSON(http.StatusInternalServerError, models.APIResponse{
+			Success: false,
+			Message: err.Error(),
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, models.APIResponse{
+		Success: true,
+		Message: "Logged Out",
+	})
+}
+
+// LogoutAll revokes every session of the logged in account
+func LogoutAll(c *gin.Context) {
+	if err := utils.RevokeAllSessions(c.GetString("principalId"), c.GetString("tokenRole"), ""); err != nil {
+		c.JSON(http.StatusInternalServerError, models.APIResponse{
+			Success: false,
+			Message: err.Error(),
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, models.APIResponse{
+		Success: true,
+		Message: "Logged Out Everywhere",
+	})
+}
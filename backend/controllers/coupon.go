@@ -0,0 +1,309 @@
+package controllers
+
+import (
+	"context"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"prescripto-go/config"
+	"prescripto-go/models"
+	"prescripto-go/utils"
+)
+
+// AddCoupon creates a discount code
+func AddCoupon(c *gin.Context) {
+	var req models.AddCouponRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, models.APIResponse{
+			Success: false,
+			Message: "Missing Details",
+		})
+		return
+	}
+
+	coupon := models.Coupon{
+		Code:           utils.NormalizeCouponCode(req.Code),
+		Description:    req.Description,
+		Type:           req.Type,
+		ValidFrom:      req.ValidFrom,
+		ValidUntil:     req.ValidUntil,
+		MaxUses:        req.MaxUses,
+		MaxUsesPerUser: req.MaxUsesPerUser,
+		DoctorIDs:      req.DoctorIDs,
+		Specialities:   req.Specialities,
+		Active:         true,
+		CreatedAt:      time.Now().Unix(),
+	}
+
+	switch req.Type {
+	case models.CouponTypePercentage:
+		if req.Percent <= 0 || req.Percent > 100 {
+			c.JSON(http.StatusBadRequest, models.APIResponse{
+				Success: false,
+				Message: "Percentage must be between 0 and 100",
+			})
+			return
+		}
+		coupon.Percent = req.Percent
+	case models.CouponTypeFixed:
+		currency := req.Currency
+		if currency == "" {
+			currency = models.DefaultCurrency()
+		}
+		amount, err := models.ParseMoney(req.Amount.String(), currency)
+		if err != nil || amount.Amount <= 0 {
+			c.JSON(http.StatusBadRequest, models.APIResponse{
+				Success: false,
+				Message: "Invalid discount amount",
+			})
+			return
+		}
+		coupon.Amount = amount
+	}
+
+	if coupon.ValidUntil != 0 && coupon.ValidUntil < coupon.ValidFrom {
+		c.JSON(http.StatusBadRequest, models.APIResponse{
+			Success: false,
+			Message: "Invalid validity window",
+		})
+		return
+	}
+
+	collection := config.GetCollection("coupons")
+	result, err := collection.InsertOne(context.Background(), coupon)
+	if err != nil {
+		if mongo.IsDuplicateKeyError(err) {
+			c.JSON(http.StatusBadRequest, models.APIResponse{
+				Success: false,
+				Message: "Coupon code already exists",
+			})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, models.APIResponse{
+			Success: false,
+			Message: err.Error(),
+		})
+		return
+	}
+	coupon.ID = result.InsertedID.(primitive.ObjectID)
+
+	c.JSON(http.StatusOK, models.APIResponse{
+		Success: true,
+		Message: "Coupon Added",
+		Data:    coupon,
+	})
+}
+
+// GetCoupons lists all coupons for admin
+func GetCoupons(c *gin.Context) {
+	collection := config.GetCollection("coupons")
+	opts := options.Find().SetSort(bson.D{{Key: "createdAt", Value: -1}})
+	cursor, err := collection.Find(context.Background(), bson.M{}, opts)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, models.APIResponse{
+			Success: false,
+			Message: err.Error(),
+		})
+		return
+	}
+	defer cursor.Close(context.Background())
+
+	var coupons []models.Coupon
+	if err = cursor.All(context.Background(), &coupons); err != nil {
+		c.JSON(http.StatusInternalServerError, models.APIResponse{
+			Success: false,
+			Message: err.Error(),
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, models.APIResponse{
+		Success: true,
+		Data:    coupons,
+	})
+}
+
+// ChangeCouponStatus enables or disables a coupon
+func ChangeCouponStatus(c *gin.Context) {
+	var req models.ChangeCouponStatusRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, models.APIResponse{
+			Success: false,
+			Message: "Missing Details",
+		})
+		return
+	}
+
+	couponObjectID, _ := primitive.ObjectIDFromHex(req.CouponID)
+
+	collection := config.GetCollection("coupons")
+	result, err := collection.UpdateOne(
+		context.Background(),
+		bson.M{"_id": couponObjectID},
+		bson.M{"$set": bson.M{"active": req.Active}},
+	)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, models.APIResponse{
+			Success: false,
+			Message: err.Error(),
+		})
+		return
+	}
+	if result.MatchedCount == 0 {
+		c.JSON(http.StatusBadRequest, models.APIResponse{
+			Success: false,
+			Message: "Coupon not found",
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, models.APIResponse{
+		Success: true,
+		Message: "Coupon Updated",
+	})
+}
+
+// ApplyCoupon applies a discount code to one of the user's unpaid appointments
+func ApplyCoupon(c *gin.Context) {
+	userID := c.GetString("userId")
+	var req models.ApplyCouponRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, models.APIResponse{
+			Success: false,
+			Message: "Missing Details",
+		})
+		return
+	}
+
+	appointmentObjectID, _ := primitive.ObjectIDFromHex(req.AppointmentID)
+
+	// Get appointment data
+	appointmentCollection := config.GetCollection("appointments")
+	var appointment models.Appointment
+	err := appointmentCollection.FindOne(context.Background(), bson.M{"_id": appointmentObjectID}).Decode(&appointment)
+	if err != nil || appointment.Cancelled {
+		c.JSON(http.StatusBadRequest, models.APIResponse{
+			Success: false,
+			Message: "Appointment Cancelled or not found",
+		})
+		return
+	}
+
+	if appointment.UserID != userID {
+		c.JSON(http.StatusUnauthorized, models.APIResponse{
+			Success: false,
+			Message: "Unauthorized action",
+		})
+		return
+	}
+
+	if appointment.Payment || appointment.CouponCode != "" || !appointment.WalletPaid.IsZero() {
+		c.JSON(http.StatusBadRequest, models.APIResponse{
+			Success: false,
+			Message: "A coupon cannot be applied to this appointment",
+		})
+		return
+	}
+
+	var coupon models.Coupon
+	err = config.GetCollection("coupons").FindOne(context.Background(), bson.M{"code": utils.NormalizeCouponCode(req.Code)}).Decode(&coupon)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, models.APIResponse{
+			Success: false,
+			Message: utils.ErrCouponInvalid.Error(),
+		})
+		return
+	}
+
+	discount, err := utils.CouponDiscount(&coupon, &appointment)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, models.APIResponse{
+			Success: false,
+			Message: err.Error(),
+		})
+		return
+	}
+	discounted, err := appointment.Amount.Sub(discount)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, models.APIResponse{
+			Success: false,
+			Message: err.Error(),
+		})
+		return
+	}
+
+	if _, err := utils.RedeemCoupon(&coupon, userID, req.AppointmentID, discount); err != nil {
+		status := http.StatusInternalServerError
+		if err == utils.ErrCouponExhausted {
+			status = http.StatusBadRequest
+		}
+		c.JSON(status, models.APIResponse{
+			Success: false,
+			Message: err.Error(),
+		})
+		return
+	}
+
+	// Tax is charged on the discounted fee at the rates fixed when booking
+	tax := models.ComputeTaxLines(discounted, appointment.Tax)
+
+	// Guard against a concurrent payment or coupon on the same appointment
+	result, err := appointmentCollection.UpdateOne(
+		context.Background(),
+		bson.M{"_id": appointmentObjectID, "payment": false, "couponCode": bson.M{"$exists": false}, "walletPaid": bson.M{"$exists": false}},
+		bson.M{"$set": bson.M{"couponCode": coupon.Code, "discount": discount, "tax": tax}},
+	)
+	if err != nil || result.MatchedCount == 0 {
+		utils.ReleaseCoupon(coupon.ID, req.AppointmentID)
+		c.JSON(http.StatusBadRequest, models.APIResponse{
+			Success: false,
+			Message: "A coupon cannot be applied to this appointment",
+		})
+		return
+	}
+
+	appointment.CouponCode = coupon.Code
+	appointment.Discount = discount
+	appointment.Tax = tax
+	due, err := appointment.AmountDue()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, models.APIResponse{
+			Success: false,
+			Message: err.Error(),
+		})
+		return
+	}
+
+	// A fully discounted appointment needs no gateway payment
+	if due.Amount == 0 {
+		utils.RecordPayment(&models.PaymentTransaction{
+			AppointmentID: req.AppointmentID,
+			UserID:        userID,
+			Gateway:       models.GatewayCoupon,
+			Type:          models.PaymentTypeVerification,
+			Status:        models.PaymentStatusPaid,
+			Amount:        due,
+		})
+		if err := utils.MarkAppointmentPaid(req.AppointmentID); err != nil {
+			c.JSON(http.StatusInternalServerError, models.APIResponse{
+				Success: false,
+				Message: err.Error(),
+			})
+			return
+		}
+	}
+
+	c.JSON(http.StatusOK, models.APIResponse{
+		Success: true,
+		Message: "Coupon Applied",
+		Data: gin.H{
+			"discount":  discount,
+			"amountDue": due,
+		},
+	})
+}
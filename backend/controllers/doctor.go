@@ -23,40 +23,21 @@ func LoginDoctor(c *gin.Context) {
 		return
 	}
 
-	collection := config.GetCollection("doctors")
-	var doctor models.Doctor
-	err := collection.FindOne(context.Background(), bson.M{"email": req.Email}).Decode(&doctor)
-	if err != nil {
-		c.JSON(http.StatusBadRequest, models.APIResponse{
-			Success: false,
-			Message: "Invalid credentials",
-		})
-		return
-	}
-
-	// Check password
-	if !utils.CheckPasswordHash(req.Password, doctor.Password) {
-		c.JSON(http.StatusBadRequest, models.APIResponse{
-			Success: false,
-			Message: "Invalid credentials",
-		})
+	email := utils.NormalizeEmail(req.Email)
+	if loginLocked(c, utils.RoleDoctor, email) {
 		return
 	}
 
-	// Generate JWT token
-	token, err := utils.GenerateJWT(doctor.ID.Hex())
-	if err != nil {
-		c.JSON(http.StatusInternalServerError, models.APIResponse{
-			Success: false,
-			Message: "Error generating token",
-		})
+	// An unknown email still goes through a hash comparison
+	collection := config.GetCollection("doctors")
+	var doctor models.Doctor
+	err := collection.FindOne(context.Background(), bson.M{"email": email}).Decode(&doctor)
+	if !utils.CheckPasswordHash(req.Password, doctor.Password) || err != nil {
+		loginFailed(c, utils.RoleDoctor, email)
 		return
 	}
 
-	c.JSON(http.StatusOK, models.APIResponse{
-		Success: true,
-		Token:   token,
-	})
+	completeLogin(c, doctor.ID.Hex(), utils.RoleDoctor)
 }
 
 // GetDoctorAppointments gets appointments for a doctor
@@ -138,6 +119,19 @@ func CancelDoctorAppointment(c *gin.Context) {
 		return
 	}
 
+	utils.ReleaseAppointmentCoupon(&appointment)
+	utils.ReleaseAppointmentWallet(&appointment)
+
+	if req.RefundToWallet {
+		if err := utils.RefundCancellationToWallet(&appointment, "doctor:"+docID); err != nil {
+			c.JSON(http.StatusBadRequest, models.APIResponse{
+				Success: false,
+				Message: "Appointment Cancelled, but the refund failed: " + err.Error(),
+			})
+			return
+		}
+	}
+
 	c.JSON(http.StatusOK, models.APIResponse{
 		Success: true,
 		Message: "Appointment Cancelled",
@@ -323,12 +317,36 @@ func UpdateDoctorProfile(c *gin.Context) {
 	}
 
 	updateData := bson.M{
-		"fees":      req.Fees,
 		"address":   req.Address,
 		"available": req.Available,
 	}
 
 	collection := config.GetCollection("doctors")
+
+	// Fees are given in major units of the doctor's currency
+	if req.Fees != "" {
+		currency := req.Currency
+		if currency == "" {
+			var doctor models.Doctor
+			if err := collection.FindOne(context.Background(), bson.M{"_id": docObjectID}).Decode(&doctor); err == nil {
+				currency = doctor.Fees.Currency
+			}
+		}
+		if currency == "" {
+			currency = models.DefaultCurrency()
+		}
+
+		fees, err := models.ParseMoney(req.Fees.String(), currency)
+		if err != nil || fees.Amount <= 0 {
+			c.JSON(http.StatusBadRequest, models.APIResponse{
+				Success: false,
+				Message: "Invalid fees",
+			})
+			return
+		}
+		updateData["fees"] = fees
+	}
+
 	_, err := collection.UpdateOne(
 		context.Background(),
 		bson.M{"_id": docObjectID},
@@ -373,13 +391,13 @@ func GetDoctorDashboard(c *gin.Context) {
 		return
 	}
 
-	// Calculate earnings
-	var earnings float64
+	// Calculate earnings per currency
+	earnings := models.MoneyTotals{}
 	uniquePatients := make(map[string]bool)
 
 	for _, appointment := range appointments {
 		if appointment.IsCompleted || appointment.Payment {
-			earnings += appointment.Amount
+			earnings.Add(appointment.Amount)
 		}
 		uniquePatients[appointment.UserID] = true
 	}
@@ -390,7 +408,7 @@ func GetDoctorDashboard(c *gin.Context) {
 	}
 
 	dashData := models.DashboardData{
-		Earnings:           earnings,
+		Earnings:           earnings.List(),
 		Appointments:       len(appointments),
 		Patients:           len(uniquePatients),
 		LatestAppointments: appointments,
@@ -0,0 +1,109 @@
+package controllers
+
+import (
+	"context"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"prescripto-go/config"
+	"prescripto-go/models"
+	"prescripto-go/utils"
+)
+
+// GetUserInvoices lists the invoices issued to the logged in user
+func GetUserInvoices(c *gin.Context) {
+	userID := c.GetString("userId")
+
+	collection := config.GetCollection("invoices")
+	opts := options.Find().SetSort(bson.D{{Key: "issuedAt", Value: -1}})
+	cursor, err := collection.Find(context.Background(), bson.M{"userId": userID}, opts)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, models.APIResponse{
+			Success: false,
+			Message: err.Error(),
+		})
+		return
+	}
+	defer cursor.Close(context.Background())
+
+	var invoices []models.Invoice
+	if err = cursor.All(context.Background(), &invoices); err != nil {
+		c.JSON(http.StatusInternalServerError, models.APIResponse{
+			Success: false,
+			Message: err.Error(),
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, models.APIResponse{
+		Success: true,
+		Data:    invoices,
+	})
+}
+
+// DownloadUserInvoice sends the invoice PDF of one of the user's appointments
+func DownloadUserInvoice(c *gin.Context) {
+	invoice, ok := loadInvoice(c)
+	if !ok {
+		return
+	}
+
+	if invoice.UserID != c.GetString("userId") {
+		c.JSON(http.StatusUnauthorized, models.APIResponse{
+			Success: false,
+			Message: "Unauthorized action",
+		})
+		return
+	}
+
+	sendInvoicePDF(c, invoice)
+}
+
+// DownloadDoctorInvoice sends the invoice PDF of one of the doctor's appointments
+func DownloadDoctorInvoice(c *gin.Context) {
+	invoice, ok := loadInvoice(c)
+	if !ok {
+		return
+	}
+
+	if invoice.DocID != c.GetString("docId") {
+		c.JSON(http.StatusUnauthorized, models.APIResponse{
+			Success: false,
+			Message: "Unauthorized action",
+		})
+		return
+	}
+
+	sendInvoicePDF(c, invoice)
+}
+
+// DownloadAdminInvoice sends the invoice PDF of any appointment
+func DownloadAdminInvoice(c *gin.Context) {
+	invoice, ok := loadInvoice(c)
+	if !ok {
+		return
+	}
+
+	sendInvoicePDF(c, invoice)
+}
+
+// loadInvoice fetches the invoice for the appointment in the URL, issuing it
+// if the appointment is paid but has no invoice yet
+func loadInvoice(c *gin.Context) (*models.Invoice, bool) {
+	invoice, err := utils.GenerateInvoice(c.Param("appointmentId"))
+	if err != nil {
+		c.JSON(http.StatusNotFound, models.APIResponse{
+			Success: false,
+			Message: "Invoice not available",
+		})
+		return nil, false
+	}
+	return invoice, true
+}
+
+func sendInvoicePDF(c *gin.Context, invoice *models.Invoice) {
+	c.Header("Content-Disposition", `attachment; filename="`+invoice.Number+`.pdf"`)
+	c.Data(http.StatusOK, "application/pdf", utils.RenderInvoicePDF(invoice))
+}
@@ -0,0 +1,91 @@
+package controllers
+
+import (
+	"log"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"prescripto-go/models"
+	"prescripto-go/utils"
+)
+
+// loginLocked responds and returns true while the account or client address
+// is locked out. The answer is the same whether or not the account exists.
+func loginLocked(c *gin.Context, role, email string) bool {
+	err := utils.CheckLoginAllowed(role, email, c.ClientIP())
+	if err == nil {
+		return false
+	}
+
+	status := http.StatusInternalServerError
+	if err == utils.ErrLoginLocked {
+		status = http.StatusTooManyRequests
+	}
+	c.JSON(status, models.APIResponse{
+		Success: false,
+		Message: err.Error(),
+	})
+	return true
+}
+
+// loginFailed counts a failed attempt and gives the same answer for unknown
+// accounts and wrong passwords
+func loginFailed(c *gin.Context, role, email string) {
+	if err := utils.RecordLoginFailure(role, email, c.ClientIP()); err != nil {
+		log.Printf("Failed to record login failure: %v", err)
+	}
+
+	c.JSON(http.StatusBadRequest, models.APIResponse{
+		Success: false,
+		Message: "Invalid credentials",
+	})
+}
+
+// loginSucceeded clears the failed attempts of an account
+func loginSucceeded(role, email string) {
+	if err := utils.RecordLoginSuccess(role, email); err != nil {
+		log.Printf("Failed to clear login failures: %v", err)
+	}
+}
+
+// GetLockouts lists accounts and addresses with recent failed logins or an active lock
+func GetLockouts(c *gin.Context) {
+	lockouts, err := utils.ListLockouts()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, models.APIResponse{
+			Success: false,
+			Message: err.Error(),
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, models.APIResponse{
+		Success: true,
+		Data:    lockouts,
+	})
+}
+
+// ClearLockout lifts the lock and forgets the failures of an account or address
+func ClearLockout(c *gin.Context) {
+	var req models.ClearLockoutRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, models.APIResponse{
+			Success: false,
+			Message: "Missing Details",
+		})
+		return
+	}
+
+	if err := utils.ClearLockout(req.Key); err != nil {
+		c.JSON(http.StatusInternalServerError, models.APIResponse{
+			Success: false,
+			Message: err.Error(),
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, models.APIResponse{
+		Success: true,
+		Message: "Lockout Cleared",
+	})
+}
@@ -0,0 +1,145 @@
+package controllers
+
+import (
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"prescripto-go/models"
+	"prescripto-go/utils"
+)
+
+// BeginUserOIDC starts a patient login with an identity provider
+func BeginUserOIDC(c *gin.Context) {
+	beginOIDC(c, utils.RoleUser)
+}
+
+// BeginDoctorOIDC starts a doctor login with the organisation's identity provider
+func BeginDoctorOIDC(c *gin.Context) {
+	beginOIDC(c, utils.RoleDoctor)
+}
+
+// BeginAdminOIDC starts an admin login with the organisation's identity provider
+func BeginAdminOIDC(c *gin.Context) {
+	beginOIDC(c, utils.RoleAdmin)
+}
+
+// FinishUserOIDC completes a patient login from the provider callback
+func FinishUserOIDC(c *gin.Context) {
+	finishOIDC(c, utils.RoleUser)
+}
+
+// FinishDoctorOIDC completes a doctor login from the provider callback
+func FinishDoctorOIDC(c *gin.Context) {
+	finishOIDC(c, utils.RoleDoctor)
+}
+
+// FinishAdminOIDC completes an admin login from the provider callback
+func FinishAdminOIDC(c *gin.Context) {
+	finishOIDC(c, utils.RoleAdmin)
+}
+
+// oidcProviderFor loads the provider named in the route and checks it signs
+// in the kind of account the role needs
+func oidcProviderFor(c *gin.Context, role string) (*models.OIDCProvider, bool) {
+	provider, err := utils.OIDCProviderByName(c.Param("provider"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, models.APIResponse{
+			Success: false,
+			Message: err.Error(),
+		})
+		return nil, false
+	}
+
+	audience := models.OIDCAudienceStaff
+	if role == utils.RoleUser {
+		audience = models.OIDCAudiencePatients
+	}
+	if provider.Audience != audience {
+		c.JSON(http.StatusBadRequest, models.APIResponse{
+			Success: false,
+			Message: utils.ErrUnknownProvider.Error(),
+		})
+		return nil, false
+	}
+	return provider, true
+}
+
+func beginOIDC(c *gin.Context, role string) {
+	provider, ok := oidcProviderFor(c, role)
+	if !ok {
+		return
+	}
+
+	authURL, err := utils.BeginOIDCLogin(provider, role)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, models.APIResponse{
+			Success: false,
+			Message: err.Error(),
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, models.APIResponse{
+		Success: true,
+		Data:    gin.H{"authUrl": authURL},
+	})
+}
+
+func finishOIDC(c *gin.Context, role string) {
+	provider, ok := oidcProviderFor(c, role)
+	if !ok {
+		return
+	}
+
+	var req models.OIDCCallbackRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, models.APIResponse{
+			Success: false,
+			Message: "Missing Details",
+		})
+		return
+	}
+
+	identity, err := utils.FinishOIDCLogin(provider, role, req.Code, req.State)
+	if err != nil {
+		oidcError(c, err)
+		return
+	}
+
+	var id string
+	if role == utils.RoleUser {
+		id, err = utils.OIDCPatientAccount(provider.Name, identity)
+	} else {
+		id, err = utils.OIDCStaffAccount(provider.Name, identity, role)
+	}
+	if err == nil && role == utils.RoleAdmin {
+		// A linked admin may have been disabled since
+		if _, activeErr := utils.FindActiveAdmin(id); activeErr != nil {
+			err = utils.ErrNoStaffAccount
+		}
+	}
+	if err != nil {
+		oidcError(c, err)
+		return
+	}
+
+	// Staff logins meet the two-factor policy like password logins, unless a
+	// trusted provider reports that it already asked for a second factor
+	if role != utils.RoleUser && !utils.OIDCMultiFactor(provider, identity) {
+		completeLogin(c, id, role)
+		return
+	}
+	issueLoginTokens(c, id, role, nil)
+}
+
+func oidcError(c *gin.Context, err error) {
+	status := http.StatusInternalServerError
+	switch err {
+	case utils.ErrInvalidOIDC, utils.ErrNoStaffAccount:
+		status = http.StatusUnauthorized
+	}
+	c.JSON(status, models.APIResponse{
+		Success: false,
+		Message: err.Error(),
+	})
+}
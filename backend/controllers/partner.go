@@ -0,0 +1,188 @@
+package controllers
+
+import (
+	"context"
+	"net/http"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"prescripto-go/config"
+	"prescripto-go/models"
+	"prescripto-go/utils"
+)
+
+// PartnerBookAppointment books a slot for a patient on behalf of a partner.
+// The patient is found by email among the accounts the partner created or was
+// allowed to book for, or an account is created for them.
+func PartnerBookAppointment(c *gin.Context) {
+	var req models.PartnerBookingRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, models.APIResponse{
+			Success: false,
+			Message: "Missing Details",
+		})
+		return
+	}
+
+	email := strings.TrimSpace(req.PatientEmail)
+	if !utils.IsValidEmail(email) {
+		c.JSON(http.StatusBadRequest, models.APIResponse{
+			Success: false,
+			Message: "Please enter a valid email",
+		})
+		return
+	}
+
+	partner := "apikey:" + c.GetString("apiKeyId")
+	userID, err := utils.FindOrCreatePartnerUser(email, req.PatientName, partner)
+	if err != nil {
+		status := http.StatusInternalServerError
+		if err == utils.ErrPartnerNotAllowed {
+			status = http.StatusForbidden
+		}
+		c.JSON(status, models.APIResponse{
+			Success: false,
+			Message: err.Error(),
+		})
+		return
+	}
+
+	bookAppointment(c, userID, models.BookAppointmentRequest{
+		DocID:    req.DocID,
+		SlotDate: req.SlotDate,
+		SlotTime: req.SlotTime,
+	}, partner)
+}
+
+// GetPartnerAppointments lists the appointments booked with the calling API key
+func GetPartnerAppointments(c *gin.Context) {
+	opts := options.Find().SetSort(bson.D{{Key: "date", Value: -1}}).SetLimit(200)
+	cursor, err := config.GetCollection("appointments").Find(context.Background(),
+		bson.M{"bookedBy": "apikey:" + c.GetString("apiKeyId")}, opts)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, models.APIResponse{
+			Success: false,
+			Message: err.Error(),
+		})
+		return
+	}
+	defer cursor.Close(context.Background())
+
+	var appointments []models.Appointment
+	if err = cursor.All(context.Background(), &appointments); err != nil {
+		c.JSON(http.StatusInternalServerError, models.APIResponse{
+			Success: false,
+			Message: err.Error(),
+		})
+		return
+	}
+
+	// Partners see the booking, not the accounts behind it
+	bookings := make([]models.PartnerAppointment, len(appointments))
+	for i, appointment := range appointments {
+		bookings[i] = models.PartnerAppointment{
+			ID:           appointment.ID.Hex(),
+			DocID:        appointment.DocID,
+			DoctorName:   appointment.DocData.Name,
+			Speciality:   appointment.DocData.Speciality,
+			SlotDate:     appointment.SlotDate,
+			SlotTime:     appointment.SlotTime,
+			PatientName:  appointment.UserData.Name,
+			PatientEmail: appointment.UserData.Email,
+			Amount:       appointment.Amount,
+			Cancelled:    appointment.Cancelled,
+			Payment:      appointment.Payment,
+			IsCompleted:  appointment.IsCompleted,
+			Date:         appointment.Date,
+		}
+	}
+
+	c.JSON(http.StatusOK, models.APIResponse{
+		Success: true,
+		Data:    bookings,
+	})
+}
+
+// GetPartners lists the partners that can book appointments and whether the
+// user allows each of them to book on their behalf
+func GetPartners(c *gin.Context) {
+	userObjectID, _ := primitive.ObjectIDFromHex(c.GetString("userId"))
+	var user models.User
+	if err := config.GetCollection("users").FindOne(context.Background(), bson.M{"_id": userObjectID}).Decode(&user); err != nil {
+		c.JSON(http.StatusBadRequest, models.APIResponse{
+			Success: false,
+			Message: "User not found",
+		})
+		return
+	}
+
+	keys, err := utils.ListAPIKeys()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, models.APIResponse{
+			Success: false,
+			Message: err.Error(),
+		})
+		return
+	}
+
+	partners := []models.Partner{}
+	for _, key := range keys {
+		if key.Status != models.APIKeyActive || !utils.Contains(key.Scopes, models.ScopeBookingsWrite) {
+			continue
+		}
+		partners = append(partners, models.Partner{
+			ID:      key.ID.Hex(),
+			Name:    key.Name,
+			Allowed: utils.Contains(user.PartnerConsents, key.ID.Hex()),
+		})
+	}
+
+	c.JSON(http.StatusOK, models.APIResponse{
+		Success: true,
+		Data:    partners,
+	})
+}
+
+// SetPartnerConsent allows or stops a partner booking on the user's behalf
+func SetPartnerConsent(c *gin.Context) {
+	var req models.PartnerConsentRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, models.APIResponse{
+			Success: false,
+			Message: "Missing Details",
+		})
+		return
+	}
+
+	if req.Allow {
+		keyObjectID, _ := primitive.ObjectIDFromHex(req.KeyID)
+		count, err := config.GetCollection("api_keys").CountDocuments(context.Background(), bson.M{"_id": keyObjectID, "status": models.APIKeyActive})
+		if err != nil || count == 0 {
+			c.JSON(http.StatusBadRequest, models.APIResponse{
+				Success: false,
+				Message: "Partner not found",
+			})
+			return
+		}
+	}
+
+	if err := utils.SetPartnerConsent(c.GetString("userId"), req.KeyID, req.Allow); err != nil {
+		c.JSON(http.StatusInternalServerError, models.APIResponse{
+			Success: false,
+			Message: err.Error(),
+		})
+		return
+	}
+
+	message := "Partner Allowed"
+	if !req.Allow {
+		message = "Partner Removed"
+	}
+	c.JSON(http.StatusOK, models.APIResponse{
+		Success: true,
+		Message: message,
+	})
+}
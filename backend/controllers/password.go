@@ -0,0 +1,177 @@
+package controllers
+
+import (
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"prescripto-go/models"
+	"prescripto-go/utils"
+)
+
+// ForgotUserPassword emails a password reset link to a user
+func ForgotUserPassword(c *gin.Context) {
+	forgotPassword(c, utils.RoleUser)
+}
+
+// ForgotDoctorPassword emails a password reset link to a doctor
+func ForgotDoctorPassword(c *gin.Context) {
+	forgotPassword(c, utils.RoleDoctor)
+}
+
+// ResetUserPassword sets a user's password from a reset link
+func ResetUserPassword(c *gin.Context) {
+	resetPassword(c, utils.RoleUser)
+}
+
+// ResetDoctorPassword sets a doctor's password from a reset link
+func ResetDoctorPassword(c *gin.Context) {
+	resetPassword(c, utils.RoleDoctor)
+}
+
+func forgotPassword(c *gin.Context, role string) {
+	var req models.ForgotPasswordRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, models.APIResponse{
+			Success: false,
+			Message: "Missing Details",
+		})
+		return
+	}
+
+	if err := utils.CheckPasswordResetAllowed(role, req.Email, c.ClientIP()); err != nil {
+		status := http.StatusInternalServerError
+		if err == utils.ErrResetRateLimited {
+			status = http.StatusTooManyRequests
+		}
+		c.JSON(status, models.APIResponse{
+			Success: false,
+			Message: err.Error(),
+		})
+		return
+	}
+
+	if err := utils.RequestPasswordReset(role, req.Email); err != nil {
+		c.JSON(http.StatusInternalServerError, models.APIResponse{
+			Success: false,
+			Message: err.Error(),
+		})
+		return
+	}
+
+	// The same answer whether or not the account exists
+	c.JSON(http.StatusOK, models.APIResponse{
+		Success: true,
+		Message: "If an account exists for this email, a reset link has been sent",
+	})
+}
+
+func resetPassword(c *gin.Context, role string) {
+	var req models.ResetPasswordRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, models.APIResponse{
+			Success: false,
+			Message: "Missing Details",
+		})
+		return
+	}
+
+	if err := utils.ResetPassword(role, req.Token, req.Password); err != nil {
+		status := http.StatusInternalServerError
+		if _, ok := err.(*utils.PasswordPolicyError); ok || err == utils.ErrInvalidResetToken {
+			status = http.StatusBadRequest
+		}
+		c.JSON(status, models.APIResponse{
+			Success: false,
+			Message: err.Error(),
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, models.APIResponse{
+		Success: true,
+		Message: "Password Reset",
+	})
+}
+
+// ChangePassword changes the password of the logged in account. Every other
+// session is logged out, so fresh tokens are returned for this one.
+func ChangePassword(c *gin.Context) {
+	var req models.ChangePasswordRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, models.APIResponse{
+			Success: false,
+			Message: "Missing Details",
+		})
+		return
+	}
+
+	id, role := c.GetString("principalId"), c.GetString("tokenRole")
+	if err := utils.ChangePassword(role, id, req.CurrentPassword, req.NewPassword); err != nil {
+		status := http.StatusInternalServerError
+		if _, ok := err.(*utils.PasswordPolicyError); ok || err == utils.ErrWrongPassword {
+			status = http.StatusBadRequest
+		}
+		c.JSON(status, models.APIResponse{
+			Success: false,
+			Message: err.Error(),
+		})
+		return
+	}
+
+	token, refresh, err := utils.IssueTokens(id, role, sessionClient(c))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, models.APIResponse{
+			Success: false,
+			Message: "Error generating token",
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, models.APIResponse{
+		Success:      true,
+		Message:      "Password Changed",
+		Token:        token,
+		RefreshToken: refresh,
+	})
+}
+
+// GetPasswordPolicy describes the rules new passwords must follow
+func GetPasswordPolicy(c *gin.Context) {
+	c.JSON(http.StatusOK, models.APIResponse{
+		Success: true,
+		Data:    utils.GetPasswordPolicy(),
+	})
+}
+
+// UploadBreachedPasswords adds an uploaded list of breached password hashes,
+// one SHA-1 hash per line with an optional ":count", to the passwords that
+// are refused
+func UploadBreachedPasswords(c *gin.Context) {
+	file, _, err := c.Request.FormFile("file")
+	if err != nil {
+		c.JSON(http.StatusBadRequest, models.APIResponse{
+			Success: false,
+			Message: "Missing Details",
+		})
+		return
+	}
+	defer file.Close()
+
+	imported, skipped, err := utils.ImportBreachedPasswords(file)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, models.APIResponse{
+			Success: false,
+			Message: err.Error(),
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, models.APIResponse{
+		Success: true,
+		Message: "Breached Passwords Imported",
+		Data: gin.H{
+			"imported": imported,
+			"skipped":  skipped,
+		},
+	})
+}
@@ -0,0 +1,195 @@
+package controllers
+
+import (
+	"log"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"prescripto-go/models"
+	"prescripto-go/utils"
+)
+
+// RequestMagicLink emails a one-time login link to a user
+func RequestMagicLink(c *gin.Context) {
+	var req models.MagicLinkRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, models.APIResponse{
+			Success: false,
+			Message: "Missing Details",
+		})
+		return
+	}
+
+	// Sent in the background so the answer comes as quickly, and is the
+	// same, whether or not the account exists
+	go func(email, ip string) {
+		if err := utils.SendMagicLink(email, ip); err != nil {
+			log.Printf("Failed to send login link: %v", err)
+		}
+	}(req.Email, c.ClientIP())
+
+	c.JSON(http.StatusOK, models.APIResponse{
+		Success: true,
+		Message: "If an account exists for this email, a login link has been sent",
+	})
+}
+
+// MagicLogin logs a user in with the token from a magic link
+func MagicLogin(c *gin.Context) {
+	var req models.MagicLoginRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, models.APIResponse{
+			Success: false,
+			Message: "Missing Details",
+		})
+		return
+	}
+
+	userID, err := utils.ConsumeMagicLink(req.Token)
+	if err != nil {
+		status := http.StatusInternalServerError
+		if err == utils.ErrInvalidLoginCode {
+			status = http.StatusBadRequest
+		}
+		c.JSON(status, models.APIResponse{
+			Success: false,
+			Message: err.Error(),
+		})
+		return
+	}
+
+	issuePasswordlessTokens(c, userID)
+}
+
+// RequestOTP texts a one-time login code to a user
+func RequestOTP(c *gin.Context) {
+	var req models.OTPRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, models.APIResponse{
+			Success: false,
+			Message: "Missing Details",
+		})
+		return
+	}
+
+	go func(phone, ip string) {
+		if err := utils.SendLoginOTP(phone, ip); err != nil {
+			log.Printf("Failed to send login code: %v", err)
+		}
+	}(req.Phone, c.ClientIP())
+
+	c.JSON(http.StatusOK, models.APIResponse{
+		Success: true,
+		Message: "If an account exists for this number, a login code has been sent",
+	})
+}
+
+// VerifyOTP logs a user in with the code texted to their phone
+func VerifyOTP(c *gin.Context) {
+	var req models.OTPVerifyRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, models.APIResponse{
+			Success: false,
+			Message: "Missing Details",
+		})
+		return
+	}
+
+	// Wrong codes count towards the same lockout as wrong passwords,
+	// with the phone number standing in for the email
+	phone := utils.NormalizePhone(req.Phone)
+	if loginLocked(c, utils.RoleUser, phone) {
+		return
+	}
+
+	userID, err := utils.VerifyLoginOTP(phone, req.Code)
+	if err == utils.ErrInvalidLoginCode {
+		loginFailed(c, utils.RoleUser, phone)
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, models.APIResponse{
+			Success: false,
+			Message: err.Error(),
+		})
+		return
+	}
+	loginSucceeded(utils.RoleUser, phone)
+
+	issuePasswordlessTokens(c, userID)
+}
+
+// RequestPhoneVerification texts a code to the logged in user's phone so it
+// can be used for SMS login
+func RequestPhoneVerification(c *gin.Context) {
+	err := utils.SendPhoneVerification(c.GetString("userId"), c.ClientIP())
+	if err != nil {
+		status := http.StatusInternalServerError
+		if err == utils.ErrPhoneUnusable {
+			status = http.StatusBadRequest
+		} else if err == utils.ErrRateLimited {
+			status = http.StatusTooManyRequests
+		}
+		c.JSON(status, models.APIResponse{
+			Success: false,
+			Message: err.Error(),
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, models.APIResponse{
+		Success: true,
+		Message: "Verification code sent",
+	})
+}
+
+// VerifyPhone confirms the logged in user's phone with the texted code
+func VerifyPhone(c *gin.Context) {
+	var req models.PhoneVerifyRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, models.APIResponse{
+			Success: false,
+			Message: "Missing Details",
+		})
+		return
+	}
+
+	phone, err := utils.ConfirmPhone(c.GetString("userId"), req.Code)
+	if err != nil {
+		status := http.StatusInternalServerError
+		if err == utils.ErrInvalidLoginCode {
+			status = http.StatusBadRequest
+		} else if err == utils.ErrPhoneTaken {
+			status = http.StatusConflict
+		}
+		c.JSON(status, models.APIResponse{
+			Success: false,
+			Message: err.Error(),
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, models.APIResponse{
+		Success: true,
+		Message: "Phone Verified",
+		Data:    gin.H{"verifiedPhone": phone},
+	})
+}
+
+// issuePasswordlessTokens answers a passwordless login the way LoginUser does
+func issuePasswordlessTokens(c *gin.Context, userID string) {
+	token, refresh, err := utils.IssueTokens(userID, utils.RoleUser, sessionClient(c))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, models.APIResponse{
+			Success: false,
+			Message: "Error generating token",
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, models.APIResponse{
+		Success:      true,
+		Token:        token,
+		RefreshToken: refresh,
+	})
+}
@@ -0,0 +1,596 @@
+package controllers
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stripe/stripe-go/v72"
+	"github.com/stripe/stripe-go/v72/refund"
+	"github.com/stripe/stripe-go/v72/webhook"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"prescripto-go/config"
+	"prescripto-go/models"
+	"prescripto-go/utils"
+)
+
+// razorpayWebhookEvent is the subset of a Razorpay webhook payload we use
+type razorpayWebhookEvent struct {
+	Event   string `json:"event"`
+	Payload struct {
+		Payment struct {
+			Entity map[string]interface{} `json:"entity"`
+		} `json:"payment"`
+		Refund struct {
+			Entity map[string]interface{} `json:"entity"`
+		} `json:"refund"`
+	} `json:"payload"`
+}
+
+// RazorpayWebhook records payment events pushed by Razorpay
+func RazorpayWebhook(c *gin.Context) {
+	body, err := io.ReadAll(c.Request.Body)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, models.APIResponse{
+			Success: false,
+			Message: "Invalid payload",
+		})
+		return
+	}
+
+	if !utils.VerifyHMACSignature(body, c.GetHeader("X-Razorpay-Signature"), os.Getenv("RAZORPAY_WEBHOOK_SECRET")) {
+		c.JSON(http.StatusUnauthorized, models.APIResponse{
+			Success: false,
+			Message: "Invalid signature",
+		})
+		return
+	}
+
+	var event razorpayWebhookEvent
+	if err := json.Unmarshal(body, &event); err != nil {
+		c.JSON(http.StatusBadRequest, models.APIResponse{
+			Success: false,
+			Message: "Invalid payload",
+		})
+		return
+	}
+
+	entity := event.Payload.Payment.Entity
+	tx := &models.PaymentTransaction{
+		Gateway:  models.GatewayRazorpay,
+		Type:     models.PaymentTypeWebhook,
+		Response: map[string]interface{}{"event": event.Event, "payment": entity},
+	}
+	if eventID := c.GetHeader("X-Razorpay-Event-Id"); eventID != "" {
+		tx.IdempotencyKey = "webhook:" + models.GatewayRazorpay + ":" + eventID
+	}
+	tx.GatewayOrderID, _ = entity["order_id"].(string)
+	tx.GatewayPaymentID, _ = entity["id"].(string)
+	currency, _ := entity["currency"].(string)
+	if amount, ok := entity["amount"].(float64); ok {
+		tx.Amount = models.NewMoney(int64(amount), currency)
+	}
+
+	switch event.Event {
+	case "payment.captured", "order.paid":
+		tx.Status = models.PaymentStatusPaid
+	case "payment.failed":
+		tx.Status = models.PaymentStatusFailed
+		tx.Error, _ = entity["error_description"].(string)
+	case "refund.processed":
+		tx.Status = models.PaymentStatusRefunded
+		if amount, ok := event.Payload.Refund.Entity["amount"].(float64); ok {
+			tx.Amount = models.NewMoney(int64(amount), currency)
+		}
+	default:
+		c.JSON(http.StatusOK, models.APIResponse{Success: true})
+		return
+	}
+
+	if order, err := utils.FindPaymentByGatewayOrderID(models.GatewayRazorpay, tx.GatewayOrderID); err == nil {
+		tx.AppointmentID = order.AppointmentID
+		tx.UserID = order.UserID
+	}
+
+	recordWebhook(c, tx)
+}
+
+// StripeWebhook records payment events pushed by Stripe
+func StripeWebhook(c *gin.Context) {
+	body, err := io.ReadAll(c.Request.Body)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, models.APIResponse{
+			Success: false,
+			Message: "Invalid payload",
+		})
+		return
+	}
+
+	event, err := webhook.ConstructEvent(body, c.GetHeader("Stripe-Signature"), os.Getenv("STRIPE_WEBHOOK_SECRET"))
+	if err != nil {
+		c.JSON(http.StatusUnauthorized, models.APIResponse{
+			Success: false,
+			Message: "Invalid signature",
+		})
+		return
+	}
+
+	tx := &models.PaymentTransaction{
+		Gateway:        models.GatewayStripe,
+		Type:           models.PaymentTypeWebhook,
+		IdempotencyKey: "webhook:" + models.GatewayStripe + ":" + event.ID,
+		Response:       map[string]interface{}{"event": event.Type, "id": event.ID},
+	}
+
+	switch event.Type {
+	case "checkout.session.completed", "checkout.session.async_payment_succeeded",
+		"checkout.session.async_payment_failed", "checkout.session.expired":
+		var sess stripe.CheckoutSession
+		if err := json.Unmarshal(event.Data.Raw, &sess); err != nil {
+			c.JSON(http.StatusBadRequest, models.APIResponse{
+				Success: false,
+				Message: "Invalid payload",
+			})
+			return
+		}
+		tx.GatewayOrderID = sess.ID
+		tx.AppointmentID = sess.ClientReferenceID
+		tx.Amount = models.NewMoney(sess.AmountTotal, string(sess.Currency))
+		if sess.PaymentIntent != nil {
+			tx.GatewayPaymentID = sess.PaymentIntent.ID
+		}
+		if sess.PaymentStatus == stripe.CheckoutSessionPaymentStatusPaid {
+			tx.Status = models.PaymentStatusPaid
+		} else if event.Type == "checkout.session.completed" {
+			// Delayed payment methods confirm later through async_payment_succeeded
+			tx.Status = models.PaymentStatusPending
+		} else {
+			tx.Status = models.PaymentStatusFailed
+			tx.Error = event.Type
+		}
+	case "charge.refunded":
+		var charge stripe.Charge
+		if err := json.Unmarshal(event.Data.Raw, &charge); err != nil {
+			c.JSON(http.StatusBadRequest, models.APIResponse{
+				Success: false,
+				Message: "Invalid payload",
+			})
+			return
+		}
+		tx.Status = models.PaymentStatusRefunded
+		tx.Amount = models.NewMoney(charge.AmountRefunded, string(charge.Currency))
+		if charge.PaymentIntent != nil {
+			tx.GatewayPaymentID = charge.PaymentIntent.ID
+		}
+		if paid, err := findPaidByGatewayPaymentID(models.GatewayStripe, tx.GatewayPaymentID); err == nil {
+			tx.AppointmentID = paid.AppointmentID
+			tx.GatewayOrderID = paid.GatewayOrderID
+		}
+	default:
+		c.JSON(http.StatusOK, models.APIResponse{Success: true})
+		return
+	}
+
+	if order, err := utils.FindPaymentByGatewayOrderID(models.GatewayStripe, tx.GatewayOrderID); err == nil {
+		tx.AppointmentID = order.AppointmentID
+		tx.UserID = order.UserID
+	}
+
+	recordWebhook(c, tx)
+}
+
+// recordWebhook stores a webhook ledger entry once per gateway event and
+// confirms the appointment when the event reports a successful charge
+func recordWebhook(c *gin.Context, tx *models.PaymentTransaction) {
+	if err := utils.RecordPayment(tx); err != nil {
+		if mongo.IsDuplicateKeyError(err) {
+			// Redelivery of an event we already processed
+			c.JSON(http.StatusOK, models.APIResponse{Success: true})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, models.APIResponse{
+			Success: false,
+			Message: err.Error(),
+		})
+		return
+	}
+
+	if tx.Status == models.PaymentStatusPaid && tx.AppointmentID != "" {
+		if err := utils.MarkAppointmentPaid(tx.AppointmentID); err != nil {
+			c.JSON(http.StatusInternalServerError, models.APIResponse{
+				Success: false,
+				Message: err.Error(),
+			})
+			return
+		}
+	}
+
+	c.JSON(http.StatusOK, models.APIResponse{Success: true})
+}
+
+// findPaidByGatewayPaymentID returns the paid ledger entry for a gateway payment
+func findPaidByGatewayPaymentID(gateway, paymentID string) (*models.PaymentTransaction, error) {
+	var tx models.PaymentTransaction
+	filter := bson.M{"gateway": gateway, "gatewayPaymentId": paymentID, "status": models.PaymentStatusPaid}
+	err := config.GetCollection("payments").FindOne(context.Background(), filter).Decode(&tx)
+	if err != nil {
+		return nil, err
+	}
+	return &tx, nil
+}
+
+// GetPayments lists ledger entries for admin, optionally for one appointment
+func GetPayments(c *gin.Context) {
+	filter := bson.M{}
+	if appointmentID := c.Query("appointmentId"); appointmentID != "" {
+		filter["appointmentId"] = appointmentID
+	}
+
+	collection := config.GetCollection("payments")
+	opts := options.Find().SetSort(bson.D{{Key: "createdAt", Value: -1}})
+	cursor, err := collection.Find(context.Background(), filter, opts)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, models.APIResponse{
+			Success: false,
+			Message: err.Error(),
+		})
+		return
+	}
+	defer cursor.Close(context.Background())
+
+	var payments []models.PaymentTransaction
+	if err = cursor.All(context.Background(), &payments); err != nil {
+		c.JSON(http.StatusInternalServerError, models.APIResponse{
+			Success: false,
+			Message: err.Error(),
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, models.APIResponse{
+		Success: true,
+		Data:    payments,
+	})
+}
+
+// RefundPayment refunds the charge of an appointment through its gateway
+func RefundPayment(c *gin.Context) {
+	var req models.RefundPaymentRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, models.APIResponse{
+			Success: false,
+			Message: "Missing Details",
+		})
+		return
+	}
+
+	paid, err := utils.LatestPaidPayment(req.AppointmentID)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, models.APIResponse{
+			Success: false,
+			Message: "No payment found for appointment",
+		})
+		return
+	}
+
+	appointmentObjectID, _ := primitive.ObjectIDFromHex(req.AppointmentID)
+	var appointment models.Appointment
+	err = config.GetCollection("appointments").FindOne(context.Background(), bson.M{"_id": appointmentObjectID}).Decode(&appointment)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, models.APIResponse{
+			Success: false,
+			Message: "Appointment not found",
+		})
+		return
+	}
+
+	idempotencyKey := c.GetHeader("Idempotency-Key")
+
+	// Everything refunded so far, through the gateway and to the wallet, counts
+	// against what the patient paid. A retry of this same refund is left out.
+	var exceptKeys []string
+	if idempotencyKey != "" {
+		exceptKeys = []string{idempotencyKey, "wallet-" + idempotencyKey}
+	}
+	refunds, err := utils.AppointmentRefunds(req.AppointmentID, exceptKeys...)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, models.APIResponse{
+			Success: false,
+			Message: err.Error(),
+		})
+		return
+	}
+	var refunded, refundedByGateway int64
+	for _, r := range refunds {
+		refunded += r.Amount.Amount
+		if r.Gateway == paid.Gateway {
+			refundedByGateway += r.Amount.Amount
+		}
+	}
+
+	// Wallet refunds can return everything the patient paid, including wallet
+	// credit; the gateway can only return its own charge
+	toWallet := req.ToWallet || paid.Gateway == models.GatewayWallet
+	captured := paid.Amount.Amount
+	if paid.Gateway != models.GatewayWallet && appointment.WalletPaid.Currency == paid.Amount.Currency {
+		captured += appointment.WalletPaid.Amount
+	}
+	refundable := models.NewMoney(captured-refunded, paid.Amount.Currency)
+	if !toWallet && paid.Amount.Amount-refundedByGateway < refundable.Amount {
+		refundable.Amount = paid.Amount.Amount - refundedByGateway
+	}
+	if refundable.Amount <= 0 {
+		c.JSON(http.StatusBadRequest, models.APIResponse{
+			Success: false,
+			Message: "Payment already refunded",
+		})
+		return
+	}
+
+	// Refund the rest of the charge unless a partial amount in major units is given
+	amount := refundable
+	if req.Amount != "" {
+		partial, err := models.ParseMoney(req.Amount.String(), refundable.Currency)
+		if err != nil || partial.Amount <= 0 {
+			c.JSON(http.StatusBadRequest, models.APIResponse{
+				Success: false,
+				Message: "Invalid refund amount",
+			})
+			return
+		}
+		if partial.Amount > refundable.Amount {
+			c.JSON(http.StatusBadRequest, models.APIResponse{
+				Success: false,
+				Message: "Refund exceeds the amount paid, " + refundable.String() + " can still be refunded",
+			})
+			return
+		}
+		amount = partial
+	}
+
+	if idempotencyKey == "" {
+		idempotencyKey = fmt.Sprintf("refund:%s:%s:%d", paid.Gateway, paid.GatewayPaymentID, amount.Amount)
+	}
+
+	if toWallet {
+		tx, err := utils.RefundToWallet(&appointment, amount, "wallet-"+idempotencyKey, req.Reason, "admin:"+c.GetString("adminId"))
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, models.APIResponse{
+				Success: false,
+				Message: err.Error(),
+			})
+			return
+		}
+		c.JSON(http.StatusOK, models.APIResponse{
+			Success: true,
+			Message: "Payment Refunded to Wallet",
+			Data:    tx,
+		})
+		return
+	}
+
+	tx := &models.PaymentTransaction{
+		AppointmentID:    paid.AppointmentID,
+		UserID:           paid.UserID,
+		Gateway:          paid.Gateway,
+		Type:             models.PaymentTypeRefund,
+		GatewayOrderID:   paid.GatewayOrderID,
+		GatewayPaymentID: paid.GatewayPaymentID,
+		Amount:           amount,
+		IdempotencyKey:   idempotencyKey,
+	}
+	existing, claimed, err := utils.ClaimIdempotencyKey(tx)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, models.APIResponse{
+			Success: false,
+			Message: err.Error(),
+		})
+		return
+	}
+	if !claimed {
+		if existing.Status == models.PaymentStatusRefunded {
+			c.JSON(http.StatusOK, models.APIResponse{
+				Success: true,
+				Message: "Payment Refunded",
+				Data:    existing,
+			})
+			return
+		}
+		c.JSON(http.StatusConflict, models.APIResponse{
+			Success: false,
+			Message: "Refund already in progress",
+		})
+		return
+	}
+
+	var response map[string]interface{}
+	switch paid.Gateway {
+	case models.GatewayRazorpay:
+		data := map[string]interface{}{"notes": map[string]interface{}{"reason": req.Reason}}
+		response, err = utils.RazorpayClient().Payment.Refund(paid.GatewayPaymentID, int(amount.Amount), data, nil)
+	case models.GatewayStripe:
+		stripe.Key = os.Getenv("STRIPE_SECRET_KEY")
+		params := &stripe.RefundParams{
+			PaymentIntent: stripe.String(paid.GatewayPaymentID),
+			Amount:        stripe.Int64(amount.Amount),
+		}
+		params.SetIdempotencyKey(idempotencyKey)
+		var r *stripe.Refund
+		if r, err = refund.New(params); err == nil {
+			response = map[string]interface{}{"id": r.ID, "status": string(r.Status)}
+		}
+	case models.GatewayOffline:
+		// Offline payments are handed back at the clinic
+		response = map[string]interface{}{"reason": req.Reason}
+	default:
+		err = fmt.Errorf("unsupported gateway %q", paid.Gateway)
+	}
+
+	if err != nil {
+		utils.FailPayment(tx.ID, err)
+		c.JSON(http.StatusInternalServerError, models.APIResponse{
+			Success: false,
+			Message: err.Error(),
+		})
+		return
+	}
+
+	tx.Status = models.PaymentStatusRefunded
+	tx.Response = response
+	utils.UpdatePayment(tx.ID, bson.M{
+		"status":   tx.Status,
+		"response": response,
+	})
+
+	c.JSON(http.StatusOK, models.APIResponse{
+		Success: true,
+		Message: "Payment Refunded",
+		Data:    tx,
+	})
+}
+
+// RecordDoctorOfflinePayment records a payment collected at the clinic for one of the doctor's appointments
+func RecordDoctorOfflinePayment(c *gin.Context) {
+	docID := c.GetString("docId")
+	recordOfflinePayment(c, "doctor:"+docID, func(appointment *models.Appointment) bool {
+		return appointment.DocID == docID
+	})
+}
+
+// RecordAdminOfflinePayment records a payment collected at the clinic for any appointment
+func RecordAdminOfflinePayment(c *gin.Context) {
+	recordOfflinePayment(c, "admin:"+c.GetString("adminId"), func(appointment *models.Appointment) bool {
+		return true
+	})
+}
+
+// recordOfflinePayment adds a paid offline entry to the ledger and marks the appointment paid
+func recordOfflinePayment(c *gin.Context, recordedBy string, allowed func(*models.Appointment) bool) {
+	var req models.RecordOfflinePaymentRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, models.APIResponse{
+			Success: false,
+			Message: "Missing Details",
+		})
+		return
+	}
+
+	switch req.Method {
+	case models.OfflineMethodCash, models.OfflineMethodCard, models.OfflineMethodUPI, models.OfflineMethodBankTransfer:
+	default:
+		c.JSON(http.StatusBadRequest, models.APIResponse{
+			Success: false,
+			Message: "Invalid payment method",
+		})
+		return
+	}
+
+	appointmentObjectID, _ := primitive.ObjectIDFromHex(req.AppointmentID)
+	var appointment models.Appointment
+	err := config.GetCollection("appointments").FindOne(context.Background(), bson.M{"_id": appointmentObjectID}).Decode(&appointment)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, models.APIResponse{
+			Success: false,
+			Message: "Appointment not found",
+		})
+		return
+	}
+
+	if !allowed(&appointment) {
+		c.JSON(http.StatusBadRequest, models.APIResponse{
+			Success: false,
+			Message: "Unauthorized action",
+		})
+		return
+	}
+
+	if appointment.Cancelled {
+		c.JSON(http.StatusBadRequest, models.APIResponse{
+			Success: false,
+			Message: "Appointment Cancelled",
+		})
+		return
+	}
+
+	if appointment.Payment {
+		c.JSON(http.StatusBadRequest, models.APIResponse{
+			Success: false,
+			Message: "Appointment already paid",
+		})
+		return
+	}
+
+	// Partial payments are not supported, so the amount must settle the appointment
+	amount, err := appointment.AmountDue()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, models.APIResponse{
+			Success: false,
+			Message: err.Error(),
+		})
+		return
+	}
+	if req.Amount != "" {
+		collected, err := models.ParseMoney(req.Amount.String(), amount.Currency)
+		if err != nil || collected != amount {
+			c.JSON(http.StatusBadRequest, models.APIResponse{
+				Success: false,
+				Message: "Amount must equal the amount due of " + amount.String(),
+			})
+			return
+		}
+	}
+
+	idempotencyKey := c.GetHeader("Idempotency-Key")
+	if idempotencyKey == "" {
+		idempotencyKey = "offline:" + req.AppointmentID
+	}
+
+	tx := &models.PaymentTransaction{
+		AppointmentID:    req.AppointmentID,
+		UserID:           appointment.UserID,
+		Gateway:          models.GatewayOffline,
+		Type:             models.PaymentTypeVerification,
+		Status:           models.PaymentStatusPaid,
+		GatewayPaymentID: req.Reference,
+		Amount:           amount,
+		Method:           req.Method,
+		CollectedBy:      req.CollectedBy,
+		RecordedBy:       recordedBy,
+		IdempotencyKey:   idempotencyKey,
+	}
+	if err := utils.RecordPayment(tx); err != nil {
+		if mongo.IsDuplicateKeyError(err) {
+			c.JSON(http.StatusConflict, models.APIResponse{
+				Success: false,
+				Message: "Offline payment already recorded",
+			})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, models.APIResponse{
+			Success: false,
+			Message: err.Error(),
+		})
+		return
+	}
+
+	if err := utils.MarkAppointmentPaid(req.AppointmentID); err != nil {
+		c.JSON(http.StatusInternalServerError, models.APIResponse{
+			Success: false,
+			Message: err.Error(),
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, models.APIResponse{
+		Success: true,
+		Message: "Payment Recorded",
+		Data:    tx,
+	})
+}
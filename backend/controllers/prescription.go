@@ -0,0 +1,381 @@
+package controllers
+
+import (
+	"context"
+	"net/http"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"prescripto-go/config"
+	"prescripto-go/models"
+	"prescripto-go/utils"
+)
+
+// SavePrescription creates or updates the draft prescription of one of the
+// doctor's appointments
+func SavePrescription(c *gin.Context) {
+	var req models.SavePrescriptionRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, models.APIResponse{
+			Success: false,
+			Message: "Missing Details",
+		})
+		return
+	}
+
+	appointment, ok := doctorAppointment(c, req.AppointmentID)
+	if !ok {
+		return
+	}
+
+	prescription, err := utils.SavePrescriptionDraft(appointment, req)
+	if err != nil {
+		c.JSON(prescriptionErrorStatus(err), models.APIResponse{
+			Success: false,
+			Message: err.Error(),
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, models.APIResponse{
+		Success: true,
+		Message: "Prescription Saved",
+		Data:    prescription,
+	})
+}
+
+// SignPrescription signs the draft prescription of one of the doctor's
+// appointments. It cannot be edited afterwards.
+func SignPrescription(c *gin.Context) {
+	var req models.SignPrescriptionRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, models.APIResponse{
+			Success: false,
+			Message: "Missing Details",
+		})
+		return
+	}
+
+	if _, ok := doctorAppointment(c, req.AppointmentID); !ok {
+		return
+	}
+
+	prescription, err := utils.SignPrescription(req.AppointmentID)
+	if err != nil {
+		c.JSON(prescriptionErrorStatus(err), models.APIResponse{
+			Success: false,
+			Message: err.Error(),
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, models.APIResponse{
+		Success: true,
+		Message: "Prescription Signed",
+		Data:    prescription,
+	})
+}
+
+// AmendPrescription issues a new signed version of a signed prescription
+func AmendPrescription(c *gin.Context) {
+	var req models.AmendPrescriptionRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, models.APIResponse{
+			Success: false,
+			Message: "Missing Details",
+		})
+		return
+	}
+
+	appointment, ok := doctorAppointment(c, req.AppointmentID)
+	if !ok {
+		return
+	}
+
+	prescription, err := utils.AmendPrescription(appointment, req)
+	if err != nil {
+		c.JSON(prescriptionErrorStatus(err), models.APIResponse{
+			Success: false,
+			Message: err.Error(),
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, models.APIResponse{
+		Success: true,
+		Message: "Prescription Amended",
+		Data:    prescription,
+	})
+}
+
+// RevokePrescription withdraws the current version of a signed prescription
+// of one of the doctor's appointments
+func RevokePrescription(c *gin.Context) {
+	var req models.RevokePrescriptionRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, models.APIResponse{
+			Success: false,
+			Message: "Missing Details",
+		})
+		return
+	}
+
+	if _, ok := doctorAppointment(c, req.AppointmentID); !ok {
+		return
+	}
+
+	prescription, err := utils.RevokePrescription(req.AppointmentID, req.Reason)
+	if err != nil {
+		c.JSON(prescriptionErrorStatus(err), models.APIResponse{
+			Success: false,
+			Message: err.Error(),
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, models.APIResponse{
+		Success: true,
+		Message: "Prescription Revoked",
+		Data:    prescription,
+	})
+}
+
+// GetDoctorPrescription returns every version, including a draft, of the
+// prescription of one of the doctor's appointments
+func GetDoctorPrescription(c *gin.Context) {
+	if _, ok := doctorAppointment(c, c.Param("appointmentId")); !ok {
+		return
+	}
+
+	prescriptions, err := utils.PrescriptionVersions(c.Param("appointmentId"), false)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, models.APIResponse{
+			Success: false,
+			Message: err.Error(),
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, models.APIResponse{
+		Success: true,
+		Data:    prescriptions,
+	})
+}
+
+// GetUserPrescriptions lists the current version of the user's signed prescriptions
+func GetUserPrescriptions(c *gin.Context) {
+	prescriptions, err := utils.UserPrescriptions(c.GetString("userId"))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, models.APIResponse{
+			Success: false,
+			Message: err.Error(),
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, models.APIResponse{
+		Success: true,
+		Data:    prescriptions,
+	})
+}
+
+// GetUserPrescription returns the signed versions of the prescription of one
+// of the user's appointments, newest first
+func GetUserPrescription(c *gin.Context) {
+	prescriptions, err := utils.PrescriptionVersions(c.Param("appointmentId"), true)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, models.APIResponse{
+			Success: false,
+			Message: err.Error(),
+		})
+		return
+	}
+
+	if len(prescriptions) == 0 {
+		c.JSON(http.StatusNotFound, models.APIResponse{
+			Success: false,
+			Message: utils.ErrPrescriptionNotFound.Error(),
+		})
+		return
+	}
+	if prescriptions[0].UserID != c.GetString("userId") {
+		c.JSON(http.StatusUnauthorized, models.APIResponse{
+			Success: false,
+			Message: "Unauthorized action",
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, models.APIResponse{
+		Success: true,
+		Data:    prescriptions,
+	})
+}
+
+// DownloadUserPrescription sends the PDF of the current prescription of one of the user's appointments
+func DownloadUserPrescription(c *gin.Context) {
+	prescription, ok := loadSignedPrescription(c)
+	if !ok {
+		return
+	}
+
+	if prescription.UserID != c.GetString("userId") {
+		c.JSON(http.StatusUnauthorized, models.APIResponse{
+			Success: false,
+			Message: "Unauthorized action",
+		})
+		return
+	}
+
+	sendPrescriptionPDF(c, prescription)
+}
+
+// DownloadDoctorPrescription sends the PDF of the current prescription of one of the doctor's appointments
+func DownloadDoctorPrescription(c *gin.Context) {
+	prescription, ok := loadSignedPrescription(c)
+	if !ok {
+		return
+	}
+
+	if prescription.DocID != c.GetString("docId") {
+		c.JSON(http.StatusUnauthorized, models.APIResponse{
+			Success: false,
+			Message: "Unauthorized action",
+		})
+		return
+	}
+
+	sendPrescriptionPDF(c, prescription)
+}
+
+// VerifyPrescription lets a pharmacy check that a verification code belongs to
+// a genuine prescription that is current and has not been revoked
+func VerifyPrescription(c *gin.Context) {
+	verification, err := utils.VerifyPrescription(c.Param("code"))
+	if err != nil {
+		c.JSON(prescriptionErrorStatus(err), models.APIResponse{
+			Success: false,
+			Message: err.Error(),
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, models.APIResponse{
+		Success: verification.Status == models.PrescriptionValid,
+		Message: "Prescription is " + verification.Status,
+		Data:    verification,
+	})
+}
+
+// SetDoctorRegistration sets the registration number printed on a doctor's prescriptions
+func SetDoctorRegistration(c *gin.Context) {
+	var req models.SetDoctorRegistrationRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, models.APIResponse{
+			Success: false,
+			Message: "Missing Details",
+		})
+		return
+	}
+
+	update := bson.M{"$unset": bson.M{"registrationNumber": ""}}
+	if number := strings.TrimSpace(req.RegistrationNumber); number != "" {
+		update = bson.M{"$set": bson.M{"registrationNumber": number}}
+	}
+
+	docObjectID, _ := primitive.ObjectIDFromHex(req.DocID)
+	result, err := config.GetCollection("doctors").UpdateOne(context.Background(), bson.M{"_id": docObjectID}, update)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, models.APIResponse{
+			Success: false,
+			Message: err.Error(),
+		})
+		return
+	}
+	if result.MatchedCount == 0 {
+		c.JSON(http.StatusBadRequest, models.APIResponse{
+			Success: false,
+			Message: "Doctor not found",
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, models.APIResponse{
+		Success: true,
+		Message: "Registration Number Updated",
+	})
+}
+
+// loadSignedPrescription fetches the current signed version of the
+// prescription for the appointment in the URL. Revoked prescriptions are not
+// handed out again.
+func loadSignedPrescription(c *gin.Context) (*models.Prescription, bool) {
+	prescription, err := utils.LatestPrescription(c.Param("appointmentId"))
+	if err == nil && prescription.Status != models.PrescriptionStatusSigned {
+		err = utils.ErrPrescriptionUnsigned
+	}
+	if err == nil && prescription.RevokedAt > 0 {
+		err = utils.ErrPrescriptionRevoked
+	}
+	if err != nil {
+		c.JSON(prescriptionErrorStatus(err), models.APIResponse{
+			Success: false,
+			Message: err.Error(),
+		})
+		return nil, false
+	}
+	return prescription, true
+}
+
+func sendPrescriptionPDF(c *gin.Context, prescription *models.Prescription) {
+	c.Header("Content-Disposition", `attachment; filename="prescription-`+prescription.VerificationCode+`.pdf"`)
+	c.Data(http.StatusOK, "application/pdf", utils.RenderPrescriptionPDF(prescription))
+}
+
+// doctorAppointment loads an appointment of the logged in doctor, responding
+// and returning false if it does not exist, belongs to another doctor or was cancelled
+func doctorAppointment(c *gin.Context, appointmentID string) (*models.Appointment, bool) {
+	appointmentObjectID, _ := primitive.ObjectIDFromHex(appointmentID)
+
+	var appointment models.Appointment
+	err := config.GetCollection("appointments").FindOne(context.Background(), bson.M{"_id": appointmentObjectID}).Decode(&appointment)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, models.APIResponse{
+			Success: false,
+			Message: "Appointment not found",
+		})
+		return nil, false
+	}
+
+	if appointment.DocID != c.GetString("docId") {
+		c.JSON(http.StatusBadRequest, models.APIResponse{
+			Success: false,
+			Message: "Unauthorized action",
+		})
+		return nil, false
+	}
+
+	if appointment.Cancelled {
+		c.JSON(http.StatusBadRequest, models.APIResponse{
+			Success: false,
+			Message: "Appointment is cancelled",
+		})
+		return nil, false
+	}
+
+	return &appointment, true
+}
+
+func prescriptionErrorStatus(err error) int {
+	switch err {
+	case utils.ErrPrescriptionNotFound:
+		return http.StatusNotFound
+	case utils.ErrPrescriptionSigned, utils.ErrPrescriptionUnsigned, utils.ErrPrescriptionRevoked:
+		return http.StatusBadRequest
+	case utils.ErrPrescriptionChanged:
+		return http.StatusConflict
+	}
+	return http.StatusInternalServerError
+}
@@ -0,0 +1,70 @@
+package controllers
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"prescripto-go/config"
+	"prescripto-go/models"
+	"prescripto-go/utils"
+)
+
+// ReconcilePayments runs a gateway reconciliation on demand
+func ReconcilePayments(c *gin.Context) {
+	var req models.ReconcilePaymentsRequest
+	c.ShouldBindJSON(&req)
+
+	if req.LookbackHours <= 0 {
+		req.LookbackHours = 48
+	}
+
+	end := time.Now().Unix()
+	report, err := utils.ReconcilePayments("manual", end-int64(req.LookbackHours)*3600, end)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, models.APIResponse{
+			Success: false,
+			Message: err.Error(),
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, models.APIResponse{
+		Success: true,
+		Message: fmt.Sprintf("%d Mismatches Found, %d Fixed", len(report.Mismatches), report.Fixed),
+		Data:    report,
+	})
+}
+
+// GetReconciliationReports lists the latest reconciliation reports
+func GetReconciliationReports(c *gin.Context) {
+	collection := config.GetCollection("reconciliation_reports")
+	opts := options.Find().SetSort(bson.D{{Key: "startedAt", Value: -1}}).SetLimit(50)
+	cursor, err := collection.Find(context.Background(), bson.M{}, opts)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, models.APIResponse{
+			Success: false,
+			Message: err.Error(),
+		})
+		return
+	}
+	defer cursor.Close(context.Background())
+
+	var reports []models.ReconciliationReport
+	if err = cursor.All(context.Background(), &reports); err != nil {
+		c.JSON(http.StatusInternalServerError, models.APIResponse{
+			Success: false,
+			Message: err.Error(),
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, models.APIResponse{
+		Success: true,
+		Data:    reports,
+	})
+}
@@ -0,0 +1,251 @@
+package controllers
+
+import (
+	"context"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"prescripto-go/config"
+	"prescripto-go/models"
+	"prescripto-go/utils"
+)
+
+// GetRoles lists all roles and the permissions that can be granted
+func GetRoles(c *gin.Context) {
+	opts := options.Find().SetSort(bson.D{{Key: "name", Value: 1}})
+	cursor, err := config.GetCollection("roles").Find(context.Background(), bson.M{}, opts)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, models.APIResponse{
+			Success: false,
+			Message: err.Error(),
+		})
+		return
+	}
+	defer cursor.Close(context.Background())
+
+	var roles []models.Role
+	if err = cursor.All(context.Background(), &roles); err != nil {
+		c.JSON(http.StatusInternalServerError, models.APIResponse{
+			Success: false,
+			Message: err.Error(),
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, models.APIResponse{
+		Success: true,
+		Data: gin.H{
+			"roles":       roles,
+			"permissions": models.AllPermissions,
+		},
+	})
+}
+
+// SaveRole creates a role or replaces the permissions of an existing one
+func SaveRole(c *gin.Context) {
+	var req models.SaveRoleRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, models.APIResponse{
+			Success: false,
+			Message: "Missing Details",
+		})
+		return
+	}
+
+	name := strings.ToLower(strings.TrimSpace(req.Name))
+	if name == models.RoleSuperAdmin {
+		c.JSON(http.StatusBadRequest, models.APIResponse{
+			Success: false,
+			Message: "The super_admin role cannot be changed",
+		})
+		return
+	}
+
+	for _, permission := range req.Permissions {
+		if !utils.ValidPermission(permission) {
+			c.JSON(http.StatusBadRequest, models.APIResponse{
+				Success: false,
+				Message: "Unknown permission " + permission,
+			})
+			return
+		}
+	}
+
+	if !utils.CanGrant(c.GetStringSlice("permissions"), req.Permissions) {
+		c.JSON(http.StatusForbidden, models.APIResponse{
+			Success: false,
+			Message: "You cannot grant permissions you do not have",
+		})
+		return
+	}
+
+	_, err := config.GetCollection("roles").UpdateOne(
+		context.Background(),
+		bson.M{"name": name},
+		bson.M{
+			"$set": bson.M{
+				"description": req.Description,
+				"permissions": req.Permissions,
+			},
+			"$setOnInsert": bson.M{
+				"system":    false,
+				"createdAt": time.Now().Unix(),
+			},
+		},
+		options.Update().SetUpsert(true),
+	)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, models.APIResponse{
+			Success: false,
+			Message: err.Error(),
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, models.APIResponse{
+		Success: true,
+		Message: "Role Saved",
+	})
+}
+
+// DeleteRole removes a custom role that no admin holds
+func DeleteRole(c *gin.Context) {
+	var req models.DeleteRoleRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, models.APIResponse{
+			Success: false,
+			Message: "Missing Details",
+		})
+		return
+	}
+
+	name := strings.ToLower(strings.TrimSpace(req.Name))
+	holders, err := config.GetCollection("admins").CountDocuments(context.Background(), bson.M{"roles": name})
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, models.APIResponse{
+			Success: false,
+			Message: err.Error(),
+		})
+		return
+	}
+	if holders > 0 {
+		c.JSON(http.StatusBadRequest, models.APIResponse{
+			Success: false,
+			Message: "Role is still assigned to admins",
+		})
+		return
+	}
+
+	result, err := config.GetCollection("roles").DeleteOne(context.Background(), bson.M{"name": name, "system": false})
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, models.APIResponse{
+			Success: false,
+			Message: err.Error(),
+		})
+		return
+	}
+	if result.DeletedCount == 0 {
+		c.JSON(http.StatusBadRequest, models.APIResponse{
+			Success: false,
+			Message: "Role not found or built in",
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, models.APIResponse{
+		Success: true,
+		Message: "Role Deleted",
+	})
+}
+
+// SetAdminRoles replaces the roles of another admin
+func SetAdminRoles(c *gin.Context) {
+	var req models.SetAdminRolesRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, models.APIResponse{
+			Success: false,
+			Message: "Missing Details",
+		})
+		return
+	}
+
+	// Changing your own roles could lock every manager out
+	if req.AdminID == c.GetString("adminId") {
+		c.JSON(http.StatusBadRequest, models.APIResponse{
+			Success: false,
+			Message: "You cannot change your own roles",
+		})
+		return
+	}
+
+	if ok, err := utils.RolesExist(req.Roles); err != nil || !ok {
+		c.JSON(http.StatusBadRequest, models.APIResponse{
+			Success: false,
+			Message: "Unknown role",
+		})
+		return
+	}
+
+	if !canAssignRoles(c, req.Roles) {
+		c.JSON(http.StatusForbidden, models.APIResponse{
+			Success: false,
+			Message: "You cannot grant permissions you do not have",
+		})
+		return
+	}
+
+	adminObjectID, _ := primitive.ObjectIDFromHex(req.AdminID)
+	var admin models.Admin
+	if err := config.GetCollection("admins").FindOne(context.Background(), bson.M{"_id": adminObjectID}).Decode(&admin); err != nil {
+		c.JSON(http.StatusBadRequest, models.APIResponse{
+			Success: false,
+			Message: "Admin not found",
+		})
+		return
+	}
+
+	// Taking roles away is limited the same way, so an admin cannot demote someone with more permissions
+	if !canAssignRoles(c, admin.Roles) {
+		c.JSON(http.StatusForbidden, models.APIResponse{
+			Success: false,
+			Message: "You cannot manage an admin with permissions you do not have",
+		})
+		return
+	}
+
+	result, err := config.GetCollection("admins").UpdateOne(
+		context.Background(),
+		bson.M{"_id": adminObjectID},
+		bson.M{"$set": bson.M{"roles": req.Roles}},
+	)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, models.APIResponse{
+			Success: false,
+			Message: err.Error(),
+		})
+		return
+	}
+	if result.MatchedCount == 0 {
+		c.JSON(http.StatusBadRequest, models.APIResponse{
+			Success: false,
+			Message: "Admin not found",
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, models.APIResponse{
+		Success: true,
+		Message: "Admin Roles Updated",
+	})
+}
+
+// canAssignRoles reports whether the logged in admin holds every permission of the roles
+func canAssignRoles(c *gin.Context, roles []string) bool {
+	permissions, err := utils.RolePermissions(roles)
+	return err == nil && utils.CanGrant(c.GetStringSlice("permissions"), permissions)
+}
@@ -0,0 +1,157 @@
+package controllers
+
+import (
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"prescripto-go/models"
+	"prescripto-go/utils"
+)
+
+// sessionClient describes the device a request comes from
+func sessionClient(c *gin.Context) models.SessionClient {
+	return models.SessionClient{
+		UserAgent: c.Request.UserAgent(),
+		IP:        c.ClientIP(),
+	}
+}
+
+// GetSessions lists where the logged in account is signed in
+func GetSessions(c *gin.Context) {
+	sessions, err := utils.ListSessions(c.GetString("principalId"), c.GetString("tokenRole"))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, models.APIResponse{
+			Success: false,
+			Message: err.Error(),
+		})
+		return
+	}
+
+	for i := range sessions {
+		sessions[i].Current = sessions[i].ID == c.GetString("sessionId")
+	}
+
+	c.JSON(http.StatusOK, models.APIResponse{
+		Success: true,
+		Data:    sessions,
+	})
+}
+
+// RevokeOwnSession signs the logged in account out of one of its sessions
+func RevokeOwnSession(c *gin.Context) {
+	var req models.RevokeSessionRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, models.APIResponse{
+			Success: false,
+			Message: "Missing Details",
+		})
+		return
+	}
+
+	// Sessions of other accounts look the same as unknown ones
+	session, err := utils.FindSession(req.SessionID)
+	if err != nil || session.PrincipalID != c.GetString("principalId") || session.Role != c.GetString("tokenRole") {
+		c.JSON(http.StatusBadRequest, models.APIResponse{
+			Success: false,
+			Message: "Session not found",
+		})
+		return
+	}
+
+	if err := utils.RevokeSession(session.ID, "self"); err != nil {
+		c.JSON(http.StatusInternalServerError, models.APIResponse{
+			Success: false,
+			Message: err.Error(),
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, models.APIResponse{
+		Success: true,
+		Message: "Session Revoked",
+	})
+}
+
+// GetAccountSessions lists the active sessions of any account
+func GetAccountSessions(c *gin.Context) {
+	role, accountID := c.Query("role"), c.Query("accountId")
+	if role != utils.RoleUser && role != utils.RoleDoctor && role != utils.RoleAdmin || accountID == "" {
+		c.JSON(http.StatusBadRequest, models.APIResponse{
+			Success: false,
+			Message: "Missing Details",
+		})
+		return
+	}
+
+	sessions, err := utils.ListSessions(accountID, role)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, models.APIResponse{
+			Success: false,
+			Message: err.Error(),
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, models.APIResponse{
+		Success: true,
+		Data:    sessions,
+	})
+}
+
+// TerminateSession signs any account out of one session
+func TerminateSession(c *gin.Context) {
+	var req models.RevokeSessionRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, models.APIResponse{
+			Success: false,
+			Message: "Missing Details",
+		})
+		return
+	}
+
+	if _, err := utils.FindSession(req.SessionID); err != nil {
+		c.JSON(http.StatusBadRequest, models.APIResponse{
+			Success: false,
+			Message: "Session not found",
+		})
+		return
+	}
+
+	if err := utils.RevokeSession(req.SessionID, "admin:"+c.GetString("adminId")); err != nil {
+		c.JSON(http.StatusInternalServerError, models.APIResponse{
+			Success: false,
+			Message: err.Error(),
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, models.APIResponse{
+		Success: true,
+		Message: "Session Terminated",
+	})
+}
+
+// TerminateAllSessions signs any account out everywhere
+func TerminateAllSessions(c *gin.Context) {
+	var req models.TerminateSessionsRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, models.APIResponse{
+			Success: false,
+			Message: "Missing Details",
+		})
+		return
+	}
+
+	if err := utils.RevokeAllSessions(req.AccountID, req.Role, "admin:"+c.GetString("adminId")); err != nil {
+		c.JSON(http.StatusInternalServerError, models.APIResponse{
+			Success: false,
+			Message: err.Error(),
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, models.APIResponse{
+		Success: true,
+		Message: "Sessions Terminated",
+	})
+}
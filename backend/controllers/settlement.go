@@ -0,0 +1,262 @@
+package controllers
+
+import (
+	"bytes"
+	"context"
+	"encoding/csv"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"prescripto-go/config"
+	"prescripto-go/models"
+	"prescripto-go/utils"
+)
+
+// SetCommission sets the platform commission, or a doctor's override when docId is given
+func SetCommission(c *gin.Context) {
+	var req models.SetCommissionRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, models.APIResponse{
+			Success: false,
+			Message: "Missing Details",
+		})
+		return
+	}
+
+	if *req.Percent < 0 || *req.Percent > 100 {
+		c.JSON(http.StatusBadRequest, models.APIResponse{
+			Success: false,
+			Message: "Commission must be between 0 and 100",
+		})
+		return
+	}
+
+	if req.DocID == "" {
+		if err := utils.SetPlatformCommissionPercent(*req.Percent); err != nil {
+			c.JSON(http.StatusInternalServerError, models.APIResponse{
+				Success: false,
+				Message: err.Error(),
+			})
+			return
+		}
+	} else {
+		docObjectID, _ := primitive.ObjectIDFromHex(req.DocID)
+		result, err := config.GetCollection("doctors").UpdateOne(
+			context.Background(),
+			bson.M{"_id": docObjectID},
+			bson.M{"$set": bson.M{"commissionPercent": *req.Percent}},
+		)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, models.APIResponse{
+				Success: false,
+				Message: err.Error(),
+			})
+			return
+		}
+		if result.MatchedCount == 0 {
+			c.JSON(http.StatusBadRequest, models.APIResponse{
+				Success: false,
+				Message: "Doctor not found",
+			})
+			return
+		}
+	}
+
+	c.JSON(http.StatusOK, models.APIResponse{
+		Success: true,
+		Message: "Commission Updated",
+	})
+}
+
+// GenerateSettlements creates payout statements for a period
+func GenerateSettlements(c *gin.Context) {
+	var req models.GenerateSettlementsRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, models.APIResponse{
+			Success: false,
+			Message: "Missing Details",
+		})
+		return
+	}
+
+	if req.PeriodEnd <= req.PeriodStart || req.PeriodEnd > time.Now().Unix() {
+		c.JSON(http.StatusBadRequest, models.APIResponse{
+			Success: false,
+			Message: "Invalid settlement period",
+		})
+		return
+	}
+
+	settlements, err := utils.GenerateSettlements(req.PeriodStart, req.PeriodEnd)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, models.APIResponse{
+			Success: false,
+			Message: err.Error(),
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, models.APIResponse{
+		Success: true,
+		Message: fmt.Sprintf("%d Settlements Generated", len(settlements)),
+		Data:    settlements,
+	})
+}
+
+// GetSettlements lists settlements for admin, optionally filtered by doctor and status
+func GetSettlements(c *gin.Context) {
+	filter := bson.M{}
+	if docID := c.Query("docId"); docID != "" {
+		filter["docId"] = docID
+	}
+	if status := c.Query("status"); status != "" {
+		filter["status"] = status
+	}
+
+	settlements, err := findSettlements(filter)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, models.APIResponse{
+			Success: false,
+			Message: err.Error(),
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, models.APIResponse{
+		Success: true,
+		Data:    settlements,
+	})
+}
+
+// GetDoctorSettlements lists the logged in doctor's settlements
+func GetDoctorSettlements(c *gin.Context) {
+	settlements, err := findSettlements(bson.M{"docId": c.GetString("docId")})
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, models.APIResponse{
+			Success: false,
+			Message: err.Error(),
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, models.APIResponse{
+		Success: true,
+		Data:    settlements,
+	})
+}
+
+func findSettlements(filter bson.M) ([]models.Settlement, error) {
+	if _, ok := filter["status"]; !ok || filter["status"] == models.SettlementStatusDraft {
+		filter["status"] = bson.M{"$ne": models.SettlementStatusDraft}
+	}
+	collection := config.GetCollection("settlements")
+	opts := options.Find().SetSort(bson.D{{Key: "createdAt", Value: -1}})
+	cursor, err := collection.Find(context.Background(), filter, opts)
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(context.Background())
+
+	var settlements []models.Settlement
+	err = cursor.All(context.Background(), &settlements)
+	return settlements, err
+}
+
+// MarkSettlementPaid records that a settlement has been paid out to the doctor
+func MarkSettlementPaid(c *gin.Context) {
+	var req models.MarkSettlementPaidRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, models.APIResponse{
+			Success: false,
+			Message: "Missing Details",
+		})
+		return
+	}
+
+	settlementObjectID, _ := primitive.ObjectIDFromHex(req.SettlementID)
+
+	collection := config.GetCollection("settlements")
+	result, err := collection.UpdateOne(
+		context.Background(),
+		bson.M{"_id": settlementObjectID, "status": models.SettlementStatusPending},
+		bson.M{"$set": bson.M{
+			"status":           models.SettlementStatusPaid,
+			"paymentReference": req.Reference,
+			"paidAt":           time.Now().Unix(),
+		}},
+	)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, models.APIResponse{
+			Success: false,
+			Message: err.Error(),
+		})
+		return
+	}
+	if result.MatchedCount == 0 {
+		c.JSON(http.StatusBadRequest, models.APIResponse{
+			Success: false,
+			Message: "Settlement not found or already paid",
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, models.APIResponse{
+		Success: true,
+		Message: "Settlement Marked Paid",
+	})
+}
+
+// ExportSettlement downloads a settlement statement as CSV
+func ExportSettlement(c *gin.Context) {
+	settlementObjectID, _ := primitive.ObjectIDFromHex(c.Param("settlementId"))
+
+	var settlement models.Settlement
+	err := config.GetCollection("settlements").FindOne(context.Background(),
+		bson.M{"_id": settlementObjectID, "status": bson.M{"$ne": models.SettlementStatusDraft}}).Decode(&settlement)
+	if err != nil {
+		c.JSON(http.StatusNotFound, models.APIResponse{
+			Success: false,
+			Message: "Settlement not found",
+		})
+		return
+	}
+
+	var buf bytes.Buffer
+	w := csv.NewWriter(&buf)
+	period := func(ts int64) string { return time.Unix(ts, 0).UTC().Format("2006-01-02") }
+
+	w.Write([]string{"Doctor", settlement.DoctorName})
+	w.Write([]string{"Period", period(settlement.PeriodStart), period(settlement.PeriodEnd)})
+	w.Write([]string{"Commission %", fmt.Sprintf("%g", settlement.CommissionPercent)})
+	w.Write([]string{"Status", settlement.Status, settlement.PaymentReference})
+	w.Write(nil)
+	w.Write([]string{"Appointment", "Slot Date", "Patient", "Gross", "Commission", "Refund", "Refunded Fee", "Commission Reversed", "Deduction", "Currency"})
+	for _, line := range settlement.Lines {
+		w.Write([]string{
+			line.AppointmentID,
+			line.SlotDate,
+			line.PatientName,
+			line.Gross.Decimal(),
+			line.Commission.Decimal(),
+			line.Refund.Decimal(),
+			line.RefundedFee.Decimal(),
+			line.CommissionReversed.Decimal(),
+			line.Deduction.Decimal(),
+			settlement.Gross.Currency,
+		})
+	}
+	w.Write(nil)
+	w.Write([]string{"Gross", settlement.Gross.Decimal()})
+	w.Write([]string{"Commission", settlement.Commission.Decimal()})
+	w.Write([]string{"Refunds", settlement.Refunds.Decimal()})
+	w.Write([]string{"Net Payable", settlement.NetPayable.Decimal()})
+	w.Flush()
+
+	c.Header("Content-Disposition", `attachment; filename="settlement-`+settlement.ID.Hex()+`.csv"`)
+	c.Data(http.StatusOK, "text/csv", buf.Bytes())
+}
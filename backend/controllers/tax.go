@@ -0,0 +1,293 @@
+package controllers
+
+import (
+	"context"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"prescripto-go/config"
+	"prescripto-go/models"
+)
+
+// AddTaxRule creates a tax rule applied to new appointments
+func AddTaxRule(c *gin.Context) {
+	var req models.AddTaxRuleRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, models.APIResponse{
+			Success: false,
+			Message: "Missing Details",
+		})
+		return
+	}
+
+	if *req.Rate <= 0 || *req.Rate > 100 {
+		c.JSON(http.StatusBadRequest, models.APIResponse{
+			Success: false,
+			Message: "Tax rate must be between 0 and 100",
+		})
+		return
+	}
+
+	rule := models.TaxRule{
+		Name:         req.Name,
+		Jurisdiction: strings.ToUpper(strings.TrimSpace(req.Jurisdiction)),
+		ServiceType:  req.ServiceType,
+		Rate:         *req.Rate,
+		Inclusive:    req.Inclusive,
+		Active:       true,
+		CreatedAt:    time.Now().Unix(),
+	}
+
+	result, err := config.GetCollection("tax_rules").InsertOne(context.Background(), rule)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, models.APIResponse{
+			Success: false,
+			Message: err.Error(),
+		})
+		return
+	}
+	rule.ID = result.InsertedID.(primitive.ObjectID)
+
+	c.JSON(http.StatusOK, models.APIResponse{
+		Success: true,
+		Message: "Tax Rule Added",
+		Data:    rule,
+	})
+}
+
+// GetTaxRules lists all tax rules for admin
+func GetTaxRules(c *gin.Context) {
+	opts := options.Find().SetSort(bson.D{{Key: "jurisdiction", Value: 1}, {Key: "serviceType", Value: 1}})
+	cursor, err := config.GetCollection("tax_rules").Find(context.Background(), bson.M{}, opts)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, models.APIResponse{
+			Success: false,
+			Message: err.Error(),
+		})
+		return
+	}
+	defer cursor.Close(context.Background())
+
+	var rules []models.TaxRule
+	if err = cursor.All(context.Background(), &rules); err != nil {
+		c.JSON(http.StatusInternalServerError, models.APIResponse{
+			Success: false,
+			Message: err.Error(),
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, models.APIResponse{
+		Success: true,
+		Data:    rules,
+	})
+}
+
+// ChangeTaxRuleStatus activates or deactivates a tax rule
+func ChangeTaxRuleStatus(c *gin.Context) {
+	var req models.ChangeTaxRuleStatusRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, models.APIResponse{
+			Success: false,
+			Message: "Missing Details",
+		})
+		return
+	}
+
+	ruleObjectID, _ := primitive.ObjectIDFromHex(req.TaxRuleID)
+	result, err := config.GetCollection("tax_rules").UpdateOne(
+		context.Background(),
+		bson.M{"_id": ruleObjectID},
+		bson.M{"$set": bson.M{"active": req.Active}},
+	)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, models.APIResponse{
+			Success: false,
+			Message: err.Error(),
+		})
+		return
+	}
+	if result.MatchedCount == 0 {
+		c.JSON(http.StatusBadRequest, models.APIResponse{
+			Success: false,
+			Message: "Tax rule not found",
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, models.APIResponse{
+		Success: true,
+		Message: "Tax Rule Updated",
+	})
+}
+
+// SetTaxJurisdiction sets the jurisdiction whose tax rules apply to a doctor's fees
+func SetTaxJurisdiction(c *gin.Context) {
+	var req models.SetTaxJurisdictionRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, models.APIResponse{
+			Success: false,
+			Message: "Missing Details",
+		})
+		return
+	}
+
+	update := bson.M{"$unset": bson.M{"taxJurisdiction": ""}}
+	if jurisdiction := strings.ToUpper(strings.TrimSpace(req.Jurisdiction)); jurisdiction != "" {
+		update = bson.M{"$set": bson.M{"taxJurisdiction": jurisdiction}}
+	}
+
+	docObjectID, _ := primitive.ObjectIDFromHex(req.DocID)
+	result, err := config.GetCollection("doctors").UpdateOne(context.Background(), bson.M{"_id": docObjectID}, update)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, models.APIResponse{
+			Success: false,
+			Message: err.Error(),
+		})
+		return
+	}
+	if result.MatchedCount == 0 {
+		c.JSON(http.StatusBadRequest, models.APIResponse{
+			Success: false,
+			Message: "Doctor not found",
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, models.APIResponse{
+		Success: true,
+		Message: "Tax Jurisdiction Updated",
+	})
+}
+
+// GetRevenueReport summarises fees, discounts and tax of appointments paid
+// between the from and to unix timestamps, defaulting to the last 30 days
+func GetRevenueReport(c *gin.Context) {
+	end, err := strconv.ParseInt(c.DefaultQuery("to", "0"), 10, 64)
+	if err != nil || end <= 0 {
+		end = time.Now().Unix()
+	}
+	start, err := strconv.ParseInt(c.DefaultQuery("from", "0"), 10, 64)
+	if err != nil || start <= 0 {
+		start = end - 30*24*3600
+	}
+
+	cursor, err := config.GetCollection("appointments").Find(context.Background(), bson.M{
+		"payment": true,
+		"paidAt":  bson.M{"$gte": start, "$lt": end},
+	})
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, models.APIResponse{
+			Success: false,
+			Message: err.Error(),
+		})
+		return
+	}
+	defer cursor.Close(context.Background())
+
+	var appointments []models.Appointment
+	if err = cursor.All(context.Background(), &appointments); err != nil {
+		c.JSON(http.StatusInternalServerError, models.APIResponse{
+			Success: false,
+			Message: err.Error(),
+		})
+		return
+	}
+
+	report := models.RevenueReport{PeriodStart: start, PeriodEnd: end, Currencies: []models.RevenueSummary{}}
+	summaries := make(map[string]*models.RevenueSummary)
+	for _, appointment := range appointments {
+		currency := appointment.Amount.Currency
+		summary, ok := summaries[currency]
+		if !ok {
+			summary = &models.RevenueSummary{
+				Currency:  currency,
+				Fees:      models.NewMoney(0, currency),
+				Discounts: models.NewMoney(0, currency),
+				Tax:       models.NewMoney(0, currency),
+				TaxLines:  []models.TaxLine{},
+				Collected: models.NewMoney(0, currency),
+			}
+			summaries[currency] = summary
+		}
+
+		if err := addRevenue(summary, &appointment); err != nil {
+			report.Inconsistent = append(report.Inconsistent, appointment.ID.Hex())
+		}
+	}
+
+	for _, summary := range summaries {
+		report.Currencies = append(report.Currencies, *summary)
+	}
+	sort.Slice(report.Currencies, func(i, j int) bool {
+		return report.Currencies[i].Currency < report.Currencies[j].Currency
+	})
+
+	c.JSON(http.StatusOK, models.APIResponse{
+		Success: true,
+		Data:    report,
+	})
+}
+
+// addRevenue adds an appointment to the summary of its currency. The summary
+// is left unchanged when the appointment's amounts cannot be combined.
+func addRevenue(summary *models.RevenueSummary, appointment *models.Appointment) error {
+	updated := *summary
+	var err error
+	if updated.Fees, err = updated.Fees.Add(appointment.Amount); err != nil {
+		return err
+	}
+	if updated.Discounts, err = updated.Discounts.Add(appointment.Discount); err != nil {
+		return err
+	}
+	tax, err := appointment.TaxTotal()
+	if err != nil {
+		return err
+	}
+	if updated.Tax, err = updated.Tax.Add(tax); err != nil {
+		return err
+	}
+	total, err := appointment.Total()
+	if err != nil {
+		return err
+	}
+	if updated.Collected, err = updated.Collected.Add(total); err != nil {
+		return err
+	}
+	if updated.TaxLines, err = addTaxLines(updated.TaxLines, appointment.Tax); err != nil {
+		return err
+	}
+	updated.Appointments++
+	*summary = updated
+	return nil
+}
+
+// addTaxLines adds tax lines into totals kept per tax name, rate and pricing
+func addTaxLines(totals, lines []models.TaxLine) ([]models.TaxLine, error) {
+	totals = append([]models.TaxLine(nil), totals...)
+	for _, line := range lines {
+		found := false
+		for i := range totals {
+			if totals[i].Name == line.Name && totals[i].Rate == line.Rate && totals[i].Inclusive == line.Inclusive {
+				amount, err := totals[i].Amount.Add(line.Amount)
+				if err != nil {
+					return nil, err
+				}
+				totals[i].Amount = amount
+				found = true
+				break
+			}
+		}
+		if !found {
+			totals = append(totals, line)
+		}
+	}
+	return totals, nil
+}
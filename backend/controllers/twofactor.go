@@ -0,0 +1,367 @@
+package controllers
+
+import (
+	"context"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"prescripto-go/config"
+	"prescripto-go/models"
+	"prescripto-go/utils"
+)
+
+// completeLogin finishes a login whose password was correct. Accounts with
+// 2FA, or that policy requires to enroll, get a challenge token instead of
+// access tokens.
+func completeLogin(c *gin.Context, id, role string) {
+	enabled, err := utils.TwoFactorEnabled(role, id)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, models.APIResponse{
+			Success: false,
+			Message: err.Error(),
+		})
+		return
+	}
+
+	purpose := ""
+	switch {
+	case enabled:
+		purpose = utils.ChallengeVerify
+	case utils.TwoFactorRequired(role):
+		purpose = utils.ChallengeEnroll
+	default:
+		issueLoginTokens(c, id, role, nil)
+		return
+	}
+
+	challenge, err := utils.IssueLoginChallenge(id, role, purpose)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, models.APIResponse{
+			Success: false,
+			Message: "Error generating token",
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, models.APIResponse{
+		Success: true,
+		Data: models.LoginChallenge{
+			TwoFactorRequired:  true,
+			EnrollmentRequired: purpose == utils.ChallengeEnroll,
+			ChallengeToken:     challenge,
+		},
+	})
+}
+
+// issueLoginTokens starts a session for a fully authenticated account.
+// Failed attempts are only cleared here, after the second factor, so a
+// stolen password does not buy unlimited guesses at codes.
+func issueLoginTokens(c *gin.Context, id, role string, data interface{}) {
+	if email, err := utils.AccountEmail(role, id); err == nil {
+		loginSucceeded(role, email)
+	}
+
+	token, refresh, err := utils.IssueTokens(id, role, sessionClient(c))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, models.APIResponse{
+			Success: false,
+			Message: "Error generating token",
+		})
+		return
+	}
+
+	if role == utils.RoleAdmin {
+		adminObjectID, _ := primitive.ObjectIDFromHex(id)
+		config.GetCollection("admins").UpdateOne(
+			context.Background(),
+			bson.M{"_id": adminObjectID},
+			bson.M{"$set": bson.M{"lastLoginAt": time.Now().Unix()}},
+		)
+	}
+
+	c.JSON(http.StatusOK, models.APIResponse{
+		Success:      true,
+		Token:        token,
+		RefreshToken: refresh,
+		Data:         data,
+	})
+}
+
+// VerifyLoginTwoFactor completes a login with an authenticator or recovery code
+func VerifyLoginTwoFactor(c *gin.Context) {
+	var req models.TwoFactorChallengeRequest
+	if err := c.ShouldBindJSON(&req); err != nil || req.Code == "" {
+		c.JSON(http.StatusBadRequest, models.APIResponse{
+			Success: false,
+			Message: "Missing Details",
+		})
+		return
+	}
+
+	id, role, err := utils.ParseLoginChallenge(req.ChallengeToken, utils.ChallengeVerify)
+	if err != nil {
+		c.JSON(http.StatusUnauthorized, models.APIResponse{
+			Success: false,
+			Message: "Login expired, please login again",
+		})
+		return
+	}
+
+	// Code guesses count towards the same lockout as passwords
+	email, err := utils.AccountEmail(role, id)
+	if err != nil {
+		c.JSON(http.StatusUnauthorized, models.APIResponse{
+			Success: false,
+			Message: "Login expired, please login again",
+		})
+		return
+	}
+	if loginLocked(c, role, email) {
+		return
+	}
+
+	if err := utils.VerifySecondFactor(role, id, req.Code); err != nil {
+		if err == utils.ErrInvalidTwoFactor {
+			if err := utils.RecordLoginFailure(role, email, c.ClientIP()); err != nil {
+				log.Printf("Failed to record login failure: %v", err)
+			}
+		}
+		twoFactorError(c, err)
+		return
+	}
+
+	issueLoginTokens(c, id, role, nil)
+}
+
+// StartLoginEnrollment sets up 2FA during a login that policy requires it for
+func StartLoginEnrollment(c *gin.Context) {
+	var req models.TwoFactorChallengeRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, models.APIResponse{
+			Success: false,
+			Message: "Missing Details",
+		})
+		return
+	}
+
+	id, role, err := utils.ParseLoginChallenge(req.ChallengeToken, utils.ChallengeEnroll)
+	if err != nil {
+		c.JSON(http.StatusUnauthorized, models.APIResponse{
+			Success: false,
+			Message: "Login expired, please login again",
+		})
+		return
+	}
+
+	startTwoFactor(c, id, role)
+}
+
+// ConfirmLoginEnrollment enables 2FA during a login and completes it
+func ConfirmLoginEnrollment(c *gin.Context) {
+	var req models.TwoFactorChallengeRequest
+	if err := c.ShouldBindJSON(&req); err != nil || req.Code == "" {
+		c.JSON(http.StatusBadRequest, models.APIResponse{
+			Success: false,
+			Message: "Missing Details",
+		})
+		return
+	}
+
+	id, role, err := utils.ParseLoginChallenge(req.ChallengeToken, utils.ChallengeEnroll)
+	if err != nil {
+		c.JSON(http.StatusUnauthorized, models.APIResponse{
+			Success: false,
+			Message: "Login expired, please login again",
+		})
+		return
+	}
+
+	codes, err := utils.ConfirmTwoFactor(role, id, req.Code)
+	if err != nil {
+		twoFactorError(c, err)
+		return
+	}
+
+	issueLoginTokens(c, id, role, gin.H{"recoveryCodes": codes})
+}
+
+// SetupTwoFactor starts 2FA enrollment for the logged in doctor or admin
+func SetupTwoFactor(c *gin.Context) {
+	startTwoFactor(c, c.GetString("principalId"), c.GetString("tokenRole"))
+}
+
+// ConfirmTwoFactor enables 2FA for the logged in doctor or admin and returns the recovery codes
+func ConfirmTwoFactor(c *gin.Context) {
+	var req models.TwoFactorCodeRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, models.APIResponse{
+			Success: false,
+			Message: "Missing Details",
+		})
+		return
+	}
+
+	codes, err := utils.ConfirmTwoFactor(c.GetString("tokenRole"), c.GetString("principalId"), req.Code)
+	if err != nil {
+		twoFactorError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, models.APIResponse{
+		Success: true,
+		Message: "Two-Factor Authentication Enabled",
+		Data:    gin.H{"recoveryCodes": codes},
+	})
+}
+
+// DisableTwoFactor turns off 2FA for the logged in account after checking a code
+func DisableTwoFactor(c *gin.Context) {
+	var req models.TwoFactorCodeRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, models.APIResponse{
+			Success: false,
+			Message: "Missing Details",
+		})
+		return
+	}
+
+	id, role := c.GetString("principalId"), c.GetString("tokenRole")
+	if utils.TwoFactorRequired(role) {
+		c.JSON(http.StatusBadRequest, models.APIResponse{
+			Success: false,
+			Message: "Two-factor authentication is required for your account",
+		})
+		return
+	}
+
+	if err := utils.VerifySecondFactor(role, id, req.Code); err != nil {
+		twoFactorError(c, err)
+		return
+	}
+
+	if _, err := utils.RemoveTwoFactor(role, id); err != nil {
+		c.JSON(http.StatusInternalServerError, models.APIResponse{
+			Success: false,
+			Message: err.Error(),
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, models.APIResponse{
+		Success: true,
+		Message: "Two-Factor Authentication Disabled",
+	})
+}
+
+// ResetTwoFactor removes the second factor of a doctor or admin who lost
+// their device and logs them out everywhere. They enroll again at next login.
+func ResetTwoFactor(c *gin.Context) {
+	var req models.ResetTwoFactorRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, models.APIResponse{
+			Success: false,
+			Message: "Missing Details",
+		})
+		return
+	}
+
+	// Admins turn off their own second factor with a code instead
+	if req.Role == utils.RoleAdmin && req.AccountID == c.GetString("adminId") {
+		c.JSON(http.StatusBadRequest, models.APIResponse{
+			Success: false,
+			Message: "You cannot reset your own second factor",
+		})
+		return
+	}
+
+	// Only admins holding every permission of another admin may reset theirs
+	if req.Role == utils.RoleAdmin {
+		adminObjectID, _ := primitive.ObjectIDFromHex(req.AccountID)
+		var admin models.Admin
+		if err := config.GetCollection("admins").FindOne(context.Background(), bson.M{"_id": adminObjectID}).Decode(&admin); err != nil {
+			c.JSON(http.StatusBadRequest, models.APIResponse{
+				Success: false,
+				Message: "Admin not found",
+			})
+			return
+		}
+		if !canAssignRoles(c, admin.Roles) {
+			c.JSON(http.StatusForbidden, models.APIResponse{
+				Success: false,
+				Message: "You cannot manage an admin with permissions you do not have",
+			})
+			return
+		}
+	}
+
+	removed, err := utils.RemoveTwoFactor(req.Role, req.AccountID)
+	if err == nil && removed {
+		err = utils.RevokeAllSessions(req.AccountID, req.Role, "admin:"+c.GetString("adminId"))
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, models.APIResponse{
+			Success: false,
+			Message: err.Error(),
+		})
+		return
+	}
+	if !removed {
+		c.JSON(http.StatusBadRequest, models.APIResponse{
+			Success: false,
+			Message: "Account does not use two-factor authentication",
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, models.APIResponse{
+		Success: true,
+		Message: "Two-Factor Authentication Reset",
+	})
+}
+
+// startTwoFactor creates a new secret and returns it with its provisioning URI
+func startTwoFactor(c *gin.Context, id, role string) {
+	email, err := utils.AccountEmail(role, id)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, models.APIResponse{
+			Success: false,
+			Message: "Account not found",
+		})
+		return
+	}
+
+	setup, err := utils.StartTwoFactorEnrollment(role, id, email)
+	if err != nil {
+		status := http.StatusInternalServerError
+		if err == utils.ErrTwoFactorEnabled {
+			status = http.StatusBadRequest
+		}
+		c.JSON(status, models.APIResponse{
+			Success: false,
+			Message: err.Error(),
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, models.APIResponse{
+		Success: true,
+		Data:    setup,
+	})
+}
+
+// twoFactorError responds to a failed 2FA operation
+func twoFactorError(c *gin.Context, err error) {
+	status := http.StatusInternalServerError
+	switch err {
+	case utils.ErrInvalidTwoFactor, utils.ErrTwoFactorEnabled, utils.ErrTwoFactorNotStarted:
+		status = http.StatusBadRequest
+	}
+	c.JSON(status, models.APIResponse{
+		Success: false,
+		Message: err.Error(),
+	})
+}
@@ -3,8 +3,10 @@ package controllers
 import (
 	"context"
 	"fmt"
+	"log"
 	"net/http"
 	"os"
+	"strings"
 	"time"
 
 	"prescripto-go/config"
@@ -12,7 +14,6 @@ import (
 	"prescripto-go/utils"
 
 	"github.com/gin-gonic/gin"
-	"github.com/razorpay/razorpay-go"
 	"github.com/stripe/stripe-go/v72"
 	"github.com/stripe/stripe-go/v72/checkout/session"
 	"go.mongodb.org/mongo-driver/bson"
@@ -31,6 +32,9 @@ func RegisterUser(c *gin.Context) {
 		return
 	}
 
+	// Emails are stored lower-case so one address cannot register twice
+	req.Email = utils.NormalizeEmail(req.Email)
+
 	// Validate email format
 	if !utils.IsValidEmail(req.Email) {
 		c.JSON(http.StatusBadRequest, models.APIResponse{
@@ -40,11 +44,15 @@ func RegisterUser(c *gin.Context) {
 		return
 	}
 
-	// Validate password length
-	if len(req.Password) < 8 {
-		c.JSON(http.StatusBadRequest, models.APIResponse{
+	// Validate password against the policy
+	if err := utils.ValidatePassword(req.Password, req.Name, req.Email); err != nil {
+		status := http.StatusInternalServerError
+		if _, ok := err.(*utils.PasswordPolicyError); ok {
+			status = http.StatusBadRequest
+		}
+		c.JSON(status, models.APIResponse{
 			Success: false,
-			Message: "Please enter a strong password",
+			Message: err.Error(),
 		})
 		return
 	}
@@ -82,8 +90,14 @@ func RegisterUser(c *gin.Context) {
 		return
 	}
 
+	// A failed email does not fail registration, the user can ask for it again
+	user.ID = result.InsertedID.(primitive.ObjectID)
+	if err := utils.SendEmailVerification(user); err != nil {
+		log.Printf("Failed to send verification email to user %s: %v", user.ID.Hex(), err)
+	}
+
 	// Generate JWT token
-	token, err := utils.GenerateJWT(result.InsertedID.(primitive.ObjectID).Hex())
+	token, refresh, err := utils.IssueTokens(user.ID.Hex(), utils.RoleUser, sessionClient(c))
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, models.APIResponse{
 			Success: false,
@@ -93,8 +107,9 @@ func RegisterUser(c *gin.Context) {
 	}
 
 	c.JSON(http.StatusOK, models.APIResponse{
-		Success: true,
-		Token:   token,
+		Success:      true,
+		Token:        token,
+		RefreshToken: refresh,
 	})
 }
 
@@ -109,28 +124,23 @@ func LoginUser(c *gin.Context) {
 		return
 	}
 
-	collection := config.GetCollection("users")
-	var user models.User
-	err := collection.FindOne(context.Background(), bson.M{"email": req.Email}).Decode(&user)
-	if err != nil {
-		c.JSON(http.StatusBadRequest, models.APIResponse{
-			Success: false,
-			Message: "User does not exist",
-		})
+	email := utils.NormalizeEmail(req.Email)
+	if loginLocked(c, utils.RoleUser, email) {
 		return
 	}
 
-	// Check password
-	if !utils.CheckPasswordHash(req.Password, user.Password) {
-		c.JSON(http.StatusBadRequest, models.APIResponse{
-			Success: false,
-			Message: "Invalid credentials",
-		})
+	// An unknown email still goes through a hash comparison
+	collection := config.GetCollection("users")
+	var user models.User
+	err := collection.FindOne(context.Background(), bson.M{"email": email}).Decode(&user)
+	if !utils.CheckPasswordHash(req.Password, user.Password) || err != nil {
+		loginFailed(c, utils.RoleUser, email)
 		return
 	}
+	loginSucceeded(utils.RoleUser, email)
 
 	// Generate JWT token
-	token, err := utils.GenerateJWT(user.ID.Hex())
+	token, refresh, err := utils.IssueTokens(user.ID.Hex(), utils.RoleUser, sessionClient(c))
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, models.APIResponse{
 			Success: false,
@@ -140,8 +150,9 @@ func LoginUser(c *gin.Context) {
 	}
 
 	c.JSON(http.StatusOK, models.APIResponse{
-		Success: true,
-		Token:   token,
+		Success:      true,
+		Token:        token,
+		RefreshToken: refresh,
 	})
 }
 
@@ -249,6 +260,14 @@ func UpdateProfile(c *gin.Context) {
 		bson.M{"_id": objectID},
 		bson.M{"$set": updateData},
 	)
+	if err == nil {
+		// A new number has to be verified again before it logs in
+		_, err = collection.UpdateOne(
+			context.Background(),
+			bson.M{"_id": objectID, "verifiedPhone": bson.M{"$exists": true, "$ne": utils.NormalizePhone(req.Phone)}},
+			bson.M{"$unset": bson.M{"verifiedPhone": ""}},
+		)
+	}
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, models.APIResponse{
 			Success: false,
@@ -275,6 +294,25 @@ func BookAppointment(c *gin.Context) {
 		return
 	}
 
+	if utils.EmailVerificationRequired() {
+		userObjectID, _ := primitive.ObjectIDFromHex(userID)
+		var user models.User
+		err := config.GetCollection("users").FindOne(context.Background(), bson.M{"_id": userObjectID}).Decode(&user)
+		if err != nil || !user.EmailVerified {
+			c.JSON(http.StatusForbidden, models.APIResponse{
+				Success: false,
+				Message: "Please verify your email before booking",
+			})
+			return
+		}
+	}
+
+	bookAppointment(c, userID, req, "")
+}
+
+// bookAppointment books a slot for a user and records who booked it when it
+// was not the user
+func bookAppointment(c *gin.Context, userID string, req models.BookAppointmentRequest, bookedBy string) {
 	docObjectID, _ := primitive.ObjectIDFromHex(req.DocID)
 	userObjectID, _ := primitive.ObjectIDFromHex(userID)
 
@@ -324,20 +362,33 @@ func BookAppointment(c *gin.Context) {
 		return
 	}
 
+	// Tax is fixed when booking so later rule changes do not alter the price
+	tax, err := utils.AppointmentTax(&doctor, models.ServiceConsultation, doctor.Fees)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, models.APIResponse{
+			Success: false,
+			Message: err.Error(),
+		})
+		return
+	}
+
 	// Create appointment
 	appointment := models.Appointment{
-		UserID:   userID,
-		DocID:    req.DocID,
-		UserData: user,
-		DocData:  doctor,
-		Amount:   doctor.Fees,
-		SlotTime: req.SlotTime,
-		SlotDate: req.SlotDate,
-		Date:     time.Now().Unix(),
+		UserID:      userID,
+		DocID:       req.DocID,
+		UserData:    user,
+		DocData:     doctor,
+		Amount:      doctor.Fees,
+		ServiceType: models.ServiceConsultation,
+		Tax:         tax,
+		SlotTime:    req.SlotTime,
+		SlotDate:    req.SlotDate,
+		Date:        time.Now().Unix(),
+		BookedBy:    bookedBy,
 	}
 
 	appointmentCollection := config.GetCollection("appointments")
-	_, err = appointmentCollection.InsertOne(context.Background(), appointment)
+	result, err := appointmentCollection.InsertOne(context.Background(), appointment)
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, models.APIResponse{
 			Success: false,
@@ -363,6 +414,7 @@ func BookAppointment(c *gin.Context) {
 	c.JSON(http.StatusOK, models.APIResponse{
 		Success: true,
 		Message: "Appointment Booked",
+		Data:    gin.H{"appointmentId": result.InsertedID.(primitive.ObjectID).Hex()},
 	})
 }
 
@@ -401,6 +453,14 @@ func CancelAppointment(c *gin.Context) {
 		return
 	}
 
+	if req.RefundToWallet && (appointment.Cancelled || appointment.IsCompleted) {
+		c.JSON(http.StatusBadRequest, models.APIResponse{
+			Success: false,
+			Message: "Completed or cancelled appointments cannot be refunded",
+		})
+		return
+	}
+
 	// Cancel appointment
 	_, err = appointmentCollection.UpdateOne(
 		context.Background(),
@@ -415,6 +475,19 @@ func CancelAppointment(c *gin.Context) {
 		return
 	}
 
+	utils.ReleaseAppointmentCoupon(&appointment)
+	utils.ReleaseAppointmentWallet(&appointment)
+
+	if req.RefundToWallet {
+		if err := utils.RefundCancellationToWallet(&appointment, userID); err != nil {
+			c.JSON(http.StatusBadRequest, models.APIResponse{
+				Success: false,
+				Message: "Appointment Cancelled, but the refund failed: " + err.Error(),
+			})
+			return
+		}
+	}
+
 	// Release doctor slot
 	docObjectID, _ := primitive.ObjectIDFromHex(appointment.DocID)
 	doctorCollection := config.GetCollection("doctors")
@@ -469,6 +542,7 @@ func ListAppointments(c *gin.Context) {
 
 // PaymentRazorpay creates Razorpay order
 func PaymentRazorpay(c *gin.Context) {
+	userID := c.GetString("userId")
 	var req models.PaymentRequest
 	if err := c.ShouldBindJSON(&req); err != nil {
 		c.JSON(http.StatusBadRequest, models.APIResponse{
@@ -492,18 +566,80 @@ func PaymentRazorpay(c *gin.Context) {
 		return
 	}
 
-	// Initialize Razorpay client
-	client := razorpay.NewClient(os.Getenv("RAZORPAY_KEY_ID"), os.Getenv("RAZORPAY_KEY_SECRET"))
+	if appointment.UserID != userID {
+		c.JSON(http.StatusUnauthorized, models.APIResponse{
+			Success: false,
+			Message: "Unauthorized action",
+		})
+		return
+	}
+
+	if appointment.Payment {
+		c.JSON(http.StatusBadRequest, models.APIResponse{
+			Success: false,
+			Message: "Appointment already paid",
+		})
+		return
+	}
+
+	amount, err := appointment.AmountDue()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, models.APIResponse{
+			Success: false,
+			Message: err.Error(),
+		})
+		return
+	}
+
+	// Retries with the same key return the order created by the first attempt
+	// and keys are namespaced per user so they cannot collide with another user's
+	idempotencyKey := c.GetHeader("Idempotency-Key")
+	if idempotencyKey == "" {
+		idempotencyKey = fmt.Sprintf("%s:%s:%d%s", models.GatewayRazorpay, req.AppointmentID, amount.Amount, amount.Currency)
+	}
+	idempotencyKey = "user:" + userID + ":" + idempotencyKey
+
+	tx := &models.PaymentTransaction{
+		AppointmentID:  req.AppointmentID,
+		UserID:         userID,
+		Gateway:        models.GatewayRazorpay,
+		Type:           models.PaymentTypeOrder,
+		Amount:         amount,
+		IdempotencyKey: idempotencyKey,
+	}
+	existing, claimed, err := utils.ClaimIdempotencyKey(tx)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, models.APIResponse{
+			Success: false,
+			Message: err.Error(),
+		})
+		return
+	}
+	if !claimed {
+		if existing.Status == models.PaymentStatusCreated {
+			c.JSON(http.StatusOK, models.APIResponse{
+				Success: true,
+				Data:    existing.Response,
+			})
+			return
+		}
+		c.JSON(http.StatusConflict, models.APIResponse{
+			Success: false,
+			Message: "Payment already in progress",
+		})
+		return
+	}
 
 	// Create order
 	data := map[string]interface{}{
-		"amount":   int(appointment.Amount * 100), // Amount in paise
-		"currency": os.Getenv("CURRENCY"),
+		"amount":   amount.Amount, // Amount in the currency's minor unit
+		"currency": amount.Currency,
 		"receipt":  req.AppointmentID,
 	}
 
-	order, err := client.Order.Create(data, nil)
+	order, err := utils.RazorpayClient().Order.Create(data, nil)
 	if err != nil {
+		utils.FailPayment(tx.ID, err)
 		c.JSON(http.StatusInternalServerError, models.APIResponse{
 			Success: false,
 			Message: err.Error(),
@@ -511,6 +647,13 @@ func PaymentRazorpay(c *gin.Context) {
 		return
 	}
 
+	orderID, _ := order["id"].(string)
+	utils.UpdatePayment(tx.ID, bson.M{
+		"status":         models.PaymentStatusCreated,
+		"gatewayOrderId": orderID,
+		"response":       order,
+	})
+
 	c.JSON(http.StatusOK, models.APIResponse{
 		Success: true,
 		Data:    order,
@@ -519,6 +662,7 @@ func PaymentRazorpay(c *gin.Context) {
 
 // VerifyRazorpay verifies Razorpay payment
 func VerifyRazorpay(c *gin.Context) {
+	userID := c.GetString("userId")
 	var req models.VerifyPaymentRequest
 	if err := c.ShouldBindJSON(&req); err != nil {
 		c.JSON(http.StatusBadRequest, models.APIResponse{
@@ -527,12 +671,27 @@ func VerifyRazorpay(c *gin.Context) {
 		})
 		return
 	}
+	if req.RazorpayOrderID == "" || req.RazorpayPaymentID == "" {
+		c.JSON(http.StatusBadRequest, models.APIResponse{
+			Success: false,
+			Message: "Missing Details",
+		})
+		return
+	}
 
-	// Initialize Razorpay client
-	client := razorpay.NewClient(os.Getenv("RAZORPAY_KEY_ID"), os.Getenv("RAZORPAY_KEY_SECRET"))
+	// Checkout signs the order and payment ids with the key secret, which
+	// proves the payment id belongs to this order
+	signed := []byte(req.RazorpayOrderID + "|" + req.RazorpayPaymentID)
+	if !utils.VerifyHMACSignature(signed, req.RazorpaySignature, os.Getenv("RAZORPAY_KEY_SECRET")) {
+		c.JSON(http.StatusBadRequest, models.APIResponse{
+			Success: false,
+			Message: "Invalid payment signature",
+		})
+		return
+	}
 
 	// Fetch order info
-	order, err := client.Order.Fetch(req.RazorpayOrderID, nil, nil)
+	order, err := utils.RazorpayClient().Order.Fetch(req.RazorpayOrderID, nil, nil)
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, models.APIResponse{
 			Success: false,
@@ -541,27 +700,27 @@ func VerifyRazorpay(c *gin.Context) {
 		return
 	}
 
-	if order["status"] == "paid" {
-		appointmentObjectID, _ := primitive.ObjectIDFromHex(order["receipt"].(string))
-		appointmentCollection := config.GetCollection("appointments")
-		_, err = appointmentCollection.UpdateOne(
-			context.Background(),
-			bson.M{"_id": appointmentObjectID},
-			bson.M{"$set": bson.M{"payment": true}},
-		)
-		if err != nil {
-			c.JSON(http.StatusInternalServerError, models.APIResponse{
-				Success: false,
-				Message: err.Error(),
-			})
-			return
-		}
+	appointmentID, _ := order["receipt"].(string)
+	tx := &models.PaymentTransaction{
+		AppointmentID:    appointmentID,
+		UserID:           userID,
+		Gateway:          models.GatewayRazorpay,
+		Type:             models.PaymentTypeVerification,
+		GatewayOrderID:   req.RazorpayOrderID,
+		GatewayPaymentID: req.RazorpayPaymentID,
+		Response:         order,
+	}
+	if amount, ok := order["amount_paid"].(float64); ok {
+		tx.Amount = models.NewMoney(int64(amount), fmt.Sprint(order["currency"]))
+	}
 
-		c.JSON(http.StatusOK, models.APIResponse{
-			Success: true,
-			Message: "Payment Successful",
-		})
+	if order["status"] == "paid" {
+		recordVerifiedPayment(c, tx)
 	} else {
+		tx.Status = models.PaymentStatusFailed
+		tx.Error = fmt.Sprintf("order status %v", order["status"])
+		utils.RecordPayment(tx)
+
 		c.JSON(http.StatusBadRequest, models.APIResponse{
 			Success: false,
 			Message: "Payment Failed",
@@ -571,6 +730,7 @@ func VerifyRazorpay(c *gin.Context) {
 
 // PaymentStripe creates Stripe checkout session
 func PaymentStripe(c *gin.Context) {
+	userID := c.GetString("userId")
 	var req models.PaymentRequest
 	if err := c.ShouldBindJSON(&req); err != nil {
 		c.JSON(http.StatusBadRequest, models.APIResponse{
@@ -595,6 +755,70 @@ func PaymentStripe(c *gin.Context) {
 		return
 	}
 
+	if appointment.UserID != userID {
+		c.JSON(http.StatusUnauthorized, models.APIResponse{
+			Success: false,
+			Message: "Unauthorized action",
+		})
+		return
+	}
+
+	if appointment.Payment {
+		c.JSON(http.StatusBadRequest, models.APIResponse{
+			Success: false,
+			Message: "Appointment already paid",
+		})
+		return
+	}
+
+	amount, err := appointment.AmountDue()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, models.APIResponse{
+			Success: false,
+			Message: err.Error(),
+		})
+		return
+	}
+
+	// Retries with the same key return the session created by the first attempt
+	// and keys are namespaced per user so they cannot collide with another user's
+	idempotencyKey := c.GetHeader("Idempotency-Key")
+	if idempotencyKey == "" {
+		idempotencyKey = fmt.Sprintf("%s:%s:%d%s", models.GatewayStripe, req.AppointmentID, amount.Amount, amount.Currency)
+	}
+	idempotencyKey = "user:" + userID + ":" + idempotencyKey
+
+	tx := &models.PaymentTransaction{
+		AppointmentID:  req.AppointmentID,
+		UserID:         userID,
+		Gateway:        models.GatewayStripe,
+		Type:           models.PaymentTypeOrder,
+		Amount:         amount,
+		IdempotencyKey: idempotencyKey,
+	}
+	existing, claimed, err := utils.ClaimIdempotencyKey(tx)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, models.APIResponse{
+			Success: false,
+			Message: err.Error(),
+		})
+		return
+	}
+	if !claimed {
+		if existing.Status == models.PaymentStatusCreated {
+			c.JSON(http.StatusOK, models.APIResponse{
+				Success: true,
+				Data:    map[string]interface{}{"session_url": existing.Response["url"]},
+			})
+			return
+		}
+		c.JSON(http.StatusConflict, models.APIResponse{
+			Success: false,
+			Message: "Payment already in progress",
+		})
+		return
+	}
+
 	// Set Stripe secret key
 	stripe.Key = os.Getenv("STRIPE_SECRET_KEY")
 
@@ -603,22 +827,26 @@ func PaymentStripe(c *gin.Context) {
 		LineItems: []*stripe.CheckoutSessionLineItemParams{
 			{
 				PriceData: &stripe.CheckoutSessionLineItemPriceDataParams{
-					Currency: stripe.String(os.Getenv("CURRENCY")),
+					Currency: stripe.String(strings.ToLower(amount.Currency)),
 					ProductData: &stripe.CheckoutSessionLineItemPriceDataProductDataParams{
 						Name: stripe.String("Appointment Fees"),
 					},
-					UnitAmount: stripe.Int64(int64(appointment.Amount * 100)),
+					UnitAmount: stripe.Int64(amount.Amount),
 				},
 				Quantity: stripe.Int64(1),
 			},
 		},
-		Mode:       stripe.String(string(stripe.CheckoutSessionModePayment)),
-		SuccessURL: stripe.String(fmt.Sprintf("%s/verify?success=true&appointmentId=%s", origin, req.AppointmentID)),
-		CancelURL:  stripe.String(fmt.Sprintf("%s/verify?success=false&appointmentId=%s", origin, req.AppointmentID)),
+		Mode:              stripe.String(string(stripe.CheckoutSessionModePayment)),
+		ClientReferenceID: stripe.String(req.AppointmentID),
+		SuccessURL:        stripe.String(fmt.Sprintf("%s/verify?success=true&appointmentId=%s", origin, req.AppointmentID)),
+		CancelURL:         stripe.String(fmt.Sprintf("%s/verify?success=false&appointmentId=%s", origin, req.AppointmentID)),
 	}
+	params.AddMetadata("appointmentId", req.AppointmentID)
+	params.SetIdempotencyKey(idempotencyKey)
 
 	sess, err := session.New(params)
 	if err != nil {
+		utils.FailPayment(tx.ID, err)
 		c.JSON(http.StatusInternalServerError, models.APIResponse{
 			Success: false,
 			Message: err.Error(),
@@ -626,6 +854,12 @@ func PaymentStripe(c *gin.Context) {
 		return
 	}
 
+	utils.UpdatePayment(tx.ID, bson.M{
+		"status":         models.PaymentStatusCreated,
+		"gatewayOrderId": sess.ID,
+		"response":       bson.M{"id": sess.ID, "url": sess.URL},
+	})
+
 	c.JSON(http.StatusOK, models.APIResponse{
 		Success: true,
 		Data:    map[string]string{"session_url": sess.URL},
@@ -634,6 +868,7 @@ func PaymentStripe(c *gin.Context) {
 
 // VerifyStripe verifies Stripe payment
 func VerifyStripe(c *gin.Context) {
+	userID := c.GetString("userId")
 	var req models.VerifyPaymentRequest
 	if err := c.ShouldBindJSON(&req); err != nil {
 		c.JSON(http.StatusBadRequest, models.APIResponse{
@@ -643,30 +878,150 @@ func VerifyStripe(c *gin.Context) {
 		return
 	}
 
-	if req.Success == "true" {
-		appointmentObjectID, _ := primitive.ObjectIDFromHex(req.AppointmentID)
-		appointmentCollection := config.GetCollection("appointments")
-		_, err := appointmentCollection.UpdateOne(
-			context.Background(),
-			bson.M{"_id": appointmentObjectID},
-			bson.M{"$set": bson.M{"payment": true}},
-		)
-		if err != nil {
-			c.JSON(http.StatusInternalServerError, models.APIResponse{
-				Success: false,
-				Message: err.Error(),
-			})
+	tx := &models.PaymentTransaction{
+		AppointmentID: req.AppointmentID,
+		UserID:        userID,
+		Gateway:       models.GatewayStripe,
+		Type:          models.PaymentTypeVerification,
+	}
+
+	if req.Success != "true" {
+		tx.Status = models.PaymentStatusFailed
+		tx.Error = "checkout cancelled"
+		utils.RecordPayment(tx)
+
+		c.JSON(http.StatusBadRequest, models.APIResponse{
+			Success: false,
+			Message: "Payment Failed",
+		})
+		return
+	}
+
+	// Confirm with Stripe instead of trusting the redirect parameters
+	order, err := utils.LatestPayment(req.AppointmentID, models.GatewayStripe, models.PaymentTypeOrder, models.PaymentStatusCreated)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, models.APIResponse{
+			Success: false,
+			Message: "Payment Failed",
+		})
+		return
+	}
+
+	stripe.Key = os.Getenv("STRIPE_SECRET_KEY")
+	sess, err := session.Get(order.GatewayOrderID, nil)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, models.APIResponse{
+			Success: false,
+			Message: err.Error(),
+		})
+		return
+	}
+
+	tx.GatewayOrderID = sess.ID
+	tx.Amount = models.NewMoney(sess.AmountTotal, string(sess.Currency))
+	if sess.PaymentIntent != nil {
+		tx.GatewayPaymentID = sess.PaymentIntent.ID
+	}
+
+	if sess.PaymentStatus != stripe.CheckoutSessionPaymentStatusPaid {
+		tx.Status = models.PaymentStatusFailed
+		tx.Error = fmt.Sprintf("session payment status %s", sess.PaymentStatus)
+		utils.RecordPayment(tx)
+
+		c.JSON(http.StatusBadRequest, models.APIResponse{
+			Success: false,
+			Message: "Payment Failed",
+		})
+		return
+	}
+
+	recordVerifiedPayment(c, tx)
+}
+
+// recordVerifiedPayment records a charge the gateway reported as paid and
+// confirms the appointment. Each gateway order is recorded once, so verifying
+// it again only confirms the appointment. A charge that does not match the
+// amount due is recorded as failed and not accepted.
+func recordVerifiedPayment(c *gin.Context, tx *models.PaymentTransaction) {
+	appointmentObjectID, _ := primitive.ObjectIDFromHex(tx.AppointmentID)
+	var appointment models.Appointment
+	err := config.GetCollection("appointments").FindOne(context.Background(), bson.M{"_id": appointmentObjectID}).Decode(&appointment)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, models.APIResponse{
+			Success: false,
+			Message: "Appointment not found",
+		})
+		return
+	}
+
+	if appointment.UserID != tx.UserID {
+		c.JSON(http.StatusUnauthorized, models.APIResponse{
+			Success: false,
+			Message: "Unauthorized action",
+		})
+		return
+	}
+
+	// Keyed on the order, which the gateway vouches for, rather than on a
+	// payment id sent by the client
+	if tx.GatewayOrderID != "" {
+		tx.IdempotencyKey = "verify:" + tx.Gateway + ":" + tx.GatewayOrderID
+		if existing, err := utils.FindPaymentByIdempotencyKey(tx.IdempotencyKey); err == nil {
+			if existing.Status != models.PaymentStatusPaid || existing.AppointmentID != tx.AppointmentID {
+				c.JSON(http.StatusBadRequest, models.APIResponse{
+					Success: false,
+					Message: "Payment Failed",
+				})
+				return
+			}
+			confirmVerifiedPayment(c, tx.AppointmentID)
 			return
 		}
+	}
 
-		c.JSON(http.StatusOK, models.APIResponse{
-			Success: true,
-			Message: "Payment Successful",
+	due, err := appointment.AmountDue()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, models.APIResponse{
+			Success: false,
+			Message: err.Error(),
 		})
-	} else {
+		return
+	}
+	if tx.Amount != due {
+		tx.Status = models.PaymentStatusFailed
+		tx.Error = fmt.Sprintf("captured %s but %s was due", tx.Amount, due)
+		utils.RecordPayment(tx)
+
 		c.JSON(http.StatusBadRequest, models.APIResponse{
 			Success: false,
-			Message: "Payment Failed",
+			Message: "Payment amount does not match the amount due",
+		})
+		return
+	}
+
+	tx.Status = models.PaymentStatusPaid
+	if err := utils.RecordPayment(tx); err != nil && !mongo.IsDuplicateKeyError(err) {
+		c.JSON(http.StatusInternalServerError, models.APIResponse{
+			Success: false,
+			Message: err.Error(),
+		})
+		return
+	}
+
+	confirmVerifiedPayment(c, tx.AppointmentID)
+}
+
+func confirmVerifiedPayment(c *gin.Context, appointmentID string) {
+	if err := utils.MarkAppointmentPaid(appointmentID); err != nil {
+		c.JSON(http.StatusInternalServerError, models.APIResponse{
+			Success: false,
+			Message: err.Error(),
 		})
+		return
 	}
-}
\ No newline at end of file
+
+	c.JSON(http.StatusOK, models.APIResponse{
+		Success: true,
+		Message: "Payment Successful",
+	})
+}
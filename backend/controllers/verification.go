@@ -0,0 +1,76 @@
+package controllers
+
+import (
+	"context"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"prescripto-go/config"
+	"prescripto-go/models"
+	"prescripto-go/utils"
+)
+
+// VerifyEmail confirms a user's email address from the emailed link
+func VerifyEmail(c *gin.Context) {
+	var req models.VerifyEmailRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, models.APIResponse{
+			Success: false,
+			Message: "Missing Details",
+		})
+		return
+	}
+
+	if err := utils.VerifyEmail(req.Token); err != nil {
+		status := http.StatusInternalServerError
+		if err == utils.ErrInvalidToken {
+			status = http.StatusBadRequest
+		}
+		c.JSON(status, models.APIResponse{
+			Success: false,
+			Message: "Invalid or expired verification link",
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, models.APIResponse{
+		Success: true,
+		Message: "Email Verified",
+	})
+}
+
+// ResendVerification emails the logged in user a new verification link
+func ResendVerification(c *gin.Context) {
+	userObjectID, _ := primitive.ObjectIDFromHex(c.GetString("userId"))
+
+	var user models.User
+	if err := config.GetCollection("users").FindOne(context.Background(), bson.M{"_id": userObjectID}).Decode(&user); err != nil {
+		c.JSON(http.StatusBadRequest, models.APIResponse{
+			Success: false,
+			Message: "User not found",
+		})
+		return
+	}
+
+	if err := utils.SendEmailVerification(&user); err != nil {
+		status := http.StatusInternalServerError
+		switch err {
+		case utils.ErrVerificationThrottled:
+			status = http.StatusTooManyRequests
+		case utils.ErrAlreadyVerified:
+			status = http.StatusBadRequest
+		}
+		c.JSON(status, models.APIResponse{
+			Success: false,
+			Message: err.Error(),
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, models.APIResponse{
+		Success: true,
+		Message: "Verification Email Sent",
+	})
+}
@@ -0,0 +1,343 @@
+package controllers
+
+import (
+	"context"
+	"net/http"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"prescripto-go/config"
+	"prescripto-go/models"
+	"prescripto-go/utils"
+)
+
+// GetWallet returns the logged in user's wallet balances and history
+func GetWallet(c *gin.Context) {
+	sendWallet(c, c.GetString("userId"))
+}
+
+// GetUserWallet returns a user's wallet balances and history for admin
+func GetUserWallet(c *gin.Context) {
+	userID := c.Query("userId")
+	if userID == "" {
+		c.JSON(http.StatusBadRequest, models.APIResponse{
+			Success: false,
+			Message: "Missing Details",
+		})
+		return
+	}
+	sendWallet(c, userID)
+}
+
+func sendWallet(c *gin.Context, userID string) {
+	balances, err := utils.WalletBalances(userID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, models.APIResponse{
+			Success: false,
+			Message: err.Error(),
+		})
+		return
+	}
+
+	opts := options.Find().SetSort(bson.D{{Key: "createdAt", Value: -1}}).SetLimit(100)
+	cursor, err := config.GetCollection("wallet_transactions").Find(context.Background(), bson.M{"userId": userID}, opts)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, models.APIResponse{
+			Success: false,
+			Message: err.Error(),
+		})
+		return
+	}
+	defer cursor.Close(context.Background())
+
+	transactions := []models.WalletTransaction{}
+	if err = cursor.All(context.Background(), &transactions); err != nil {
+		c.JSON(http.StatusInternalServerError, models.APIResponse{
+			Success: false,
+			Message: err.Error(),
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, models.APIResponse{
+		Success: true,
+		Data: gin.H{
+			"balances":     balances,
+			"transactions": transactions,
+		},
+	})
+}
+
+// AdjustWallet credits or, with a negative amount, debits a user's wallet
+func AdjustWallet(c *gin.Context) {
+	var req models.AdjustWalletRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, models.APIResponse{
+			Success: false,
+			Message: "Missing Details",
+		})
+		return
+	}
+
+	userObjectID, _ := primitive.ObjectIDFromHex(req.UserID)
+	count, err := config.GetCollection("users").CountDocuments(context.Background(), bson.M{"_id": userObjectID})
+	if err != nil || count == 0 {
+		c.JSON(http.StatusBadRequest, models.APIResponse{
+			Success: false,
+			Message: "User not found",
+		})
+		return
+	}
+
+	currency := req.Currency
+	if currency == "" {
+		currency = models.DefaultCurrency()
+	}
+	txType := models.WalletCredit
+	value := req.Amount.String()
+	if strings.HasPrefix(value, "-") {
+		txType = models.WalletDebit
+		value = value[1:]
+	}
+	amount, err := models.ParseMoney(value, currency)
+	if err != nil || amount.Amount <= 0 {
+		c.JSON(http.StatusBadRequest, models.APIResponse{
+			Success: false,
+			Message: "Invalid amount",
+		})
+		return
+	}
+
+	tx, err := utils.ApplyWalletTransaction(&models.WalletTransaction{
+		UserID:         req.UserID,
+		Type:           txType,
+		Reason:         models.WalletReasonAdjustment,
+		Amount:         amount,
+		Note:           req.Reason,
+		CreatedBy:      "admin:" + c.GetString("adminId"),
+		IdempotencyKey: c.GetHeader("Idempotency-Key"),
+	})
+	if err != nil {
+		status := http.StatusInternalServerError
+		if err == utils.ErrInsufficientBalance {
+			status = http.StatusBadRequest
+		}
+		c.JSON(status, models.APIResponse{
+			Success: false,
+			Message: err.Error(),
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, models.APIResponse{
+		Success: true,
+		Message: "Wallet Updated",
+		Data:    tx,
+	})
+}
+
+// PayFromWallet pays an appointment fully or partly from the user's wallet.
+// Whatever remains is paid through Razorpay or Stripe as usual.
+func PayFromWallet(c *gin.Context) {
+	userID := c.GetString("userId")
+	var req models.PayFromWalletRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, models.APIResponse{
+			Success: false,
+			Message: "Missing Details",
+		})
+		return
+	}
+
+	appointmentObjectID, _ := primitive.ObjectIDFromHex(req.AppointmentID)
+
+	// Get appointment data
+	appointmentCollection := config.GetCollection("appointments")
+	var appointment models.Appointment
+	err := appointmentCollection.FindOne(context.Background(), bson.M{"_id": appointmentObjectID}).Decode(&appointment)
+	if err != nil || appointment.Cancelled {
+		c.JSON(http.StatusBadRequest, models.APIResponse{
+			Success: false,
+			Message: "Appointment Cancelled or not found",
+		})
+		return
+	}
+
+	if appointment.UserID != userID {
+		c.JSON(http.StatusUnauthorized, models.APIResponse{
+			Success: false,
+			Message: "Unauthorized action",
+		})
+		return
+	}
+
+	if appointment.Payment {
+		c.JSON(http.StatusBadRequest, models.APIResponse{
+			Success: false,
+			Message: "Appointment already paid",
+		})
+		return
+	}
+
+	// Use as much of the balance as needed unless an amount is given
+	due, err := appointment.AmountDue()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, models.APIResponse{
+			Success: false,
+			Message: err.Error(),
+		})
+		return
+	}
+	amount := utils.WalletBalance(userID, due.Currency)
+	if req.Amount != "" {
+		amount, err = models.ParseMoney(req.Amount.String(), due.Currency)
+		if err != nil || amount.Amount <= 0 {
+			c.JSON(http.StatusBadRequest, models.APIResponse{
+				Success: false,
+				Message: "Invalid amount",
+			})
+			return
+		}
+	}
+	if amount.Amount > due.Amount {
+		amount = due
+	}
+	if amount.Amount <= 0 {
+		c.JSON(http.StatusBadRequest, models.APIResponse{
+			Success: false,
+			Message: utils.ErrInsufficientBalance.Error(),
+		})
+		return
+	}
+
+	// The appointment's new totals are worked out before any money moves
+	walletPaid, err := appointment.WalletPaid.Add(amount)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, models.APIResponse{
+			Success: false,
+			Message: err.Error(),
+		})
+		return
+	}
+	remaining, err := due.Sub(amount)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, models.APIResponse{
+			Success: false,
+			Message: err.Error(),
+		})
+		return
+	}
+
+	// Keys are scoped to the user and appointment so a key cannot replay a
+	// debit made for another appointment or user
+	idempotencyKey := c.GetHeader("Idempotency-Key")
+	if idempotencyKey == "" {
+		idempotencyKey = appointment.WalletPaid.Decimal()
+	}
+	idempotencyKey = "wallet-pay:" + userID + ":" + req.AppointmentID + ":" + idempotencyKey
+
+	tx := &models.WalletTransaction{
+		UserID:         userID,
+		Type:           models.WalletDebit,
+		Reason:         models.WalletReasonPayment,
+		Amount:         amount,
+		AppointmentID:  req.AppointmentID,
+		CreatedBy:      userID,
+		IdempotencyKey: idempotencyKey,
+	}
+	debit, err := utils.ApplyWalletTransaction(tx)
+	if err != nil {
+		status := http.StatusInternalServerError
+		if err == utils.ErrInsufficientBalance {
+			status = http.StatusBadRequest
+		}
+		c.JSON(status, models.APIResponse{
+			Success: false,
+			Message: err.Error(),
+		})
+		return
+	}
+
+	if debit != tx {
+		// A retry of an earlier request whose debit was already counted
+		if debit.Type != models.WalletDebit || debit.UserID != userID ||
+			debit.AppointmentID != req.AppointmentID || debit.Amount != amount {
+			c.JSON(http.StatusConflict, models.APIResponse{
+				Success: false,
+				Message: "Idempotency-Key was already used for another payment",
+			})
+			return
+		}
+		c.JSON(http.StatusOK, models.APIResponse{
+			Success: true,
+			Message: "Paid from Wallet",
+			Data: gin.H{
+				"walletPaid": appointment.WalletPaid,
+				"amountDue":  due,
+			},
+		})
+		return
+	}
+
+	// Guard against a concurrent payment or wallet use on the same appointment
+	filter := bson.M{"_id": appointmentObjectID, "payment": false, "cancelled": false}
+	if appointment.WalletPaid.IsZero() {
+		filter["walletPaid"] = bson.M{"$exists": false}
+	} else {
+		filter["walletPaid.amount"] = appointment.WalletPaid.Amount
+	}
+	result, err := appointmentCollection.UpdateOne(
+		context.Background(),
+		filter,
+		bson.M{"$set": bson.M{"walletPaid": walletPaid}},
+	)
+	if err != nil || result.MatchedCount == 0 {
+		// Give back the debit made by this request
+		utils.ApplyWalletTransaction(&models.WalletTransaction{
+			UserID:         userID,
+			Type:           models.WalletCredit,
+			Reason:         models.WalletReasonRelease,
+			Amount:         debit.Amount,
+			AppointmentID:  req.AppointmentID,
+			CreatedBy:      "system",
+			IdempotencyKey: "wallet-reverse:" + debit.ID.Hex(),
+		})
+		c.JSON(http.StatusConflict, models.APIResponse{
+			Success: false,
+			Message: "Appointment changed while paying, please retry",
+		})
+		return
+	}
+	appointment.WalletPaid = walletPaid
+
+	// Paid in full from the wallet
+	if remaining.Amount == 0 {
+		utils.RecordPayment(&models.PaymentTransaction{
+			AppointmentID: req.AppointmentID,
+			UserID:        userID,
+			Gateway:       models.GatewayWallet,
+			Type:          models.PaymentTypeVerification,
+			Status:        models.PaymentStatusPaid,
+			Amount:        walletPaid,
+		})
+		if err := utils.MarkAppointmentPaid(req.AppointmentID); err != nil {
+			c.JSON(http.StatusInternalServerError, models.APIResponse{
+				Success: false,
+				Message: err.Error(),
+			})
+			return
+		}
+	}
+
+	c.JSON(http.StatusOK, models.APIResponse{
+		Success: true,
+		Message: "Paid from Wallet",
+		Data: gin.H{
+			"walletPaid": walletPaid,
+			"amountDue":  remaining,
+		},
+	})
+}
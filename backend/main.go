@@ -10,6 +10,7 @@ import (
 	"github.com/joho/godotenv"
 	"prescripto-go/config"
 	"prescripto-go/routes"
+	"prescripto-go/utils"
 )
 
 func main() {
@@ -18,10 +19,25 @@ func main() {
 		log.Println("No .env file found")
 	}
 
+	// Maintenance commands run instead of the server
+	if len(os.Args) > 1 {
+		runCommand(os.Args[1:])
+		return
+	}
+
 	// Connect to database
 	config.ConnectMongoDB()
+	config.EnsureIndexes()
+	utils.EnsureDefaultRoles()
+	if err := utils.EnsureSigningKey(); err != nil {
+		log.Fatalf("Failed to load signing keys: %v", err)
+	}
 	config.ConnectCloudinary()
 
+	// Background jobs
+	utils.StartReconciliationJob()
+	utils.StartSigningKeyRefresh()
+
 	// Setup Gin router
 	r := gin.Default()
 
@@ -33,6 +49,9 @@ func main() {
 		AllowCredentials: true,
 	}))
 
+	// Public keys for verifying our tokens
+	routes.WellKnownRoutes(r.Group("/.well-known"))
+
 	// API routes
 	api := r.Group("/api")
 	{
@@ -51,6 +70,18 @@ func main() {
 		// Admin routes
 		adminGroup := api.Group("/admin")
 		routes.AdminRoutes(adminGroup)
+
+		// Partner routes authenticated with API keys
+		partnerGroup := api.Group("/partner")
+		routes.PartnerRoutes(partnerGroup)
+
+		// Public prescription verification for pharmacies
+		prescriptionGroup := api.Group("/prescriptions")
+		routes.PrescriptionRoutes(prescriptionGroup)
+
+		// Payment gateway routes
+		paymentGroup := api.Group("/payments")
+		routes.PaymentRoutes(paymentGroup)
 	}
 
 	port := os.Getenv("PORT")
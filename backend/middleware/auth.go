@@ -2,48 +2,61 @@ package middleware
 
 import (
 	"net/http"
-	"os"
-	// "strings"  // REMOVE THIS - not used
+	"strconv"
+	"strings"
 
-	"github.com/dgrijalva/jwt-go"
 	"github.com/gin-gonic/gin"
 	"prescripto-go/models"
+	"prescripto-go/utils"
 )
 
+// authenticate verifies the token in header was issued for role and that its
+// login session is still active, and returns the principal id; it aborts the
+// request otherwise
+func authenticate(c *gin.Context, header, role string) (string, bool) {
+	token := c.GetHeader(header)
+	if token == "" {
+		c.JSON(http.StatusUnauthorized, models.APIResponse{
+			Success: false,
+			Message: "Not Authorized Login Again",
+		})
+		c.Abort()
+		return "", false
+	}
+
+	// A token of another role is refused, so a doctor token cannot act as a user
+	claims, err := utils.ParseJWT(token, role)
+	if err != nil {
+		c.JSON(http.StatusUnauthorized, models.APIResponse{
+			Success: false,
+			Message: "Not Authorized Login Again",
+		})
+		c.Abort()
+		return "", false
+	}
+
+	// Logging out revokes the session before its access tokens expire
+	active, err := utils.SessionActive(claims.SessionID, c.ClientIP())
+	if err != nil || !active {
+		c.JSON(http.StatusUnauthorized, models.APIResponse{
+			Success: false,
+			Message: "Not Authorized Login Again",
+		})
+		c.Abort()
+		return "", false
+	}
+
+	c.Set("sessionId", claims.SessionID)
+	c.Set("principalId", claims.ID)
+	c.Set("tokenRole", role)
+	return claims.ID, true
+}
+
 // AuthUser middleware for user authentication
 func AuthUser() gin.HandlerFunc {
 	return gin.HandlerFunc(func(c *gin.Context) {
-		token := c.GetHeader("token")
-		if token == "" {
-			c.JSON(http.StatusUnauthorized, models.APIResponse{
-				Success: false,
-				Message: "Not Authorized Login Again",
-			})
-			c.Abort()
-			return
-		}
-
-		claims := jwt.MapClaims{}
-		parsedToken, err := jwt.ParseWithClaims(token, claims, func(token *jwt.Token) (interface{}, error) {
-			return []byte(os.Getenv("JWT_SECRET")), nil
-		})
-
-		if err != nil || !parsedToken.Valid {
-			c.JSON(http.StatusUnauthorized, models.APIResponse{
-				Success: false,
-				Message: "Not Authorized Login Again",
-			})
-			c.Abort()
-			return
-		}
-
-		userID, ok := claims["id"].(string)
+		userID, ok := authenticate(c, "token", utils.RoleUser)
 		if !ok {
-			c.JSON(http.StatusUnauthorized, models.APIResponse{
-				Success: false,
-				Message: "Invalid token",
-			})
-			c.Abort()
 			return
 		}
 
@@ -55,22 +68,27 @@ func AuthUser() gin.HandlerFunc {
 // AuthDoctor middleware for doctor authentication
 func AuthDoctor() gin.HandlerFunc {
 	return gin.HandlerFunc(func(c *gin.Context) {
-		token := c.GetHeader("dtoken")
-		if token == "" {
-			c.JSON(http.StatusUnauthorized, models.APIResponse{
-				Success: false,
-				Message: "Not Authorized Login Again",
-			})
-			c.Abort()
+		docID, ok := authenticate(c, "dtoken", utils.RoleDoctor)
+		if !ok {
 			return
 		}
 
-		claims := jwt.MapClaims{}
-		parsedToken, err := jwt.ParseWithClaims(token, claims, func(token *jwt.Token) (interface{}, error) {
-			return []byte(os.Getenv("JWT_SECRET")), nil
-		})
+		c.Set("docId", docID)
+		c.Next()
+	})
+}
+
+// AuthAdmin middleware for admin authentication
+func AuthAdmin() gin.HandlerFunc {
+	return gin.HandlerFunc(func(c *gin.Context) {
+		adminID, ok := authenticate(c, "atoken", utils.RoleAdmin)
+		if !ok {
+			return
+		}
 
-		if err != nil || !parsedToken.Valid {
+		// Disabled admins lose access immediately
+		admin, err := utils.FindActiveAdmin(adminID)
+		if err != nil {
 			c.JSON(http.StatusUnauthorized, models.APIResponse{
 				Success: false,
 				Message: "Not Authorized Login Again",
@@ -79,62 +97,87 @@ func AuthDoctor() gin.HandlerFunc {
 			return
 		}
 
-		docID, ok := claims["id"].(string)
-		if !ok {
-			c.JSON(http.StatusUnauthorized, models.APIResponse{
+		permissions, err := utils.RolePermissions(admin.Roles)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, models.APIResponse{
 				Success: false,
-				Message: "Invalid token",
+				Message: err.Error(),
 			})
 			c.Abort()
 			return
 		}
 
-		c.Set("docId", docID)
+		c.Set("adminId", adminID)
+		c.Set("permissions", permissions)
 		c.Next()
 	})
 }
 
-// AuthAdmin middleware for admin authentication
-func AuthAdmin() gin.HandlerFunc {
+// AuthAPIKey authenticates partner requests with an API key sent as
+// "Authorization: Bearer <key>", requires the scope and applies the key's rate limit
+func AuthAPIKey(scope string) gin.HandlerFunc {
 	return gin.HandlerFunc(func(c *gin.Context) {
-		token := c.GetHeader("atoken")
-		if token == "" {
+		plain := strings.TrimPrefix(c.GetHeader("Authorization"), "Bearer ")
+		if plain == "" {
 			c.JSON(http.StatusUnauthorized, models.APIResponse{
 				Success: false,
-				Message: "Not Authorized Login Again",
+				Message: "Missing API key",
 			})
 			c.Abort()
 			return
 		}
 
-		claims := jwt.MapClaims{}
-		parsedToken, err := jwt.ParseWithClaims(token, claims, func(token *jwt.Token) (interface{}, error) {
-			return []byte(os.Getenv("JWT_SECRET")), nil
-		})
-
-		if err != nil || !parsedToken.Valid {
+		key, err := utils.FindActiveAPIKey(plain)
+		if err != nil {
 			c.JSON(http.StatusUnauthorized, models.APIResponse{
 				Success: false,
-				Message: "Not Authorized Login Again",
+				Message: "Invalid API key",
 			})
 			c.Abort()
 			return
 		}
 
-		// Check if token payload matches admin credentials
-		adminEmail := os.Getenv("ADMIN_EMAIL")
-		adminPassword := os.Getenv("ADMIN_PASSWORD")
-		expectedPayload := adminEmail + adminPassword
+		if !utils.Contains(key.Scopes, scope) {
+			c.JSON(http.StatusForbidden, models.APIResponse{
+				Success: false,
+				Message: "API key is not allowed to do this",
+			})
+			c.Abort()
+			return
+		}
 
-		if tokenStr, ok := claims["data"].(string); !ok || tokenStr != expectedPayload {
-			c.JSON(http.StatusUnauthorized, models.APIResponse{
+		retryAfter, err := utils.TakeAPIKeyRequest(key)
+		if err != nil {
+			status := http.StatusInternalServerError
+			if err == utils.ErrRateLimited {
+				status = http.StatusTooManyRequests
+				c.Header("Retry-After", strconv.Itoa(retryAfter))
+			}
+			c.JSON(status, models.APIResponse{
 				Success: false,
-				Message: "Not Authorized Login Again",
+				Message: err.Error(),
 			})
 			c.Abort()
 			return
 		}
 
+		c.Set("apiKeyId", key.ID.Hex())
+		c.Next()
+	})
+}
+
+// RequirePermission only lets through principals whose roles grant the permission.
+// It runs after the authentication middleware that loads the permissions.
+func RequirePermission(permission string) gin.HandlerFunc {
+	return gin.HandlerFunc(func(c *gin.Context) {
+		if !utils.HasPermission(c.GetStringSlice("permissions"), permission) {
+			c.JSON(http.StatusForbidden, models.APIResponse{
+				Success: false,
+				Message: "You do not have permission to do this",
+			})
+			c.Abort()
+			return
+		}
 		c.Next()
 	})
 }
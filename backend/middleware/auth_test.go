@@ -0,0 +1,121 @@
+package middleware
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/dgrijalva/jwt-go"
+	"github.com/gin-gonic/gin"
+	"prescripto-go/utils"
+)
+
+func TestAuthRejectsForeignTokens(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	sign := func(method jwt.SigningMethod, signingKey interface{}, claims jwt.MapClaims) string {
+		token := jwt.NewWithClaims(method, claims)
+		token.Header["kid"] = "test-kid"
+		signed, err := token.SignedString(signingKey)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return signed
+	}
+	claims := func(role string, edit func(jwt.MapClaims)) jwt.MapClaims {
+		c := jwt.MapClaims{
+			"id":   "principal",
+			"role": role,
+			"sid":  "session",
+			"aud":  role,
+			"iss":  "prescripto",
+			"exp":  time.Now().Add(time.Minute).Unix(),
+		}
+		if edit != nil {
+			edit(c)
+		}
+		return c
+	}
+	issued := func(role string) func() string {
+		return func() string {
+			token, err := utils.GenerateJWT("principal", role, "session")
+			if err != nil {
+				t.Fatal(err)
+			}
+			return token
+		}
+	}
+
+	routes := []struct {
+		name       string
+		header     string
+		role       string
+		middleware gin.HandlerFunc
+	}{
+		{"user", "token", utils.RoleUser, AuthUser()},
+		{"doctor", "dtoken", utils.RoleDoctor, AuthDoctor()},
+		{"admin", "atoken", utils.RoleAdmin, AuthAdmin()},
+	}
+	roles := []string{utils.RoleUser, utils.RoleDoctor, utils.RoleAdmin}
+
+	for _, route := range routes {
+		type tokenCase struct {
+			name  string
+			token func() string
+		}
+		var cases []tokenCase
+		for _, role := range roles {
+			if role != route.role {
+				cases = append(cases, tokenCase{role + " token", issued(role)})
+			}
+		}
+		cases = append(cases,
+			tokenCase{"no token", func() string { return "" }},
+			tokenCase{"wrong audience", func() string {
+				return sign(jwt.SigningMethodRS256, key, claims(route.role, func(c jwt.MapClaims) { c["aud"] = "partner" }))
+			}},
+			tokenCase{"wrong issuer", func() string {
+				return sign(jwt.SigningMethodRS256, key, claims(route.role, func(c jwt.MapClaims) { c["iss"] = "someone-else" }))
+			}},
+			tokenCase{"unknown kid", func() string {
+				token := jwt.NewWithClaims(jwt.SigningMethodRS256, claims(route.role, nil))
+				token.Header["kid"] = "unknown-kid"
+				signed, _ := token.SignedString(key)
+				return signed
+			}},
+			tokenCase{"HS256", func() string {
+				return sign(jwt.SigningMethodHS256, []byte("secret"), claims(route.role, nil))
+			}},
+		)
+
+		for _, tc := range cases {
+			t.Run(route.name+" route/"+tc.name, func(t *testing.T) {
+				// A fresh keyring keeps an unknown kid from reloading from the database
+				utils.UseSigningKey("test-kid", key)
+
+				reached := false
+				r := gin.New()
+				r.GET("/", route.middleware, func(c *gin.Context) { reached = true })
+
+				req := httptest.NewRequest(http.MethodGet, "/", nil)
+				if token := tc.token(); token != "" {
+					req.Header.Set(route.header, token)
+				}
+				w := httptest.NewRecorder()
+				r.ServeHTTP(w, req)
+
+				if reached || w.Code != http.StatusUnauthorized {
+					t.Fatalf("token let through: status %d, handler reached %v", w.Code, reached)
+				}
+			})
+		}
+	}
+}
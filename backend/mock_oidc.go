@@ -0,0 +1,184 @@
+package main
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"flag"
+	"html/template"
+	"log"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/dgrijalva/jwt-go"
+	"prescripto-go/models"
+	"prescripto-go/utils"
+)
+
+// mockOIDCKeyID names the signing key of the mock provider
+const mockOIDCKeyID = "mock-oidc"
+
+// mockAuthorization is an authorization code waiting to be redeemed
+type mockAuthorization struct {
+	clientID    string
+	redirectURI string
+	nonce       string
+	challenge   string
+	email       string
+	name        string
+	expiresAt   time.Time
+}
+
+var mockLoginPage = template.Must(template.New("login").Parse(`<!doctype html>
+<title>Mock identity provider</title>
+<h1>Mock identity provider</h1>
+<form method="post">
+{{range $name, $value := .}}<input type="hidden" name="{{$name}}" value="{{index $value 0}}">
+{{end}}<p><label>Email <input name="email" type="email" required></label></p>
+<p><label>Name <input name="name"></label></p>
+<button>Sign in</button>
+</form>
+`))
+
+// mockOIDC runs a local OpenID Connect provider for development. Any email
+// typed on its login page is signed in and reported as verified, e.g.
+// `./main mock-oidc -addr :9000` with OIDC_MOCK_ISSUER=http://localhost:9000.
+func mockOIDC(args []string) {
+	flags := flag.NewFlagSet("mock-oidc", flag.ExitOnError)
+	addr := flags.String("addr", ":9000", "listen address")
+	issuer := flags.String("issuer", "http://localhost:9000", "issuer URL the provider is reached at")
+	flags.Parse(args)
+
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	var mu sync.Mutex
+	codes := make(map[string]*mockAuthorization)
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/.well-known/openid-configuration", func(w http.ResponseWriter, r *http.Request) {
+		writeMockJSON(w, http.StatusOK, map[string]interface{}{
+			"issuer":                                *issuer,
+			"authorization_endpoint":                *issuer + "/authorize",
+			"token_endpoint":                        *issuer + "/token",
+			"jwks_uri":                              *issuer + "/jwks",
+			"response_types_supported":              []string{"code"},
+			"subject_types_supported":               []string{"public"},
+			"id_token_signing_alg_values_supported": []string{"RS256"},
+			"code_challenge_methods_supported":      []string{"S256"},
+		})
+	})
+
+	mux.HandleFunc("/jwks", func(w http.ResponseWriter, r *http.Request) {
+		writeMockJSON(w, http.StatusOK, models.JWKSet{Keys: []models.JWK{utils.RSAPublicJWK(mockOIDCKeyID, &key.PublicKey)}})
+	})
+
+	mux.HandleFunc("/authorize", func(w http.ResponseWriter, r *http.Request) {
+		r.ParseForm()
+		if r.Method == http.MethodGet {
+			params := url.Values{}
+			for _, name := range []string{"client_id", "redirect_uri", "state", "nonce", "code_challenge", "code_challenge_method"} {
+				params.Set(name, r.Form.Get(name))
+			}
+			mockLoginPage.Execute(w, params)
+			return
+		}
+
+		if r.Form.Get("code_challenge_method") != "S256" || r.Form.Get("code_challenge") == "" {
+			http.Error(w, "PKCE with S256 is required", http.StatusBadRequest)
+			return
+		}
+		redirect, err := url.Parse(r.Form.Get("redirect_uri"))
+		if err != nil || redirect.Scheme == "" {
+			http.Error(w, "invalid redirect_uri", http.StatusBadRequest)
+			return
+		}
+
+		raw := make([]byte, 16)
+		rand.Read(raw)
+		code := hex.EncodeToString(raw)
+		mu.Lock()
+		codes[code] = &mockAuthorization{
+			clientID:    r.Form.Get("client_id"),
+			redirectURI: r.Form.Get("redirect_uri"),
+			nonce:       r.Form.Get("nonce"),
+			challenge:   r.Form.Get("code_challenge"),
+			email:       strings.TrimSpace(r.Form.Get("email")),
+			name:        r.Form.Get("name"),
+			expiresAt:   time.Now().Add(time.Minute),
+		}
+		mu.Unlock()
+
+		query := redirect.Query()
+		query.Set("code", code)
+		query.Set("state", r.Form.Get("state"))
+		redirect.RawQuery = query.Encode()
+		http.Redirect(w, r, redirect.String(), http.StatusFound)
+	})
+
+	mux.HandleFunc("/token", func(w http.ResponseWriter, r *http.Request) {
+		r.ParseForm()
+		code := r.Form.Get("code")
+
+		mu.Lock()
+		auth := codes[code]
+		delete(codes, code)
+		mu.Unlock()
+
+		verifier := sha256.Sum256([]byte(r.Form.Get("code_verifier")))
+		if auth == nil || time.Now().After(auth.expiresAt) ||
+			r.Form.Get("grant_type") != "authorization_code" ||
+			r.Form.Get("client_id") != auth.clientID ||
+			r.Form.Get("redirect_uri") != auth.redirectURI ||
+			base64.RawURLEncoding.EncodeToString(verifier[:]) != auth.challenge {
+			writeMockJSON(w, http.StatusBadRequest, map[string]string{"error": "invalid_grant"})
+			return
+		}
+
+		subject := sha256.Sum256([]byte(strings.ToLower(auth.email)))
+		now := time.Now()
+		idToken := jwt.NewWithClaims(jwt.SigningMethodRS256, jwt.MapClaims{
+			"iss":            *issuer,
+			"sub":            hex.EncodeToString(subject[:16]),
+			"aud":            auth.clientID,
+			"nonce":          auth.nonce,
+			"email":          auth.email,
+			"email_verified": true,
+			"name":           auth.name,
+			"iat":            now.Unix(),
+			"exp":            now.Add(5 * time.Minute).Unix(),
+		})
+		idToken.Header["kid"] = mockOIDCKeyID
+		signed, err := idToken.SignedString(key)
+		if err != nil {
+			writeMockJSON(w, http.StatusInternalServerError, map[string]string{"error": "server_error"})
+			return
+		}
+
+		accessToken := make([]byte, 16)
+		rand.Read(accessToken)
+		writeMockJSON(w, http.StatusOK, map[string]interface{}{
+			"access_token": hex.EncodeToString(accessToken),
+			"token_type":   "Bearer",
+			"expires_in":   300,
+			"id_token":     signed,
+		})
+	})
+
+	log.Printf("Mock identity provider %s listening on %s", *issuer, *addr)
+	log.Fatal(http.ListenAndServe(*addr, mux))
+}
+
+func writeMockJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
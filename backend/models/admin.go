@@ -0,0 +1,43 @@
+package models
+
+import (
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// Admin account statuses
+const (
+	AdminStatusInvited  = "invited"
+	AdminStatusActive   = "active"
+	AdminStatusDisabled = "disabled"
+)
+
+// Admin is a named administrator account
+type Admin struct {
+	ID              primitive.ObjectID `bson:"_id,omitempty" json:"id"`
+	Name            string             `bson:"name" json:"name"`
+	Email           string             `bson:"email" json:"email"`
+	Password        string             `bson:"password,omitempty" json:"-"`
+	Status          string             `bson:"status" json:"status"`
+	Roles           []string           `bson:"roles" json:"roles"`
+	InviteTokenHash string             `bson:"inviteTokenHash,omitempty" json:"-"`
+	InviteExpiresAt int64              `bson:"inviteExpiresAt,omitempty" json:"inviteExpiresAt,omitempty"`
+	InvitedBy       string             `bson:"invitedBy,omitempty" json:"invitedBy,omitempty"`
+	LastLoginAt     int64              `bson:"lastLoginAt,omitempty" json:"lastLoginAt,omitempty"`
+	CreatedAt       int64              `bson:"createdAt" json:"createdAt"`
+}
+
+type InviteAdminRequest struct {
+	Name  string   `json:"name" binding:"required"`
+	Email string   `json:"email" binding:"required,email"`
+	Roles []string `json:"roles"`
+}
+
+type AcceptAdminInviteRequest struct {
+	Token    string `json:"token" binding:"required"`
+	Password string `json:"password" binding:"required,min=8"`
+}
+
+type ChangeAdminStatusRequest struct {
+	AdminID string `json:"adminId" binding:"required"`
+	Active  bool   `json:"active"`
+}
@@ -0,0 +1,87 @@
+package models
+
+import (
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// Scopes an API key can be granted for the partner routes
+const (
+	ScopeAvailabilityRead = "availability:read"
+	ScopeBookingsRead     = "bookings:read"
+	ScopeBookingsWrite    = "bookings:write"
+)
+
+// AllScopes lists every scope an API key can be granted
+var AllScopes = []string{ScopeAvailabilityRead, ScopeBookingsRead, ScopeBookingsWrite}
+
+// API key statuses
+const (
+	APIKeyActive  = "active"
+	APIKeyRevoked = "revoked"
+)
+
+// APIKey lets a partner call the partner routes server-to-server. Only the
+// hash of the key is stored; the prefix identifies it in lists and logs.
+type APIKey struct {
+	ID      primitive.ObjectID `bson:"_id,omitempty" json:"id"`
+	Name    string             `bson:"name" json:"name"`
+	Prefix  string             `bson:"prefix" json:"prefix"`
+	KeyHash string             `bson:"keyHash" json:"-"`
+	Scopes  []string           `bson:"scopes" json:"scopes"`
+	// RateLimit is the number of requests allowed per minute
+	RateLimit  int    `bson:"rateLimit" json:"rateLimit"`
+	Status     string `bson:"status" json:"status"`
+	CreatedBy  string `bson:"createdBy" json:"createdBy"`
+	CreatedAt  int64  `bson:"createdAt" json:"createdAt"`
+	LastUsedAt int64  `bson:"lastUsedAt,omitempty" json:"lastUsedAt,omitempty"`
+	RevokedAt  int64  `bson:"revokedAt,omitempty" json:"revokedAt,omitempty"`
+}
+
+type CreateAPIKeyRequest struct {
+	Name      string   `json:"name" binding:"required"`
+	Scopes    []string `json:"scopes" binding:"required"`
+	RateLimit int      `json:"rateLimit"`
+}
+
+type RevokeAPIKeyRequest struct {
+	KeyID string `json:"keyId" binding:"required"`
+}
+
+// PartnerAppointment is a booking as shown to the partner that made it. It
+// carries no more about the patient than the partner sent when booking.
+type PartnerAppointment struct {
+	ID           string `json:"id"`
+	DocID        string `json:"docId"`
+	DoctorName   string `json:"doctorName"`
+	Speciality   string `json:"speciality"`
+	SlotDate     string `json:"slotDate"`
+	SlotTime     string `json:"slotTime"`
+	PatientName  string `json:"patientName"`
+	PatientEmail string `json:"patientEmail"`
+	Amount       Money  `json:"amount"`
+	Cancelled    bool   `json:"cancelled"`
+	Payment      bool   `json:"payment"`
+	IsCompleted  bool   `json:"isCompleted"`
+	Date         int64  `json:"date"`
+}
+
+// Partner is an API key as listed to a patient deciding whether it may book for them
+type Partner struct {
+	ID      string `json:"id"`
+	Name    string `json:"name"`
+	Allowed bool   `json:"allowed"`
+}
+
+type PartnerConsentRequest struct {
+	KeyID string `json:"keyId" binding:"required"`
+	Allow bool   `json:"allow"`
+}
+
+// PartnerBookingRequest books a slot for a patient identified by email
+type PartnerBookingRequest struct {
+	DocID        string `json:"docId" binding:"required"`
+	SlotDate     string `json:"slotDate" binding:"required"`
+	SlotTime     string `json:"slotTime" binding:"required"`
+	PatientEmail string `json:"patientEmail" binding:"required"`
+	PatientName  string `json:"patientName"`
+}
@@ -0,0 +1,67 @@
+package models
+
+import (
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// RefreshToken is one link of a rotating refresh token chain. Every token
+// issued from the same login shares a SessionID; the access tokens of that
+// login carry it too, so revoking the session logs the device out.
+type RefreshToken struct {
+	ID          primitive.ObjectID `bson:"_id,omitempty" json:"id"`
+	SessionID   string             `bson:"sessionId" json:"sessionId"`
+	Role        string             `bson:"role" json:"role"`
+	PrincipalID string             `bson:"principalId" json:"principalId"`
+	TokenHash   string             `bson:"tokenHash" json:"-"`
+	ExpiresAt   int64              `bson:"expiresAt" json:"expiresAt"`
+	// UsedAt is set once the token has been exchanged for a new one
+	UsedAt    int64 `bson:"usedAt,omitempty" json:"usedAt,omitempty"`
+	Revoked   bool  `bson:"revoked" json:"revoked"`
+	CreatedAt int64 `bson:"createdAt" json:"createdAt"`
+}
+
+type RefreshTokenRequest struct {
+	RefreshToken string `json:"refreshToken" binding:"required"`
+}
+
+type VerifyEmailRequest struct {
+	Token string `json:"token" binding:"required"`
+}
+
+// Session is one login of an account on a device. Its id is the session id
+// carried by the access tokens and refresh tokens of that login.
+type Session struct {
+	ID          string `bson:"_id" json:"id"`
+	Role        string `bson:"role" json:"role"`
+	PrincipalID string `bson:"principalId" json:"principalId"`
+	UserAgent   string `bson:"userAgent" json:"userAgent"`
+	IP          string `bson:"ip" json:"ip"`
+	CreatedAt   int64  `bson:"createdAt" json:"createdAt"`
+	LastSeenAt  int64  `bson:"lastSeenAt" json:"lastSeenAt"`
+	Revoked     bool   `bson:"revoked" json:"revoked"`
+	RevokedAt   int64  `bson:"revokedAt,omitempty" json:"revokedAt,omitempty"`
+	// RevokedBy is "self" or "admin:<id>"; empty for logouts and password changes
+	RevokedBy string `bson:"revokedBy,omitempty" json:"revokedBy,omitempty"`
+	// IdleExpiresAt is when the session can no longer be resumed if unused;
+	// a TTL index removes the record then
+	IdleExpiresAt time.Time `bson:"idleExpiresAt" json:"-"`
+	// Current marks the session of the request in listings
+	Current bool `bson:"-" json:"current,omitempty"`
+}
+
+// SessionClient describes the device a login comes from
+type SessionClient struct {
+	UserAgent string
+	IP        string
+}
+
+type RevokeSessionRequest struct {
+	SessionID string `json:"sessionId" binding:"required"`
+}
+
+type TerminateSessionsRequest struct {
+	Role      string `json:"role" binding:"required,oneof=user doctor admin"`
+	AccountID string `json:"accountId" binding:"required"`
+}
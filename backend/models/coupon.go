@@ -0,0 +1,70 @@
+package models
+
+import (
+	"encoding/json"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// Coupon types
+const (
+	CouponTypePercentage = "percentage"
+	CouponTypeFixed      = "fixed"
+)
+
+// Coupon is an admin managed discount code
+type Coupon struct {
+	ID             primitive.ObjectID `bson:"_id,omitempty" json:"id"`
+	Code           string             `bson:"code" json:"code"`
+	Description    string             `bson:"description" json:"description"`
+	Type           string             `bson:"type" json:"type"`
+	Percent        float64            `bson:"percent,omitempty" json:"percent,omitempty"`
+	Amount         Money              `bson:"amount,omitempty" json:"amount,omitempty"`
+	ValidFrom      int64              `bson:"validFrom" json:"validFrom"`
+	ValidUntil     int64              `bson:"validUntil" json:"validUntil"`
+	MaxUses        int                `bson:"maxUses" json:"maxUses"`
+	MaxUsesPerUser int                `bson:"maxUsesPerUser" json:"maxUsesPerUser"`
+	UsedCount      int                `bson:"usedCount" json:"usedCount"`
+	DoctorIDs      []string           `bson:"doctorIds,omitempty" json:"doctorIds,omitempty"`
+	Specialities   []string           `bson:"specialities,omitempty" json:"specialities,omitempty"`
+	Active         bool               `bson:"active" json:"active"`
+	CreatedAt      int64              `bson:"createdAt" json:"createdAt"`
+}
+
+// CouponRedemption records a coupon applied to an appointment
+type CouponRedemption struct {
+	ID            primitive.ObjectID `bson:"_id,omitempty" json:"id"`
+	CouponID      string             `bson:"couponId" json:"couponId"`
+	Code          string             `bson:"code" json:"code"`
+	UserID        string             `bson:"userId" json:"userId"`
+	AppointmentID string             `bson:"appointmentId" json:"appointmentId"`
+	Discount      Money              `bson:"discount" json:"discount"`
+	// Slot numbers the user's redemptions of a coupon with a per user cap
+	Slot      int   `bson:"slot,omitempty" json:"-"`
+	CreatedAt int64 `bson:"createdAt" json:"createdAt"`
+}
+
+type AddCouponRequest struct {
+	Code           string      `json:"code" binding:"required"`
+	Description    string      `json:"description"`
+	Type           string      `json:"type" binding:"required,oneof=percentage fixed"`
+	Percent        float64     `json:"percent"`
+	Amount         json.Number `json:"amount"`
+	Currency       string      `json:"currency"`
+	ValidFrom      int64       `json:"validFrom"`
+	ValidUntil     int64       `json:"validUntil"`
+	MaxUses        int         `json:"maxUses"`
+	MaxUsesPerUser int         `json:"maxUsesPerUser"`
+	DoctorIDs      []string    `json:"doctorIds"`
+	Specialities   []string    `json:"specialities"`
+}
+
+type ChangeCouponStatusRequest struct {
+	CouponID string `json:"couponId" binding:"required"`
+	Active   bool   `json:"active"`
+}
+
+type ApplyCouponRequest struct {
+	AppointmentID string `json:"appointmentId" binding:"required"`
+	Code          string `json:"code" binding:"required"`
+}
@@ -0,0 +1,50 @@
+package models
+
+import (
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// Invoice issued when an appointment is paid
+type Invoice struct {
+	ID            primitive.ObjectID `bson:"_id,omitempty" json:"id"`
+	Number        string             `bson:"number" json:"number"`
+	Sequence      int64              `bson:"sequence" json:"sequence"`
+	AppointmentID string             `bson:"appointmentId" json:"appointmentId"`
+	UserID        string             `bson:"userId" json:"userId"`
+	DocID         string             `bson:"docId" json:"docId"`
+	Patient       InvoiceParty       `bson:"patient" json:"patient"`
+	Doctor        InvoiceParty       `bson:"doctor" json:"doctor"`
+	ClinicAddress Address            `bson:"clinicAddress" json:"clinicAddress"`
+	SlotDate      string             `bson:"slotDate" json:"slotDate"`
+	SlotTime      string             `bson:"slotTime" json:"slotTime"`
+	Items         []InvoiceItem      `bson:"items" json:"items"`
+	Subtotal      Money              `bson:"subtotal" json:"subtotal"`
+	Tax           Money              `bson:"tax" json:"tax"`
+	TaxRate       float64            `bson:"taxRate" json:"taxRate"`
+	TaxLines      []TaxLine          `bson:"taxLines,omitempty" json:"taxLines,omitempty"`
+	Total         Money              `bson:"total" json:"total"`
+	Wallet        Money              `bson:"wallet,omitempty" json:"wallet,omitempty"`
+	Payment       InvoicePayment     `bson:"payment" json:"payment"`
+	IssuedAt      int64              `bson:"issuedAt" json:"issuedAt"`
+}
+
+// InvoiceParty identifies a patient or doctor on an invoice
+type InvoiceParty struct {
+	Name   string `bson:"name" json:"name"`
+	Email  string `bson:"email" json:"email"`
+	Detail string `bson:"detail,omitempty" json:"detail,omitempty"`
+}
+
+// InvoiceItem is a single line of the fee breakdown
+type InvoiceItem struct {
+	Description string `bson:"description" json:"description"`
+	Amount      Money  `bson:"amount" json:"amount"`
+}
+
+// InvoicePayment references the charge that settled the invoice
+type InvoicePayment struct {
+	Gateway   string `bson:"gateway" json:"gateway"`
+	Method    string `bson:"method,omitempty" json:"method,omitempty"`
+	Reference string `bson:"reference" json:"reference"`
+	PaidAt    int64  `bson:"paidAt" json:"paidAt"`
+}
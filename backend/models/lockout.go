@@ -0,0 +1,14 @@
+package models
+
+// LoginAttempts tracks recent failed logins for an account or an IP address
+type LoginAttempts struct {
+	// Key is "account:<role>:<email>" or "ip:<address>"
+	Key           string `bson:"_id" json:"key"`
+	Failures      int    `bson:"failures" json:"failures"`
+	LastFailureAt int64  `bson:"lastFailureAt" json:"lastFailureAt"`
+	LockedUntil   int64  `bson:"lockedUntil" json:"lockedUntil"`
+}
+
+type ClearLockoutRequest struct {
+	Key string `json:"key" binding:"required"`
+}
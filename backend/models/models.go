@@ -1,6 +1,7 @@
 package models
 
 import (
+	"encoding/json"
 	"time"
 
 	"go.mongodb.org/mongo-driver/bson/primitive"
@@ -17,6 +18,15 @@ type User struct {
 	Address  Address            `bson:"address" json:"address"`
 	Gender   string             `bson:"gender" json:"gender"`
 	DOB      string             `bson:"dob" json:"dob"`
+	// EmailVerified is set once the user opens the link sent at registration
+	EmailVerified      bool  `bson:"emailVerified" json:"emailVerified"`
+	VerificationSentAt int64 `bson:"verificationSentAt,omitempty" json:"-"`
+	// VerifiedPhone is the normalized number the user confirmed by SMS; only it logs in
+	VerifiedPhone string `bson:"verifiedPhone,omitempty" json:"verifiedPhone,omitempty"`
+	// CreatedBy is the partner that created the account, e.g. "apikey:<id>"
+	CreatedBy string `bson:"createdBy,omitempty" json:"-"`
+	// PartnerConsents are the API keys the patient allows to book on their behalf
+	PartnerConsents []string `bson:"partnerConsents,omitempty" json:"partnerConsents,omitempty"`
 }
 
 // Doctor model
@@ -31,7 +41,13 @@ type Doctor struct {
 	Experience  string             `bson:"experience" json:"experience" binding:"required"`
 	About       string             `bson:"about" json:"about" binding:"required"`
 	Available   bool               `bson:"available" json:"available"`
-	Fees        float64            `bson:"fees" json:"fees" binding:"required"`
+	Fees        Money              `bson:"fees" json:"fees"`
+	// CommissionPercent overrides the platform commission for this doctor
+	CommissionPercent *float64 `bson:"commissionPercent,omitempty" json:"commissionPercent,omitempty"`
+	// TaxJurisdiction selects the tax rules for this doctor's fees
+	TaxJurisdiction string `bson:"taxJurisdiction,omitempty" json:"taxJurisdiction,omitempty"`
+	// RegistrationNumber is the doctor's medical council registration, printed on prescriptions
+	RegistrationNumber string `bson:"registrationNumber,omitempty" json:"registrationNumber,omitempty"`
 	SlotsBooked map[string][]string `bson:"slots_booked" json:"slots_booked"`
 	Address     Address            `bson:"address" json:"address" binding:"required"`
 	Date        int64              `bson:"date" json:"date"`
@@ -46,11 +62,43 @@ type Appointment struct {
 	SlotTime    string             `bson:"slotTime" json:"slotTime" binding:"required"`
 	UserData    User               `bson:"userData" json:"userData"`
 	DocData     Doctor             `bson:"docData" json:"docData"`
-	Amount      float64            `bson:"amount" json:"amount" binding:"required"`
+	Amount      Money              `bson:"amount" json:"amount"`
+	ServiceType string             `bson:"serviceType,omitempty" json:"serviceType,omitempty"`
+	Tax         []TaxLine          `bson:"tax,omitempty" json:"tax,omitempty"`
+	CouponCode  string             `bson:"couponCode,omitempty" json:"couponCode,omitempty"`
+	Discount    Money              `bson:"discount,omitempty" json:"discount,omitempty"`
+	WalletPaid  Money              `bson:"walletPaid,omitempty" json:"walletPaid,omitempty"`
 	Date        int64              `bson:"date" json:"date"`
 	Cancelled   bool               `bson:"cancelled" json:"cancelled"`
 	Payment     bool               `bson:"payment" json:"payment"`
 	IsCompleted bool               `bson:"isCompleted" json:"isCompleted"`
+	PaidAt      int64              `bson:"paidAt,omitempty" json:"paidAt,omitempty"`
+	// SettlementID is the doctor payout statement that includes this appointment
+	SettlementID string `bson:"settlementId,omitempty" json:"settlementId,omitempty"`
+	// BookedBy is set when a partner booked on the patient's behalf, e.g. "apikey:<id>"
+	BookedBy string `bson:"bookedBy,omitempty" json:"bookedBy,omitempty"`
+}
+
+// Total returns the fee after discounts plus tax charged on top, however it is paid
+func (a *Appointment) Total() (Money, error) {
+	net, err := a.Amount.Sub(a.Discount)
+	if err != nil {
+		return Money{}, err
+	}
+	tax, err := a.ExclusiveTax()
+	if err != nil {
+		return Money{}, err
+	}
+	return net.Add(tax)
+}
+
+// AmountDue returns the fee left to pay through a gateway after discounts and wallet credit
+func (a *Appointment) AmountDue() (Money, error) {
+	total, err := a.Total()
+	if err != nil {
+		return Money{}, err
+	}
+	return total.Sub(a.WalletPaid)
 }
 
 // Address embedded document
@@ -86,25 +134,30 @@ type BookAppointmentRequest struct {
 }
 
 type CancelAppointmentRequest struct {
-	AppointmentID string `json:"appointmentId" binding:"required"`
+	AppointmentID  string `json:"appointmentId" binding:"required"`
+	RefundToWallet bool   `json:"refundToWallet"`
 }
 
 type AddDoctorRequest struct {
-	Name       string  `json:"name" binding:"required"`
-	Email      string  `json:"email" binding:"required,email"`
-	Password   string  `json:"password" binding:"required,min=8"`
-	Speciality string  `json:"speciality" binding:"required"`
-	Degree     string  `json:"degree" binding:"required"`
-	Experience string  `json:"experience" binding:"required"`
-	About      string  `json:"about" binding:"required"`
-	Fees       float64 `json:"fees" binding:"required"`
-	Address    Address `json:"address" binding:"required"`
+	Name       string      `json:"name" binding:"required"`
+	Email      string      `json:"email" binding:"required,email"`
+	Password   string      `json:"password" binding:"required,min=8"`
+	Speciality string      `json:"speciality" binding:"required"`
+	Degree     string      `json:"degree" binding:"required"`
+	Experience string      `json:"experience" binding:"required"`
+	About      string      `json:"about" binding:"required"`
+	Fees       json.Number `json:"fees" binding:"required"`
+	Currency   string      `json:"currency"`
+	Address    Address     `json:"address" binding:"required"`
+	// RegistrationNumber is printed on the doctor's prescriptions
+	RegistrationNumber string `json:"registrationNumber"`
 }
 
 type UpdateDoctorProfileRequest struct {
-	Fees      float64 `json:"fees"`
-	Address   Address `json:"address"`
-	Available bool    `json:"available"`
+	Fees      json.Number `json:"fees"`
+	Currency  string      `json:"currency"`
+	Address   Address     `json:"address"`
+	Available bool        `json:"available"`
 }
 
 type ChangeAvailabilityRequest struct {
@@ -116,24 +169,28 @@ type PaymentRequest struct {
 }
 
 type VerifyPaymentRequest struct {
-	RazorpayOrderID string `json:"razorpay_order_id"`
-	AppointmentID   string `json:"appointmentId"`
-	Success         string `json:"success"`
+	RazorpayOrderID   string `json:"razorpay_order_id"`
+	RazorpayPaymentID string `json:"razorpay_payment_id"`
+	RazorpaySignature string `json:"razorpay_signature"`
+	AppointmentID     string `json:"appointmentId"`
+	Success           string `json:"success"`
 }
 
 // Response structures
 type APIResponse struct {
-	Success bool        `json:"success"`
-	Message string      `json:"message,omitempty"`
-	Token   string      `json:"token,omitempty"`
-	Data    interface{} `json:"data,omitempty"`
+	Success      bool        `json:"success"`
+	Message      string      `json:"message,omitempty"`
+	Token        string      `json:"token,omitempty"`
+	RefreshToken string      `json:"refreshToken,omitempty"`
+	Data         interface{} `json:"data,omitempty"`
 }
 
 type DashboardData struct {
 	Doctors             int           `json:"doctors"`
 	Appointments        int           `json:"appointments"`
 	Patients            int           `json:"patients"`
-	Earnings            float64       `json:"earnings,omitempty"`
+	Earnings            []Money       `json:"earnings,omitempty"`
+	Tax                 []Money       `json:"tax,omitempty"`
 	LatestAppointments  []Appointment `json:"latestAppointments"`
 }
 
@@ -0,0 +1,198 @@
+package models
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/bsontype"
+)
+
+// ErrCurrencyMismatch is returned when amounts of different currencies are combined
+var ErrCurrencyMismatch = errors.New("money: amounts have different currencies")
+
+// Money is an amount in the minor unit of an ISO 4217 currency, e.g. paise
+// for INR, yen for JPY and fils for KWD
+type Money struct {
+	Amount   int64  `bson:"amount" json:"amount"`
+	Currency string `bson:"currency" json:"currency"`
+}
+
+// Currencies whose minor unit is not a hundredth of the major unit
+var currencyExponents = map[string]int{
+	"BIF": 0, "CLP": 0, "DJF": 0, "GNF": 0, "ISK": 0, "JPY": 0, "KMF": 0, "KRW": 0,
+	"PYG": 0, "RWF": 0, "UGX": 0, "VND": 0, "VUV": 0, "XAF": 0, "XOF": 0, "XPF": 0,
+	"BHD": 3, "IQD": 3, "JOD": 3, "KWD": 3, "LYD": 3, "OMR": 3, "TND": 3,
+}
+
+// CurrencyExponent returns the number of decimals of a currency's minor unit
+func CurrencyExponent(currency string) int {
+	if exp, ok := currencyExponents[strings.ToUpper(currency)]; ok {
+		return exp
+	}
+	return 2
+}
+
+// DefaultCurrency is the currency used when none is configured for a doctor
+func DefaultCurrency() string {
+	if currency := os.Getenv("CURRENCY"); currency != "" {
+		return strings.ToUpper(currency)
+	}
+	return "INR"
+}
+
+// NewMoney creates an amount from minor units
+func NewMoney(amount int64, currency string) Money {
+	return Money{Amount: amount, Currency: strings.ToUpper(currency)}
+}
+
+// ParseMoney parses a decimal amount in major units such as "19.99" without
+// going through floating point. More decimals than the currency allows and
+// negative amounts are errors; callers that accept a sign strip it first.
+func ParseMoney(value, currency string) (Money, error) {
+	currency = strings.ToUpper(strings.TrimSpace(currency))
+	if len(currency) != 3 {
+		return Money{}, fmt.Errorf("invalid currency %q", currency)
+	}
+
+	value = strings.TrimSpace(value)
+	if strings.HasPrefix(value, "-") {
+		return Money{}, fmt.Errorf("amount %q must not be negative", value)
+	}
+
+	whole, frac, _ := strings.Cut(value, ".")
+	exp := CurrencyExponent(currency)
+	if whole == "" && frac == "" {
+		return Money{}, fmt.Errorf("invalid amount %q", value)
+	}
+	if len(frac) > exp {
+		return Money{}, fmt.Errorf("%s amounts allow at most %d decimals", currency, exp)
+	}
+	frac += strings.Repeat("0", exp-len(frac))
+
+	amount, err := strconv.ParseInt(whole+frac, 10, 64)
+	if err != nil || strings.ContainsAny(whole+frac, "+-") {
+		return Money{}, fmt.Errorf("invalid amount %q", value)
+	}
+	return Money{Amount: amount, Currency: currency}, nil
+}
+
+// MoneyFromMajor converts a floating point major unit amount, rounding to the
+// nearest minor unit. It exists for legacy data and gateway payloads only.
+func MoneyFromMajor(value float64, currency string) Money {
+	scale := math.Pow10(CurrencyExponent(currency))
+	return NewMoney(int64(math.Round(value*scale)), currency)
+}
+
+// IsZero reports whether the amount is zero
+func (m Money) IsZero() bool {
+	return m.Amount == 0
+}
+
+// Add returns the sum of two amounts of the same currency, or
+// ErrCurrencyMismatch when they differ. An amount without a currency is the
+// zero value and takes the currency of the other.
+func (m Money) Add(other Money) (Money, error) {
+	currency, err := m.commonCurrency(other)
+	if err != nil {
+		return Money{}, err
+	}
+	return Money{Amount: m.Amount + other.Amount, Currency: currency}, nil
+}
+
+// Sub returns the difference of two amounts of the same currency, or
+// ErrCurrencyMismatch when they differ
+func (m Money) Sub(other Money) (Money, error) {
+	currency, err := m.commonCurrency(other)
+	if err != nil {
+		return Money{}, err
+	}
+	return Money{Amount: m.Amount - other.Amount, Currency: currency}, nil
+}
+
+func (m Money) commonCurrency(other Money) (string, error) {
+	switch {
+	case m.Currency == "":
+		return other.Currency, nil
+	case other.Currency == "" || other.Currency == m.Currency:
+		return m.Currency, nil
+	}
+	return "", fmt.Errorf("%w: %s and %s", ErrCurrencyMismatch, m.Currency, other.Currency)
+}
+
+// Percent returns the given percentage of the amount, rounded to the nearest minor unit
+func (m Money) Percent(rate float64) Money {
+	return Money{Amount: int64(math.Round(float64(m.Amount) * rate / 100)), Currency: m.Currency}
+}
+
+// Decimal formats the amount in major units, e.g. "19.99"
+func (m Money) Decimal() string {
+	exp := CurrencyExponent(m.Currency)
+	amount := m.Amount
+	sign := ""
+	if amount < 0 {
+		sign = "-"
+		amount = -amount
+	}
+	if exp == 0 {
+		return sign + strconv.FormatInt(amount, 10)
+	}
+	scale := int64(math.Pow10(exp))
+	return fmt.Sprintf("%s%d.%0*d", sign, amount/scale, exp, amount%scale)
+}
+
+// String formats the amount with its currency, e.g. "INR 19.99"
+func (m Money) String() string {
+	return m.Currency + " " + m.Decimal()
+}
+
+// UnmarshalBSONValue decodes a Money document, and also accepts the plain
+// numbers stored before amounts carried a currency
+func (m *Money) UnmarshalBSONValue(t bsontype.Type, data []byte) error {
+	raw := bson.RawValue{Type: t, Value: data}
+	switch t {
+	case bsontype.Double:
+		*m = MoneyFromMajor(raw.Double(), DefaultCurrency())
+		return nil
+	case bsontype.Int32:
+		*m = MoneyFromMajor(float64(raw.Int32()), DefaultCurrency())
+		return nil
+	case bsontype.Int64:
+		*m = MoneyFromMajor(float64(raw.Int64()), DefaultCurrency())
+		return nil
+	case bsontype.Null, bsontype.Undefined:
+		*m = Money{}
+		return nil
+	}
+
+	type money Money
+	var decoded money
+	if err := raw.Unmarshal(&decoded); err != nil {
+		return err
+	}
+	*m = Money(decoded)
+	return nil
+}
+
+// MoneyTotals accumulates amounts per currency
+type MoneyTotals map[string]int64
+
+// Add adds an amount to the total of its currency
+func (t MoneyTotals) Add(m Money) {
+	t[m.Currency] += m.Amount
+}
+
+// List returns one Money per currency
+func (t MoneyTotals) List() []Money {
+	list := make([]Money, 0, len(t))
+	for currency, amount := range t {
+		list = append(list, Money{Amount: amount, Currency: currency})
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].Currency < list[j].Currency })
+	return list
+}
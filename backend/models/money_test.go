@@ -0,0 +1,112 @@
+package models
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestMoneyArithmetic(t *testing.T) {
+	tests := []struct {
+		name    string
+		a, b    Money
+		sum     Money
+		diff    Money
+		invalid bool
+	}{
+		{"same currency", NewMoney(1999, "INR"), NewMoney(1, "INR"), NewMoney(2000, "INR"), NewMoney(1998, "INR"), false},
+		{"zero value takes the other currency", Money{}, NewMoney(500, "USD"), NewMoney(500, "USD"), NewMoney(-500, "USD"), false},
+		{"other without currency", NewMoney(500, "JPY"), Money{}, NewMoney(500, "JPY"), NewMoney(500, "JPY"), false},
+		{"different currencies", NewMoney(500, "INR"), NewMoney(500, "USD"), Money{}, Money{}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			sum, err := tt.a.Add(tt.b)
+			if tt.invalid {
+				if !errors.Is(err, ErrCurrencyMismatch) {
+					t.Fatalf("Add: got %v, %v, want ErrCurrencyMismatch", sum, err)
+				}
+			} else if err != nil || sum != tt.sum {
+				t.Fatalf("Add: got %v, %v, want %v", sum, err, tt.sum)
+			}
+
+			diff, err := tt.a.Sub(tt.b)
+			if tt.invalid {
+				if !errors.Is(err, ErrCurrencyMismatch) {
+					t.Fatalf("Sub: got %v, %v, want ErrCurrencyMismatch", diff, err)
+				}
+			} else if err != nil || diff != tt.diff {
+				t.Fatalf("Sub: got %v, %v, want %v", diff, err, tt.diff)
+			}
+		})
+	}
+}
+
+func TestAppointmentAmounts(t *testing.T) {
+	appointment := Appointment{
+		Amount:     NewMoney(50000, "INR"),
+		Discount:   NewMoney(5000, "INR"),
+		WalletPaid: NewMoney(10000, "INR"),
+		Tax: []TaxLine{
+			{Name: "GST", Rate: 18, Amount: NewMoney(8100, "INR")},
+			{Name: "Cess", Rate: 1, Inclusive: true, Amount: NewMoney(450, "INR")},
+		},
+	}
+	total, err := appointment.Total()
+	if err != nil || total != NewMoney(53100, "INR") {
+		t.Fatalf("Total: got %v, %v", total, err)
+	}
+	due, err := appointment.AmountDue()
+	if err != nil || due != NewMoney(43100, "INR") {
+		t.Fatalf("AmountDue: got %v, %v", due, err)
+	}
+	tax, err := appointment.TaxTotal()
+	if err != nil || tax != NewMoney(8550, "INR") {
+		t.Fatalf("TaxTotal: got %v, %v", tax, err)
+	}
+
+	// A record whose fee currency changed after the discount was applied
+	mixed := []Appointment{
+		{Amount: NewMoney(50000, "INR"), Discount: NewMoney(500, "USD")},
+		{Amount: NewMoney(50000, "INR"), WalletPaid: NewMoney(500, "USD")},
+		{Amount: NewMoney(50000, "INR"), Tax: []TaxLine{{Name: "VAT", Rate: 20, Amount: NewMoney(100, "USD")}}},
+	}
+	for i, appointment := range mixed {
+		if due, err := appointment.AmountDue(); !errors.Is(err, ErrCurrencyMismatch) {
+			t.Errorf("record %d: AmountDue got %v, %v, want ErrCurrencyMismatch", i, due, err)
+		}
+	}
+}
+
+func TestParseMoney(t *testing.T) {
+	tests := []struct {
+		value    string
+		currency string
+		want     Money
+		invalid  bool
+	}{
+		{"19.99", "inr", NewMoney(1999, "INR"), false},
+		{"19.9", "INR", NewMoney(1990, "INR"), false},
+		{"500", "JPY", NewMoney(500, "JPY"), false},
+		{"1.234", "KWD", NewMoney(1234, "KWD"), false},
+		{".5", "USD", NewMoney(50, "USD"), false},
+		{"19.999", "INR", Money{}, true},
+		{"1.5", "JPY", Money{}, true},
+		{"-5", "INR", Money{}, true},
+		{"+5", "INR", Money{}, true},
+		{"1e3", "INR", Money{}, true},
+		{"", "INR", Money{}, true},
+		{"5", "RUPEE", Money{}, true},
+	}
+	for _, tt := range tests {
+		got, err := ParseMoney(tt.value, tt.currency)
+		if tt.invalid {
+			if err == nil {
+				t.Errorf("ParseMoney(%q, %q) = %v, want an error", tt.value, tt.currency, got)
+			}
+			continue
+		}
+		if err != nil || got != tt.want {
+			t.Errorf("ParseMoney(%q, %q) = %v, %v, want %v", tt.value, tt.currency, got, err, tt.want)
+		}
+	}
+}
@@ -0,0 +1,77 @@
+package models
+
+import (
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// Who an OIDC provider signs in: patients get User accounts, staff are
+// matched to existing doctor and admin accounts
+const (
+	OIDCAudiencePatients = "patients"
+	OIDCAudienceStaff    = "staff"
+)
+
+// OIDCProvider is an identity provider configured through OIDC_<NAME>_* variables
+type OIDCProvider struct {
+	Name         string
+	Issuer       string
+	ClientID     string
+	ClientSecret string
+	RedirectURL  string
+	Audience     string
+	// TrustMFA accepts the provider's amr claim as proof of a second factor for staff
+	TrustMFA bool
+}
+
+// OIDCState remembers a login between the redirect to the provider and the
+// callback. It is deleted when the callback uses it.
+type OIDCState struct {
+	ID        primitive.ObjectID `bson:"_id,omitempty"`
+	StateHash string             `bson:"stateHash"`
+	Provider  string             `bson:"provider"`
+	Role      string             `bson:"role"`
+	Nonce     string             `bson:"nonce"`
+	Verifier  string             `bson:"verifier"`
+	ExpiresAt int64              `bson:"expiresAt"`
+}
+
+// OIDCIdentity links a provider account to one of our accounts
+type OIDCIdentity struct {
+	ID          primitive.ObjectID `bson:"_id,omitempty" json:"id"`
+	Provider    string             `bson:"provider" json:"provider"`
+	Subject     string             `bson:"subject" json:"subject"`
+	Role        string             `bson:"role" json:"role"`
+	PrincipalID string             `bson:"principalId" json:"principalId"`
+	Email       string             `bson:"email" json:"email"`
+	CreatedAt   int64              `bson:"createdAt" json:"createdAt"`
+}
+
+// OIDCClaims are the ID token claims used to find or create an account
+type OIDCClaims struct {
+	Subject       string
+	Email         string
+	EmailVerified bool
+	Name          string
+	// AMR lists the authentication methods the provider used, e.g. "pwd" and "mfa"
+	AMR []string
+}
+
+type OIDCCallbackRequest struct {
+	Code  string `json:"code" binding:"required"`
+	State string `json:"state" binding:"required"`
+}
+
+// JWK is a public RSA key in JSON Web Key form
+type JWK struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid"`
+	Use string `json:"use,omitempty"`
+	Alg string `json:"alg,omitempty"`
+	N   string `json:"n"`
+	E   string `json:"e"`
+}
+
+// JWKSet is the document served at a jwks_uri
+type JWKSet struct {
+	Keys []JWK `json:"keys"`
+}
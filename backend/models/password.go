@@ -0,0 +1,31 @@
+package models
+
+import (
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// PasswordReset is a single-use password reset link. Only the hash of the
+// emailed token is stored.
+type PasswordReset struct {
+	ID          primitive.ObjectID `bson:"_id,omitempty" json:"id"`
+	Role        string             `bson:"role" json:"role"`
+	PrincipalID string             `bson:"principalId" json:"principalId"`
+	TokenHash   string             `bson:"tokenHash" json:"-"`
+	ExpiresAt   int64              `bson:"expiresAt" json:"expiresAt"`
+	UsedAt      int64              `bson:"usedAt,omitempty" json:"usedAt,omitempty"`
+	CreatedAt   int64              `bson:"createdAt" json:"createdAt"`
+}
+
+type ForgotPasswordRequest struct {
+	Email string `json:"email" binding:"required"`
+}
+
+type ResetPasswordRequest struct {
+	Token    string `json:"token" binding:"required"`
+	Password string `json:"password" binding:"required"`
+}
+
+type ChangePasswordRequest struct {
+	CurrentPassword string `json:"currentPassword" binding:"required"`
+	NewPassword     string `json:"newPassword" binding:"required"`
+}
@@ -0,0 +1,50 @@
+package models
+
+import (
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// Channels a passwordless login code is delivered through
+const (
+	LoginCodeEmail = "email"
+	LoginCodeSMS   = "sms"
+	// LoginCodePhone confirms a logged in user's phone before it can be used to log in
+	LoginCodePhone = "phone"
+)
+
+// LoginCode is a single-use magic link token or SMS code. Only its hash is stored.
+type LoginCode struct {
+	ID          primitive.ObjectID `bson:"_id,omitempty" json:"id"`
+	Channel     string             `bson:"channel" json:"channel"`
+	Destination string             `bson:"destination" json:"destination"`
+	UserID      string             `bson:"userId" json:"userId"`
+	CodeHash    string             `bson:"codeHash" json:"-"`
+	// Attempts counts wrong SMS codes entered against this code
+	Attempts  int   `bson:"attempts" json:"attempts"`
+	ExpiresAt int64 `bson:"expiresAt" json:"expiresAt"`
+	UsedAt    int64 `bson:"usedAt,omitempty" json:"usedAt,omitempty"`
+	// IP is the address that asked for the code
+	IP        string `bson:"ip,omitempty" json:"-"`
+	CreatedAt int64  `bson:"createdAt" json:"createdAt"`
+}
+
+type MagicLinkRequest struct {
+	Email string `json:"email" binding:"required"`
+}
+
+type MagicLoginRequest struct {
+	Token string `json:"token" binding:"required"`
+}
+
+type OTPRequest struct {
+	Phone string `json:"phone" binding:"required"`
+}
+
+type PhoneVerifyRequest struct {
+	Code string `json:"code" binding:"required"`
+}
+
+type OTPVerifyRequest struct {
+	Phone string `json:"phone" binding:"required"`
+	Code  string `json:"code" binding:"required"`
+}
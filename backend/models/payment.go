@@ -0,0 +1,80 @@
+package models
+
+import (
+	"encoding/json"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// Payment gateways
+const (
+	GatewayRazorpay = "razorpay"
+	GatewayStripe   = "stripe"
+	GatewayCoupon   = "coupon"
+	GatewayOffline  = "offline"
+	GatewayWallet   = "wallet"
+)
+
+// Offline payment methods collected at the clinic
+const (
+	OfflineMethodCash         = "cash"
+	OfflineMethodCard         = "card"
+	OfflineMethodUPI          = "upi"
+	OfflineMethodBankTransfer = "bank_transfer"
+)
+
+// Payment transaction types
+const (
+	PaymentTypeOrder        = "order"
+	PaymentTypeVerification = "verification"
+	PaymentTypeWebhook      = "webhook"
+	PaymentTypeRefund       = "refund"
+	// PaymentTypeReconciliation marks a charge confirmed by the reconciliation job
+	PaymentTypeReconciliation = "reconciliation"
+)
+
+// Payment transaction statuses
+const (
+	PaymentStatusPending  = "pending"
+	PaymentStatusCreated  = "created"
+	PaymentStatusPaid     = "paid"
+	PaymentStatusFailed   = "failed"
+	PaymentStatusRefunded = "refunded"
+)
+
+// PaymentTransaction is a single entry in the payments ledger
+type PaymentTransaction struct {
+	ID               primitive.ObjectID     `bson:"_id,omitempty" json:"id"`
+	AppointmentID    string                 `bson:"appointmentId" json:"appointmentId"`
+	UserID           string                 `bson:"userId" json:"userId"`
+	Gateway          string                 `bson:"gateway" json:"gateway"`
+	Type             string                 `bson:"type" json:"type"`
+	Status           string                 `bson:"status" json:"status"`
+	GatewayOrderID   string                 `bson:"gatewayOrderId,omitempty" json:"gatewayOrderId,omitempty"`
+	GatewayPaymentID string                 `bson:"gatewayPaymentId,omitempty" json:"gatewayPaymentId,omitempty"`
+	Amount           Money                  `bson:"amount" json:"amount"`
+	Method           string                 `bson:"method,omitempty" json:"method,omitempty"`
+	CollectedBy      string                 `bson:"collectedBy,omitempty" json:"collectedBy,omitempty"`
+	RecordedBy       string                 `bson:"recordedBy,omitempty" json:"recordedBy,omitempty"`
+	IdempotencyKey   string                 `bson:"idempotencyKey,omitempty" json:"-"`
+	Response         map[string]interface{} `bson:"response,omitempty" json:"response,omitempty"`
+	Error            string                 `bson:"error,omitempty" json:"error,omitempty"`
+	SettlementID     string                 `bson:"settlementId,omitempty" json:"settlementId,omitempty"`
+	CreatedAt        int64                  `bson:"createdAt" json:"createdAt"`
+	UpdatedAt        int64                  `bson:"updatedAt" json:"updatedAt"`
+}
+
+type RefundPaymentRequest struct {
+	AppointmentID string      `json:"appointmentId" binding:"required"`
+	Amount        json.Number `json:"amount"`
+	Reason        string      `json:"reason"`
+	ToWallet      bool        `json:"toWallet"`
+}
+
+type RecordOfflinePaymentRequest struct {
+	AppointmentID string      `json:"appointmentId" binding:"required"`
+	Method        string      `json:"method" binding:"required"`
+	Amount        json.Number `json:"amount"`
+	Reference     string      `json:"reference"`
+	CollectedBy   string      `json:"collectedBy"`
+}
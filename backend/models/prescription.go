@@ -0,0 +1,118 @@
+package models
+
+import (
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// Prescription statuses. A signed prescription is never changed again; an
+// amendment is stored as the next version of the same appointment's prescription.
+const (
+	PrescriptionStatusDraft  = "draft"
+	PrescriptionStatusSigned = "signed"
+)
+
+// Results of checking a verification code. Only the current, unrevoked
+// version of a prescription is valid.
+const (
+	PrescriptionValid      = "valid"
+	PrescriptionSuperseded = "superseded"
+	PrescriptionRevoked    = "revoked"
+)
+
+// Prescription is one version of the prescription written for an appointment
+type Prescription struct {
+	ID            primitive.ObjectID `bson:"_id,omitempty" json:"id"`
+	AppointmentID string             `bson:"appointmentId" json:"appointmentId"`
+	UserID        string             `bson:"userId" json:"userId"`
+	DocID         string             `bson:"docId" json:"docId"`
+	Version       int                `bson:"version" json:"version"`
+	// PreviousID is the version this one amends
+	PreviousID      string              `bson:"previousId,omitempty" json:"previousId,omitempty"`
+	AmendmentReason string              `bson:"amendmentReason,omitempty" json:"amendmentReason,omitempty"`
+	Patient         PrescriptionPatient `bson:"patient" json:"patient"`
+	Doctor          PrescriptionDoctor  `bson:"doctor" json:"doctor"`
+	SlotDate        string              `bson:"slotDate" json:"slotDate"`
+	SlotTime        string              `bson:"slotTime" json:"slotTime"`
+	Diagnosis       string              `bson:"diagnosis" json:"diagnosis"`
+	Medications     []Medication        `bson:"medications" json:"medications"`
+	Notes           string              `bson:"notes,omitempty" json:"notes,omitempty"`
+	Status          string              `bson:"status" json:"status"`
+	SignedAt        int64               `bson:"signedAt,omitempty" json:"signedAt,omitempty"`
+	// VerificationCode is printed on the signed prescription for pharmacies to check
+	VerificationCode string `bson:"verificationCode,omitempty" json:"verificationCode,omitempty"`
+	// RevokedAt withdraws a signed prescription; its contents stay unchanged
+	RevokedAt    int64  `bson:"revokedAt,omitempty" json:"revokedAt,omitempty"`
+	RevokeReason string `bson:"revokeReason,omitempty" json:"revokeReason,omitempty"`
+	CreatedAt    int64  `bson:"createdAt" json:"createdAt"`
+	UpdatedAt    int64  `bson:"updatedAt" json:"updatedAt"`
+}
+
+// PrescriptionPatient is the patient as they were when the prescription was written
+type PrescriptionPatient struct {
+	Name   string `bson:"name" json:"name"`
+	Email  string `bson:"email" json:"email"`
+	Gender string `bson:"gender,omitempty" json:"gender,omitempty"`
+	DOB    string `bson:"dob,omitempty" json:"dob,omitempty"`
+}
+
+// PrescriptionDoctor is the prescribing doctor as they were when the prescription was written
+type PrescriptionDoctor struct {
+	Name               string  `bson:"name" json:"name"`
+	Email              string  `bson:"email" json:"email"`
+	Degree             string  `bson:"degree" json:"degree"`
+	Speciality         string  `bson:"speciality" json:"speciality"`
+	Address            Address `bson:"address" json:"address"`
+	RegistrationNumber string  `bson:"registrationNumber,omitempty" json:"registrationNumber,omitempty"`
+}
+
+// Medication is one line of a prescription
+type Medication struct {
+	Name         string `bson:"name" json:"name" binding:"required"`
+	Dose         string `bson:"dose" json:"dose" binding:"required"`
+	Frequency    string `bson:"frequency" json:"frequency" binding:"required"`
+	Duration     string `bson:"duration" json:"duration" binding:"required"`
+	Instructions string `bson:"instructions,omitempty" json:"instructions,omitempty"`
+}
+
+type SavePrescriptionRequest struct {
+	AppointmentID string       `json:"appointmentId" binding:"required"`
+	Diagnosis     string       `json:"diagnosis" binding:"required"`
+	Medications   []Medication `json:"medications" binding:"required,min=1,dive"`
+	Notes         string       `json:"notes"`
+}
+
+type SignPrescriptionRequest struct {
+	AppointmentID string `json:"appointmentId" binding:"required"`
+}
+
+type AmendPrescriptionRequest struct {
+	AppointmentID string       `json:"appointmentId" binding:"required"`
+	Reason        string       `json:"reason" binding:"required"`
+	Diagnosis     string       `json:"diagnosis" binding:"required"`
+	Medications   []Medication `json:"medications" binding:"required,min=1,dive"`
+	Notes         string       `json:"notes"`
+}
+
+type RevokePrescriptionRequest struct {
+	AppointmentID string `json:"appointmentId" binding:"required"`
+	Reason        string `json:"reason" binding:"required"`
+}
+
+type SetDoctorRegistrationRequest struct {
+	DocID              string `json:"docId" binding:"required"`
+	RegistrationNumber string `json:"registrationNumber"`
+}
+
+// PrescriptionVerification is what a pharmacy learns from a verification
+// code: whether the prescription is genuine and current, and what it prescribes
+type PrescriptionVerification struct {
+	Status       string             `json:"status"`
+	Code         string             `json:"code"`
+	Version      int                `json:"version"`
+	PatientName  string             `json:"patientName"`
+	Doctor       PrescriptionDoctor `json:"doctor"`
+	Medications  []Medication       `json:"medications"`
+	SignedAt     int64              `json:"signedAt"`
+	RevokedAt    int64              `json:"revokedAt,omitempty"`
+	RevokeReason string             `json:"revokeReason,omitempty"`
+}
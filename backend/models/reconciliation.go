@@ -0,0 +1,42 @@
+package models
+
+import (
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// Reconciliation mismatch types
+const (
+	MismatchChargedUnpaid  = "charged_but_unpaid"
+	MismatchPaidNoCharge   = "paid_but_no_charge"
+	MismatchAmountDiffers  = "amount_difference"
+	MismatchUnknownReceipt = "unknown_appointment"
+)
+
+// ReconciliationReport is the outcome of comparing gateway records with appointments
+type ReconciliationReport struct {
+	ID          primitive.ObjectID       `bson:"_id,omitempty" json:"id"`
+	Trigger     string                   `bson:"trigger" json:"trigger"`
+	WindowStart int64                    `bson:"windowStart" json:"windowStart"`
+	WindowEnd   int64                    `bson:"windowEnd" json:"windowEnd"`
+	Checked     int                      `bson:"checked" json:"checked"`
+	Fixed       int                      `bson:"fixed" json:"fixed"`
+	Mismatches  []ReconciliationMismatch `bson:"mismatches" json:"mismatches"`
+	Errors      []string                 `bson:"errors,omitempty" json:"errors,omitempty"`
+	StartedAt   int64                    `bson:"startedAt" json:"startedAt"`
+	FinishedAt  int64                    `bson:"finishedAt" json:"finishedAt"`
+}
+
+// ReconciliationMismatch describes one disagreement between a gateway and our records
+type ReconciliationMismatch struct {
+	Type           string `bson:"type" json:"type"`
+	Gateway        string `bson:"gateway,omitempty" json:"gateway,omitempty"`
+	AppointmentID  string `bson:"appointmentId" json:"appointmentId"`
+	GatewayOrderID string `bson:"gatewayOrderId,omitempty" json:"gatewayOrderId,omitempty"`
+	Expected       Money  `bson:"expected" json:"expected"`
+	Charged        Money  `bson:"charged" json:"charged"`
+	Fixed          bool   `bson:"fixed" json:"fixed"`
+}
+
+type ReconcilePaymentsRequest struct {
+	LookbackHours int `json:"lookbackHours"`
+}
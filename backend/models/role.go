@@ -0,0 +1,79 @@
+package models
+
+import (
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// Permissions guarding admin routes. A role may also grant every permission
+// of a resource with "resource:*" or everything with "*".
+const (
+	PermAppointmentsRead   = "appointments:read"
+	PermAppointmentsCancel = "appointments:cancel"
+	PermDoctorsRead        = "doctors:read"
+	PermDoctorsWrite       = "doctors:write"
+	PermDashboardRead      = "dashboard:read"
+	PermPaymentsRead       = "payments:read"
+	PermPaymentsRecord     = "payments:record"
+	PermPaymentsRefund     = "payments:refund"
+	PermPaymentsReconcile  = "payments:reconcile"
+	PermInvoicesRead       = "invoices:read"
+	PermWalletsRead        = "wallets:read"
+	PermWalletsWrite       = "wallets:write"
+	PermCouponsRead        = "coupons:read"
+	PermCouponsWrite       = "coupons:write"
+	PermSettlementsRead    = "settlements:read"
+	PermSettlementsWrite   = "settlements:write"
+	PermTaxRead            = "tax:read"
+	PermTaxWrite           = "tax:write"
+	PermReportsRead        = "reports:read"
+	PermAdminsManage       = "admins:manage"
+	PermRolesManage        = "roles:manage"
+	PermAPIKeysManage      = "api_keys:manage"
+	PermSessionsManage     = "sessions:manage"
+	PermAll                = "*"
+)
+
+// AllPermissions lists every permission a role can be granted
+var AllPermissions = []string{
+	PermAppointmentsRead, PermAppointmentsCancel,
+	PermDoctorsRead, PermDoctorsWrite,
+	PermDashboardRead,
+	PermPaymentsRead, PermPaymentsRecord, PermPaymentsRefund, PermPaymentsReconcile,
+	PermInvoicesRead,
+	PermWalletsRead, PermWalletsWrite,
+	PermCouponsRead, PermCouponsWrite,
+	PermSettlementsRead, PermSettlementsWrite,
+	PermTaxRead, PermTaxWrite,
+	PermReportsRead,
+	PermAdminsManage, PermRolesManage,
+	PermAPIKeysManage, PermSessionsManage,
+}
+
+// RoleSuperAdmin is the built-in role holding every permission
+const RoleSuperAdmin = "super_admin"
+
+// Role is a named set of permissions assigned to admins
+type Role struct {
+	ID          primitive.ObjectID `bson:"_id,omitempty" json:"id"`
+	Name        string             `bson:"name" json:"name"`
+	Description string             `bson:"description" json:"description"`
+	Permissions []string           `bson:"permissions" json:"permissions"`
+	// System roles are created at startup and cannot be deleted
+	System    bool  `bson:"system" json:"system"`
+	CreatedAt int64 `bson:"createdAt" json:"createdAt"`
+}
+
+type SaveRoleRequest struct {
+	Name        string   `json:"name" binding:"required"`
+	Description string   `json:"description"`
+	Permissions []string `json:"permissions" binding:"required"`
+}
+
+type DeleteRoleRequest struct {
+	Name string `json:"name" binding:"required"`
+}
+
+type SetAdminRolesRequest struct {
+	AdminID string   `json:"adminId" binding:"required"`
+	Roles   []string `json:"roles" binding:"required"`
+}
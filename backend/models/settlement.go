@@ -0,0 +1,64 @@
+package models
+
+import (
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// Settlement statuses. A draft is being built and is not shown.
+const (
+	SettlementStatusDraft   = "draft"
+	SettlementStatusPending = "pending"
+	SettlementStatusPaid    = "paid"
+)
+
+// Settlement is a payout statement for a doctor covering one period. Refunds
+// is the total of the refund lines' deductions.
+type Settlement struct {
+	ID                primitive.ObjectID `bson:"_id,omitempty" json:"id"`
+	DocID             string             `bson:"docId" json:"docId"`
+	DoctorName        string             `bson:"doctorName" json:"doctorName"`
+	PeriodStart       int64              `bson:"periodStart" json:"periodStart"`
+	PeriodEnd         int64              `bson:"periodEnd" json:"periodEnd"`
+	CommissionPercent float64            `bson:"commissionPercent" json:"commissionPercent"`
+	Lines             []SettlementLine   `bson:"lines" json:"lines"`
+	Gross             Money              `bson:"gross" json:"gross"`
+	Commission        Money              `bson:"commission" json:"commission"`
+	Refunds           Money              `bson:"refunds" json:"refunds"`
+	NetPayable        Money              `bson:"netPayable" json:"netPayable"`
+	Status            string             `bson:"status" json:"status"`
+	PaymentReference  string             `bson:"paymentReference,omitempty" json:"paymentReference,omitempty"`
+	PaidAt            int64              `bson:"paidAt,omitempty" json:"paidAt,omitempty"`
+	CreatedAt         int64              `bson:"createdAt" json:"createdAt"`
+}
+
+// SettlementLine is one appointment or refund included in a settlement
+type SettlementLine struct {
+	AppointmentID string `bson:"appointmentId" json:"appointmentId"`
+	SlotDate      string `bson:"slotDate" json:"slotDate"`
+	PatientName   string `bson:"patientName" json:"patientName"`
+	Gross         Money  `bson:"gross" json:"gross"`
+	Commission    Money  `bson:"commission" json:"commission"`
+	// Refund is what the patient got back, tax included
+	Refund Money `bson:"refund" json:"refund"`
+	// RefundedFee is the refunded share of the fee net of tax and
+	// CommissionReversed the commission kept on it; the doctor repays the
+	// difference, Deduction
+	RefundedFee        Money `bson:"refundedFee,omitempty" json:"refundedFee,omitempty"`
+	CommissionReversed Money `bson:"commissionReversed,omitempty" json:"commissionReversed,omitempty"`
+	Deduction          Money `bson:"deduction,omitempty" json:"deduction,omitempty"`
+}
+
+type SetCommissionRequest struct {
+	DocID   string   `json:"docId"`
+	Percent *float64 `json:"percent" binding:"required"`
+}
+
+type GenerateSettlementsRequest struct {
+	PeriodStart int64 `json:"periodStart" binding:"required"`
+	PeriodEnd   int64 `json:"periodEnd" binding:"required"`
+}
+
+type MarkSettlementPaidRequest struct {
+	SettlementID string `json:"settlementId" binding:"required"`
+	Reference    string `json:"reference" binding:"required"`
+}
@@ -0,0 +1,22 @@
+package models
+
+import (
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// Signing key statuses. Tokens are signed with the single active key;
+// retired keys still verify tokens issued before a rotation.
+const (
+	SigningKeyActive  = "active"
+	SigningKeyRetired = "retired"
+)
+
+// SigningKey is an RSA key pair used to sign tokens, shared by all instances
+type SigningKey struct {
+	ID         primitive.ObjectID `bson:"_id,omitempty" json:"id"`
+	Kid        string             `bson:"kid" json:"kid"`
+	PrivateKey string             `bson:"privateKey" json:"-"`
+	Status     string             `bson:"status" json:"status"`
+	CreatedAt  int64              `bson:"createdAt" json:"createdAt"`
+	RetiredAt  int64              `bson:"retiredAt,omitempty" json:"retiredAt,omitempty"`
+}
@@ -0,0 +1,119 @@
+package models
+
+import (
+	"math"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// Service types that tax rules can target
+const (
+	ServiceConsultation = "consultation"
+)
+
+// TaxRule is a tax applied to fees of a service type in a jurisdiction.
+// An empty jurisdiction or service type matches any.
+type TaxRule struct {
+	ID           primitive.ObjectID `bson:"_id,omitempty" json:"id"`
+	Name         string             `bson:"name" json:"name"`
+	Jurisdiction string             `bson:"jurisdiction" json:"jurisdiction"`
+	ServiceType  string             `bson:"serviceType" json:"serviceType"`
+	Rate         float64            `bson:"rate" json:"rate"`
+	Inclusive    bool               `bson:"inclusive" json:"inclusive"`
+	Active       bool               `bson:"active" json:"active"`
+	CreatedAt    int64              `bson:"createdAt" json:"createdAt"`
+}
+
+// TaxLine is a tax charged on an appointment
+type TaxLine struct {
+	Name      string  `bson:"name" json:"name"`
+	Rate      float64 `bson:"rate" json:"rate"`
+	Inclusive bool    `bson:"inclusive" json:"inclusive"`
+	Amount    Money   `bson:"amount" json:"amount"`
+}
+
+// ComputeTaxLines works out the tax on a price using the rates of the given
+// lines. Inclusive rates are contained in the price and exclusive rates are
+// charged on top of the price net of inclusive tax.
+func ComputeTaxLines(price Money, lines []TaxLine) []TaxLine {
+	if len(lines) == 0 {
+		return nil
+	}
+
+	inclusiveRate := 0.0
+	for _, line := range lines {
+		if line.Inclusive {
+			inclusiveRate += line.Rate
+		}
+	}
+	net := float64(price.Amount) * 100 / (100 + inclusiveRate)
+
+	computed := make([]TaxLine, len(lines))
+	for i, line := range lines {
+		line.Amount = NewMoney(int64(math.Round(net*line.Rate/100)), price.Currency)
+		computed[i] = line
+	}
+	return computed
+}
+
+// TaxTotal returns the tax charged on the appointment
+func (a *Appointment) TaxTotal() (Money, error) {
+	return a.sumTax(true)
+}
+
+// ExclusiveTax returns the tax charged on top of the fee
+func (a *Appointment) ExclusiveTax() (Money, error) {
+	return a.sumTax(false)
+}
+
+func (a *Appointment) sumTax(inclusive bool) (Money, error) {
+	total := NewMoney(0, a.Amount.Currency)
+	for _, line := range a.Tax {
+		if line.Inclusive && !inclusive {
+			continue
+		}
+		var err error
+		if total, err = total.Add(line.Amount); err != nil {
+			return Money{}, err
+		}
+	}
+	return total, nil
+}
+
+// RevenueReport summarises paid appointments in a period, one entry per currency
+type RevenueReport struct {
+	PeriodStart int64            `json:"periodStart"`
+	PeriodEnd   int64            `json:"periodEnd"`
+	Currencies  []RevenueSummary `json:"currencies"`
+	// Inconsistent lists appointments left out because their amounts are in different currencies
+	Inconsistent []string `json:"inconsistent,omitempty"`
+}
+
+// RevenueSummary is the revenue collected in one currency
+type RevenueSummary struct {
+	Currency     string    `json:"currency"`
+	Appointments int       `json:"appointments"`
+	Fees         Money     `json:"fees"`
+	Discounts    Money     `json:"discounts"`
+	Tax          Money     `json:"tax"`
+	TaxLines     []TaxLine `json:"taxLines"`
+	Collected    Money     `json:"collected"`
+}
+
+type AddTaxRuleRequest struct {
+	Name         string   `json:"name" binding:"required"`
+	Jurisdiction string   `json:"jurisdiction"`
+	ServiceType  string   `json:"serviceType"`
+	Rate         *float64 `json:"rate" binding:"required"`
+	Inclusive    bool     `json:"inclusive"`
+}
+
+type ChangeTaxRuleStatusRequest struct {
+	TaxRuleID string `json:"taxRuleId" binding:"required"`
+	Active    bool   `json:"active"`
+}
+
+type SetTaxJurisdictionRequest struct {
+	DocID        string `json:"docId" binding:"required"`
+	Jurisdiction string `json:"jurisdiction"`
+}
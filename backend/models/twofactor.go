@@ -0,0 +1,49 @@
+package models
+
+import (
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// TwoFactor is the TOTP second factor of a doctor or admin account
+type TwoFactor struct {
+	ID          primitive.ObjectID `bson:"_id,omitempty" json:"id"`
+	Role        string             `bson:"role" json:"role"`
+	PrincipalID string             `bson:"principalId" json:"principalId"`
+	Secret      string             `bson:"secret" json:"-"`
+	// Enabled is set once a code from the authenticator app has been confirmed
+	Enabled bool `bson:"enabled" json:"enabled"`
+	// RecoveryCodes holds hashes of the unused single-use recovery codes
+	RecoveryCodes []string `bson:"recoveryCodes" json:"-"`
+	// LastUsedStep is the TOTP time step of the last accepted code, so a code works once
+	LastUsedStep int64 `bson:"lastUsedStep" json:"-"`
+	EnabledAt    int64 `bson:"enabledAt,omitempty" json:"enabledAt,omitempty"`
+	CreatedAt    int64 `bson:"createdAt" json:"createdAt"`
+}
+
+// TwoFactorSetup is returned when enrollment starts; the URI is shown as a QR code
+type TwoFactorSetup struct {
+	Secret          string `json:"secret"`
+	ProvisioningURI string `json:"provisioningUri"`
+}
+
+// LoginChallenge is returned by a login that still needs a second factor.
+// EnrollmentRequired means the account must set up 2FA before it can log in.
+type LoginChallenge struct {
+	TwoFactorRequired  bool   `json:"twoFactorRequired"`
+	EnrollmentRequired bool   `json:"enrollmentRequired,omitempty"`
+	ChallengeToken     string `json:"challengeToken"`
+}
+
+type TwoFactorCodeRequest struct {
+	Code string `json:"code" binding:"required"`
+}
+
+type TwoFactorChallengeRequest struct {
+	ChallengeToken string `json:"challengeToken" binding:"required"`
+	Code           string `json:"code"`
+}
+
+type ResetTwoFactorRequest struct {
+	Role      string `json:"role" binding:"required,oneof=doctor admin"`
+	AccountID string `json:"accountId" binding:"required"`
+}
@@ -0,0 +1,56 @@
+package models
+
+import (
+	"encoding/json"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// Wallet transaction types
+const (
+	WalletCredit = "credit"
+	WalletDebit  = "debit"
+)
+
+// Wallet transaction reasons
+const (
+	WalletReasonRefund     = "refund"
+	WalletReasonAdjustment = "adjustment"
+	WalletReasonPayment    = "payment"
+	WalletReasonRelease    = "release"
+)
+
+// Wallet holds a user's credit balance in one currency
+type Wallet struct {
+	ID        primitive.ObjectID `bson:"_id,omitempty" json:"id"`
+	UserID    string             `bson:"userId" json:"userId"`
+	Balance   Money              `bson:"balance" json:"balance"`
+	UpdatedAt int64              `bson:"updatedAt" json:"updatedAt"`
+}
+
+// WalletTransaction is one credit or debit in a user's wallet history
+type WalletTransaction struct {
+	ID             primitive.ObjectID `bson:"_id,omitempty" json:"id"`
+	UserID         string             `bson:"userId" json:"userId"`
+	Type           string             `bson:"type" json:"type"`
+	Reason         string             `bson:"reason" json:"reason"`
+	Amount         Money              `bson:"amount" json:"amount"`
+	BalanceAfter   Money              `bson:"balanceAfter" json:"balanceAfter"`
+	AppointmentID  string             `bson:"appointmentId,omitempty" json:"appointmentId,omitempty"`
+	Note           string             `bson:"note,omitempty" json:"note,omitempty"`
+	CreatedBy      string             `bson:"createdBy" json:"createdBy"`
+	IdempotencyKey string             `bson:"idempotencyKey,omitempty" json:"-"`
+	CreatedAt      int64              `bson:"createdAt" json:"createdAt"`
+}
+
+type PayFromWalletRequest struct {
+	AppointmentID string      `json:"appointmentId" binding:"required"`
+	Amount        json.Number `json:"amount"`
+}
+
+type AdjustWalletRequest struct {
+	UserID   string      `json:"userId" binding:"required"`
+	Amount   json.Number `json:"amount" binding:"required"`
+	Currency string      `json:"currency"`
+	Reason   string      `json:"reason" binding:"required"`
+}
@@ -4,38 +4,84 @@ import (
 	"github.com/gin-gonic/gin"
 	"prescripto-go/controllers"
 	"prescripto-go/middleware"
+	"prescripto-go/models"
 )
 
 // UserRoutes defines all user-related routes
 func UserRoutes(router *gin.RouterGroup) {
 	router.POST("/register", controllers.RegisterUser)
 	router.POST("/login", controllers.LoginUser)
+	router.POST("/refresh-token", controllers.RefreshUserToken)
+	router.GET("/oidc/:provider/login", controllers.BeginUserOIDC)
+	router.POST("/oidc/:provider/callback", controllers.FinishUserOIDC)
+	router.POST("/forgot-password", controllers.ForgotUserPassword)
+	router.POST("/reset-password", controllers.ResetUserPassword)
+	router.POST("/verify-email", controllers.VerifyEmail)
+	router.POST("/magic-link", controllers.RequestMagicLink)
+	router.POST("/magic-login", controllers.MagicLogin)
+	router.POST("/otp/request", controllers.RequestOTP)
+	router.POST("/otp/verify", controllers.VerifyOTP)
+	router.GET("/password-policy", controllers.GetPasswordPolicy)
 
 	// Protected routes
 	protected := router.Group("/")
 	protected.Use(middleware.AuthUser())
 	{
+		protected.POST("/logout", controllers.Logout)
+		protected.POST("/logout-all", controllers.LogoutAll)
+		protected.GET("/sessions", controllers.GetSessions)
+		protected.POST("/revoke-session", controllers.RevokeOwnSession)
+		protected.POST("/change-password", controllers.ChangePassword)
+		protected.POST("/resend-verification", controllers.ResendVerification)
 		protected.GET("/get-profile", controllers.GetProfile)
 		protected.POST("/update-profile", middleware.FileUpload(), controllers.UpdateProfile)
+		protected.POST("/phone/request", controllers.RequestPhoneVerification)
+		protected.POST("/phone/verify", controllers.VerifyPhone)
 		protected.POST("/book-appointment", controllers.BookAppointment)
 		protected.GET("/appointments", controllers.ListAppointments)
 		protected.POST("/cancel-appointment", controllers.CancelAppointment)
+		protected.POST("/apply-coupon", controllers.ApplyCoupon)
+		protected.GET("/partners", controllers.GetPartners)
+		protected.POST("/partner-consent", controllers.SetPartnerConsent)
+		protected.GET("/wallet", controllers.GetWallet)
+		protected.POST("/pay-wallet", controllers.PayFromWallet)
 		protected.POST("/payment-razorpay", controllers.PaymentRazorpay)
 		protected.POST("/verifyRazorpay", controllers.VerifyRazorpay)
 		protected.POST("/payment-stripe", controllers.PaymentStripe)
 		protected.POST("/verifyStripe", controllers.VerifyStripe)
+		protected.GET("/invoices", controllers.GetUserInvoices)
+		protected.GET("/invoice/:appointmentId", controllers.DownloadUserInvoice)
+		protected.GET("/prescriptions", controllers.GetUserPrescriptions)
+		protected.GET("/prescription/:appointmentId", controllers.GetUserPrescription)
+		protected.GET("/prescription/:appointmentId/pdf", controllers.DownloadUserPrescription)
 	}
 }
 
 // DoctorRoutes defines all doctor-related routes
 func DoctorRoutes(router *gin.RouterGroup) {
 	router.POST("/login", controllers.LoginDoctor)
+	router.POST("/refresh-token", controllers.RefreshDoctorToken)
+	router.GET("/oidc/:provider/login", controllers.BeginDoctorOIDC)
+	router.POST("/oidc/:provider/callback", controllers.FinishDoctorOIDC)
+	router.POST("/login-2fa", controllers.VerifyLoginTwoFactor)
+	router.POST("/login-2fa/setup", controllers.StartLoginEnrollment)
+	router.POST("/login-2fa/confirm", controllers.ConfirmLoginEnrollment)
+	router.POST("/forgot-password", controllers.ForgotDoctorPassword)
+	router.POST("/reset-password", controllers.ResetDoctorPassword)
 	router.GET("/list", controllers.GetDoctorList)
 
 	// Protected routes
 	protected := router.Group("/")
 	protected.Use(middleware.AuthDoctor())
 	{
+		protected.POST("/logout", controllers.Logout)
+		protected.POST("/logout-all", controllers.LogoutAll)
+		protected.GET("/sessions", controllers.GetSessions)
+		protected.POST("/revoke-session", controllers.RevokeOwnSession)
+		protected.POST("/change-password", controllers.ChangePassword)
+		protected.POST("/2fa/setup", controllers.SetupTwoFactor)
+		protected.POST("/2fa/confirm", controllers.ConfirmTwoFactor)
+		protected.POST("/2fa/disable", controllers.DisableTwoFactor)
 		protected.POST("/cancel-appointment", controllers.CancelDoctorAppointment)
 		protected.GET("/appointments", controllers.GetDoctorAppointments)
 		protected.POST("/change-availability", controllers.ChangeAvailability)
@@ -43,22 +89,113 @@ func DoctorRoutes(router *gin.RouterGroup) {
 		protected.GET("/dashboard", controllers.GetDoctorDashboard)
 		protected.GET("/profile", controllers.GetDoctorProfile)
 		protected.POST("/update-profile", controllers.UpdateDoctorProfile)
+		protected.GET("/invoice/:appointmentId", controllers.DownloadDoctorInvoice)
+		protected.POST("/save-prescription", controllers.SavePrescription)
+		protected.POST("/sign-prescription", controllers.SignPrescription)
+		protected.POST("/amend-prescription", controllers.AmendPrescription)
+		protected.POST("/revoke-prescription", controllers.RevokePrescription)
+		protected.GET("/prescription/:appointmentId", controllers.GetDoctorPrescription)
+		protected.GET("/prescription/:appointmentId/pdf", controllers.DownloadDoctorPrescription)
+		protected.GET("/settlements", controllers.GetDoctorSettlements)
+		protected.POST("/record-payment", controllers.RecordDoctorOfflinePayment)
 	}
 }
 
 // AdminRoutes defines all admin-related routes
 func AdminRoutes(router *gin.RouterGroup) {
 	router.POST("/login", controllers.LoginAdmin)
+	router.POST("/refresh-token", controllers.RefreshAdminToken)
+	router.GET("/oidc/:provider/login", controllers.BeginAdminOIDC)
+	router.POST("/oidc/:provider/callback", controllers.FinishAdminOIDC)
+	router.POST("/login-2fa", controllers.VerifyLoginTwoFactor)
+	router.POST("/login-2fa/setup", controllers.StartLoginEnrollment)
+	router.POST("/login-2fa/confirm", controllers.ConfirmLoginEnrollment)
+	router.POST("/accept-invite", controllers.AcceptAdminInvite)
 
 	// Protected routes
 	protected := router.Group("/")
 	protected.Use(middleware.AuthAdmin())
 	{
-		protected.POST("/add-doctor", middleware.FileUpload(), controllers.AddDoctor)
-		protected.GET("/appointments", controllers.GetAllAppointments)
-		protected.POST("/cancel-appointment", controllers.CancelAppointmentAdmin)
-		protected.GET("/all-doctors", controllers.GetAllDoctors)
-		protected.POST("/change-availability", controllers.ChangeAvailability)
-		protected.GET("/dashboard", controllers.GetAdminDashboard)
+		// Each route requires a permission granted through the admin's roles
+		allow := middleware.RequirePermission
+
+		protected.POST("/logout", controllers.Logout)
+		protected.POST("/logout-all", controllers.LogoutAll)
+		protected.GET("/sessions", controllers.GetSessions)
+		protected.POST("/revoke-session", controllers.RevokeOwnSession)
+		protected.POST("/change-password", controllers.ChangePassword)
+		protected.POST("/2fa/setup", controllers.SetupTwoFactor)
+		protected.POST("/2fa/confirm", controllers.ConfirmTwoFactor)
+		protected.POST("/2fa/disable", controllers.DisableTwoFactor)
+		protected.POST("/add-doctor", allow(models.PermDoctorsWrite), middleware.FileUpload(), controllers.AddDoctor)
+		protected.GET("/appointments", allow(models.PermAppointmentsRead), controllers.GetAllAppointments)
+		protected.POST("/cancel-appointment", allow(models.PermAppointmentsCancel), controllers.CancelAppointmentAdmin)
+		protected.GET("/all-doctors", allow(models.PermDoctorsRead), controllers.GetAllDoctors)
+		protected.POST("/change-availability", allow(models.PermDoctorsWrite), controllers.ChangeAvailability)
+		protected.GET("/dashboard", allow(models.PermDashboardRead), controllers.GetAdminDashboard)
+		protected.GET("/payments", allow(models.PermPaymentsRead), controllers.GetPayments)
+		protected.POST("/refund-payment", allow(models.PermPaymentsRefund), controllers.RefundPayment)
+		protected.POST("/record-payment", allow(models.PermPaymentsRecord), controllers.RecordAdminOfflinePayment)
+		protected.GET("/wallet", allow(models.PermWalletsRead), controllers.GetUserWallet)
+		protected.POST("/adjust-wallet", allow(models.PermWalletsWrite), controllers.AdjustWallet)
+		protected.POST("/add-tax-rule", allow(models.PermTaxWrite), controllers.AddTaxRule)
+		protected.GET("/tax-rules", allow(models.PermTaxRead), controllers.GetTaxRules)
+		protected.POST("/change-tax-rule-status", allow(models.PermTaxWrite), controllers.ChangeTaxRuleStatus)
+		protected.POST("/set-tax-jurisdiction", allow(models.PermTaxWrite), controllers.SetTaxJurisdiction)
+		protected.POST("/set-doctor-registration", allow(models.PermDoctorsWrite), controllers.SetDoctorRegistration)
+		protected.GET("/revenue-report", allow(models.PermReportsRead), controllers.GetRevenueReport)
+		protected.GET("/admins", allow(models.PermAdminsManage), controllers.GetAdmins)
+		protected.POST("/invite-admin", allow(models.PermAdminsManage), controllers.InviteAdmin)
+		protected.POST("/change-admin-status", allow(models.PermAdminsManage), controllers.ChangeAdminStatus)
+		protected.POST("/reset-2fa", allow(models.PermAdminsManage), controllers.ResetTwoFactor)
+		protected.GET("/account-sessions", allow(models.PermSessionsManage), controllers.GetAccountSessions)
+		protected.POST("/terminate-session", allow(models.PermSessionsManage), controllers.TerminateSession)
+		protected.POST("/terminate-all-sessions", allow(models.PermSessionsManage), controllers.TerminateAllSessions)
+		protected.POST("/create-api-key", allow(models.PermAPIKeysManage), controllers.CreateAPIKey)
+		protected.GET("/api-keys", allow(models.PermAPIKeysManage), controllers.GetAPIKeys)
+		protected.POST("/revoke-api-key", allow(models.PermAPIKeysManage), controllers.RevokeAPIKey)
+		protected.GET("/lockouts", allow(models.PermAdminsManage), controllers.GetLockouts)
+		protected.POST("/clear-lockout", allow(models.PermAdminsManage), controllers.ClearLockout)
+		protected.POST("/breached-passwords", allow(models.PermAdminsManage), middleware.FileUpload(), controllers.UploadBreachedPasswords)
+		protected.POST("/set-admin-roles", allow(models.PermAdminsManage), controllers.SetAdminRoles)
+		protected.GET("/roles", allow(models.PermRolesManage), controllers.GetRoles)
+		protected.POST("/save-role", allow(models.PermRolesManage), controllers.SaveRole)
+		protected.POST("/delete-role", allow(models.PermRolesManage), controllers.DeleteRole)
+		protected.GET("/invoice/:appointmentId", allow(models.PermInvoicesRead), controllers.DownloadAdminInvoice)
+		protected.POST("/add-coupon", allow(models.PermCouponsWrite), controllers.AddCoupon)
+		protected.GET("/coupons", allow(models.PermCouponsRead), controllers.GetCoupons)
+		protected.POST("/change-coupon-status", allow(models.PermCouponsWrite), controllers.ChangeCouponStatus)
+		protected.POST("/set-commission", allow(models.PermSettlementsWrite), controllers.SetCommission)
+		protected.POST("/generate-settlements", allow(models.PermSettlementsWrite), controllers.GenerateSettlements)
+		protected.GET("/settlements", allow(models.PermSettlementsRead), controllers.GetSettlements)
+		protected.POST("/mark-settlement-paid", allow(models.PermSettlementsWrite), controllers.MarkSettlementPaid)
+		protected.GET("/settlement/:settlementId/export", allow(models.PermSettlementsRead), controllers.ExportSettlement)
+		protected.POST("/reconcile-payments", allow(models.PermPaymentsReconcile), controllers.ReconcilePayments)
+		protected.GET("/reconciliation-reports", allow(models.PermPaymentsRead), controllers.GetReconciliationReports)
 	}
+}
+
+// PartnerRoutes defines the server-to-server routes called with API keys
+func PartnerRoutes(router *gin.RouterGroup) {
+	scope := middleware.AuthAPIKey
+
+	router.GET("/doctors", scope(models.ScopeAvailabilityRead), controllers.GetDoctorList)
+	router.POST("/book-appointment", scope(models.ScopeBookingsWrite), controllers.PartnerBookAppointment)
+	router.GET("/appointments", scope(models.ScopeBookingsRead), controllers.GetPartnerAppointments)
+}
+
+// PaymentRoutes defines payment gateway callback routes
+func PaymentRoutes(router *gin.RouterGroup) {
+	router.POST("/razorpay/webhook", controllers.RazorpayWebhook)
+	router.POST("/stripe/webhook", controllers.StripeWebhook)
+}
+
+// PrescriptionRoutes defines public routes for pharmacies checking prescriptions
+func PrescriptionRoutes(router *gin.RouterGroup) {
+	router.GET("/verify/:code", controllers.VerifyPrescription)
+}
+
+// WellKnownRoutes defines the discovery documents served at /.well-known
+func WellKnownRoutes(router *gin.RouterGroup) {
+	router.GET("/jwks.json", controllers.GetJWKS)
 }
\ No newline at end of file
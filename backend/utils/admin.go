@@ -0,0 +1,113 @@
+package utils
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"prescripto-go/config"
+	"prescripto-go/models"
+)
+
+// AdminInviteTTL is how long an admin invitation stays valid
+const AdminInviteTTL = 72 * time.Hour
+
+var ErrAdminExists = errors.New("An admin with this email already exists")
+
+// NormalizeEmail lower-cases and trims an email address for lookups
+func NormalizeEmail(email string) string {
+	return strings.ToLower(strings.TrimSpace(email))
+}
+
+// CreateAdmin adds an active super admin with the given password
+func CreateAdmin(name, email, password string) (*models.Admin, error) {
+	hash, err := HashPassword(password)
+	if err != nil {
+		return nil, err
+	}
+
+	admin := &models.Admin{
+		Name:      name,
+		Email:     NormalizeEmail(email),
+		Password:  hash,
+		Status:    models.AdminStatusActive,
+		Roles:     []string{models.RoleSuperAdmin},
+		CreatedAt: time.Now().Unix(),
+	}
+	if err := insertAdmin(admin); err != nil {
+		return nil, err
+	}
+	return admin, nil
+}
+
+// InviteAdmin adds an invited admin and returns the invitation token, which
+// is only stored hashed
+func InviteAdmin(name, email string, roles []string, invitedBy string) (*models.Admin, string, error) {
+	token, err := RandomToken(32)
+	if err != nil {
+		return nil, "", err
+	}
+
+	admin := &models.Admin{
+		Name:            name,
+		Email:           NormalizeEmail(email),
+		Status:          models.AdminStatusInvited,
+		Roles:           roles,
+		InviteTokenHash: HashToken(token),
+		InviteExpiresAt: time.Now().Add(AdminInviteTTL).Unix(),
+		InvitedBy:       invitedBy,
+		CreatedAt:       time.Now().Unix(),
+	}
+	if err := insertAdmin(admin); err != nil {
+		return nil, "", err
+	}
+	return admin, token, nil
+}
+
+func insertAdmin(admin *models.Admin) error {
+	result, err := config.GetCollection("admins").InsertOne(context.Background(), admin)
+	if mongo.IsDuplicateKeyError(err) {
+		return ErrAdminExists
+	}
+	if err != nil {
+		return err
+	}
+	admin.ID = result.InsertedID.(primitive.ObjectID)
+	return nil
+}
+
+// FindActiveAdmin returns an admin that is allowed to sign in
+func FindActiveAdmin(adminID string) (*models.Admin, error) {
+	adminObjectID, err := primitive.ObjectIDFromHex(adminID)
+	if err != nil {
+		return nil, err
+	}
+
+	var admin models.Admin
+	err = config.GetCollection("admins").FindOne(context.Background(), bson.M{
+		"_id":    adminObjectID,
+		"status": models.AdminStatusActive,
+	}).Decode(&admin)
+	if err != nil {
+		return nil, err
+	}
+	return &admin, nil
+}
+
+// CountAdmins returns how many admin accounts exist
+func CountAdmins() (int64, error) {
+	return config.GetCollection("admins").CountDocuments(context.Background(), bson.M{})
+}
+
+// RolesExist reports whether every named role has been defined
+func RolesExist(names []string) (bool, error) {
+	count, err := config.GetCollection("roles").CountDocuments(context.Background(), bson.M{"name": bson.M{"$in": names}})
+	if err != nil {
+		return false, err
+	}
+	return count == int64(len(names)), nil
+}
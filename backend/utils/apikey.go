@@ -0,0 +1,135 @@
+package utils
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"prescripto-go/config"
+	"prescripto-go/models"
+)
+
+// apiKeyPrefix marks our keys so they are easy to spot in leaked secrets
+const apiKeyPrefix = "psk_"
+
+var ErrRateLimited = errors.New("Rate limit exceeded")
+
+// defaultAPIKeyRateLimit applies when a key is created without a limit
+func defaultAPIKeyRateLimit() int {
+	return envInt("API_KEY_RATE_LIMIT", 60)
+}
+
+// CreateAPIKey issues a key with the scopes. The plain key is returned once.
+func CreateAPIKey(name string, scopes []string, rateLimit int, createdBy string) (*models.APIKey, string, error) {
+	secret, err := RandomToken(24)
+	if err != nil {
+		return nil, "", err
+	}
+	plain := apiKeyPrefix + secret
+	if rateLimit <= 0 {
+		rateLimit = defaultAPIKeyRateLimit()
+	}
+
+	key := &models.APIKey{
+		Name:      name,
+		Prefix:    plain[:len(apiKeyPrefix)+8],
+		KeyHash:   HashToken(plain),
+		Scopes:    scopes,
+		RateLimit: rateLimit,
+		Status:    models.APIKeyActive,
+		CreatedBy: createdBy,
+		CreatedAt: time.Now().Unix(),
+	}
+	result, err := config.GetCollection("api_keys").InsertOne(context.Background(), key)
+	if err != nil {
+		return nil, "", err
+	}
+	key.ID = result.InsertedID.(primitive.ObjectID)
+	return key, plain, nil
+}
+
+// FindActiveAPIKey returns the active key matching a plain key
+func FindActiveAPIKey(plain string) (*models.APIKey, error) {
+	var key models.APIKey
+	err := config.GetCollection("api_keys").FindOne(context.Background(),
+		bson.M{"keyHash": HashToken(plain), "status": models.APIKeyActive},
+	).Decode(&key)
+	if err != nil {
+		return nil, err
+	}
+	return &key, nil
+}
+
+// ValidScope reports whether a scope can be granted to an API key
+func ValidScope(scope string) bool {
+	return Contains(models.AllScopes, scope)
+}
+
+// TakeAPIKeyRequest counts a request against the key's per-minute limit,
+// shared by all instances. It returns ErrRateLimited and the seconds until
+// the next window once the limit is used up.
+func TakeAPIKeyRequest(key *models.APIKey) (int, error) {
+	now := time.Now()
+	window := now.Truncate(time.Minute)
+
+	var usage struct {
+		Count int `bson:"count"`
+	}
+	err := config.GetCollection("api_key_usage").FindOneAndUpdate(context.Background(),
+		bson.M{"_id": key.ID.Hex() + ":" + window.Format("200601021504")},
+		bson.M{
+			"$inc": bson.M{"count": 1},
+			// Counters are removed by a TTL index once their window has passed
+			"$setOnInsert": bson.M{"expiresAt": window.Add(2 * time.Minute)},
+		},
+		options.FindOneAndUpdate().SetUpsert(true).SetReturnDocument(options.After),
+	).Decode(&usage)
+	if err != nil {
+		return 0, err
+	}
+
+	if usage.Count > key.RateLimit {
+		return int(window.Add(time.Minute).Sub(now).Seconds()) + 1, ErrRateLimited
+	}
+
+	// Last use is recorded at most once a minute to keep writes down
+	config.GetCollection("api_keys").UpdateOne(context.Background(),
+		bson.M{"_id": key.ID, "$or": []bson.M{
+			{"lastUsedAt": bson.M{"$exists": false}},
+			{"lastUsedAt": bson.M{"$lt": now.Add(-time.Minute).Unix()}},
+		}},
+		bson.M{"$set": bson.M{"lastUsedAt": now.Unix()}},
+	)
+	return 0, nil
+}
+
+// RevokeAPIKey stops a key from working
+func RevokeAPIKey(id string) (bool, error) {
+	objectID, _ := primitive.ObjectIDFromHex(id)
+	result, err := config.GetCollection("api_keys").UpdateOne(context.Background(),
+		bson.M{"_id": objectID, "status": models.APIKeyActive},
+		bson.M{"$set": bson.M{"status": models.APIKeyRevoked, "revokedAt": time.Now().Unix()}},
+	)
+	if err != nil {
+		return false, err
+	}
+	return result.MatchedCount > 0, nil
+}
+
+// ListAPIKeys returns all keys, newest first
+func ListAPIKeys() ([]models.APIKey, error) {
+	cursor, err := config.GetCollection("api_keys").Find(context.Background(), bson.M{},
+		options.Find().SetSort(bson.D{{Key: "createdAt", Value: -1}}),
+	)
+	if err != nil {
+		return nil, err
+	}
+	var keys []models.APIKey
+	if err = cursor.All(context.Background(), &keys); err != nil {
+		return nil, err
+	}
+	return keys, nil
+}
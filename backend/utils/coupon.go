@@ -0,0 +1,142 @@
+package utils
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"prescripto-go/config"
+	"prescripto-go/models"
+)
+
+// Errors returned when a coupon cannot be applied
+var (
+	ErrCouponInvalid     = errors.New("Invalid coupon code")
+	ErrCouponExpired     = errors.New("Coupon is not valid at this time")
+	ErrCouponNotEligible = errors.New("Coupon does not apply to this appointment")
+	ErrCouponExhausted   = errors.New("Coupon usage limit reached")
+)
+
+// NormalizeCouponCode returns the canonical form of a coupon code
+func NormalizeCouponCode(code string) string {
+	return strings.ToUpper(strings.TrimSpace(code))
+}
+
+// CouponDiscount checks that a coupon applies to an appointment and returns
+// the discount it grants, never more than the appointment fee
+func CouponDiscount(coupon *models.Coupon, appointment *models.Appointment) (models.Money, error) {
+	now := time.Now().Unix()
+	if !coupon.Active {
+		return models.Money{}, ErrCouponInvalid
+	}
+	if (coupon.ValidFrom != 0 && now < coupon.ValidFrom) || (coupon.ValidUntil != 0 && now > coupon.ValidUntil) {
+		return models.Money{}, ErrCouponExpired
+	}
+	if len(coupon.DoctorIDs) > 0 && !Contains(coupon.DoctorIDs, appointment.DocID) {
+		return models.Money{}, ErrCouponNotEligible
+	}
+	if len(coupon.Specialities) > 0 && !Contains(coupon.Specialities, appointment.DocData.Speciality) {
+		return models.Money{}, ErrCouponNotEligible
+	}
+
+	fee := appointment.Amount
+	var discount models.Money
+	switch coupon.Type {
+	case models.CouponTypePercentage:
+		discount = fee.Percent(coupon.Percent)
+	case models.CouponTypeFixed:
+		if coupon.Amount.Currency != fee.Currency {
+			return models.Money{}, ErrCouponNotEligible
+		}
+		discount = coupon.Amount
+	default:
+		return models.Money{}, ErrCouponInvalid
+	}
+
+	if discount.Amount > fee.Amount {
+		discount.Amount = fee.Amount
+	}
+	return discount, nil
+}
+
+// RedeemCoupon reserves one use of a coupon for a user's appointment,
+// enforcing the overall and per user usage caps. A capped coupon gives each
+// user numbered redemption slots under a unique index, so concurrent
+// redemptions by the same user cannot take more slots than the cap.
+func RedeemCoupon(coupon *models.Coupon, userID, appointmentID string, discount models.Money) (*models.CouponRedemption, error) {
+	redemptions := config.GetCollection("coupon_redemptions")
+
+	redemption := &models.CouponRedemption{
+		CouponID:      coupon.ID.Hex(),
+		Code:          coupon.Code,
+		UserID:        userID,
+		AppointmentID: appointmentID,
+		Discount:      discount,
+		CreatedAt:     time.Now().Unix(),
+	}
+	slots := 1
+	if coupon.MaxUsesPerUser > 0 {
+		slots = coupon.MaxUsesPerUser
+	}
+	for slot := 1; slot <= slots && redemption.ID.IsZero(); slot++ {
+		if coupon.MaxUsesPerUser > 0 {
+			redemption.Slot = slot
+		}
+		inserted, err := redemptions.InsertOne(context.Background(), redemption)
+		if err == nil {
+			redemption.ID = inserted.InsertedID.(primitive.ObjectID)
+		} else if !mongo.IsDuplicateKeyError(err) || coupon.MaxUsesPerUser == 0 {
+			return nil, err
+		}
+	}
+	if redemption.ID.IsZero() {
+		return nil, ErrCouponExhausted
+	}
+
+	// Increment only while below the cap so concurrent redemptions cannot overshoot
+	filter := bson.M{"_id": coupon.ID}
+	if coupon.MaxUses > 0 {
+		filter["usedCount"] = bson.M{"$lt": coupon.MaxUses}
+	}
+	result, err := config.GetCollection("coupons").UpdateOne(context.Background(), filter, bson.M{"$inc": bson.M{"usedCount": 1}})
+	if err == nil && result.MatchedCount == 0 {
+		err = ErrCouponExhausted
+	}
+	if err != nil {
+		redemptions.DeleteOne(context.Background(), bson.M{"_id": redemption.ID})
+		return nil, err
+	}
+	return redemption, nil
+}
+
+// ReleaseCoupon gives back a use of a coupon and removes the redemption of an appointment
+func ReleaseCoupon(couponID primitive.ObjectID, appointmentID string) {
+	config.GetCollection("coupons").UpdateOne(
+		context.Background(),
+		bson.M{"_id": couponID, "usedCount": bson.M{"$gt": 0}},
+		bson.M{"$inc": bson.M{"usedCount": -1}},
+	)
+	if appointmentID != "" {
+		config.GetCollection("coupon_redemptions").DeleteOne(context.Background(), bson.M{
+			"couponId":      couponID.Hex(),
+			"appointmentId": appointmentID,
+		})
+	}
+}
+
+// ReleaseAppointmentCoupon returns the coupon of an unpaid appointment that is being cancelled
+func ReleaseAppointmentCoupon(appointment *models.Appointment) {
+	if appointment.CouponCode == "" || appointment.Payment {
+		return
+	}
+
+	var coupon models.Coupon
+	err := config.GetCollection("coupons").FindOne(context.Background(), bson.M{"code": appointment.CouponCode}).Decode(&coupon)
+	if err == nil {
+		ReleaseCoupon(coupon.ID, appointment.ID.Hex())
+	}
+}
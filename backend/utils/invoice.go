@@ -0,0 +1,301 @@
+package utils
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"prescripto-go/config"
+	"prescripto-go/models"
+)
+
+// maxInvoiceNumberAttempts bounds how often numbering is retried when
+// concurrent invoices race for the same number
+const maxInvoiceNumberAttempts = 20
+
+// FindInvoice returns the invoice issued for an appointment
+func FindInvoice(appointmentID string) (*models.Invoice, error) {
+	var invoice models.Invoice
+	err := config.GetCollection("invoices").FindOne(context.Background(), bson.M{"appointmentId": appointmentID}).Decode(&invoice)
+	if err != nil {
+		return nil, err
+	}
+	return &invoice, nil
+}
+
+// GenerateInvoice issues the invoice for a paid appointment. It is safe to call
+// repeatedly and concurrently: an appointment only ever gets one invoice, and
+// the invoice returned always carries its number.
+func GenerateInvoice(appointmentID string) (*models.Invoice, error) {
+	if invoice, err := FindInvoice(appointmentID); err == nil {
+		return numberInvoice(invoice)
+	} else if err != mongo.ErrNoDocuments {
+		return nil, err
+	}
+
+	appointmentObjectID, err := primitive.ObjectIDFromHex(appointmentID)
+	if err != nil {
+		return nil, err
+	}
+	var appointment models.Appointment
+	err = config.GetCollection("appointments").FindOne(context.Background(), bson.M{"_id": appointmentObjectID}).Decode(&appointment)
+	if err != nil {
+		return nil, err
+	}
+	if !appointment.Payment {
+		return nil, fmt.Errorf("appointment %s is not paid", appointmentID)
+	}
+
+	invoice, err := buildInvoice(&appointment)
+	if err != nil {
+		return nil, err
+	}
+	result, err := config.GetCollection("invoices").InsertOne(context.Background(), invoice)
+	if err != nil {
+		if mongo.IsDuplicateKeyError(err) {
+			// Another request issued the invoice first and may not have numbered it yet
+			existing, err := FindInvoice(appointmentID)
+			if err != nil {
+				return nil, err
+			}
+			return numberInvoice(existing)
+		}
+		return nil, err
+	}
+	invoice.ID = result.InsertedID.(primitive.ObjectID)
+
+	return numberInvoice(invoice)
+}
+
+// numberInvoice gives an unnumbered invoice the number after the highest one
+// issued. Numbers are taken by setting them on the invoice under the unique
+// sequence index, so a number is never skipped: when another invoice already
+// holds it, the counter catches up and the next number is tried. The counter
+// only records the highest number taken and may lag behind after a crash.
+func numberInvoice(invoice *models.Invoice) (*models.Invoice, error) {
+	if invoice.Sequence > 0 && invoice.Number != "" {
+		return invoice, nil
+	}
+
+	ctx := context.Background()
+	counters := config.GetCollection("counters")
+	invoices := config.GetCollection("invoices")
+
+	prefix := os.Getenv("INVOICE_PREFIX")
+	if prefix == "" {
+		prefix = "INV"
+	}
+
+	for attempt := 0; attempt < maxInvoiceNumberAttempts; attempt++ {
+		var counter struct {
+			Seq int64 `bson:"seq"`
+		}
+		err := counters.FindOne(ctx, bson.M{"_id": "invoice"}).Decode(&counter)
+		if err != nil && err != mongo.ErrNoDocuments {
+			return nil, err
+		}
+		seq := counter.Seq + 1
+		number := fmt.Sprintf("%s-%06d", prefix, seq)
+
+		result, err := invoices.UpdateOne(ctx,
+			bson.M{"_id": invoice.ID, "sequence": 0},
+			bson.M{"$set": bson.M{"sequence": seq, "number": number}},
+		)
+		if err != nil && !mongo.IsDuplicateKeyError(err) {
+			return nil, err
+		}
+		if err == nil && result.MatchedCount == 0 {
+			// A concurrent call numbered this invoice
+			numbered, err := FindInvoice(invoice.AppointmentID)
+			if err != nil {
+				return nil, err
+			}
+			if numbered.Sequence == 0 || numbered.Number == "" {
+				return nil, fmt.Errorf("invoice %s was not numbered", numbered.ID.Hex())
+			}
+			return numbered, nil
+		}
+
+		// Whether this invoice took the number or another one holds it
+		_, counterErr := counters.UpdateOne(ctx,
+			bson.M{"_id": "invoice"},
+			bson.M{"$max": bson.M{"seq": seq}},
+			options.Update().SetUpsert(true),
+		)
+		if err == nil {
+			invoice.Sequence = seq
+			invoice.Number = number
+			return invoice, nil
+		}
+		if counterErr != nil && !mongo.IsDuplicateKeyError(counterErr) {
+			return nil, counterErr
+		}
+	}
+	return nil, fmt.Errorf("could not number invoice %s", invoice.ID.Hex())
+}
+
+// buildInvoice prepares an unnumbered invoice for an appointment. Item
+// amounts include inclusive tax and exclusive tax is added on top of them.
+// The tax lines are the ones computed when the appointment was booked.
+func buildInvoice(appointment *models.Appointment) (*models.Invoice, error) {
+	total, err := appointment.Total()
+	if err != nil {
+		return nil, err
+	}
+	taxLines := appointment.Tax
+
+	tax, err := appointment.TaxTotal()
+	if err != nil {
+		return nil, err
+	}
+	taxRate := 0.0
+	for _, line := range taxLines {
+		taxRate += line.Rate
+	}
+	subtotal, err := total.Sub(tax)
+	if err != nil {
+		return nil, err
+	}
+
+	invoice := &models.Invoice{
+		AppointmentID: appointment.ID.Hex(),
+		UserID:        appointment.UserID,
+		DocID:         appointment.DocID,
+		Patient: models.InvoiceParty{
+			Name:  appointment.UserData.Name,
+			Email: appointment.UserData.Email,
+		},
+		Doctor: models.InvoiceParty{
+			Name:   appointment.DocData.Name,
+			Email:  appointment.DocData.Email,
+			Detail: appointment.DocData.Degree + " - " + appointment.DocData.Speciality,
+		},
+		ClinicAddress: appointment.DocData.Address,
+		SlotDate:      appointment.SlotDate,
+		SlotTime:      appointment.SlotTime,
+		Items: []models.InvoiceItem{
+			{Description: "Consultation fee - " + appointment.DocData.Name, Amount: appointment.Amount},
+		},
+		Subtotal: subtotal,
+		Tax:      tax,
+		TaxRate:  taxRate,
+		TaxLines: taxLines,
+		Total:    total,
+		Wallet:   appointment.WalletPaid,
+		IssuedAt: time.Now().Unix(),
+	}
+
+	if !appointment.Discount.IsZero() {
+		invoice.Items = append(invoice.Items, models.InvoiceItem{
+			Description: "Discount (" + appointment.CouponCode + ")",
+			Amount:      models.NewMoney(-appointment.Discount.Amount, appointment.Discount.Currency),
+		})
+	}
+
+	if paid, err := LatestPaidPayment(invoice.AppointmentID); err == nil {
+		invoice.Payment = models.InvoicePayment{
+			Gateway:   paid.Gateway,
+			Method:    paid.Method,
+			Reference: paid.GatewayPaymentID,
+			PaidAt:    paid.CreatedAt,
+		}
+	}
+
+	return invoice, nil
+}
+
+// RenderInvoicePDF renders an invoice as a PDF document
+func RenderInvoicePDF(invoice *models.Invoice) []byte {
+	clinicName := os.Getenv("CLINIC_NAME")
+	if clinicName == "" {
+		clinicName = "Prescripto"
+	}
+
+	doc := NewPDFDocument()
+	left, right := 50.0, PDFPageWidth-50
+
+	// Header
+	doc.Text(left, 70, 22, true, clinicName)
+	doc.TextRight(right, 70, 18, true, "INVOICE")
+	doc.TextRight(right, 90, 10, false, "Invoice No: "+invoice.Number)
+	doc.TextRight(right, 104, 10, false, "Date: "+time.Unix(invoice.IssuedAt, 0).Format("02 Jan 2006"))
+	doc.Line(left, 120, right, 120, 1)
+
+	// Parties
+	doc.Text(left, 145, 11, true, "Billed To")
+	doc.Text(left, 162, 10, false, invoice.Patient.Name)
+	doc.Text(left, 176, 10, false, invoice.Patient.Email)
+
+	doc.Text(300, 145, 11, true, "Doctor")
+	doc.Text(300, 162, 10, false, invoice.Doctor.Name)
+	doc.Text(300, 176, 10, false, invoice.Doctor.Detail)
+	doc.Text(300, 190, 10, false, invoice.ClinicAddress.Line1)
+	doc.Text(300, 204, 10, false, invoice.ClinicAddress.Line2)
+
+	doc.Text(left, 230, 10, false, fmt.Sprintf("Appointment: %s at %s", invoice.SlotDate, invoice.SlotTime))
+
+	// Fee breakdown
+	y := 260.0
+	doc.Rect(left, y, right-left, 20, 0.9)
+	doc.Text(left+8, y+14, 10, true, "Description")
+	doc.TextRight(right-8, y+14, 10, true, "Amount")
+	y += 20
+	for _, item := range invoice.Items {
+		y += 18
+		doc.Text(left+8, y, 10, false, item.Description)
+		doc.TextRight(right-8, y, 10, false, item.Amount.String())
+	}
+	y += 10
+	doc.Line(left, y, right, y, 0.5)
+
+	y += 18
+	doc.Text(330, y, 10, false, "Subtotal (excl. tax)")
+	doc.TextRight(right-8, y, 10, false, invoice.Subtotal.String())
+	y += 16
+	if len(invoice.TaxLines) == 0 {
+		doc.Text(330, y, 10, false, fmt.Sprintf("Tax (%g%%)", invoice.TaxRate))
+		doc.TextRight(right-8, y, 10, false, invoice.Tax.String())
+	}
+	for i, line := range invoice.TaxLines {
+		if i > 0 {
+			y += 16
+		}
+		label := fmt.Sprintf("%s (%g%%)", line.Name, line.Rate)
+		if line.Inclusive {
+			label += " incl."
+		}
+		doc.Text(330, y, 10, false, label)
+		doc.TextRight(right-8, y, 10, false, line.Amount.String())
+	}
+	y += 20
+	doc.Text(330, y, 11, true, "Total Paid")
+	doc.TextRight(right-8, y, 11, true, invoice.Total.String())
+	if !invoice.Wallet.IsZero() {
+		y += 16
+		doc.Text(330, y, 10, false, "Paid from wallet")
+		doc.TextRight(right-8, y, 10, false, invoice.Wallet.String())
+	}
+
+	// Payment reference
+	if invoice.Payment.Gateway != "" {
+		y += 40
+		doc.Text(left, y, 11, true, "Payment")
+		method := invoice.Payment.Gateway
+		if invoice.Payment.Method != "" {
+			method += " (" + invoice.Payment.Method + ")"
+		}
+		doc.Text(left, y+16, 10, false, "Method: "+method)
+		doc.Text(left, y+30, 10, false, "Reference: "+invoice.Payment.Reference)
+		doc.Text(left, y+44, 10, false, "Paid on: "+time.Unix(invoice.Payment.PaidAt, 0).Format("02 Jan 2006 15:04"))
+	}
+
+	doc.Line(left, PDFPageHeight-60, right, PDFPageHeight-60, 0.5)
+	doc.Text(left, PDFPageHeight-45, 8, false, "This is a computer generated receipt and does not require a signature.")
+
+	return doc.Bytes()
+}
@@ -0,0 +1,226 @@
+package utils
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"log"
+	"sync"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"prescripto-go/config"
+	"prescripto-go/models"
+)
+
+var ErrNoSigningKey = errors.New("no active signing key")
+
+// keyring caches the signing keys so requests do not hit the database
+var keyring struct {
+	sync.RWMutex
+	active   *models.SigningKey
+	signer   *rsa.PrivateKey
+	verify   map[string]*rsa.PublicKey
+	loadedAt time.Time
+}
+
+// signingKeyGrace is how long a retired key keeps verifying tokens. It must
+// outlive every token signed before a rotation, such as email verification links.
+func signingKeyGrace() time.Duration {
+	return time.Duration(envInt("SIGNING_KEY_GRACE_HOURS", 48)) * time.Hour
+}
+
+// EnsureSigningKey creates the first signing key if there is none and loads the keyring
+func EnsureSigningKey() error {
+	count, err := config.GetCollection("signing_keys").CountDocuments(context.Background(),
+		bson.M{"status": models.SigningKeyActive},
+	)
+	if err != nil {
+		return err
+	}
+	if count == 0 {
+		// Several instances may start at once; the unique index lets one win
+		if _, err := insertSigningKey(); err != nil && !mongo.IsDuplicateKeyError(err) {
+			return err
+		}
+	}
+	return LoadSigningKeys()
+}
+
+// LoadSigningKeys reloads the active key and the keys that still verify
+func LoadSigningKeys() error {
+	cursor, err := config.GetCollection("signing_keys").Find(context.Background(),
+		bson.M{"$or": []bson.M{
+			{"status": models.SigningKeyActive},
+			{"status": models.SigningKeyRetired, "retiredAt": bson.M{"$gt": time.Now().Add(-signingKeyGrace()).Unix()}},
+		}},
+		options.Find().SetSort(bson.D{{Key: "createdAt", Value: -1}}),
+	)
+	if err != nil {
+		return err
+	}
+	var keys []models.SigningKey
+	if err = cursor.All(context.Background(), &keys); err != nil {
+		return err
+	}
+
+	var active *models.SigningKey
+	var signer *rsa.PrivateKey
+	verify := make(map[string]*rsa.PublicKey)
+	for i, key := range keys {
+		private, err := parsePrivateKey(key.PrivateKey)
+		if err != nil {
+			log.Printf("Skipping unreadable signing key %s: %v", key.Kid, err)
+			continue
+		}
+		verify[key.Kid] = &private.PublicKey
+		if key.Status == models.SigningKeyActive && active == nil {
+			active, signer = &keys[i], private
+		}
+	}
+
+	keyring.Lock()
+	defer keyring.Unlock()
+	keyring.loadedAt = time.Now()
+	keyring.verify = verify
+	// Mid-rotation there is briefly no active key; keep signing with the old one
+	if active != nil {
+		keyring.active, keyring.signer = active, signer
+	}
+	return nil
+}
+
+// StartSigningKeyRefresh reloads the keyring every minute so a rotation made
+// with the rotate-keys command reaches every running instance
+func StartSigningKeyRefresh() {
+	go func() {
+		for range time.Tick(time.Minute) {
+			if err := LoadSigningKeys(); err != nil {
+				log.Printf("Failed to reload signing keys: %v", err)
+			}
+		}
+	}()
+}
+
+// RotateSigningKey retires the active key and makes a new one active
+func RotateSigningKey() (*models.SigningKey, error) {
+	keys := config.GetCollection("signing_keys")
+	now := time.Now().Unix()
+
+	_, err := keys.UpdateMany(context.Background(),
+		bson.M{"status": models.SigningKeyActive},
+		bson.M{"$set": bson.M{"status": models.SigningKeyRetired, "retiredAt": now}},
+	)
+	if err != nil {
+		return nil, err
+	}
+	key, err := insertSigningKey()
+	if err != nil {
+		return nil, err
+	}
+
+	// Retired keys past their grace period can no longer verify anything
+	_, err = keys.DeleteMany(context.Background(), bson.M{
+		"status":    models.SigningKeyRetired,
+		"retiredAt": bson.M{"$lte": time.Now().Add(-signingKeyGrace()).Unix()},
+	})
+	return key, err
+}
+
+// JWKS returns the public keys that verify our tokens
+func JWKS() models.JWKSet {
+	keyring.RLock()
+	defer keyring.RUnlock()
+
+	set := models.JWKSet{Keys: []models.JWK{}}
+	for kid, key := range keyring.verify {
+		set.Keys = append(set.Keys, RSAPublicJWK(kid, key))
+	}
+	return set
+}
+
+// UseSigningKey makes key the active signing key without going through the
+// database, for tests and tools that run without MongoDB
+func UseSigningKey(kid string, key *rsa.PrivateKey) {
+	keyring.Lock()
+	defer keyring.Unlock()
+	keyring.active = &models.SigningKey{Kid: kid, Status: models.SigningKeyActive}
+	keyring.signer = key
+	keyring.verify = map[string]*rsa.PublicKey{kid: &key.PublicKey}
+	keyring.loadedAt = time.Now()
+}
+
+// activeSigningKey returns the key new tokens are signed with
+func activeSigningKey() (string, *rsa.PrivateKey, error) {
+	keyring.RLock()
+	defer keyring.RUnlock()
+
+	if keyring.active == nil {
+		return "", nil, ErrNoSigningKey
+	}
+	return keyring.active.Kid, keyring.signer, nil
+}
+
+// verificationKey returns the public key with the kid. An unknown kid may
+// come from a rotation on another instance, so the keyring is reloaded,
+// at most every few seconds so random kids cannot hammer the database.
+func verificationKey(kid string) (*rsa.PublicKey, error) {
+	keyring.RLock()
+	key, ok := keyring.verify[kid]
+	stale := time.Since(keyring.loadedAt) > 5*time.Second
+	keyring.RUnlock()
+	if ok {
+		return key, nil
+	}
+
+	if stale {
+		if err := LoadSigningKeys(); err != nil {
+			return nil, err
+		}
+		keyring.RLock()
+		key, ok = keyring.verify[kid]
+		keyring.RUnlock()
+		if ok {
+			return key, nil
+		}
+	}
+	return nil, ErrInvalidToken
+}
+
+func insertSigningKey() (*models.SigningKey, error) {
+	private, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		return nil, err
+	}
+	kid, err := RandomToken(8)
+	if err != nil {
+		return nil, err
+	}
+
+	key := &models.SigningKey{
+		Kid: kid,
+		PrivateKey: string(pem.EncodeToMemory(&pem.Block{
+			Type:  "RSA PRIVATE KEY",
+			Bytes: x509.MarshalPKCS1PrivateKey(private),
+		})),
+		Status:    models.SigningKeyActive,
+		CreatedAt: time.Now().Unix(),
+	}
+	if _, err := config.GetCollection("signing_keys").InsertOne(context.Background(), key); err != nil {
+		return nil, err
+	}
+	return key, nil
+}
+
+func parsePrivateKey(encoded string) (*rsa.PrivateKey, error) {
+	block, _ := pem.Decode([]byte(encoded))
+	if block == nil {
+		return nil, errors.New("invalid PEM")
+	}
+	return x509.ParsePKCS1PrivateKey(block.Bytes)
+}
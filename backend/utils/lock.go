@@ -0,0 +1,27 @@
+package utils
+
+import (
+	"context"
+	"os"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"prescripto-go/config"
+)
+
+// TryLock acquires a named lock shared by all server instances for the given
+// duration. It returns false while another holder's lock has not expired.
+func TryLock(name string, ttl time.Duration) bool {
+	now := time.Now()
+	holder, _ := os.Hostname()
+
+	_, err := config.GetCollection("locks").UpdateOne(
+		context.Background(),
+		bson.M{"_id": name, "until": bson.M{"$lt": now.Unix()}},
+		bson.M{"$set": bson.M{"until": now.Add(ttl).Unix(), "holder": holder}},
+		options.Update().SetUpsert(true),
+	)
+	// A live lock makes the upsert collide with the existing document
+	return err == nil
+}
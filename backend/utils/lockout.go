@@ -0,0 +1,343 @@
+package utils
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"prescripto-go/config"
+	"prescripto-go/models"
+)
+
+var ErrLoginLocked = errors.New("Too many login attempts, please try again later")
+
+// AttemptStore keeps failed login counters. LOCKOUT_STORE=memory keeps them
+// in process for a single instance; by default they live in MongoDB so every
+// instance sees the same counts.
+type AttemptStore interface {
+	Get(key string) (*models.LoginAttempts, error)
+	// RecordFailure counts a failure, starting over when the previous one is
+	// older than window, and returns the updated counter
+	RecordFailure(key string, now time.Time, window time.Duration) (*models.LoginAttempts, error)
+	Lock(key string, until int64) error
+	Reset(key string) error
+	// List returns counters that have failures within window or an active lock
+	List(now time.Time, window time.Duration) ([]models.LoginAttempts, error)
+}
+
+var (
+	attemptStore     AttemptStore
+	resetStore       AttemptStore
+	attemptStoreOnce sync.Once
+)
+
+// newAttemptStore returns the configured kind of store keeping its counters
+// in the collection
+func newAttemptStore(collection string) AttemptStore {
+	if os.Getenv("LOCKOUT_STORE") == "memory" {
+		return &MemoryAttemptStore{attempts: make(map[string]*models.LoginAttempts)}
+	}
+	return &MongoAttemptStore{collection: collection}
+}
+
+// GetAttemptStore returns the configured attempt store for login failures
+func GetAttemptStore() AttemptStore {
+	attemptStoreOnce.Do(func() {
+		attemptStore = newAttemptStore("login_attempts")
+		resetStore = newAttemptStore("reset_attempts")
+	})
+	return attemptStore
+}
+
+// GetResetAttemptStore returns the store counting password reset requests.
+// It is kept apart from login failures so throttled resets are not reported
+// as locked out accounts.
+func GetResetAttemptStore() AttemptStore {
+	GetAttemptStore()
+	return resetStore
+}
+
+// lockoutPolicy holds the thresholds for one kind of key
+type lockoutPolicy struct {
+	freeAttempts int
+}
+
+func accountPolicy() lockoutPolicy {
+	return lockoutPolicy{freeAttempts: envInt("LOGIN_MAX_ATTEMPTS", 5)}
+}
+
+// ipPolicy is looser than accountPolicy since many patients can share an address
+func ipPolicy() lockoutPolicy {
+	return lockoutPolicy{freeAttempts: envInt("LOGIN_MAX_ATTEMPTS_PER_IP", 20)}
+}
+
+// lockoutWindow is how long failures are remembered
+func lockoutWindow() time.Duration {
+	return time.Duration(envInt("LOCKOUT_WINDOW_MINUTES", 60)) * time.Minute
+}
+
+// lockDuration doubles with every failure past the free attempts, from
+// LOCKOUT_BASE_SECONDS up to LOCKOUT_MAX_MINUTES
+func (p lockoutPolicy) lockDuration(failures int) time.Duration {
+	if failures <= p.freeAttempts {
+		return 0
+	}
+	max := time.Duration(envInt("LOCKOUT_MAX_MINUTES", 60)) * time.Minute
+	lock := time.Duration(envInt("LOCKOUT_BASE_SECONDS", 30)) * time.Second
+	for i := p.freeAttempts + 1; i < failures && lock < max; i++ {
+		lock *= 2
+	}
+	if lock > max {
+		lock = max
+	}
+	return lock
+}
+
+func accountLockoutKey(role, email string) string {
+	return "account:" + role + ":" + strings.ToLower(strings.TrimSpace(email))
+}
+
+func ipLockoutKey(ip string) string {
+	return "ip:" + ip
+}
+
+// CheckLoginAllowed returns ErrLoginLocked while the account or the IP address is locked
+func CheckLoginAllowed(role, email, ip string) error {
+	now := time.Now().Unix()
+	for _, key := range []string{accountLockoutKey(role, email), ipLockoutKey(ip)} {
+		attempts, err := GetAttemptStore().Get(key)
+		if err != nil {
+			return err
+		}
+		if attempts != nil && attempts.LockedUntil > now {
+			return ErrLoginLocked
+		}
+	}
+	return nil
+}
+
+// RecordLoginFailure counts a failed login against the account and the IP
+// address, locks them once they run out of attempts and tells the account
+// owner when their account gets locked
+func RecordLoginFailure(role, email, ip string) error {
+	store := GetAttemptStore()
+	now := time.Now()
+
+	for _, target := range []struct {
+		key    string
+		policy lockoutPolicy
+	}{
+		{accountLockoutKey(role, email), accountPolicy()},
+		{ipLockoutKey(ip), ipPolicy()},
+	} {
+		attempts, err := store.RecordFailure(target.key, now, lockoutWindow())
+		if err != nil {
+			return err
+		}
+
+		lock := target.policy.lockDuration(attempts.Failures)
+		if lock == 0 {
+			continue
+		}
+		if err := store.Lock(target.key, now.Add(lock).Unix()); err != nil {
+			return err
+		}
+		// Only the first lock of a run is announced
+		if attempts.Failures == target.policy.freeAttempts+1 && strings.HasPrefix(target.key, "account:") {
+			notifyLockout(role, email, lock)
+		}
+	}
+	return nil
+}
+
+// RecordLoginSuccess clears the failures of an account
+func RecordLoginSuccess(role, email string) error {
+	return GetAttemptStore().Reset(accountLockoutKey(role, email))
+}
+
+// notifyLockout emails the owner of a locked account, if the account exists
+func notifyLockout(role, email string, lock time.Duration) {
+	count, err := config.GetCollection(accountCollections[role]).CountDocuments(context.Background(),
+		bson.M{"email": NormalizeEmail(email)},
+	)
+	if err != nil || count == 0 {
+		return
+	}
+
+	body := fmt.Sprintf("There were several failed attempts to log in to your account, so logins are paused for %s.\n\nIf this was not you, consider changing your password once you can log in again.\n",
+		lock.Round(time.Second))
+	if err := SendMail(strings.TrimSpace(email), "Your account was temporarily locked", body); err != nil {
+		log.Printf("Failed to send lockout notification: %v", err)
+	}
+}
+
+// MongoAttemptStore shares attempt counters between instances through MongoDB
+type MongoAttemptStore struct {
+	collection string
+}
+
+func (s *MongoAttemptStore) Get(key string) (*models.LoginAttempts, error) {
+	var attempts models.LoginAttempts
+	err := config.GetCollection(s.collection).FindOne(context.Background(), bson.M{"_id": key}).Decode(&attempts)
+	if err == mongo.ErrNoDocuments {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &attempts, nil
+}
+
+func (s *MongoAttemptStore) RecordFailure(key string, now time.Time, window time.Duration) (*models.LoginAttempts, error) {
+	collection := config.GetCollection(s.collection)
+
+	// Forget a run of failures that ended long ago
+	_, err := collection.UpdateOne(context.Background(),
+		bson.M{"_id": key, "lastFailureAt": bson.M{"$lt": now.Add(-window).Unix()}, "lockedUntil": bson.M{"$lt": now.Unix()}},
+		bson.M{"$set": bson.M{"failures": 0}},
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	var attempts models.LoginAttempts
+	err = collection.FindOneAndUpdate(context.Background(),
+		bson.M{"_id": key},
+		bson.M{
+			"$inc":         bson.M{"failures": 1},
+			"$set":         bson.M{"lastFailureAt": now.Unix()},
+			"$setOnInsert": bson.M{"lockedUntil": int64(0)},
+		},
+		options.FindOneAndUpdate().SetUpsert(true).SetReturnDocument(options.After),
+	).Decode(&attempts)
+	if err != nil {
+		return nil, err
+	}
+	return &attempts, nil
+}
+
+func (s *MongoAttemptStore) Lock(key string, until int64) error {
+	_, err := config.GetCollection(s.collection).UpdateOne(context.Background(),
+		bson.M{"_id": key},
+		bson.M{"$max": bson.M{"lockedUntil": until}},
+	)
+	return err
+}
+
+func (s *MongoAttemptStore) Reset(key string) error {
+	_, err := config.GetCollection(s.collection).DeleteOne(context.Background(), bson.M{"_id": key})
+	return err
+}
+
+func (s *MongoAttemptStore) List(now time.Time, window time.Duration) ([]models.LoginAttempts, error) {
+	opts := options.Find().SetSort(bson.D{{Key: "lastFailureAt", Value: -1}}).SetLimit(200)
+	cursor, err := config.GetCollection(s.collection).Find(context.Background(), bson.M{
+		"$or": []bson.M{
+			{"lockedUntil": bson.M{"$gt": now.Unix()}},
+			{"lastFailureAt": bson.M{"$gte": now.Add(-window).Unix()}},
+		},
+	}, opts)
+	if err != nil {
+		return nil, err
+	}
+	var list []models.LoginAttempts
+	if err = cursor.All(context.Background(), &list); err != nil {
+		return nil, err
+	}
+	return list, nil
+}
+
+// MemoryAttemptStore keeps attempt counters in process for single instance deployments
+type MemoryAttemptStore struct {
+	mu       sync.Mutex
+	attempts map[string]*models.LoginAttempts
+}
+
+func (s *MemoryAttemptStore) Get(key string) (*models.LoginAttempts, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	attempts, ok := s.attempts[key]
+	if !ok {
+		return nil, nil
+	}
+	copied := *attempts
+	return &copied, nil
+}
+
+func (s *MemoryAttemptStore) RecordFailure(key string, now time.Time, window time.Duration) (*models.LoginAttempts, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	attempts, ok := s.attempts[key]
+	if !ok {
+		attempts = &models.LoginAttempts{Key: key}
+		s.attempts[key] = attempts
+	}
+	if attempts.LastFailureAt < now.Add(-window).Unix() && attempts.LockedUntil < now.Unix() {
+		attempts.Failures = 0
+	}
+	attempts.Failures++
+	attempts.LastFailureAt = now.Unix()
+
+	// Drop counters nobody has touched for a while so the map stays small
+	for k, other := range s.attempts {
+		if other.LastFailureAt < now.Add(-window).Unix() && other.LockedUntil < now.Unix() {
+			delete(s.attempts, k)
+		}
+	}
+
+	copied := *attempts
+	return &copied, nil
+}
+
+func (s *MemoryAttemptStore) Lock(key string, until int64) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if attempts, ok := s.attempts[key]; ok && attempts.LockedUntil < until {
+		attempts.LockedUntil = until
+	}
+	return nil
+}
+
+func (s *MemoryAttemptStore) Reset(key string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	delete(s.attempts, key)
+	return nil
+}
+
+func (s *MemoryAttemptStore) List(now time.Time, window time.Duration) ([]models.LoginAttempts, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var list []models.LoginAttempts
+	for _, attempts := range s.attempts {
+		if attempts.LockedUntil > now.Unix() || attempts.LastFailureAt >= now.Add(-window).Unix() {
+			list = append(list, *attempts)
+		}
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].LastFailureAt > list[j].LastFailureAt })
+	return list, nil
+}
+
+// ListLockouts returns the accounts and addresses with recent failures or an active lock
+func ListLockouts() ([]models.LoginAttempts, error) {
+	return GetAttemptStore().List(time.Now(), lockoutWindow())
+}
+
+// ClearLockout removes the failures and lock of a key
+func ClearLockout(key string) error {
+	return GetAttemptStore().Reset(key)
+}
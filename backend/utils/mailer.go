@@ -0,0 +1,117 @@
+package utils
+
+import (
+	"fmt"
+	"net/smtp"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+// MailMessage is a plain text email
+type MailMessage struct {
+	To      string
+	Subject string
+	Body    string
+}
+
+// Mailer delivers emails. MAIL_DRIVER selects the implementation: "smtp"
+// sends through SMTP_HOST, anything else writes the messages to MAIL_DIR
+// so they can be read during development.
+type Mailer interface {
+	Send(msg MailMessage) error
+}
+
+var (
+	mailer     Mailer
+	mailerOnce sync.Once
+)
+
+// GetMailer returns the configured mailer
+func GetMailer() Mailer {
+	mailerOnce.Do(func() {
+		if os.Getenv("MAIL_DRIVER") == "smtp" {
+			mailer = &SMTPMailer{
+				Host:     os.Getenv("SMTP_HOST"),
+				Port:     os.Getenv("SMTP_PORT"),
+				Username: os.Getenv("SMTP_USERNAME"),
+				Password: os.Getenv("SMTP_PASSWORD"),
+				From:     mailFrom(),
+			}
+			return
+		}
+
+		dir := os.Getenv("MAIL_DIR")
+		if dir == "" {
+			dir = "mail"
+		}
+		mailer = &FileMailer{Dir: dir, From: mailFrom()}
+	})
+	return mailer
+}
+
+// SendMail sends a message through the configured mailer
+func SendMail(to, subject, body string) error {
+	return GetMailer().Send(MailMessage{To: to, Subject: subject, Body: body})
+}
+
+func mailFrom() string {
+	if from := os.Getenv("MAIL_FROM"); from != "" {
+		return from
+	}
+	return "no-reply@prescripto.local"
+}
+
+// formatMail renders a message with the headers mail servers expect
+func formatMail(from string, msg MailMessage) []byte {
+	// Header values must not smuggle in extra headers
+	clean := strings.NewReplacer("\r", "", "\n", "")
+	return []byte(fmt.Sprintf(
+		"From: %s\r\nTo: %s\r\nSubject: %s\r\nDate: %s\r\nMIME-Version: 1.0\r\nContent-Type: text/plain; charset=UTF-8\r\n\r\n%s\r\n",
+		clean.Replace(from), clean.Replace(msg.To), clean.Replace(msg.Subject),
+		time.Now().Format(time.RFC1123Z), msg.Body,
+	))
+}
+
+// SMTPMailer sends mail through an SMTP server, e.g. a local MailHog in development
+type SMTPMailer struct {
+	Host     string
+	Port     string
+	Username string
+	Password string
+	From     string
+}
+
+func (m *SMTPMailer) Send(msg MailMessage) error {
+	port := m.Port
+	if port == "" {
+		port = "25"
+	}
+
+	var auth smtp.Auth
+	if m.Username != "" {
+		auth = smtp.PlainAuth("", m.Username, m.Password, m.Host)
+	}
+	return smtp.SendMail(m.Host+":"+port, auth, m.From, []string{msg.To}, formatMail(m.From, msg))
+}
+
+// FileMailer writes each message to an .eml file instead of sending it
+type FileMailer struct {
+	Dir  string
+	From string
+}
+
+func (m *FileMailer) Send(msg MailMessage) error {
+	if err := os.MkdirAll(m.Dir, 0o755); err != nil {
+		return err
+	}
+
+	suffix, err := RandomToken(4)
+	if err != nil {
+		return err
+	}
+	name := fmt.Sprintf("%d-%s.eml", time.Now().UnixNano(), suffix)
+	return os.WriteFile(filepath.Join(m.Dir, name), formatMail(m.From, msg), 0o600)
+}
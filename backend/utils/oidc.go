@@ -0,0 +1,388 @@
+package utils
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/dgrijalva/jwt-go"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"prescripto-go/config"
+	"prescripto-go/models"
+)
+
+var (
+	ErrUnknownProvider = errors.New("Unknown sign-in provider")
+	ErrInvalidOIDC     = errors.New("Sign-in failed, please try again")
+	ErrNoStaffAccount  = errors.New("No staff account matches this identity")
+)
+
+// oidcStateTTL bounds how long a user may spend at the provider
+const oidcStateTTL = 10 * time.Minute
+
+var oidcClient = &http.Client{Timeout: 10 * time.Second}
+
+// OIDCProviderByName reads a provider from OIDC_<NAME>_ISSUER, _CLIENT_ID,
+// _CLIENT_SECRET, _REDIRECT_URL, _AUDIENCE ("patients" or "staff") and
+// _TRUST_MFA. Only providers listed in OIDC_PROVIDERS can be used.
+func OIDCProviderByName(name string) (*models.OIDCProvider, error) {
+	listed := false
+	for _, configured := range strings.Split(os.Getenv("OIDC_PROVIDERS"), ",") {
+		if strings.TrimSpace(configured) == name && name != "" {
+			listed = true
+		}
+	}
+	if !listed {
+		return nil, ErrUnknownProvider
+	}
+
+	prefix := "OIDC_" + strings.ToUpper(name) + "_"
+	provider := &models.OIDCProvider{
+		Name:         name,
+		Issuer:       strings.TrimRight(os.Getenv(prefix+"ISSUER"), "/"),
+		ClientID:     os.Getenv(prefix + "CLIENT_ID"),
+		ClientSecret: os.Getenv(prefix + "CLIENT_SECRET"),
+		RedirectURL:  os.Getenv(prefix + "REDIRECT_URL"),
+		Audience:     os.Getenv(prefix + "AUDIENCE"),
+		TrustMFA:     os.Getenv(prefix+"TRUST_MFA") == "true",
+	}
+	if provider.Audience == "" {
+		provider.Audience = models.OIDCAudiencePatients
+	}
+	if provider.Issuer == "" || provider.ClientID == "" || provider.RedirectURL == "" {
+		return nil, fmt.Errorf("sign-in provider %s is not fully configured", name)
+	}
+	return provider, nil
+}
+
+// oidcDiscovery is the part of the provider metadata the login flow needs
+type oidcDiscovery struct {
+	Issuer                string `json:"issuer"`
+	AuthorizationEndpoint string `json:"authorization_endpoint"`
+	TokenEndpoint         string `json:"token_endpoint"`
+	JWKSURI               string `json:"jwks_uri"`
+}
+
+var (
+	oidcCacheMu     sync.Mutex
+	discoveryCache  = map[string]*oidcDiscovery{}
+	providerKeysets = map[string]map[string]models.JWK{}
+)
+
+// discover fetches and caches the provider's OpenID configuration
+func discover(issuer string) (*oidcDiscovery, error) {
+	oidcCacheMu.Lock()
+	cached := discoveryCache[issuer]
+	oidcCacheMu.Unlock()
+	if cached != nil {
+		return cached, nil
+	}
+
+	var doc oidcDiscovery
+	if err := getJSON(issuer+"/.well-known/openid-configuration", &doc); err != nil {
+		return nil, err
+	}
+	if strings.TrimRight(doc.Issuer, "/") != issuer {
+		return nil, fmt.Errorf("provider reports issuer %s, expected %s", doc.Issuer, issuer)
+	}
+
+	oidcCacheMu.Lock()
+	discoveryCache[issuer] = &doc
+	oidcCacheMu.Unlock()
+	return &doc, nil
+}
+
+// providerKey returns the signing key with the kid, refetching the key set
+// once when the kid is unknown since providers rotate keys
+func providerKey(jwksURI, kid string) (models.JWK, error) {
+	oidcCacheMu.Lock()
+	key, ok := providerKeysets[jwksURI][kid]
+	oidcCacheMu.Unlock()
+	if ok {
+		return key, nil
+	}
+
+	var set models.JWKSet
+	if err := getJSON(jwksURI, &set); err != nil {
+		return models.JWK{}, err
+	}
+	keys := make(map[string]models.JWK)
+	for _, k := range set.Keys {
+		keys[k.Kid] = k
+	}
+
+	oidcCacheMu.Lock()
+	providerKeysets[jwksURI] = keys
+	oidcCacheMu.Unlock()
+
+	if key, ok = keys[kid]; !ok {
+		return models.JWK{}, fmt.Errorf("unknown signing key %q", kid)
+	}
+	return key, nil
+}
+
+func getJSON(url string, v interface{}) error {
+	resp, err := oidcClient.Get(url)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("GET %s returned %s", url, resp.Status)
+	}
+	return json.NewDecoder(resp.Body).Decode(v)
+}
+
+// BeginOIDCLogin stores the state, nonce and PKCE verifier of a new login and
+// returns the provider URL to send the browser to
+func BeginOIDCLogin(provider *models.OIDCProvider, role string) (string, error) {
+	doc, err := discover(provider.Issuer)
+	if err != nil {
+		return "", err
+	}
+
+	state, err := RandomToken(32)
+	if err != nil {
+		return "", err
+	}
+	nonce, err := RandomToken(32)
+	if err != nil {
+		return "", err
+	}
+	verifier, err := RandomToken(32)
+	if err != nil {
+		return "", err
+	}
+
+	_, err = config.GetCollection("oidc_states").InsertOne(context.Background(), models.OIDCState{
+		StateHash: HashToken(state),
+		Provider:  provider.Name,
+		Role:      role,
+		Nonce:     nonce,
+		Verifier:  verifier,
+		ExpiresAt: time.Now().Add(oidcStateTTL).Unix(),
+	})
+	if err != nil {
+		return "", err
+	}
+
+	challenge := sha256.Sum256([]byte(verifier))
+	query := url.Values{
+		"response_type":         {"code"},
+		"client_id":             {provider.ClientID},
+		"redirect_uri":          {provider.RedirectURL},
+		"scope":                 {"openid email profile"},
+		"state":                 {state},
+		"nonce":                 {nonce},
+		"code_challenge":        {base64.RawURLEncoding.EncodeToString(challenge[:])},
+		"code_challenge_method": {"S256"},
+	}
+	separator := "?"
+	if strings.Contains(doc.AuthorizationEndpoint, "?") {
+		separator = "&"
+	}
+	return doc.AuthorizationEndpoint + separator + query.Encode(), nil
+}
+
+// FinishOIDCLogin redeems the authorization code of a callback and returns
+// the validated identity. The state works once and only for the role that
+// started the login.
+func FinishOIDCLogin(provider *models.OIDCProvider, role, code, state string) (*models.OIDCClaims, error) {
+	var saved models.OIDCState
+	err := config.GetCollection("oidc_states").FindOneAndDelete(context.Background(), bson.M{
+		"stateHash": HashToken(state),
+		"provider":  provider.Name,
+		"role":      role,
+		"expiresAt": bson.M{"$gt": time.Now().Unix()},
+	}).Decode(&saved)
+	if err == mongo.ErrNoDocuments {
+		return nil, ErrInvalidOIDC
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	doc, err := discover(provider.Issuer)
+	if err != nil {
+		return nil, err
+	}
+
+	form := url.Values{
+		"grant_type":    {"authorization_code"},
+		"code":          {code},
+		"redirect_uri":  {provider.RedirectURL},
+		"client_id":     {provider.ClientID},
+		"code_verifier": {saved.Verifier},
+	}
+	if provider.ClientSecret != "" {
+		form.Set("client_secret", provider.ClientSecret)
+	}
+	resp, err := oidcClient.PostForm(doc.TokenEndpoint, form)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	var tokens struct {
+		IDToken string `json:"id_token"`
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, ErrInvalidOIDC
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&tokens); err != nil || tokens.IDToken == "" {
+		return nil, ErrInvalidOIDC
+	}
+
+	return validateIDToken(provider, doc, tokens.IDToken, saved.Nonce)
+}
+
+// validateIDToken checks the signature, issuer, audience, expiry and nonce of an ID token
+func validateIDToken(provider *models.OIDCProvider, doc *oidcDiscovery, idToken, nonce string) (*models.OIDCClaims, error) {
+	claims := jwt.MapClaims{}
+	parsed, err := jwt.ParseWithClaims(idToken, claims, func(token *jwt.Token) (interface{}, error) {
+		if token.Method != jwt.SigningMethodRS256 {
+			return nil, fmt.Errorf("unexpected signing method %v", token.Header["alg"])
+		}
+		kid, _ := token.Header["kid"].(string)
+		jwk, err := providerKey(doc.JWKSURI, kid)
+		if err != nil {
+			return nil, err
+		}
+		return ParseRSAJWK(jwk)
+	})
+	if err != nil || !parsed.Valid {
+		return nil, ErrInvalidOIDC
+	}
+
+	if !claims.VerifyIssuer(provider.Issuer, true) && !claims.VerifyIssuer(provider.Issuer+"/", true) {
+		return nil, ErrInvalidOIDC
+	}
+	if !claims.VerifyAudience(provider.ClientID, true) || !claims.VerifyExpiresAt(time.Now().Unix(), true) {
+		return nil, ErrInvalidOIDC
+	}
+	if claims["nonce"] != nonce {
+		return nil, ErrInvalidOIDC
+	}
+
+	identity := &models.OIDCClaims{}
+	identity.Subject, _ = claims["sub"].(string)
+	identity.Email, _ = claims["email"].(string)
+	identity.Name, _ = claims["name"].(string)
+	// Some providers send email_verified as a string
+	switch verified := claims["email_verified"].(type) {
+	case bool:
+		identity.EmailVerified = verified
+	case string:
+		identity.EmailVerified = verified == "true"
+	}
+	if amr, ok := claims["amr"].([]interface{}); ok {
+		for _, method := range amr {
+			if method, ok := method.(string); ok {
+				identity.AMR = append(identity.AMR, method)
+			}
+		}
+	}
+	if identity.Subject == "" {
+		return nil, ErrInvalidOIDC
+	}
+	return identity, nil
+}
+
+// OIDCMultiFactor reports whether a login counts as two-factor: the provider
+// must be trusted to enforce it with OIDC_<NAME>_TRUST_MFA and its ID token
+// must say that several factors were used
+func OIDCMultiFactor(provider *models.OIDCProvider, identity *models.OIDCClaims) bool {
+	return provider.TrustMFA && Contains(identity.AMR, "mfa")
+}
+
+// linkedAccount returns the account id linked to a provider identity
+func linkedAccount(provider, subject, role string) (string, error) {
+	var link models.OIDCIdentity
+	err := config.GetCollection("oidc_identities").FindOne(context.Background(),
+		bson.M{"provider": provider, "subject": subject, "role": role},
+	).Decode(&link)
+	if err == mongo.ErrNoDocuments {
+		return "", nil
+	}
+	return link.PrincipalID, err
+}
+
+func linkIdentity(provider string, identity *models.OIDCClaims, role, id string) error {
+	_, err := config.GetCollection("oidc_identities").InsertOne(context.Background(), models.OIDCIdentity{
+		Provider:    provider,
+		Subject:     identity.Subject,
+		Role:        role,
+		PrincipalID: id,
+		Email:       identity.Email,
+		CreatedAt:   time.Now().Unix(),
+	})
+	if mongo.IsDuplicateKeyError(err) {
+		return nil
+	}
+	return err
+}
+
+// OIDCPatientAccount returns the user linked to a patient identity. A new
+// identity is linked to the user with the same verified email, or a new user
+// is created for it.
+func OIDCPatientAccount(provider string, identity *models.OIDCClaims) (string, error) {
+	id, err := linkedAccount(provider, identity.Subject, RoleUser)
+	if err != nil || id != "" {
+		return id, err
+	}
+
+	// An unverified email could belong to someone else
+	if identity.Email == "" || !identity.EmailVerified {
+		return "", ErrInvalidOIDC
+	}
+
+	id, err = FindOrCreateUser(identity.Email, identity.Name, true)
+	if err != nil {
+		return "", err
+	}
+
+	return id, linkIdentity(provider, identity, RoleUser, id)
+}
+
+// OIDCStaffAccount returns the doctor or admin account of a staff identity.
+// Staff accounts are never created here; a new identity is linked to the
+// account with the same verified email.
+func OIDCStaffAccount(provider string, identity *models.OIDCClaims, role string) (string, error) {
+	id, err := linkedAccount(provider, identity.Subject, role)
+	if err != nil || id != "" {
+		return id, err
+	}
+
+	if identity.Email == "" || !identity.EmailVerified {
+		return "", ErrNoStaffAccount
+	}
+
+	filter := bson.M{"email": NormalizeEmail(identity.Email)}
+	if role == RoleAdmin {
+		filter["status"] = models.AdminStatusActive
+	}
+	var account struct {
+		ID primitive.ObjectID `bson:"_id"`
+	}
+	err = config.GetCollection(accountCollections[role]).FindOne(context.Background(), filter).Decode(&account)
+	if err == mongo.ErrNoDocuments {
+		return "", ErrNoStaffAccount
+	}
+	if err != nil {
+		return "", err
+	}
+	id = account.ID.Hex()
+
+	return id, linkIdentity(provider, identity, role, id)
+}
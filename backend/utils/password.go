@@ -0,0 +1,229 @@
+package utils
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"prescripto-go/config"
+	"prescripto-go/models"
+)
+
+var (
+	ErrInvalidResetToken = errors.New("Invalid or expired reset link")
+	ErrWrongPassword     = errors.New("Current password is incorrect")
+	ErrResetRateLimited  = errors.New("Too many password reset requests, please try again later")
+)
+
+// accountCollections maps a token role to the collection holding its accounts
+var accountCollections = map[string]string{
+	RoleUser:   "users",
+	RoleDoctor: "doctors",
+	RoleAdmin:  "admins",
+}
+
+// passwordResetTTL is how long an emailed reset link works
+func passwordResetTTL() time.Duration {
+	return time.Duration(envInt("PASSWORD_RESET_MINUTES", 60)) * time.Minute
+}
+
+// appURL returns the frontend that serves a role's pages; doctors and admins
+// share the admin panel
+func appURL(role string) string {
+	url := os.Getenv("FRONTEND_URL")
+	if role != RoleUser && os.Getenv("ADMIN_URL") != "" {
+		url = os.Getenv("ADMIN_URL")
+	}
+	return strings.TrimRight(url, "/")
+}
+
+// CheckPasswordResetAllowed counts a reset request against the email and the
+// client address in the reset attempt store, and returns ErrResetRateLimited
+// once either made more than PASSWORD_RESET_MAX_PER_EMAIL or
+// PASSWORD_RESET_MAX_PER_IP requests within PASSWORD_RESET_WINDOW_MINUTES.
+// Requests count whether or not the account exists.
+func CheckPasswordResetAllowed(role, email, ip string) error {
+	store := GetResetAttemptStore()
+	now := time.Now()
+	window := time.Duration(envInt("PASSWORD_RESET_WINDOW_MINUTES", 60)) * time.Minute
+
+	limited := false
+	for _, target := range []struct {
+		key   string
+		limit int
+	}{
+		{"reset:" + role + ":" + NormalizeEmail(email), envInt("PASSWORD_RESET_MAX_PER_EMAIL", 3)},
+		{"reset-ip:" + ip, envInt("PASSWORD_RESET_MAX_PER_IP", 10)},
+	} {
+		attempts, err := store.RecordFailure(target.key, now, window)
+		if err != nil {
+			return err
+		}
+		if attempts.Failures > target.limit {
+			limited = true
+		}
+	}
+	if limited {
+		return ErrResetRateLimited
+	}
+	return nil
+}
+
+// RequestPasswordReset emails a reset link to the account with the email.
+// Unknown emails are ignored so the endpoint does not reveal who has an account.
+func RequestPasswordReset(role, email string) error {
+	var account struct {
+		ID   primitive.ObjectID `bson:"_id"`
+		Name string             `bson:"name"`
+	}
+	err := config.GetCollection(accountCollections[role]).FindOne(context.Background(),
+		bson.M{"email": NormalizeEmail(email)},
+	).Decode(&account)
+	if err == mongo.ErrNoDocuments {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	token, err := RandomToken(32)
+	if err != nil {
+		return err
+	}
+
+	// Only the newest link works
+	resets := config.GetCollection("password_resets")
+	now := time.Now()
+	_, err = resets.UpdateMany(context.Background(),
+		bson.M{"role": role, "principalId": account.ID.Hex(), "usedAt": bson.M{"$exists": false}},
+		bson.M{"$set": bson.M{"usedAt": now.Unix()}},
+	)
+	if err != nil {
+		return err
+	}
+
+	_, err = resets.InsertOne(context.Background(), models.PasswordReset{
+		Role:        role,
+		PrincipalID: account.ID.Hex(),
+		TokenHash:   HashToken(token),
+		ExpiresAt:   now.Add(passwordResetTTL()).Unix(),
+		CreatedAt:   now.Unix(),
+	})
+	if err != nil {
+		return err
+	}
+
+	link := fmt.Sprintf("%s/reset-password?role=%s&token=%s", appURL(role), role, token)
+	body := fmt.Sprintf("Hi %s,\n\nUse the link below to choose a new password. It expires in %d minutes and works once.\n\n%s\n\nIf you did not ask for this you can ignore this email.\n",
+		account.Name, int(passwordResetTTL().Minutes()), link)
+	return SendMail(strings.TrimSpace(email), "Reset your password", body)
+}
+
+// ResetPassword sets a new password with a reset token and logs the account
+// out everywhere
+func ResetPassword(role, token, password string) error {
+	resets := config.GetCollection("password_resets")
+	filter := bson.M{
+		"tokenHash": HashToken(token),
+		"role":      role,
+		"usedAt":    bson.M{"$exists": false},
+		"expiresAt": bson.M{"$gt": time.Now().Unix()},
+	}
+
+	// A rejected password leaves the link usable for another try
+	var reset models.PasswordReset
+	err := resets.FindOne(context.Background(), filter).Decode(&reset)
+	if err == mongo.ErrNoDocuments {
+		return ErrInvalidResetToken
+	}
+	if err != nil {
+		return err
+	}
+	if err := validateAccountPassword(role, reset.PrincipalID, password); err != nil {
+		return err
+	}
+
+	err = resets.FindOneAndUpdate(context.Background(), filter,
+		bson.M{"$set": bson.M{"usedAt": time.Now().Unix()}},
+	).Decode(&reset)
+	if err == mongo.ErrNoDocuments {
+		return ErrInvalidResetToken
+	}
+	if err != nil {
+		return err
+	}
+
+	return setPassword(role, reset.PrincipalID, password)
+}
+
+// ChangePassword replaces the password of a logged in account after checking
+// the current one, and logs the account out everywhere
+func ChangePassword(role, id, current, password string) error {
+	objectID, _ := primitive.ObjectIDFromHex(id)
+	var account struct {
+		Password string `bson:"password"`
+	}
+	err := config.GetCollection(accountCollections[role]).FindOne(context.Background(), bson.M{"_id": objectID}).Decode(&account)
+	if err != nil {
+		return err
+	}
+	if !CheckPasswordHash(current, account.Password) {
+		return ErrWrongPassword
+	}
+	if err := validateAccountPassword(role, id, password); err != nil {
+		return err
+	}
+
+	return setPassword(role, id, password)
+}
+
+// validateAccountPassword checks a new password against the policy using the
+// name and email of the account
+func validateAccountPassword(role, id, password string) error {
+	objectID, _ := primitive.ObjectIDFromHex(id)
+	var account struct {
+		Name  string `bson:"name"`
+		Email string `bson:"email"`
+	}
+	err := config.GetCollection(accountCollections[role]).FindOne(context.Background(), bson.M{"_id": objectID}).Decode(&account)
+	if err != nil {
+		return err
+	}
+	return ValidatePassword(password, account.Name, account.Email)
+}
+
+// setPassword stores a new password hash and revokes existing sessions so
+// whoever knew the old password loses access
+func setPassword(role, id, password string) error {
+	hash, err := HashPassword(password)
+	if err != nil {
+		return err
+	}
+
+	objectID, _ := primitive.ObjectIDFromHex(id)
+	_, err = config.GetCollection(accountCollections[role]).UpdateOne(context.Background(),
+		bson.M{"_id": objectID},
+		bson.M{"$set": bson.M{"password": hash}},
+	)
+	if err != nil {
+		return err
+	}
+
+	return RevokeAllSessions(id, role, "")
+}
+
+// AccountEmail returns the email of an account
+func AccountEmail(role, id string) (string, error) {
+	objectID, _ := primitive.ObjectIDFromHex(id)
+	var account struct {
+		Email string `bson:"email"`
+	}
+	err := config.GetCollection(accountCollections[role]).FindOne(context.Background(), bson.M{"_id": objectID}).Decode(&account)
+	return account.Email, err
+}
@@ -0,0 +1,231 @@
+package utils
+
+import (
+	"bufio"
+	"context"
+	"crypto/sha1"
+	_ "embed"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"sync"
+	"unicode"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"prescripto-go/config"
+)
+
+// maxPasswordLength is the most bcrypt will hash
+const maxPasswordLength = 72
+
+// breachedPrefixLength is how many hex characters of a SHA-1 hash select a
+// bucket, as in the Pwned Passwords range API
+const breachedPrefixLength = 5
+
+// PasswordPolicyError explains why a password was rejected
+type PasswordPolicyError struct {
+	Reason string
+}
+
+func (e *PasswordPolicyError) Error() string {
+	return e.Reason
+}
+
+// PasswordPolicy is configured through PASSWORD_MIN_LENGTH, PASSWORD_MIN_CLASSES
+// (how many of lowercase, uppercase, digits and symbols must appear) and
+// PASSWORD_CHECK_BREACHED
+type PasswordPolicy struct {
+	MinLength     int  `json:"minLength"`
+	MaxLength     int  `json:"maxLength"`
+	MinClasses    int  `json:"minClasses"`
+	CheckBreached bool `json:"checkBreached"`
+}
+
+// GetPasswordPolicy returns the configured password policy
+func GetPasswordPolicy() PasswordPolicy {
+	return PasswordPolicy{
+		MinLength:     envInt("PASSWORD_MIN_LENGTH", 10),
+		MaxLength:     maxPasswordLength,
+		MinClasses:    envInt("PASSWORD_MIN_CLASSES", 3),
+		CheckBreached: os.Getenv("PASSWORD_CHECK_BREACHED") != "false",
+	}
+}
+
+// ValidatePassword checks a new password against the policy. The name and
+// email of the account may not appear in it.
+func ValidatePassword(password, name, email string) error {
+	policy := GetPasswordPolicy()
+
+	if len([]rune(password)) < policy.MinLength {
+		return &PasswordPolicyError{fmt.Sprintf("Password must be at least %d characters", policy.MinLength)}
+	}
+	if len(password) > policy.MaxLength {
+		return &PasswordPolicyError{fmt.Sprintf("Password must be at most %d characters", policy.MaxLength)}
+	}
+	if passwordClasses(password) < policy.MinClasses {
+		return &PasswordPolicyError{fmt.Sprintf("Password must mix at least %d of lowercase letters, uppercase letters, digits and symbols", policy.MinClasses)}
+	}
+	if containsPersonalInfo(password, name, email) {
+		return &PasswordPolicyError{"Password must not contain your name or email"}
+	}
+
+	if policy.CheckBreached {
+		breached, err := PasswordBreached(password)
+		if err != nil {
+			return err
+		}
+		if breached {
+			return &PasswordPolicyError{"This password has appeared in a data breach, please choose another"}
+		}
+	}
+	return nil
+}
+
+func passwordClasses(password string) int {
+	var lower, upper, digit, symbol bool
+	for _, r := range password {
+		switch {
+		case unicode.IsLower(r):
+			lower = true
+		case unicode.IsUpper(r):
+			upper = true
+		case unicode.IsDigit(r):
+			digit = true
+		default:
+			symbol = true
+		}
+	}
+
+	count := 0
+	for _, present := range []bool{lower, upper, digit, symbol} {
+		if present {
+			count++
+		}
+	}
+	return count
+}
+
+// containsPersonalInfo reports whether the password contains the email, its
+// local part or a word of the name. Parts shorter than 3 characters are ignored.
+func containsPersonalInfo(password, name, email string) bool {
+	password = strings.ToLower(password)
+	email = NormalizeEmail(email)
+
+	parts := strings.Fields(strings.ToLower(name))
+	if email != "" {
+		parts = append(parts, email, strings.Split(email, "@")[0])
+	}
+	for _, part := range parts {
+		if len(part) >= 3 && strings.Contains(password, part) {
+			return true
+		}
+	}
+	return false
+}
+
+// The bundled list of breached password hashes, one uppercase SHA-1 per line
+//
+//go:embed breached_passwords.txt
+var bundledBreachedPasswords string
+
+var (
+	bundledBreached     map[string][]string
+	bundledBreachedOnce sync.Once
+)
+
+// PasswordBreached looks a password up in the bundled list and the lists
+// uploaded by admins. Hashes are grouped by prefix so a lookup only reads one
+// bucket and the password never leaves the server.
+func PasswordBreached(password string) (bool, error) {
+	sum := sha1.Sum([]byte(password))
+	hash := strings.ToUpper(hex.EncodeToString(sum[:]))
+	prefix, suffix := hash[:breachedPrefixLength], hash[breachedPrefixLength:]
+
+	bundledBreachedOnce.Do(func() {
+		bundledBreached = make(map[string][]string)
+		scanner := bufio.NewScanner(strings.NewReader(bundledBreachedPasswords))
+		for scanner.Scan() {
+			if hash, ok := parseBreachedHash(scanner.Text()); ok {
+				p := hash[:breachedPrefixLength]
+				bundledBreached[p] = append(bundledBreached[p], hash[breachedPrefixLength:])
+			}
+		}
+	})
+	if Contains(bundledBreached[prefix], suffix) {
+		return true, nil
+	}
+
+	count, err := config.GetCollection("breached_passwords").CountDocuments(context.Background(),
+		bson.M{"_id": prefix, "suffixes": suffix},
+	)
+	if err != nil {
+		return false, err
+	}
+	return count > 0, nil
+}
+
+// parseBreachedHash reads a line holding a SHA-1 hash, optionally followed by
+// ":count" as in the Pwned Passwords downloads
+func parseBreachedHash(line string) (string, bool) {
+	hash := strings.ToUpper(strings.TrimSpace(strings.SplitN(line, ":", 2)[0]))
+	if len(hash) != sha1.Size*2 {
+		return "", false
+	}
+	if _, err := hex.DecodeString(hash); err != nil {
+		return "", false
+	}
+	return hash, true
+}
+
+// ImportBreachedPasswords adds the hashes in a list to the breached passwords
+// shared by every instance and returns how many lines were read and skipped
+func ImportBreachedPasswords(r io.Reader) (int, int, error) {
+	collection := config.GetCollection("breached_passwords")
+	imported, skipped := 0, 0
+	buckets := make(map[string][]string)
+
+	flush := func() error {
+		if len(buckets) == 0 {
+			return nil
+		}
+		writes := make([]mongo.WriteModel, 0, len(buckets))
+		for prefix, suffixes := range buckets {
+			writes = append(writes, mongo.NewUpdateOneModel().
+				SetFilter(bson.M{"_id": prefix}).
+				SetUpdate(bson.M{"$addToSet": bson.M{"suffixes": bson.M{"$each": suffixes}}}).
+				SetUpsert(true))
+		}
+		buckets = make(map[string][]string)
+		_, err := collection.BulkWrite(context.Background(), writes, options.BulkWrite().SetOrdered(false))
+		return err
+	}
+
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		if strings.TrimSpace(scanner.Text()) == "" {
+			continue
+		}
+		hash, ok := parseBreachedHash(scanner.Text())
+		if !ok {
+			skipped++
+			continue
+		}
+		prefix := hash[:breachedPrefixLength]
+		buckets[prefix] = append(buckets[prefix], hash[breachedPrefixLength:])
+		imported++
+
+		if len(buckets) >= 1000 {
+			if err := flush(); err != nil {
+				return imported, skipped, err
+			}
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return imported, skipped, err
+	}
+	return imported, skipped, flush()
+}
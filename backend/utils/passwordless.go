@@ -0,0 +1,365 @@
+package utils
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"errors"
+	"fmt"
+	"math/big"
+	"strings"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"prescripto-go/config"
+	"prescripto-go/models"
+)
+
+// maxOTPAttempts is how many wrong codes end an SMS code
+const maxOTPAttempts = 5
+
+var (
+	ErrInvalidLoginCode = errors.New("Invalid or expired code")
+	ErrPhoneUnusable    = errors.New("Please set a valid phone number first")
+	ErrPhoneTaken       = errors.New("This phone number is verified on another account")
+)
+
+func magicLinkTTL() time.Duration {
+	return time.Duration(envInt("MAGIC_LINK_MINUTES", 15)) * time.Minute
+}
+
+func loginOTPTTL() time.Duration {
+	return time.Duration(envInt("LOGIN_OTP_MINUTES", 5)) * time.Minute
+}
+
+// NormalizePhone keeps the digits of a phone number and a leading +
+func NormalizePhone(phone string) string {
+	phone = strings.TrimSpace(phone)
+	var b strings.Builder
+	for i, r := range phone {
+		if r >= '0' && r <= '9' || r == '+' && i == 0 {
+			b.WriteRune(r)
+		}
+	}
+	return b.String()
+}
+
+// loginCodeAllowed reports whether another code may be sent to a destination:
+// one a minute and LOGIN_CODE_HOURLY_LIMIT an hour. An IP may ask for
+// LOGIN_CODE_IP_HOURLY_LIMIT codes an hour across all destinations.
+func loginCodeAllowed(channel, destination, ip string) (bool, error) {
+	codes := config.GetCollection("login_codes")
+	now := time.Now()
+
+	recent, err := codes.CountDocuments(context.Background(), bson.M{
+		"channel":     channel,
+		"destination": destination,
+		"createdAt":   bson.M{"$gt": now.Add(-time.Minute).Unix()},
+	})
+	if err != nil || recent > 0 {
+		return false, err
+	}
+
+	hourly, err := codes.CountDocuments(context.Background(), bson.M{
+		"channel":     channel,
+		"destination": destination,
+		"createdAt":   bson.M{"$gt": now.Add(-time.Hour).Unix()},
+	})
+	if err != nil || hourly >= int64(envInt("LOGIN_CODE_HOURLY_LIMIT", 5)) {
+		return false, err
+	}
+
+	fromIP, err := codes.CountDocuments(context.Background(), bson.M{
+		"ip":        ip,
+		"createdAt": bson.M{"$gt": now.Add(-time.Hour).Unix()},
+	})
+	if err != nil {
+		return false, err
+	}
+	return fromIP < int64(envInt("LOGIN_CODE_IP_HOURLY_LIMIT", 20)), nil
+}
+
+// storeLoginCode saves a new code for a destination; earlier unused codes stop working
+func storeLoginCode(channel, destination, userID, codeHash, ip string, ttl time.Duration) error {
+	codes := config.GetCollection("login_codes")
+	now := time.Now()
+
+	_, err := codes.UpdateMany(context.Background(),
+		bson.M{"channel": channel, "destination": destination, "usedAt": bson.M{"$exists": false}},
+		bson.M{"$set": bson.M{"usedAt": now.Unix()}},
+	)
+	if err != nil {
+		return err
+	}
+
+	_, err = codes.InsertOne(context.Background(), models.LoginCode{
+		Channel:     channel,
+		Destination: destination,
+		UserID:      userID,
+		CodeHash:    codeHash,
+		ExpiresAt:   now.Add(ttl).Unix(),
+		IP:          ip,
+		CreatedAt:   now.Unix(),
+	})
+	return err
+}
+
+// hashLoginCode salts six digit codes with their phone number
+func hashLoginCode(destination, code string) string {
+	return HashToken(destination + ":" + code)
+}
+
+// SendMagicLink emails a single-use login link to the user with the email.
+// Unknown emails and throttled requests are ignored silently so the endpoint
+// does not reveal who has an account.
+func SendMagicLink(email, ip string) error {
+	email = NormalizeEmail(email)
+
+	var user models.User
+	err := config.GetCollection("users").FindOne(context.Background(), bson.M{"email": email}).Decode(&user)
+	if err == mongo.ErrNoDocuments {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	allowed, err := loginCodeAllowed(models.LoginCodeEmail, email, ip)
+	if err != nil || !allowed {
+		return err
+	}
+
+	token, err := RandomToken(32)
+	if err != nil {
+		return err
+	}
+	if err := storeLoginCode(models.LoginCodeEmail, email, user.ID.Hex(), HashToken(token), ip, magicLinkTTL()); err != nil {
+		return err
+	}
+
+	link := fmt.Sprintf("%s/magic-login?token=%s", appURL(RoleUser), token)
+	body := fmt.Sprintf("Hi %s,\n\nOpen the link below to log in. It expires in %d minutes and works once.\n\n%s\n\nIf you did not ask for this you can ignore this email.\n",
+		user.Name, int(magicLinkTTL().Minutes()), link)
+	return SendMail(email, "Your login link", body)
+}
+
+// ConsumeMagicLink uses up a magic link and returns the user it logs in.
+// Opening the link also proves the user owns the email.
+func ConsumeMagicLink(token string) (string, error) {
+	codes := config.GetCollection("login_codes")
+	now := time.Now()
+
+	var code models.LoginCode
+	err := codes.FindOneAndUpdate(context.Background(),
+		bson.M{
+			"channel":   models.LoginCodeEmail,
+			"codeHash":  HashToken(token),
+			"usedAt":    bson.M{"$exists": false},
+			"expiresAt": bson.M{"$gt": now.Unix()},
+		},
+		bson.M{"$set": bson.M{"usedAt": now.Unix()}},
+	).Decode(&code)
+	if err == mongo.ErrNoDocuments {
+		return "", ErrInvalidLoginCode
+	}
+	if err != nil {
+		return "", err
+	}
+
+	userID, _ := primitive.ObjectIDFromHex(code.UserID)
+	config.GetCollection("users").UpdateOne(context.Background(),
+		bson.M{"_id": userID, "email": code.Destination},
+		bson.M{"$set": bson.M{"emailVerified": true}},
+	)
+	return code.UserID, nil
+}
+
+// smsCode returns a random six digit code
+func smsCode() (string, error) {
+	n, err := rand.Int(rand.Reader, big.NewInt(1000000))
+	if err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("%06d", n.Int64()), nil
+}
+
+// latestSMSCode finds the newest live code on a channel that still has guesses left
+func latestSMSCode(filter bson.M) (*models.LoginCode, error) {
+	filter["usedAt"] = bson.M{"$exists": false}
+	filter["expiresAt"] = bson.M{"$gt": time.Now().Unix()}
+	filter["attempts"] = bson.M{"$lt": maxOTPAttempts}
+
+	var latest models.LoginCode
+	err := config.GetCollection("login_codes").FindOne(context.Background(), filter,
+		options.FindOne().SetSort(bson.D{{Key: "createdAt", Value: -1}}),
+	).Decode(&latest)
+	if err == mongo.ErrNoDocuments {
+		return nil, ErrInvalidLoginCode
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &latest, nil
+}
+
+// checkSMSCode compares a code with the one stored; a wrong guess uses up an attempt
+func checkSMSCode(latest *models.LoginCode, code string) error {
+	if !hmac.Equal([]byte(hashLoginCode(latest.Destination, strings.TrimSpace(code))), []byte(latest.CodeHash)) {
+		config.GetCollection("login_codes").UpdateOne(context.Background(), bson.M{"_id": latest.ID}, bson.M{"$inc": bson.M{"attempts": 1}})
+		return ErrInvalidLoginCode
+	}
+	return nil
+}
+
+// usablePhone reports whether a normalized number can receive codes.
+// Accounts start with a placeholder number until the user sets their own.
+func usablePhone(phone string) bool {
+	return len(phone) >= 7 && strings.Trim(phone, "+0") != ""
+}
+
+// SendPhoneVerification texts a code to the logged in user's profile phone.
+// Once confirmed the number can be used for SMS login.
+func SendPhoneVerification(userID, ip string) error {
+	objectID, _ := primitive.ObjectIDFromHex(userID)
+	var user models.User
+	if err := config.GetCollection("users").FindOne(context.Background(), bson.M{"_id": objectID}).Decode(&user); err != nil {
+		return err
+	}
+
+	phone := NormalizePhone(user.Phone)
+	if !usablePhone(phone) {
+		return ErrPhoneUnusable
+	}
+	allowed, err := loginCodeAllowed(models.LoginCodePhone, phone, ip)
+	if err != nil {
+		return err
+	}
+	if !allowed {
+		return ErrRateLimited
+	}
+
+	code, err := smsCode()
+	if err != nil {
+		return err
+	}
+	if err := storeLoginCode(models.LoginCodePhone, phone, userID, hashLoginCode(phone, code), ip, loginOTPTTL()); err != nil {
+		return err
+	}
+
+	return SendSMS(phone, fmt.Sprintf("Your %s verification code is %s. It expires in %d minutes.",
+		tokenIssuer(), code, int(loginOTPTTL().Minutes())))
+}
+
+// ConfirmPhone checks the code sent by SendPhoneVerification and marks the
+// number verified. A number can be verified on one account only.
+func ConfirmPhone(userID, code string) (string, error) {
+	latest, err := latestSMSCode(bson.M{"channel": models.LoginCodePhone, "userId": userID})
+	if err != nil {
+		return "", err
+	}
+	if err := checkSMSCode(latest, code); err != nil {
+		return "", err
+	}
+	if _, err := useLoginCode(latest.ID, userID); err != nil {
+		return "", err
+	}
+
+	// The profile phone may have changed since the code was sent
+	objectID, _ := primitive.ObjectIDFromHex(userID)
+	var user models.User
+	if err := config.GetCollection("users").FindOne(context.Background(), bson.M{"_id": objectID}).Decode(&user); err != nil {
+		return "", err
+	}
+	if NormalizePhone(user.Phone) != latest.Destination {
+		return "", ErrInvalidLoginCode
+	}
+
+	_, err = config.GetCollection("users").UpdateOne(context.Background(),
+		bson.M{"_id": objectID},
+		bson.M{"$set": bson.M{"verifiedPhone": latest.Destination}},
+	)
+	if mongo.IsDuplicateKeyError(err) {
+		return "", ErrPhoneTaken
+	}
+	if err != nil {
+		return "", err
+	}
+	return latest.Destination, nil
+}
+
+// SendLoginOTP texts a six digit login code to the user who verified the
+// phone number. Unknown numbers and throttled requests are ignored silently.
+func SendLoginOTP(phone, ip string) error {
+	phone = NormalizePhone(phone)
+	if !usablePhone(phone) {
+		return nil
+	}
+
+	var user models.User
+	err := config.GetCollection("users").FindOne(context.Background(), bson.M{"verifiedPhone": phone}).Decode(&user)
+	if err == mongo.ErrNoDocuments {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	allowed, err := loginCodeAllowed(models.LoginCodeSMS, phone, ip)
+	if err != nil || !allowed {
+		return err
+	}
+
+	code, err := smsCode()
+	if err != nil {
+		return err
+	}
+	if err := storeLoginCode(models.LoginCodeSMS, phone, user.ID.Hex(), hashLoginCode(phone, code), ip, loginOTPTTL()); err != nil {
+		return err
+	}
+
+	return SendSMS(phone, fmt.Sprintf("Your %s login code is %s. It expires in %d minutes.",
+		tokenIssuer(), code, int(loginOTPTTL().Minutes())))
+}
+
+// VerifyLoginOTP checks the latest SMS code sent to a phone and returns the
+// user it logs in. A code stops working after a few wrong guesses, and when
+// the number is no longer verified on the account.
+func VerifyLoginOTP(phone, code string) (string, error) {
+	phone = NormalizePhone(phone)
+	latest, err := latestSMSCode(bson.M{"channel": models.LoginCodeSMS, "destination": phone})
+	if err != nil {
+		return "", err
+	}
+	if err := checkSMSCode(latest, code); err != nil {
+		return "", err
+	}
+
+	userID, _ := primitive.ObjectIDFromHex(latest.UserID)
+	count, err := config.GetCollection("users").CountDocuments(context.Background(), bson.M{"_id": userID, "verifiedPhone": phone})
+	if err != nil {
+		return "", err
+	}
+	if count == 0 {
+		return "", ErrInvalidLoginCode
+	}
+
+	return useLoginCode(latest.ID, latest.UserID)
+}
+
+// useLoginCode marks a code used; only the first caller gets the user
+func useLoginCode(id primitive.ObjectID, userID string) (string, error) {
+	result, err := config.GetCollection("login_codes").UpdateOne(context.Background(),
+		bson.M{"_id": id, "usedAt": bson.M{"$exists": false}},
+		bson.M{"$set": bson.M{"usedAt": time.Now().Unix()}},
+	)
+	if err != nil {
+		return "", err
+	}
+	if result.ModifiedCount == 0 {
+		return "", ErrInvalidLoginCode
+	}
+	return userID, nil
+}
@@ -0,0 +1,237 @@
+package utils
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"log"
+	"os"
+	"time"
+
+	"github.com/razorpay/razorpay-go"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"prescripto-go/config"
+	"prescripto-go/models"
+)
+
+// RazorpayClient returns a Razorpay client configured from the environment
+func RazorpayClient() *razorpay.Client {
+	return razorpay.NewClient(os.Getenv("RAZORPAY_KEY_ID"), os.Getenv("RAZORPAY_KEY_SECRET"))
+}
+
+// VerifyHMACSignature checks a hex encoded HMAC-SHA256 signature of body
+func VerifyHMACSignature(body []byte, signature, secret string) bool {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(body)
+	expected := hex.EncodeToString(mac.Sum(nil))
+	return hmac.Equal([]byte(expected), []byte(signature))
+}
+
+// RecordPayment appends a transaction to the payments ledger
+func RecordPayment(tx *models.PaymentTransaction) error {
+	now := time.Now().Unix()
+	if tx.CreatedAt == 0 {
+		tx.CreatedAt = now
+	}
+	tx.UpdatedAt = now
+
+	result, err := config.GetCollection("payments").InsertOne(context.Background(), tx)
+	if err != nil {
+		return err
+	}
+	tx.ID = result.InsertedID.(primitive.ObjectID)
+	return nil
+}
+
+// UpdatePayment sets fields on an existing ledger entry
+func UpdatePayment(id primitive.ObjectID, fields bson.M) error {
+	fields["updatedAt"] = time.Now().Unix()
+	_, err := config.GetCollection("payments").UpdateOne(
+		context.Background(),
+		bson.M{"_id": id},
+		bson.M{"$set": fields},
+	)
+	return err
+}
+
+// FailPayment marks a ledger entry as failed and releases its idempotency key
+// so that the operation can be retried
+func FailPayment(id primitive.ObjectID, cause error) error {
+	_, err := config.GetCollection("payments").UpdateOne(
+		context.Background(),
+		bson.M{"_id": id},
+		bson.M{
+			"$set": bson.M{
+				"status":    models.PaymentStatusFailed,
+				"error":     cause.Error(),
+				"updatedAt": time.Now().Unix(),
+			},
+			"$unset": bson.M{"idempotencyKey": ""},
+		},
+	)
+	return err
+}
+
+// FindPaymentByIdempotencyKey returns the ledger entry holding the given key
+func FindPaymentByIdempotencyKey(key string) (*models.PaymentTransaction, error) {
+	var tx models.PaymentTransaction
+	err := config.GetCollection("payments").FindOne(context.Background(), bson.M{"idempotencyKey": key}).Decode(&tx)
+	if err != nil {
+		return nil, err
+	}
+	return &tx, nil
+}
+
+// FindPaymentByGatewayOrderID returns the order entry created for a gateway order or session
+func FindPaymentByGatewayOrderID(gateway, orderID string) (*models.PaymentTransaction, error) {
+	var tx models.PaymentTransaction
+	filter := bson.M{"gateway": gateway, "gatewayOrderId": orderID, "type": models.PaymentTypeOrder}
+	err := config.GetCollection("payments").FindOne(context.Background(), filter).Decode(&tx)
+	if err != nil {
+		return nil, err
+	}
+	return &tx, nil
+}
+
+// LatestPayment returns the most recent ledger entry for an appointment
+// matching the given gateway, type and status
+func LatestPayment(appointmentID, gateway, txType, status string) (*models.PaymentTransaction, error) {
+	filter := bson.M{"appointmentId": appointmentID, "type": txType}
+	if gateway != "" {
+		filter["gateway"] = gateway
+	}
+	if status != "" {
+		filter["status"] = status
+	}
+
+	var tx models.PaymentTransaction
+	opts := options.FindOne().SetSort(bson.D{{Key: "createdAt", Value: -1}})
+	err := config.GetCollection("payments").FindOne(context.Background(), filter, opts).Decode(&tx)
+	if err != nil {
+		return nil, err
+	}
+	return &tx, nil
+}
+
+// LatestPaidPayment returns the most recent confirmed charge for an appointment
+func LatestPaidPayment(appointmentID string) (*models.PaymentTransaction, error) {
+	filter := bson.M{
+		"appointmentId": appointmentID,
+		"status":        models.PaymentStatusPaid,
+		"type": bson.M{"$in": []string{
+			models.PaymentTypeVerification,
+			models.PaymentTypeWebhook,
+			models.PaymentTypeReconciliation,
+		}},
+	}
+
+	var tx models.PaymentTransaction
+	opts := options.FindOne().SetSort(bson.D{{Key: "createdAt", Value: -1}})
+	err := config.GetCollection("payments").FindOne(context.Background(), filter, opts).Decode(&tx)
+	if err != nil {
+		return nil, err
+	}
+	return &tx, nil
+}
+
+// ClaimIdempotencyKey inserts a pending ledger entry guarded by its idempotency key.
+// When another entry already holds the key, that entry is returned with claimed set to false.
+// An order left pending for longer than PAYMENT_PENDING_TIMEOUT seconds, e.g. because the
+// server stopped while calling the gateway, is failed and its key claimed again. Refunds
+// are never taken over since the gateway may have processed them.
+func ClaimIdempotencyKey(tx *models.PaymentTransaction) (existing *models.PaymentTransaction, claimed bool, err error) {
+	for attempt := 0; attempt < 2; attempt++ {
+		tx.ID = primitive.NilObjectID
+		tx.CreatedAt = 0
+		tx.Status = models.PaymentStatusPending
+		err := RecordPayment(tx)
+		if err == nil {
+			return nil, true, nil
+		}
+		if !mongo.IsDuplicateKeyError(err) {
+			return nil, false, err
+		}
+		existing, err = FindPaymentByIdempotencyKey(tx.IdempotencyKey)
+		if err != nil {
+			return nil, false, err
+		}
+		if !pendingOrderAbandoned(existing) {
+			return existing, false, nil
+		}
+		// Only one of several concurrent retries releases the key
+		_, err = config.GetCollection("payments").UpdateOne(
+			context.Background(),
+			bson.M{"_id": existing.ID, "status": models.PaymentStatusPending},
+			bson.M{
+				"$set": bson.M{
+					"status":    models.PaymentStatusFailed,
+					"error":     "order abandoned while pending",
+					"updatedAt": time.Now().Unix(),
+				},
+				"$unset": bson.M{"idempotencyKey": ""},
+			},
+		)
+		if err != nil {
+			return nil, false, err
+		}
+	}
+	return existing, false, nil
+}
+
+func pendingOrderAbandoned(tx *models.PaymentTransaction) bool {
+	timeout := int64(envInt("PAYMENT_PENDING_TIMEOUT", 600))
+	return tx.Type == models.PaymentTypeOrder &&
+		tx.Status == models.PaymentStatusPending &&
+		tx.UpdatedAt < time.Now().Unix()-timeout
+}
+
+// AppointmentRefunds returns the refunds of an appointment, through its
+// gateway and to the wallet, that have not failed. Entries holding one of the
+// given idempotency keys are left out so that a retried refund is not counted
+// against itself.
+func AppointmentRefunds(appointmentID string, exceptKeys ...string) ([]models.PaymentTransaction, error) {
+	filter := bson.M{
+		"appointmentId": appointmentID,
+		"type":          models.PaymentTypeRefund,
+		"status":        bson.M{"$ne": models.PaymentStatusFailed},
+	}
+	if len(exceptKeys) > 0 {
+		filter["idempotencyKey"] = bson.M{"$nin": exceptKeys}
+	}
+
+	cursor, err := config.GetCollection("payments").Find(context.Background(), filter)
+	if err != nil {
+		return nil, err
+	}
+	var refunds []models.PaymentTransaction
+	if err = cursor.All(context.Background(), &refunds); err != nil {
+		return nil, err
+	}
+	return refunds, nil
+}
+
+// MarkAppointmentPaid flags an appointment as paid and issues its invoice
+func MarkAppointmentPaid(appointmentID string) error {
+	appointmentObjectID, err := primitive.ObjectIDFromHex(appointmentID)
+	if err != nil {
+		return err
+	}
+	_, err = config.GetCollection("appointments").UpdateOne(
+		context.Background(),
+		bson.M{"_id": appointmentObjectID, "payment": bson.M{"$ne": true}},
+		bson.M{"$set": bson.M{"payment": true, "paidAt": time.Now().Unix()}},
+	)
+	if err != nil {
+		return err
+	}
+
+	// The payment stands even if the invoice fails; it is issued again on download
+	if _, err := GenerateInvoice(appointmentID); err != nil {
+		log.Printf("Failed to generate invoice for appointment %s: %v", appointmentID, err)
+	}
+	return nil
+}
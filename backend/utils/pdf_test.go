@@ -0,0 +1,124 @@
+package utils
+
+import (
+	"bytes"
+	"fmt"
+	"regexp"
+	"strconv"
+	"testing"
+	"time"
+
+	"prescripto-go/models"
+)
+
+var (
+	pdfStartXref = regexp.MustCompile(`startxref\n(\d+)\n%%EOF\n$`)
+	pdfTrailer   = regexp.MustCompile(`trailer\n<< /Size (\d+) /Root 1 0 R >>`)
+	pdfStream    = regexp.MustCompile(`(?s)^(\d+) 0 obj\n<< /Length (\d+) >>\nstream\n`)
+)
+
+// checkPDFStructure verifies that the cross-reference table points at every
+// object and that each content stream is as long as it declares
+func checkPDFStructure(t *testing.T, pdf []byte) {
+	t.Helper()
+	if !bytes.HasPrefix(pdf, []byte("%PDF-1.4\n")) {
+		t.Fatalf("missing header: %q", pdf[:min(len(pdf), 16)])
+	}
+
+	m := pdfStartXref.FindSubmatch(pdf)
+	if m == nil {
+		t.Fatal("missing startxref")
+	}
+	xref, _ := strconv.Atoi(string(m[1]))
+	if xref >= len(pdf) || !bytes.HasPrefix(pdf[xref:], []byte("xref\n")) {
+		t.Fatalf("startxref %d does not point at the xref table", xref)
+	}
+
+	var first, count int
+	if _, err := fmt.Sscanf(string(pdf[xref:]), "xref\n%d %d\n", &first, &count); err != nil || first != 0 {
+		t.Fatalf("bad xref subsection header: %v", err)
+	}
+	entries := pdf[xref+bytes.IndexByte(pdf[xref+5:], '\n')+6:]
+	if len(entries) < 20*count {
+		t.Fatalf("xref table declares %d entries but is truncated", count)
+	}
+	if string(entries[:20]) != "0000000000 65535 f \n" {
+		t.Fatalf("bad free entry %q", entries[:20])
+	}
+	for i := 1; i < count; i++ {
+		entry := string(entries[20*i : 20*i+20])
+		var offset, generation int
+		var kind string
+		if _, err := fmt.Sscanf(entry, "%010d %05d %1s", &offset, &generation, &kind); err != nil || kind != "n" || entry[18:] != " \n" {
+			t.Fatalf("bad xref entry %d: %q", i, entry)
+		}
+		if !bytes.HasPrefix(pdf[offset:], []byte(fmt.Sprintf("%d 0 obj\n", i))) {
+			t.Fatalf("xref entry %d points at %q", i, pdf[offset:min(len(pdf), offset+16)])
+		}
+		if s := pdfStream.FindSubmatch(pdf[offset:]); s != nil {
+			length, _ := strconv.Atoi(string(s[2]))
+			end := offset + len(s[0]) + length
+			if !bytes.HasPrefix(pdf[end:], []byte("endstream")) {
+				t.Fatalf("object %d: stream /Length %d does not end at endstream", i, length)
+			}
+		}
+	}
+
+	trailer := pdfTrailer.FindSubmatch(pdf[xref:])
+	if trailer == nil {
+		t.Fatal("missing trailer")
+	}
+	if size, _ := strconv.Atoi(string(trailer[1])); size != count {
+		t.Fatalf("trailer /Size %d, xref has %d entries", size, count)
+	}
+}
+
+func TestRenderInvoicePDFStructure(t *testing.T) {
+	inr := func(amount int64) models.Money { return models.NewMoney(amount, "INR") }
+	invoice := &models.Invoice{
+		Number:   "INV-2026-000042",
+		Sequence: 42,
+		Patient:  models.InvoiceParty{Name: "Asha (Rao) \\ Ümit 李"},
+		Doctor:   models.InvoiceParty{Name: "Dr. Richard James"},
+		SlotDate: "19_10_2026",
+		SlotTime: "10:30 AM",
+		Items:    []models.InvoiceItem{{Description: "Consultation", Amount: inr(50000)}},
+		Subtotal: inr(50000),
+		Tax:      inr(9000),
+		TaxRate:  18,
+		Total:    inr(59000),
+		Payment:  models.InvoicePayment{Gateway: "razorpay", Reference: "pay_123", PaidAt: time.Now().UnixMilli()},
+		IssuedAt: time.Now().UnixMilli(),
+	}
+	checkPDFStructure(t, RenderInvoicePDF(invoice))
+}
+
+func TestRenderPrescriptionPDFStructure(t *testing.T) {
+	prescription := &models.Prescription{
+		Version:          1,
+		Patient:          models.PrescriptionPatient{Name: "Asha (Rao) Ümit", Email: "asha@example.com"},
+		Doctor:           models.PrescriptionDoctor{Name: "Dr. Richard James", Degree: "MBBS", Speciality: "General physician"},
+		SlotDate:         "19_10_2026",
+		SlotTime:         "10:30 AM",
+		Diagnosis:        "Seasonal allergic rhinitis",
+		Status:           "signed",
+		SignedAt:         time.Now().UnixMilli(),
+		VerificationCode: "7KQ2M9XW4TPB",
+	}
+	// Enough medications to run onto further pages
+	for i := 0; i < 60; i++ {
+		prescription.Medications = append(prescription.Medications, models.Medication{
+			Name:         fmt.Sprintf("Cetirizine %d", i),
+			Dose:         "10 mg",
+			Frequency:    "Once daily",
+			Duration:     "5 days",
+			Instructions: "Take after food (at night)",
+		})
+	}
+
+	pdf := RenderPrescriptionPDF(prescription)
+	checkPDFStructure(t, pdf)
+	if !bytes.Contains(pdf, []byte("/Count ")) || bytes.Contains(pdf, []byte("/Count 1 ")) {
+		t.Fatal("expected the prescription to span several pages")
+	}
+}
@@ -0,0 +1,498 @@
+package utils
+
+import (
+	"context"
+	"crypto/rand"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"prescripto-go/config"
+	"prescripto-go/models"
+)
+
+var (
+	ErrPrescriptionNotFound = errors.New("Prescription not found")
+	ErrPrescriptionSigned   = errors.New("Prescription is already signed, amend it instead")
+	ErrPrescriptionUnsigned = errors.New("Prescription is not signed yet")
+	ErrPrescriptionChanged  = errors.New("Prescription was changed by another request, please try again")
+	ErrPrescriptionRevoked  = errors.New("Prescription was revoked")
+)
+
+// verificationAlphabet leaves out characters that are easily misread, such as 0 and O
+const verificationAlphabet = "ABCDEFGHJKLMNPQRSTUVWXYZ23456789"
+
+// LatestPrescription returns the newest version of an appointment's prescription
+func LatestPrescription(appointmentID string) (*models.Prescription, error) {
+	var prescription models.Prescription
+	err := config.GetCollection("prescriptions").FindOne(context.Background(),
+		bson.M{"appointmentId": appointmentID},
+		options.FindOne().SetSort(bson.D{{Key: "version", Value: -1}}),
+	).Decode(&prescription)
+	if err == mongo.ErrNoDocuments {
+		return nil, ErrPrescriptionNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &prescription, nil
+}
+
+// PrescriptionVersions returns every version of an appointment's
+// prescription, newest first. Patients only see signed versions.
+func PrescriptionVersions(appointmentID string, signedOnly bool) ([]models.Prescription, error) {
+	filter := bson.M{"appointmentId": appointmentID}
+	if signedOnly {
+		filter["status"] = models.PrescriptionStatusSigned
+	}
+
+	cursor, err := config.GetCollection("prescriptions").Find(context.Background(), filter,
+		options.Find().SetSort(bson.D{{Key: "version", Value: -1}}),
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(context.Background())
+
+	prescriptions := []models.Prescription{}
+	if err := cursor.All(context.Background(), &prescriptions); err != nil {
+		return nil, err
+	}
+	return prescriptions, nil
+}
+
+// UserPrescriptions returns the current signed version of every prescription
+// written for a user, newest appointment first
+func UserPrescriptions(userID string) ([]models.Prescription, error) {
+	cursor, err := config.GetCollection("prescriptions").Find(context.Background(),
+		bson.M{"userId": userID, "status": models.PrescriptionStatusSigned},
+		options.Find().SetSort(bson.D{{Key: "signedAt", Value: -1}}),
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(context.Background())
+
+	var versions []models.Prescription
+	if err := cursor.All(context.Background(), &versions); err != nil {
+		return nil, err
+	}
+
+	// The newest signature of each appointment is its current version
+	prescriptions := []models.Prescription{}
+	seen := make(map[string]bool)
+	for _, prescription := range versions {
+		if !seen[prescription.AppointmentID] {
+			seen[prescription.AppointmentID] = true
+			prescriptions = append(prescriptions, prescription)
+		}
+	}
+	return prescriptions, nil
+}
+
+// SavePrescriptionDraft creates or updates the draft prescription of an
+// appointment. Signed prescriptions can only be amended.
+func SavePrescriptionDraft(appointment *models.Appointment, req models.SavePrescriptionRequest) (*models.Prescription, error) {
+	latest, err := LatestPrescription(appointment.ID.Hex())
+	if err != nil && err != ErrPrescriptionNotFound {
+		return nil, err
+	}
+	now := time.Now().Unix()
+
+	if latest != nil {
+		if latest.Status != models.PrescriptionStatusDraft {
+			return nil, ErrPrescriptionSigned
+		}
+
+		result, err := config.GetCollection("prescriptions").UpdateOne(context.Background(),
+			bson.M{"_id": latest.ID, "status": models.PrescriptionStatusDraft},
+			bson.M{"$set": bson.M{
+				"diagnosis":   req.Diagnosis,
+				"medications": req.Medications,
+				"notes":       req.Notes,
+				"updatedAt":   now,
+			}},
+		)
+		if err != nil {
+			return nil, err
+		}
+		if result.MatchedCount == 0 {
+			return nil, ErrPrescriptionSigned
+		}
+
+		latest.Diagnosis, latest.Medications, latest.Notes, latest.UpdatedAt = req.Diagnosis, req.Medications, req.Notes, now
+		return latest, nil
+	}
+
+	prescription, err := newPrescription(appointment, req.Diagnosis, req.Medications, req.Notes)
+	if err != nil {
+		return nil, err
+	}
+	prescription.Version = 1
+	prescription.Status = models.PrescriptionStatusDraft
+	if err := insertPrescription(prescription); err != nil {
+		return nil, err
+	}
+	return prescription, nil
+}
+
+// SignPrescription signs the draft prescription of an appointment, after
+// which it can no longer be edited
+func SignPrescription(appointmentID string) (*models.Prescription, error) {
+	code, err := newVerificationCode()
+	if err != nil {
+		return nil, err
+	}
+	now := time.Now().Unix()
+
+	var prescription models.Prescription
+	err = config.GetCollection("prescriptions").FindOneAndUpdate(context.Background(),
+		bson.M{"appointmentId": appointmentID, "status": models.PrescriptionStatusDraft},
+		bson.M{"$set": bson.M{
+			"status":           models.PrescriptionStatusSigned,
+			"signedAt":         now,
+			"verificationCode": code,
+			"updatedAt":        now,
+		}},
+		options.FindOneAndUpdate().SetReturnDocument(options.After),
+	).Decode(&prescription)
+	if err == mongo.ErrNoDocuments {
+		if _, err := LatestPrescription(appointmentID); err != nil {
+			return nil, err
+		}
+		return nil, ErrPrescriptionSigned
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &prescription, nil
+}
+
+// AmendPrescription records a change to a signed prescription as a new signed
+// version. Earlier versions are kept unchanged.
+func AmendPrescription(appointment *models.Appointment, req models.AmendPrescriptionRequest) (*models.Prescription, error) {
+	latest, err := LatestPrescription(appointment.ID.Hex())
+	if err != nil {
+		return nil, err
+	}
+	if latest.Status != models.PrescriptionStatusSigned {
+		return nil, ErrPrescriptionUnsigned
+	}
+	if latest.RevokedAt > 0 {
+		return nil, ErrPrescriptionRevoked
+	}
+
+	prescription, err := newPrescription(appointment, req.Diagnosis, req.Medications, req.Notes)
+	if err != nil {
+		return nil, err
+	}
+	if prescription.VerificationCode, err = newVerificationCode(); err != nil {
+		return nil, err
+	}
+	prescription.Version = latest.Version + 1
+	prescription.PreviousID = latest.ID.Hex()
+	prescription.AmendmentReason = req.Reason
+	prescription.Status = models.PrescriptionStatusSigned
+	prescription.SignedAt = prescription.CreatedAt
+
+	if err := insertPrescription(prescription); err != nil {
+		return nil, err
+	}
+	return prescription, nil
+}
+
+// RevokePrescription withdraws the current version of a signed prescription
+// so that its verification code no longer checks out
+func RevokePrescription(appointmentID, reason string) (*models.Prescription, error) {
+	latest, err := LatestPrescription(appointmentID)
+	if err != nil {
+		return nil, err
+	}
+	if latest.Status != models.PrescriptionStatusSigned {
+		return nil, ErrPrescriptionUnsigned
+	}
+
+	now := time.Now().Unix()
+	result, err := config.GetCollection("prescriptions").UpdateOne(context.Background(),
+		bson.M{"_id": latest.ID, "revokedAt": bson.M{"$exists": false}},
+		bson.M{"$set": bson.M{"revokedAt": now, "revokeReason": reason}},
+	)
+	if err != nil {
+		return nil, err
+	}
+	if result.MatchedCount == 0 {
+		return nil, ErrPrescriptionRevoked
+	}
+
+	latest.RevokedAt, latest.RevokeReason = now, reason
+	return latest, nil
+}
+
+// VerifyPrescription checks a verification code. Codes of revoked versions
+// and of versions replaced by an amendment are reported as such.
+func VerifyPrescription(code string) (*models.PrescriptionVerification, error) {
+	var prescription models.Prescription
+	err := config.GetCollection("prescriptions").FindOne(context.Background(),
+		bson.M{"verificationCode": NormalizeVerificationCode(code)},
+	).Decode(&prescription)
+	if err == mongo.ErrNoDocuments {
+		return nil, ErrPrescriptionNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	status := models.PrescriptionValid
+	if prescription.RevokedAt > 0 {
+		status = models.PrescriptionRevoked
+	} else {
+		latest, err := LatestPrescription(prescription.AppointmentID)
+		if err != nil {
+			return nil, err
+		}
+		if latest.Version > prescription.Version {
+			status = models.PrescriptionSuperseded
+		}
+	}
+
+	return &models.PrescriptionVerification{
+		Status:       status,
+		Code:         prescription.VerificationCode,
+		Version:      prescription.Version,
+		PatientName:  prescription.Patient.Name,
+		Doctor:       prescription.Doctor,
+		Medications:  prescription.Medications,
+		SignedAt:     prescription.SignedAt,
+		RevokedAt:    prescription.RevokedAt,
+		RevokeReason: prescription.RevokeReason,
+	}, nil
+}
+
+// NormalizeVerificationCode puts a code typed in by hand into the XXXX-XXXX-XXXX form
+func NormalizeVerificationCode(code string) string {
+	var b strings.Builder
+	for _, r := range strings.ToUpper(code) {
+		if !strings.ContainsRune(verificationAlphabet, r) {
+			continue
+		}
+		if b.Len() > 0 && (b.Len()+1)%5 == 0 {
+			b.WriteByte('-')
+		}
+		b.WriteRune(r)
+	}
+	return b.String()
+}
+
+// PrescriptionVerifyURL is the page a pharmacy opens to check a code
+func PrescriptionVerifyURL(code string) string {
+	return appURL(RoleUser) + "/verify-prescription?code=" + code
+}
+
+// newVerificationCode returns a random code of 12 characters, about 60 bits
+func newVerificationCode() (string, error) {
+	random := make([]byte, 12)
+	if _, err := rand.Read(random); err != nil {
+		return "", err
+	}
+	code := make([]byte, len(random))
+	for i, b := range random {
+		code[i] = verificationAlphabet[int(b)%len(verificationAlphabet)]
+	}
+	return NormalizeVerificationCode(string(code)), nil
+}
+
+// newPrescription prepares a prescription for an appointment with the
+// current details of the patient and doctor
+func newPrescription(appointment *models.Appointment, diagnosis string, medications []models.Medication, notes string) (*models.Prescription, error) {
+	docObjectID, _ := primitive.ObjectIDFromHex(appointment.DocID)
+	var doctor models.Doctor
+	err := config.GetCollection("doctors").FindOne(context.Background(), bson.M{"_id": docObjectID}).Decode(&doctor)
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now().Unix()
+	return &models.Prescription{
+		AppointmentID: appointment.ID.Hex(),
+		UserID:        appointment.UserID,
+		DocID:         appointment.DocID,
+		Patient: models.PrescriptionPatient{
+			Name:   appointment.UserData.Name,
+			Email:  appointment.UserData.Email,
+			Gender: appointment.UserData.Gender,
+			DOB:    appointment.UserData.DOB,
+		},
+		Doctor: models.PrescriptionDoctor{
+			Name:       doctor.Name,
+			Email:      doctor.Email,
+			Degree:     doctor.Degree,
+			Speciality: doctor.Speciality,
+			Address:    doctor.Address,
+
+			RegistrationNumber: doctor.RegistrationNumber,
+		},
+		SlotDate:    appointment.SlotDate,
+		SlotTime:    appointment.SlotTime,
+		Diagnosis:   diagnosis,
+		Medications: medications,
+		Notes:       notes,
+		CreatedAt:   now,
+		UpdatedAt:   now,
+	}, nil
+}
+
+// insertPrescription stores a new version. The unique appointment and version
+// index turns concurrent amendments into a conflict instead of a fork.
+func insertPrescription(prescription *models.Prescription) error {
+	result, err := config.GetCollection("prescriptions").InsertOne(context.Background(), prescription)
+	if mongo.IsDuplicateKeyError(err) {
+		return ErrPrescriptionChanged
+	}
+	if err != nil {
+		return err
+	}
+	prescription.ID = result.InsertedID.(primitive.ObjectID)
+	return nil
+}
+
+// RenderPrescriptionPDF renders a signed prescription as a PDF document with
+// its verification code and a QR code linking to the verification page
+func RenderPrescriptionPDF(prescription *models.Prescription) []byte {
+	clinicName := os.Getenv("CLINIC_NAME")
+	if clinicName == "" {
+		clinicName = "Prescripto"
+	}
+
+	doc := NewPDFDocument()
+	left, right := 50.0, PDFPageWidth-50
+	signedAt := time.Unix(prescription.SignedAt, 0)
+
+	// Clinic header
+	doc.Text(left, 70, 22, true, clinicName)
+	doc.Text(left, 88, 10, false, prescription.Doctor.Address.Line1)
+	doc.Text(left, 102, 10, false, prescription.Doctor.Address.Line2)
+	doc.TextRight(right, 70, 18, true, "PRESCRIPTION")
+	doc.TextRight(right, 90, 10, false, "Date: "+signedAt.Format("02 Jan 2006"))
+	if prescription.Version > 1 {
+		doc.TextRight(right, 104, 10, false, fmt.Sprintf("Amended, version %d", prescription.Version))
+	}
+	doc.Line(left, 120, right, 120, 1)
+
+	// Doctor and patient
+	doc.Text(left, 145, 11, true, prescription.Doctor.Name)
+	doc.Text(left, 162, 10, false, prescription.Doctor.Degree+" - "+prescription.Doctor.Speciality)
+	if prescription.Doctor.RegistrationNumber != "" {
+		doc.Text(left, 176, 10, false, "Reg. No: "+prescription.Doctor.RegistrationNumber)
+	}
+
+	doc.Text(330, 145, 11, true, "Patient")
+	doc.Text(330, 162, 10, false, prescription.Patient.Name)
+	// Profiles start with "Not Selected" until the patient fills them in
+	var details []string
+	if gender := prescription.Patient.Gender; gender != "" && gender != "Not Selected" {
+		details = append(details, gender)
+	}
+	if dob := prescription.Patient.DOB; dob != "" && dob != "Not Selected" {
+		details = append(details, "DOB "+dob)
+	}
+	doc.Text(330, 176, 10, false, strings.Join(details, ", "))
+	doc.Text(330, 190, 10, false, fmt.Sprintf("Consultation: %s at %s", prescription.SlotDate, prescription.SlotTime))
+
+	y := 220.0
+	doc.Text(left, y, 11, true, "Diagnosis")
+	for _, line := range WrapText(prescription.Diagnosis, right-left, 10, false) {
+		y += 14
+		doc.Text(left, y, 10, false, line)
+	}
+
+	// Medication table
+	columns := []struct {
+		title string
+		x     float64
+	}{{"Medicine", left + 8}, {"Dose", 200}, {"Frequency", 270}, {"Duration", 360}, {"Instructions", 430}}
+	y += 20
+	doc.Rect(left, y, right-left, 20, 0.9)
+	for _, column := range columns {
+		doc.Text(column.x, y+14, 10, true, column.title)
+	}
+	y += 20
+	for _, medication := range prescription.Medications {
+		// Long prescriptions continue on another page, above the signature
+		if y > PDFPageHeight-260 {
+			doc.AddPage()
+			y = 60
+		}
+		cells := []string{medication.Name, medication.Dose, medication.Frequency, medication.Duration, medication.Instructions}
+		rows := 1
+		wrapped := make([][]string, len(cells))
+		for i, cell := range cells {
+			width := right - columns[i].x - 8
+			if i+1 < len(columns) {
+				width = columns[i+1].x - columns[i].x - 8
+			}
+			wrapped[i] = WrapText(cell, width, 10, false)
+			if len(wrapped[i]) > rows {
+				rows = len(wrapped[i])
+			}
+		}
+		for i, lines := range wrapped {
+			for j, line := range lines {
+				doc.Text(columns[i].x, y+16+float64(j)*13, 10, false, line)
+			}
+		}
+		y += 8 + float64(rows)*13
+		doc.Line(left, y, right, y, 0.3)
+	}
+
+	if prescription.Notes != "" {
+		y += 24
+		doc.Text(left, y, 11, true, "Advice")
+		for _, line := range WrapText(prescription.Notes, right-left, 10, false) {
+			y += 14
+			if y > PDFPageHeight-220 {
+				doc.AddPage()
+				y = 60
+			}
+			doc.Text(left, y, 10, false, line)
+		}
+	}
+	if prescription.AmendmentReason != "" {
+		y += 24
+		doc.Text(left, y, 10, true, "Amendment: ")
+		doc.Text(left+PDFTextWidth("Amendment: ", 10, true), y, 10, false, prescription.AmendmentReason)
+	}
+
+	// Signature and verification, anchored to the bottom of the page
+	qrTop := PDFPageHeight - 200
+	doc.Text(left, qrTop+20, 10, false, "Digitally signed by")
+	doc.Text(left, qrTop+36, 11, true, prescription.Doctor.Name)
+	doc.Text(left, qrTop+52, 10, false, "on "+signedAt.Format("02 Jan 2006 15:04"))
+
+	verifyURL := PrescriptionVerifyURL(prescription.VerificationCode)
+	doc.Text(left, qrTop+84, 10, true, "Verification code: "+prescription.VerificationCode)
+	doc.Text(left, qrTop+100, 8, false, "Scan the QR code or enter the code at")
+	doc.Text(left, qrTop+112, 8, false, verifyURL)
+
+	if qr, err := EncodeQR([]byte(verifyURL)); err == nil {
+		module := 110.0 / float64(qr.Size)
+		qrLeft := right - 110
+		for y := 0; y < qr.Size; y++ {
+			for x := 0; x < qr.Size; x++ {
+				if qr.Dark(x, y) {
+					doc.Rect(qrLeft+float64(x)*module, qrTop+float64(y)*module, module, module, 0)
+				}
+			}
+		}
+	}
+
+	doc.Line(left, PDFPageHeight-60, right, PDFPageHeight-60, 0.5)
+	doc.Text(left, PDFPageHeight-45, 8, false, "Valid only while the verification code checks out as current and not revoked.")
+
+	return doc.Bytes()
+}
@@ -0,0 +1,483 @@
+package utils
+
+import (
+	"bytes"
+	"fmt"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+// Format information at level M for each mask, most significant bit first,
+// as tabulated in ISO/IEC 18004
+var qrFormatStrings = []string{
+	"101010000010010",
+	"101000100100101",
+	"101111001111100",
+	"101101101001011",
+	"100010111111001",
+	"100000011001110",
+	"100111110010111",
+	"100101010100000",
+}
+
+// Version information from ISO/IEC 18004, most significant bit first
+var qrVersionStrings = map[int]string{
+	7:  "000111110010010100",
+	8:  "001000010110111100",
+	9:  "001001101010011001",
+	10: "001010010011010011",
+}
+
+// Total codewords and remainder bits per version, from ISO/IEC 18004
+var qrTotalCodewords = []int{1: 26, 44, 70, 100, 134, 172, 196, 242, 292, 346}
+var qrRemainderBits = []int{1: 0, 7, 7, 7, 7, 7, 0, 0, 0, 0}
+
+func TestQRMultiply(t *testing.T) {
+	tests := []struct{ x, y, want byte }{
+		{0, 0x53, 0},
+		{1, 0x53, 0x53},
+		{0x80, 0x02, 0x1D},
+		{0x02, 0x02, 0x04},
+		{0xFF, 0xFF, 0xE2},
+	}
+	for _, tt := range tests {
+		if got := qrMultiply(tt.x, tt.y); got != tt.want {
+			t.Errorf("qrMultiply(%#x, %#x) = %#x, want %#x", tt.x, tt.y, got, tt.want)
+		}
+		if got := qrMultiply(tt.y, tt.x); got != tt.want {
+			t.Errorf("qrMultiply(%#x, %#x) = %#x, want %#x", tt.y, tt.x, got, tt.want)
+		}
+	}
+}
+
+func TestQRReedSolomonDivisor(t *testing.T) {
+	// Generator polynomial for 10 codewords as powers of alpha:
+	// x^10 + a^251 x^9 + a^67 x^8 + a^46 x^7 + a^61 x^6 + a^118 x^5 +
+	// a^70 x^4 + a^64 x^3 + a^94 x^2 + a^32 x + a^45
+	exponents := []int{251, 67, 46, 61, 118, 70, 64, 94, 32, 45}
+	want := make([]byte, len(exponents))
+	for i, e := range exponents {
+		want[i] = qrAlphaPower(e)
+	}
+	if got := qrReedSolomonDivisor(10); !bytes.Equal(got, want) {
+		t.Fatalf("qrReedSolomonDivisor(10) = %v, want %v", got, want)
+	}
+}
+
+func TestQRReedSolomonRemainder(t *testing.T) {
+	tests := []struct {
+		name string
+		data []byte
+		ecc  []byte
+	}{
+		{
+			// "HELLO WORLD" at 1-M
+			"1-M",
+			[]byte{32, 91, 11, 120, 209, 114, 220, 77, 67, 64, 236, 17, 236, 17, 236, 17},
+			[]byte{196, 35, 39, 119, 235, 215, 231, 226, 93, 23},
+		},
+		{
+			// First block of the 5-Q example
+			"5-Q block 1",
+			[]byte{67, 85, 70, 134, 87, 38, 85, 194, 119, 50, 6, 18, 6, 103, 38},
+			[]byte{213, 199, 11, 45, 115, 247, 241, 223, 229, 248, 154, 117, 154, 111, 86, 161, 111, 39},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := qrReedSolomonRemainder(tt.data, qrReedSolomonDivisor(len(tt.ecc)))
+			if !bytes.Equal(got, tt.ecc) {
+				t.Fatalf("got %v, want %v", got, tt.ecc)
+			}
+		})
+	}
+}
+
+func TestQRDataCodewords(t *testing.T) {
+	got := qrDataCodewords([]byte("hello"), 1)
+	want := []byte{64, 86, 134, 86, 198, 198, 240, 236, 17, 236, 17, 236, 17, 236, 17, 236}
+	if !bytes.Equal(got, want) {
+		t.Fatalf("got %v, want %v", got, want)
+	}
+}
+
+func TestQRVersionTable(t *testing.T) {
+	for version := 1; version < len(qrVersions); version++ {
+		info := qrVersions[version]
+		total := 0
+		for _, n := range info.blocks {
+			total += n + info.eccPerBlock
+		}
+		if total != qrTotalCodewords[version] {
+			t.Errorf("version %d has %d codewords, want %d", version, total, qrTotalCodewords[version])
+		}
+
+		// Every module outside the function patterns carries a bit
+		size := 4*version + 17
+		free := 0
+		for y := 0; y < size; y++ {
+			for x := 0; x < size; x++ {
+				if !qrReferenceFunction(version, x, y) {
+					free++
+				}
+			}
+		}
+		if want := 8*qrTotalCodewords[version] + qrRemainderBits[version]; free != want {
+			t.Errorf("version %d has %d data modules, want %d", version, free, want)
+		}
+
+		q := newQRCode(version)
+		for y := 0; y < size; y++ {
+			for x := 0; x < size; x++ {
+				if q.function[y][x] != qrReferenceFunction(version, x, y) {
+					t.Fatalf("version %d: module (%d, %d) function = %v", version, x, y, q.function[y][x])
+				}
+			}
+		}
+	}
+}
+
+func TestQRFormatBits(t *testing.T) {
+	for mask, want := range qrFormatStrings {
+		q := newQRCode(1)
+		q.drawFormatBits(mask)
+		first, second := qrReadFormat(q)
+		if first != want || second != want {
+			t.Errorf("mask %d: format bits %s and %s, want %s", mask, first, second, want)
+		}
+	}
+}
+
+func TestQRVersionBits(t *testing.T) {
+	for version, want := range qrVersionStrings {
+		q := newQRCode(version)
+		topRight, bottomLeft := qrReadVersion(q)
+		if topRight != want || bottomLeft != want {
+			t.Errorf("version %d: version bits %s and %s, want %s", version, topRight, bottomLeft, want)
+		}
+	}
+}
+
+// qrGolden is the symbol for qrGoldenText, version 4-M with mask 2
+const qrGoldenText = "https://hosplify.example/verify-prescription?code=7KQ2M9XW4TPB"
+
+var qrGolden = []string{
+	"#######..#.#....#..##..#..#######",
+	"#.....#...##...##.#.#.#.#.#.....#",
+	"#.###.#.#..##..#....###...#.###.#",
+	"#.###.#.#..##.#.#####.##..#.###.#",
+	"#.###.#.###.#..##...#..##.#.###.#",
+	"#.....#.##.#####..#.##..#.#.....#",
+	"#######.#.#.#.#.#.#.#.#.#.#######",
+	"........##.....##.##.###.........",
+	"#.#####...######.#.#..###.#####..",
+	".####...#.###.#.#.####.#..##.####",
+	"..##.##..#.###.#.##.#...#.#.#.##.",
+	"..###...##.#......#..##.##..###..",
+	"...##.#.....#..##.....#.##.###...",
+	"#..#...##......####.##.#.##...###",
+	"##.##.###.#...##....#.#..####..#.",
+	".#..##..####..###.####.#.###.##..",
+	"..#.#.#.##.#######....##.#.##...#",
+	"###.##.##.#..#..#####.##..##.####",
+	".....##...##.####.....#....##.##.",
+	".#.##....#.###.##....####..#####.",
+	"###.#.#.#.#..########.#.##..##.##",
+	"#.#..#.###.#..#....#...#.##..##.#",
+	"#...###..#....###...##....#.####.",
+	"#.#.##.###..##..#...###.#..#.##.#",
+	"#.....#..#.#####.####..#######.##",
+	"........#.##.#..######.##...#.#.#",
+	"#######...#....#..#.#####.#.#.#..",
+	"#.....#.#..##...#...##.##...####.",
+	"#.###.#.#....#.##...#.#.######..#",
+	"#.###.#.#.#.#.####.#...#.#..#.###",
+	"#.###.#.#.###..#.#..#.##..##.#...",
+	"#.....#....#.###....##...#..###..",
+	"#######.##.#.##.##....###..#...#.",
+}
+
+func TestQRGolden(t *testing.T) {
+	q, err := EncodeQR([]byte(qrGoldenText))
+	if err != nil {
+		t.Fatal(err)
+	}
+	got := qrRows(q)
+	for i, want := range qrGolden {
+		if i >= len(got) || got[i] != want {
+			t.Fatalf("row %d differs\ngot:\n%s", i, strings.Join(got, "\n"))
+		}
+	}
+
+	data, err := qrReferenceDecode(q)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(data) != qrGoldenText {
+		t.Fatalf("decoded %q", data)
+	}
+}
+
+func TestEncodeQRRoundTrip(t *testing.T) {
+	// Lengths at the edges of every version's capacity
+	for _, length := range []int{0, 1, 14, 15, 26, 27, 42, 43, 62, 63, 84, 85, 106, 107, 122, 123, 152, 153, 180, 181, 213} {
+		data := make([]byte, length)
+		for i := range data {
+			data[i] = byte(i*37 + length)
+		}
+		q, err := EncodeQR(data)
+		if err != nil {
+			t.Fatalf("length %d: %v", length, err)
+		}
+		decoded, err := qrReferenceDecode(q)
+		if err != nil {
+			t.Fatalf("length %d, version %d: %v", length, (q.Size-17)/4, err)
+		}
+		if !bytes.Equal(decoded, data) {
+			t.Fatalf("length %d: decoded %v", length, decoded)
+		}
+	}
+
+	if _, err := EncodeQR(make([]byte, 214)); err != ErrQRDataTooLong {
+		t.Fatalf("214 bytes: got %v, want ErrQRDataTooLong", err)
+	}
+}
+
+func qrAlphaPower(e int) byte {
+	v := byte(1)
+	for i := 0; i < e; i++ {
+		v = qrMultiply(v, 2)
+	}
+	return v
+}
+
+func qrRows(q *QRCode) []string {
+	rows := make([]string, q.Size)
+	for y := 0; y < q.Size; y++ {
+		var b strings.Builder
+		for x := 0; x < q.Size; x++ {
+			if q.Dark(x, y) {
+				b.WriteByte('#')
+			} else {
+				b.WriteByte('.')
+			}
+		}
+		rows[y] = b.String()
+	}
+	return rows
+}
+
+func qrBits(q *QRCode, points [][2]int) string {
+	var b strings.Builder
+	for _, p := range points {
+		if q.Dark(p[0], p[1]) {
+			b.WriteByte('1')
+		} else {
+			b.WriteByte('0')
+		}
+	}
+	return b.String()
+}
+
+// qrReadFormat reads both copies of the format information, most significant bit first
+func qrReadFormat(q *QRCode) (string, string) {
+	var first, second [][2]int
+	// Along row 8 left of the timing pattern, then up column 8
+	for _, x := range []int{0, 1, 2, 3, 4, 5, 7, 8} {
+		first = append(first, [2]int{x, 8})
+	}
+	for _, y := range []int{7, 5, 4, 3, 2, 1, 0} {
+		first = append(first, [2]int{8, y})
+	}
+	// Up column 8 from the bottom, then along row 8 to the right edge
+	for y := q.Size - 1; y >= q.Size-7; y-- {
+		second = append(second, [2]int{8, y})
+	}
+	for x := q.Size - 8; x < q.Size; x++ {
+		second = append(second, [2]int{x, 8})
+	}
+	return qrBits(q, first), qrBits(q, second)
+}
+
+// qrReadVersion reads both copies of the version information, most
+// significant bit first. The least significant bit is the top left module of
+// the upper right block and of the transposed lower left block.
+func qrReadVersion(q *QRCode) (string, string) {
+	var topRight, bottomLeft [][2]int
+	for i := 17; i >= 0; i-- {
+		topRight = append(topRight, [2]int{q.Size - 11 + i%3, i / 3})
+		bottomLeft = append(bottomLeft, [2]int{i / 3, q.Size - 11 + i%3})
+	}
+	return qrBits(q, topRight), qrBits(q, bottomLeft)
+}
+
+// qrReferenceFunction reports whether a module belongs to a function pattern
+// or a reserved area, following the layout rules of ISO/IEC 18004
+func qrReferenceFunction(version, x, y int) bool {
+	size := 4*version + 17
+	switch {
+	case x == 6 || y == 6: // timing patterns
+		return true
+	case x <= 8 && y <= 8: // top left finder, separator and format
+		return true
+	case x >= size-8 && y <= 8: // top right finder, separator and format
+		return true
+	case x <= 8 && y >= size-8: // bottom left finder, separator, format and dark module
+		return true
+	}
+	if version >= 7 && (x >= size-11 && x <= size-9 && y <= 5 || y >= size-11 && y <= size-9 && x <= 5) {
+		return true
+	}
+
+	if version >= 2 {
+		// Alignment centres are spread evenly from 6 to size-7
+		last := size - 7
+		count := version/7 + 2
+		step := (last - 6 + count - 2) / (count - 1)
+		if step%2 == 1 {
+			step++
+		}
+		centers := []int{6}
+		for c := last - step*(count-2); c <= last; c += step {
+			centers = append(centers, c)
+		}
+		for _, cx := range centers {
+			for _, cy := range centers {
+				if cx == 6 && cy == 6 || cx == 6 && cy == last || cx == last && cy == 6 {
+					continue
+				}
+				if qrAbs(x-cx) <= 2 && qrAbs(y-cy) <= 2 {
+					return true
+				}
+			}
+		}
+	}
+	return false
+}
+
+// qrReferenceDecode reads the data back out of a symbol: it finds the mask
+// from the format information, reads the codewords in placement order,
+// checks every block's Reed-Solomon syndromes and parses the byte segment
+func qrReferenceDecode(q *QRCode) ([]byte, error) {
+	version := (q.Size - 17) / 4
+	info := qrVersions[version]
+
+	first, second := qrReadFormat(q)
+	if first != second {
+		return nil, fmt.Errorf("format copies differ: %s, %s", first, second)
+	}
+	mask := -1
+	for m, format := range qrFormatStrings {
+		if format == first {
+			mask = m
+		}
+	}
+	if mask < 0 {
+		return nil, fmt.Errorf("unknown format %s", first)
+	}
+
+	masked := func(x, y int) bool {
+		switch mask {
+		case 0:
+			return (y+x)%2 == 0
+		case 1:
+			return y%2 == 0
+		case 2:
+			return x%3 == 0
+		case 3:
+			return (y+x)%3 == 0
+		case 4:
+			return (y/2+x/3)%2 == 0
+		case 5:
+			return (y*x)%2+(y*x)%3 == 0
+		case 6:
+			return ((y*x)%2+(y*x)%3)%2 == 0
+		default:
+			return ((y+x)%2+(y*x)%3)%2 == 0
+		}
+	}
+
+	// Two columns at a time from the right, alternately upwards and downwards
+	var bits []bool
+	upwards := true
+	for right := q.Size - 1; right > 0; right -= 2 {
+		if right == 6 {
+			right--
+		}
+		for i := 0; i < q.Size; i++ {
+			y := i
+			if upwards {
+				y = q.Size - 1 - i
+			}
+			for _, x := range []int{right, right - 1} {
+				if !qrReferenceFunction(version, x, y) {
+					bits = append(bits, q.Dark(x, y) != masked(x, y))
+				}
+			}
+		}
+		upwards = !upwards
+	}
+
+	codewords := make([]byte, qrTotalCodewords[version])
+	for i := range codewords {
+		for j := 0; j < 8; j++ {
+			if bits[8*i+j] {
+				codewords[i] |= 1 << uint(7-j)
+			}
+		}
+	}
+
+	// Undo the interleaving
+	blocks := make([][]byte, len(info.blocks))
+	k := 0
+	for i := 0; i < info.blocks[len(info.blocks)-1]; i++ {
+		for b, n := range info.blocks {
+			if i < n {
+				blocks[b] = append(blocks[b], codewords[k])
+				k++
+			}
+		}
+	}
+	for i := 0; i < info.eccPerBlock; i++ {
+		for b := range blocks {
+			blocks[b] = append(blocks[b], codewords[k])
+			k++
+		}
+	}
+
+	var data []byte
+	for b, block := range blocks {
+		for i := 0; i < info.eccPerBlock; i++ {
+			alpha := qrAlphaPower(i)
+			var syndrome byte
+			for _, c := range block {
+				syndrome = qrMultiply(syndrome, alpha) ^ c
+			}
+			if syndrome != 0 {
+				return nil, fmt.Errorf("block %d: syndrome %d is %d", b, i, syndrome)
+			}
+		}
+		data = append(data, block[:info.blocks[b]]...)
+	}
+
+	var stream strings.Builder
+	for _, c := range data {
+		stream.WriteString(fmt.Sprintf("%08b", c))
+	}
+	s := stream.String()
+	if s[:4] != "0100" {
+		return nil, fmt.Errorf("mode %s is not byte mode", s[:4])
+	}
+	countBits := 8
+	if version >= 10 {
+		countBits = 16
+	}
+	count, _ := strconv.ParseInt(s[4:4+countBits], 2, 32)
+	payload := make([]byte, count)
+	for i := range payload {
+		v, _ := strconv.ParseUint(s[4+countBits+8*i:12+countBits+8*i], 2, 8)
+		payload[i] = byte(v)
+	}
+	return payload, nil
+}
@@ -0,0 +1,144 @@
+package utils
+
+import (
+	"context"
+	"log"
+	"strings"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"prescripto-go/config"
+	"prescripto-go/models"
+)
+
+// defaultRoles are created at startup; apart from super_admin they can be edited
+var defaultRoles = []models.Role{
+	{
+		Name:        models.RoleSuperAdmin,
+		Description: "Full access",
+		Permissions: []string{models.PermAll},
+	},
+	{
+		Name:        "clinic_manager",
+		Description: "Runs doctors, appointments and promotions",
+		Permissions: []string{
+			"appointments:*", "doctors:*", "coupons:*",
+			models.PermDashboardRead, models.PermInvoicesRead, models.PermReportsRead,
+			models.PermPaymentsRead, models.PermPaymentsRecord,
+		},
+	},
+	{
+		Name:        "receptionist",
+		Description: "Front desk appointments and payments at the clinic",
+		Permissions: []string{
+			models.PermAppointmentsRead, models.PermAppointmentsCancel, models.PermDoctorsRead,
+			models.PermPaymentsRecord, models.PermInvoicesRead, models.PermWalletsRead,
+		},
+	},
+	{
+		Name:        "billing",
+		Description: "Payments, refunds, payouts and tax",
+		Permissions: []string{
+			"payments:*", "wallets:*", "settlements:*", "tax:*",
+			models.PermInvoicesRead, models.PermReportsRead, models.PermDashboardRead,
+		},
+	},
+}
+
+// EnsureDefaultRoles creates the built-in roles and gives admins created
+// before roles existed full access, so nobody is locked out
+func EnsureDefaultRoles() {
+	ctx := context.Background()
+	roles := config.GetCollection("roles")
+
+	for _, role := range defaultRoles {
+		_, err := roles.UpdateOne(ctx,
+			bson.M{"name": role.Name},
+			bson.M{"$setOnInsert": bson.M{
+				"description": role.Description,
+				"permissions": role.Permissions,
+				"system":      true,
+				"createdAt":   time.Now().Unix(),
+			}},
+			options.Update().SetUpsert(true),
+		)
+		if err != nil {
+			log.Fatalf("Failed to create role %s: %v", role.Name, err)
+		}
+	}
+
+	_, err := config.GetCollection("admins").UpdateMany(ctx,
+		bson.M{"roles": bson.M{"$exists": false}},
+		bson.M{"$set": bson.M{"roles": []string{models.RoleSuperAdmin}}},
+	)
+	if err != nil {
+		log.Fatalf("Failed to migrate admin roles: %v", err)
+	}
+}
+
+// RolePermissions returns the union of the permissions granted by the named roles
+func RolePermissions(names []string) ([]string, error) {
+	if len(names) == 0 {
+		return nil, nil
+	}
+
+	cursor, err := config.GetCollection("roles").Find(context.Background(), bson.M{"name": bson.M{"$in": names}})
+	if err != nil {
+		return nil, err
+	}
+	var roles []models.Role
+	if err = cursor.All(context.Background(), &roles); err != nil {
+		return nil, err
+	}
+
+	seen := make(map[string]bool)
+	var permissions []string
+	for _, role := range roles {
+		for _, permission := range role.Permissions {
+			if !seen[permission] {
+				seen[permission] = true
+				permissions = append(permissions, permission)
+			}
+		}
+	}
+	return permissions, nil
+}
+
+// HasPermission reports whether granted permissions cover the required one
+func HasPermission(granted []string, required string) bool {
+	resource := required
+	if i := strings.Index(required, ":"); i >= 0 {
+		resource = required[:i]
+	}
+	for _, permission := range granted {
+		if permission == models.PermAll || permission == required || permission == resource+":*" {
+			return true
+		}
+	}
+	return false
+}
+
+// CanGrant reports whether an admin holding granted may hand out all of
+// permissions, so nobody can give others more access than they have
+func CanGrant(granted, permissions []string) bool {
+	for _, permission := range permissions {
+		if !HasPermission(granted, permission) {
+			return false
+		}
+	}
+	return true
+}
+
+// ValidPermission reports whether a permission can be granted to a role
+func ValidPermission(permission string) bool {
+	if permission == models.PermAll {
+		return true
+	}
+	for _, known := range models.AllPermissions {
+		if permission == known || strings.HasSuffix(permission, ":*") && strings.HasPrefix(known, strings.TrimSuffix(permission, "*")) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,310 @@
+package utils
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/stripe/stripe-go/v72"
+	"github.com/stripe/stripe-go/v72/checkout/session"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"prescripto-go/config"
+	"prescripto-go/models"
+)
+
+// gatewayCharge is a paid order or checkout session reported by a gateway
+type gatewayCharge struct {
+	Gateway       string
+	OrderID       string
+	PaymentID     string
+	AppointmentID string
+	Amount        models.Money
+}
+
+// StartReconciliationJob periodically reconciles recent gateway charges.
+// RECONCILE_INTERVAL_MINUTES (default 60, 0 disables) sets how often it runs
+// and RECONCILE_LOOKBACK_HOURS (default 48) how far back it looks.
+func StartReconciliationJob() {
+	interval := envInt("RECONCILE_INTERVAL_MINUTES", 60)
+	lookback := envInt("RECONCILE_LOOKBACK_HOURS", 48)
+	if interval <= 0 {
+		return
+	}
+
+	go func() {
+		ticker := time.NewTicker(time.Duration(interval) * time.Minute)
+		defer ticker.Stop()
+		for range ticker.C {
+			// Only one instance runs each round
+			if !TryLock("reconcile-payments", time.Duration(interval)*time.Minute/2) {
+				continue
+			}
+			end := time.Now().Unix()
+			report, err := ReconcilePayments("schedule", end-int64(lookback)*3600, end)
+			if err != nil {
+				log.Printf("Payment reconciliation failed: %v", err)
+				continue
+			}
+			log.Printf("Payment reconciliation checked %d charges, fixed %d, found %d mismatches",
+				report.Checked, report.Fixed, len(report.Mismatches))
+		}
+	}()
+}
+
+func envInt(name string, fallback int) int {
+	if value, err := strconv.Atoi(os.Getenv(name)); err == nil {
+		return value
+	}
+	return fallback
+}
+
+// ReconcilePayments compares gateway charges created in [start, end) with
+// appointments, confirms charged appointments that were never marked paid,
+// and stores a report of everything that does not match
+func ReconcilePayments(trigger string, start, end int64) (*models.ReconciliationReport, error) {
+	report := &models.ReconciliationReport{
+		Trigger:     trigger,
+		WindowStart: start,
+		WindowEnd:   end,
+		Mismatches:  []models.ReconciliationMismatch{},
+		StartedAt:   time.Now().Unix(),
+	}
+
+	var charges []gatewayCharge
+	listed := make(map[string]bool)
+	if os.Getenv("RAZORPAY_KEY_ID") != "" {
+		found, err := listRazorpayCharges(start, end)
+		if err != nil {
+			report.Errors = append(report.Errors, fmt.Sprintf("razorpay: %v", err))
+		} else {
+			charges = append(charges, found...)
+			listed[models.GatewayRazorpay] = true
+		}
+	}
+	if os.Getenv("STRIPE_SECRET_KEY") != "" {
+		found, err := listStripeCharges(start, end)
+		if err != nil {
+			report.Errors = append(report.Errors, fmt.Sprintf("stripe: %v", err))
+		} else {
+			charges = append(charges, found...)
+			listed[models.GatewayStripe] = true
+		}
+	}
+
+	charged := make(map[string]bool)
+	for _, charge := range charges {
+		report.Checked++
+		charged[charge.AppointmentID] = true
+		if mismatch := reconcileCharge(charge); mismatch != nil {
+			if mismatch.Fixed {
+				report.Fixed++
+			}
+			report.Mismatches = append(report.Mismatches, *mismatch)
+		}
+	}
+
+	mismatches, err := findPaidWithoutCharge(start, end, charged, listed)
+	if err != nil {
+		report.Errors = append(report.Errors, err.Error())
+	}
+	report.Mismatches = append(report.Mismatches, mismatches...)
+
+	report.FinishedAt = time.Now().Unix()
+	result, err := config.GetCollection("reconciliation_reports").InsertOne(context.Background(), report)
+	if err != nil {
+		return nil, err
+	}
+	report.ID = result.InsertedID.(primitive.ObjectID)
+	return report, nil
+}
+
+// reconcileCharge checks one gateway charge against its appointment
+func reconcileCharge(charge gatewayCharge) *models.ReconciliationMismatch {
+	mismatch := &models.ReconciliationMismatch{
+		Gateway:        charge.Gateway,
+		AppointmentID:  charge.AppointmentID,
+		GatewayOrderID: charge.OrderID,
+		Charged:        charge.Amount,
+	}
+
+	appointmentObjectID, _ := primitive.ObjectIDFromHex(charge.AppointmentID)
+	var appointment models.Appointment
+	err := config.GetCollection("appointments").FindOne(context.Background(), bson.M{"_id": appointmentObjectID}).Decode(&appointment)
+	if err != nil {
+		mismatch.Type = models.MismatchUnknownReceipt
+		return mismatch
+	}
+
+	// An appointment whose amounts do not add up cannot match any charge
+	mismatch.Expected, err = appointment.AmountDue()
+	if err != nil || mismatch.Expected != charge.Amount {
+		mismatch.Type = models.MismatchAmountDiffers
+		return mismatch
+	}
+	if appointment.Payment {
+		return nil
+	}
+
+	// The patient was charged but the confirmation never reached us
+	mismatch.Type = models.MismatchChargedUnpaid
+	err = RecordPayment(&models.PaymentTransaction{
+		AppointmentID:    charge.AppointmentID,
+		UserID:           appointment.UserID,
+		Gateway:          charge.Gateway,
+		Type:             models.PaymentTypeReconciliation,
+		Status:           models.PaymentStatusPaid,
+		GatewayOrderID:   charge.OrderID,
+		GatewayPaymentID: charge.PaymentID,
+		Amount:           charge.Amount,
+		IdempotencyKey:   "reconcile:" + charge.Gateway + ":" + charge.OrderID,
+	})
+	if err != nil && !mongo.IsDuplicateKeyError(err) {
+		return mismatch
+	}
+	if err := MarkAppointmentPaid(charge.AppointmentID); err != nil {
+		return mismatch
+	}
+	mismatch.Fixed = true
+	return mismatch
+}
+
+// findPaidWithoutCharge reports appointments paid in the window that no
+// listed gateway charged. Payments settled without a gateway are skipped, as
+// are appointments whose gateway could not be listed.
+func findPaidWithoutCharge(start, end int64, charged, listed map[string]bool) ([]models.ReconciliationMismatch, error) {
+	ctx := context.Background()
+	cursor, err := config.GetCollection("appointments").Find(ctx, bson.M{
+		"payment": true,
+		"paidAt":  bson.M{"$gte": start, "$lt": end},
+	})
+	if err != nil {
+		return nil, err
+	}
+	var appointments []models.Appointment
+	if err = cursor.All(ctx, &appointments); err != nil {
+		return nil, err
+	}
+
+	var mismatches []models.ReconciliationMismatch
+	for _, appointment := range appointments {
+		appointmentID := appointment.ID.Hex()
+		if charged[appointmentID] {
+			continue
+		}
+
+		gateway := ""
+		if paid, err := LatestPaidPayment(appointmentID); err == nil {
+			gateway = paid.Gateway
+		} else if order, err := LatestPayment(appointmentID, "", models.PaymentTypeOrder, ""); err == nil {
+			gateway = order.Gateway
+		}
+		if gateway != "" && gateway != models.GatewayRazorpay && gateway != models.GatewayStripe {
+			continue
+		}
+		if gateway != "" && !listed[gateway] {
+			continue
+		}
+
+		// The mismatch is reported even when the amount due cannot be worked out
+		expected, _ := appointment.AmountDue()
+		mismatches = append(mismatches, models.ReconciliationMismatch{
+			Type:          models.MismatchPaidNoCharge,
+			Gateway:       gateway,
+			AppointmentID: appointmentID,
+			Expected:      expected,
+		})
+	}
+	return mismatches, nil
+}
+
+// listRazorpayCharges returns the paid Razorpay orders created in [start, end)
+func listRazorpayCharges(start, end int64) ([]gatewayCharge, error) {
+	client := RazorpayClient()
+	var charges []gatewayCharge
+
+	const pageSize = 100
+	for skip := 0; ; skip += pageSize {
+		page, err := client.Order.All(map[string]interface{}{
+			"from":  start,
+			"to":    end - 1,
+			"count": pageSize,
+			"skip":  skip,
+		}, nil)
+		if err != nil {
+			return nil, err
+		}
+
+		items, _ := page["items"].([]interface{})
+		for _, item := range items {
+			order, ok := item.(map[string]interface{})
+			if !ok || order["status"] != "paid" {
+				continue
+			}
+			charge := gatewayCharge{Gateway: models.GatewayRazorpay}
+			charge.OrderID, _ = order["id"].(string)
+			charge.AppointmentID, _ = order["receipt"].(string)
+			currency, _ := order["currency"].(string)
+			amount, _ := order["amount_paid"].(float64)
+			charge.Amount = models.NewMoney(int64(amount), currency)
+			charge.PaymentID = razorpayCapturedPayment(charge.OrderID)
+			charges = append(charges, charge)
+		}
+
+		if len(items) < pageSize {
+			return charges, nil
+		}
+	}
+}
+
+// razorpayCapturedPayment returns the id of the captured payment of an order
+func razorpayCapturedPayment(orderID string) string {
+	payments, err := RazorpayClient().Order.Payments(orderID, nil, nil)
+	if err != nil {
+		return ""
+	}
+	items, _ := payments["items"].([]interface{})
+	for _, item := range items {
+		if payment, ok := item.(map[string]interface{}); ok && payment["status"] == "captured" {
+			id, _ := payment["id"].(string)
+			return id
+		}
+	}
+	return ""
+}
+
+// listStripeCharges returns the paid Stripe checkout sessions created in [start, end)
+func listStripeCharges(start, end int64) ([]gatewayCharge, error) {
+	stripe.Key = os.Getenv("STRIPE_SECRET_KEY")
+
+	params := &stripe.CheckoutSessionListParams{}
+	params.Filters.AddFilter("created", "gte", strconv.FormatInt(start, 10))
+	params.Filters.AddFilter("created", "lt", strconv.FormatInt(end, 10))
+
+	var charges []gatewayCharge
+	iter := session.List(params)
+	for iter.Next() {
+		sess := iter.CheckoutSession()
+		if sess.PaymentStatus != stripe.CheckoutSessionPaymentStatusPaid {
+			continue
+		}
+		charge := gatewayCharge{
+			Gateway:       models.GatewayStripe,
+			OrderID:       sess.ID,
+			AppointmentID: sess.ClientReferenceID,
+			Amount:        models.NewMoney(sess.AmountTotal, string(sess.Currency)),
+		}
+		if sess.PaymentIntent != nil {
+			charge.PaymentID = sess.PaymentIntent.ID
+		}
+		if charge.AppointmentID == "" {
+			charge.AppointmentID = sess.Metadata["appointmentId"]
+		}
+		charges = append(charges, charge)
+	}
+	return charges, iter.Err()
+}
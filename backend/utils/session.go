@@ -0,0 +1,242 @@
+package utils
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"prescripto-go/config"
+	"prescripto-go/models"
+)
+
+var ErrRefreshTokenReused = errors.New("Refresh token was already used, please login again")
+
+// refreshTokenTTL bounds how long a login can go unused
+func refreshTokenTTL() time.Duration {
+	return time.Duration(envInt("REFRESH_TOKEN_DAYS", 30)) * 24 * time.Hour
+}
+
+// sessionMaxAge bounds how long a login lasts however often it is refreshed
+func sessionMaxAge() time.Duration {
+	return time.Duration(envInt("SESSION_MAX_AGE_DAYS", 90)) * 24 * time.Hour
+}
+
+// sessionEnd is when a session stops working whatever its use
+func sessionEnd(session *models.Session) time.Time {
+	return time.Unix(session.CreatedAt, 0).Add(sessionMaxAge())
+}
+
+// sessionIdleExpiry is when a session used at now can no longer be resumed:
+// once its last refresh token expires, and never after the session ends
+func sessionIdleExpiry(session *models.Session, now time.Time) time.Time {
+	expiry := now.Add(refreshTokenTTL())
+	if end := sessionEnd(session); end.Before(expiry) {
+		return end
+	}
+	return expiry
+}
+
+// IssueTokens starts a new login session from a device and returns its
+// access and refresh tokens
+func IssueTokens(id, role string, client models.SessionClient) (string, string, error) {
+	now := time.Now()
+	session := models.Session{
+		ID:          primitive.NewObjectID().Hex(),
+		Role:        role,
+		PrincipalID: id,
+		UserAgent:   truncate(client.UserAgent, 300),
+		IP:          client.IP,
+		CreatedAt:   now.Unix(),
+		LastSeenAt:  now.Unix(),
+	}
+	session.IdleExpiresAt = sessionIdleExpiry(&session, now)
+	if _, err := config.GetCollection("sessions").InsertOne(context.Background(), session); err != nil {
+		return "", "", err
+	}
+	return issueTokens(id, role, &session)
+}
+
+// issueTokens stores a new refresh token for the session and signs an access
+// token for it. The refresh token does not outlive the session.
+func issueTokens(id, role string, session *models.Session) (string, string, error) {
+	refresh, err := RandomToken(32)
+	if err != nil {
+		return "", "", err
+	}
+
+	now := time.Now()
+	_, err = config.GetCollection("refresh_tokens").InsertOne(context.Background(), models.RefreshToken{
+		SessionID:   session.ID,
+		Role:        role,
+		PrincipalID: id,
+		TokenHash:   HashToken(refresh),
+		ExpiresAt:   sessionIdleExpiry(session, now).Unix(),
+		CreatedAt:   now.Unix(),
+	})
+	if err != nil {
+		return "", "", err
+	}
+
+	access, err := GenerateJWT(id, role, session.ID)
+	if err != nil {
+		return "", "", err
+	}
+	return access, refresh, nil
+}
+
+// RotateRefreshToken exchanges a refresh token for a new access and refresh
+// token. Each refresh token works once; presenting one again means it was
+// stolen, so the whole session is revoked.
+func RotateRefreshToken(refresh, role string) (string, string, error) {
+	collection := config.GetCollection("refresh_tokens")
+
+	var token models.RefreshToken
+	err := collection.FindOne(context.Background(), bson.M{"tokenHash": HashToken(refresh), "role": role}).Decode(&token)
+	if err != nil {
+		return "", "", ErrInvalidToken
+	}
+	if token.Revoked || token.ExpiresAt <= time.Now().Unix() {
+		return "", "", ErrInvalidToken
+	}
+
+	result, err := collection.UpdateOne(context.Background(),
+		bson.M{"_id": token.ID, "usedAt": bson.M{"$exists": false}, "revoked": false},
+		bson.M{"$set": bson.M{"usedAt": time.Now().Unix()}},
+	)
+	if err != nil {
+		return "", "", err
+	}
+	if result.ModifiedCount == 0 {
+		if err := RevokeSession(token.SessionID, ""); err != nil {
+			return "", "", err
+		}
+		return "", "", ErrRefreshTokenReused
+	}
+
+	session, err := FindSession(token.SessionID)
+	if err == mongo.ErrNoDocuments {
+		return "", "", ErrInvalidToken
+	}
+	if err != nil {
+		return "", "", err
+	}
+	now := time.Now()
+	if session.Revoked || !now.Before(sessionEnd(session)) {
+		RevokeSession(session.ID, "")
+		return "", "", ErrInvalidToken
+	}
+	config.GetCollection("sessions").UpdateOne(context.Background(),
+		bson.M{"_id": session.ID},
+		bson.M{"$set": bson.M{"lastSeenAt": now.Unix(), "idleExpiresAt": sessionIdleExpiry(session, now)}},
+	)
+
+	return issueTokens(token.PrincipalID, role, session)
+}
+
+// SessionActive reports whether the login session of an access token has
+// not been revoked or outlived SESSION_MAX_AGE_DAYS, and records that it
+// was seen
+func SessionActive(sessionID, ip string) (bool, error) {
+	var session models.Session
+	err := config.GetCollection("sessions").FindOne(context.Background(),
+		bson.M{"_id": sessionID, "revoked": false},
+	).Decode(&session)
+	if err == mongo.ErrNoDocuments {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+
+	now := time.Now()
+	if !now.Before(sessionEnd(&session)) {
+		if err := RevokeSession(sessionID, ""); err != nil {
+			return false, err
+		}
+		return false, nil
+	}
+
+	// Last seen is kept to the minute so requests do not all write
+	if now.Unix()-session.LastSeenAt >= 60 || session.IP != ip {
+		config.GetCollection("sessions").UpdateOne(context.Background(),
+			bson.M{"_id": sessionID},
+			bson.M{"$set": bson.M{"lastSeenAt": now.Unix(), "ip": ip, "idleExpiresAt": sessionIdleExpiry(&session, now)}},
+		)
+	}
+	return true, nil
+}
+
+// ListSessions returns the active sessions of an account, most recently used first
+func ListSessions(id, role string) ([]models.Session, error) {
+	cursor, err := config.GetCollection("sessions").Find(context.Background(),
+		bson.M{"principalId": id, "role": role, "revoked": false},
+		options.Find().SetSort(bson.D{{Key: "lastSeenAt", Value: -1}}).SetLimit(100),
+	)
+	if err != nil {
+		return nil, err
+	}
+	sessions := []models.Session{}
+	if err = cursor.All(context.Background(), &sessions); err != nil {
+		return nil, err
+	}
+	return sessions, nil
+}
+
+// FindSession returns a session by id
+func FindSession(sessionID string) (*models.Session, error) {
+	var session models.Session
+	err := config.GetCollection("sessions").FindOne(context.Background(), bson.M{"_id": sessionID}).Decode(&session)
+	if err != nil {
+		return nil, err
+	}
+	return &session, nil
+}
+
+// RevokeSession logs out one login session
+func RevokeSession(sessionID, revokedBy string) error {
+	return revokeSessions(bson.M{"_id": sessionID}, revokedBy)
+}
+
+// RevokeAllSessions logs an account out everywhere, e.g. after a password change
+func RevokeAllSessions(id, role, revokedBy string) error {
+	return revokeSessions(bson.M{"principalId": id, "role": role}, revokedBy)
+}
+
+// revokeSessions revokes the matching sessions and every refresh token issued for them
+func revokeSessions(filter bson.M, revokedBy string) error {
+	filter["revoked"] = false
+	set := bson.M{"revoked": true, "revokedAt": time.Now().Unix()}
+	if revokedBy != "" {
+		set["revokedBy"] = revokedBy
+	}
+
+	refreshFilter := bson.M{"revoked": false}
+	if id, ok := filter["_id"]; ok {
+		refreshFilter["sessionId"] = id
+	} else {
+		refreshFilter["principalId"] = filter["principalId"]
+		refreshFilter["role"] = filter["role"]
+	}
+
+	if _, err := config.GetCollection("sessions").UpdateMany(context.Background(), filter, bson.M{"$set": set}); err != nil {
+		return err
+	}
+	_, err := config.GetCollection("refresh_tokens").UpdateMany(context.Background(),
+		refreshFilter,
+		bson.M{"$set": bson.M{"revoked": true}},
+	)
+	return err
+}
+
+// truncate shortens s to at most n bytes without splitting a character
+func truncate(s string, n int) string {
+	if len(s) > n {
+		return strings.ToValidUTF8(s[:n], "")
+	}
+	return s
+}
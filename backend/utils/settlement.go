@@ -0,0 +1,437 @@
+package utils
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"math"
+	"os"
+	"strconv"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"prescripto-go/config"
+	"prescripto-go/models"
+)
+
+// PlatformCommissionPercent returns the commission the platform keeps on
+// doctors without an override, falling back to COMMISSION_PERCENT
+func PlatformCommissionPercent() float64 {
+	var setting struct {
+		Percent float64 `bson:"percent"`
+	}
+	err := config.GetCollection("settings").FindOne(context.Background(), bson.M{"_id": "commission"}).Decode(&setting)
+	if err == nil {
+		return setting.Percent
+	}
+
+	percent, _ := strconv.ParseFloat(os.Getenv("COMMISSION_PERCENT"), 64)
+	return percent
+}
+
+// SetPlatformCommissionPercent stores the platform wide commission
+func SetPlatformCommissionPercent(percent float64) error {
+	_, err := config.GetCollection("settings").UpdateOne(
+		context.Background(),
+		bson.M{"_id": "commission"},
+		bson.M{"$set": bson.M{"percent": percent}},
+		options.Update().SetUpsert(true),
+	)
+	return err
+}
+
+// CommissionPercentFor returns the commission applicable to a doctor
+func CommissionPercentFor(doctor *models.Doctor) float64 {
+	if doctor.CommissionPercent != nil {
+		return *doctor.CommissionPercent
+	}
+	return PlatformCommissionPercent()
+}
+
+// GenerateSettlements creates one statement per doctor and currency for
+// appointments paid in [start, end) and refunds recorded before end that no
+// earlier statement included. Appointments and refunds are claimed with a
+// conditional update so that concurrent runs never settle the same item twice.
+func GenerateSettlements(start, end int64) ([]models.Settlement, error) {
+	ctx := context.Background()
+	if err := releaseStaleDrafts(); err != nil {
+		return nil, err
+	}
+	appointmentCollection := config.GetCollection("appointments")
+	paymentCollection := config.GetCollection("payments")
+
+	// Paid appointments not yet settled; older records have no paidAt and use the booking date
+	cursor, err := appointmentCollection.Find(ctx, bson.M{
+		"payment":      true,
+		"settlementId": bson.M{"$exists": false},
+		"$or": []bson.M{
+			{"paidAt": bson.M{"$gte": start, "$lt": end}},
+			{"paidAt": bson.M{"$exists": false}, "date": bson.M{"$gte": start, "$lt": end}},
+		},
+	})
+	if err != nil {
+		return nil, err
+	}
+	var appointments []models.Appointment
+	if err = cursor.All(ctx, &appointments); err != nil {
+		return nil, err
+	}
+
+	// Refunds not yet deducted from any statement
+	cursor, err = paymentCollection.Find(ctx, bson.M{
+		"type":         models.PaymentTypeRefund,
+		"status":       models.PaymentStatusRefunded,
+		"settlementId": bson.M{"$exists": false},
+		"createdAt":    bson.M{"$lt": end},
+	})
+	if err != nil {
+		return nil, err
+	}
+	var refunds []models.PaymentTransaction
+	if err = cursor.All(ctx, &refunds); err != nil {
+		return nil, err
+	}
+
+	// Group the candidate appointment and refund ids by doctor and currency,
+	// since a doctor's fee currency may have changed between bookings
+	appointmentIDs := make(map[settlementKey][]primitive.ObjectID)
+	for _, appointment := range appointments {
+		if _, err := appointment.Total(); err != nil {
+			// Left unsettled until the record is corrected
+			log.Printf("Skipping appointment %s in settlement: %v", appointment.ID.Hex(), err)
+			continue
+		}
+		key := settlementKey{appointment.DocID, appointment.Amount.Currency}
+		appointmentIDs[key] = append(appointmentIDs[key], appointment.ID)
+	}
+
+	refundDoctors, err := doctorsOfAppointments(refunds)
+	if err != nil {
+		return nil, err
+	}
+	refundIDs := make(map[settlementKey][]primitive.ObjectID)
+	for _, refund := range refunds {
+		if docID, ok := refundDoctors[refund.AppointmentID]; ok {
+			key := settlementKey{docID, refund.Amount.Currency}
+			refundIDs[key] = append(refundIDs[key], refund.ID)
+		}
+	}
+
+	keys := make(map[settlementKey]bool)
+	for key := range appointmentIDs {
+		keys[key] = true
+	}
+	for key := range refundIDs {
+		keys[key] = true
+	}
+
+	var settlements []models.Settlement
+	for key := range keys {
+		settlement, err := settleDoctor(key, start, end, appointmentIDs[key], refundIDs[key])
+		if err != nil {
+			return settlements, err
+		}
+		if settlement != nil {
+			settlements = append(settlements, *settlement)
+		}
+	}
+
+	return settlements, nil
+}
+
+// doctorsOfAppointments maps the appointment ids of ledger entries to their doctor ids
+func doctorsOfAppointments(txs []models.PaymentTransaction) (map[string]string, error) {
+	appointments, err := appointmentsOf(txs)
+	if err != nil {
+		return nil, err
+	}
+	doctors := make(map[string]string)
+	for id, appointment := range appointments {
+		doctors[id] = appointment.DocID
+	}
+	return doctors, nil
+}
+
+// appointmentsOf maps the appointment ids of ledger entries to their appointments
+func appointmentsOf(txs []models.PaymentTransaction) (map[string]models.Appointment, error) {
+	appointments := make(map[string]models.Appointment)
+	if len(txs) == 0 {
+		return appointments, nil
+	}
+
+	ids := make([]primitive.ObjectID, 0, len(txs))
+	for _, tx := range txs {
+		if id, err := primitive.ObjectIDFromHex(tx.AppointmentID); err == nil {
+			ids = append(ids, id)
+		}
+	}
+
+	cursor, err := config.GetCollection("appointments").Find(context.Background(), bson.M{"_id": bson.M{"$in": ids}})
+	if err != nil {
+		return nil, err
+	}
+	var found []models.Appointment
+	if err = cursor.All(context.Background(), &found); err != nil {
+		return nil, err
+	}
+	for _, appointment := range found {
+		appointments[appointment.ID.Hex()] = appointment
+	}
+	return appointments, nil
+}
+
+// settlementKey identifies the statement an item goes into
+type settlementKey struct {
+	docID    string
+	currency string
+}
+
+// settleDoctor claims the given appointments and refunds for a new statement
+// and stores it. The statement is inserted as a draft before anything is
+// claimed, so every claim belongs to a stored statement; when building it
+// fails the claims are released and the draft removed.
+func settleDoctor(key settlementKey, start, end int64, appointmentIDs, refundIDs []primitive.ObjectID) (*models.Settlement, error) {
+	ctx := context.Background()
+
+	docObjectID, _ := primitive.ObjectIDFromHex(key.docID)
+	var doctor models.Doctor
+	if err := config.GetCollection("doctors").FindOne(ctx, bson.M{"_id": docObjectID}).Decode(&doctor); err != nil {
+		return nil, err
+	}
+
+	settlement := &models.Settlement{
+		ID:                primitive.NewObjectID(),
+		DocID:             key.docID,
+		DoctorName:        doctor.Name,
+		PeriodStart:       start,
+		PeriodEnd:         end,
+		CommissionPercent: CommissionPercentFor(&doctor),
+		Gross:             models.NewMoney(0, key.currency),
+		Commission:        models.NewMoney(0, key.currency),
+		Refunds:           models.NewMoney(0, key.currency),
+		Status:            models.SettlementStatusDraft,
+		CreatedAt:         time.Now().Unix(),
+	}
+	settlements := config.GetCollection("settlements")
+	if _, err := settlements.InsertOne(ctx, settlement); err != nil {
+		return nil, err
+	}
+
+	claimed, err := claimForSettlement(settlement, appointmentIDs, refundIDs)
+	if err == nil && claimed {
+		settlement.Status = models.SettlementStatusPending
+		var result *mongo.UpdateResult
+		result, err = settlements.ReplaceOne(ctx, bson.M{"_id": settlement.ID, "status": models.SettlementStatusDraft}, settlement)
+		if err == nil && result.MatchedCount == 0 {
+			// Released as stale by another run while this one was building it
+			err = fmt.Errorf("settlement %s was released before it was stored", settlement.ID.Hex())
+		}
+	}
+	if err != nil || !claimed {
+		if releaseErr := releaseSettlement(settlement.ID); releaseErr != nil {
+			log.Printf("Failed to release settlement %s: %v", settlement.ID.Hex(), releaseErr)
+		}
+		return nil, err
+	}
+	return settlement, nil
+}
+
+// claimForSettlement tags the items with the statement and fills it in from
+// what was actually claimed. It reports false when another run claimed them all.
+func claimForSettlement(settlement *models.Settlement, appointmentIDs, refundIDs []primitive.ObjectID) (bool, error) {
+	ctx := context.Background()
+	settlementID := settlement.ID.Hex()
+	appointmentCollection := config.GetCollection("appointments")
+	paymentCollection := config.GetCollection("payments")
+
+	claim := bson.M{"$set": bson.M{"settlementId": settlementID}}
+	if len(appointmentIDs) > 0 {
+		_, err := appointmentCollection.UpdateMany(ctx, bson.M{"_id": bson.M{"$in": appointmentIDs}, "settlementId": bson.M{"$exists": false}}, claim)
+		if err != nil {
+			return false, err
+		}
+	}
+	if len(refundIDs) > 0 {
+		_, err := paymentCollection.UpdateMany(ctx, bson.M{"_id": bson.M{"$in": refundIDs}, "settlementId": bson.M{"$exists": false}}, claim)
+		if err != nil {
+			return false, err
+		}
+	}
+
+	// Only what this run actually claimed goes into the statement
+	cursor, err := appointmentCollection.Find(ctx, bson.M{"settlementId": settlementID})
+	if err != nil {
+		return false, err
+	}
+	var appointments []models.Appointment
+	if err = cursor.All(ctx, &appointments); err != nil {
+		return false, err
+	}
+
+	cursor, err = paymentCollection.Find(ctx, bson.M{"settlementId": settlementID})
+	if err != nil {
+		return false, err
+	}
+	var refunds []models.PaymentTransaction
+	if err = cursor.All(ctx, &refunds); err != nil {
+		return false, err
+	}
+
+	if len(appointments) == 0 && len(refunds) == 0 {
+		return false, nil
+	}
+
+	percent := settlement.CommissionPercent
+	for _, appointment := range appointments {
+		// The platform remits the tax it collects, so doctors are settled on the fee net of tax
+		gross, err := settlementGross(&appointment)
+		if err != nil {
+			return false, err
+		}
+		commission := gross.Percent(percent)
+		settlement.Lines = append(settlement.Lines, models.SettlementLine{
+			AppointmentID: appointment.ID.Hex(),
+			SlotDate:      appointment.SlotDate,
+			PatientName:   appointment.UserData.Name,
+			Gross:         gross,
+			Commission:    commission,
+		})
+		if settlement.Gross, err = settlement.Gross.Add(gross); err != nil {
+			return false, err
+		}
+		if settlement.Commission, err = settlement.Commission.Add(commission); err != nil {
+			return false, err
+		}
+	}
+
+	// A refund takes back the doctor's share of what was refunded, not the
+	// tax in it, and gives back the commission kept on that share
+	refunded, err := appointmentsOf(refunds)
+	if err != nil {
+		return false, err
+	}
+	for _, refund := range refunds {
+		appointment, ok := refunded[refund.AppointmentID]
+		if !ok {
+			return false, fmt.Errorf("appointment %s of refund %s not found", refund.AppointmentID, refund.ID.Hex())
+		}
+		line, err := refundLine(&refund, &appointment, settlementID, percent)
+		if err != nil {
+			return false, err
+		}
+		settlement.Lines = append(settlement.Lines, *line)
+		if settlement.Refunds, err = settlement.Refunds.Add(line.Deduction); err != nil {
+			return false, err
+		}
+	}
+	net, err := settlement.Gross.Sub(settlement.Commission)
+	if err != nil {
+		return false, err
+	}
+	if settlement.NetPayable, err = net.Sub(settlement.Refunds); err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// releaseSettlement gives the items claimed by a draft statement back to the
+// next run and removes the draft
+func releaseSettlement(id primitive.ObjectID) error {
+	ctx := context.Background()
+	release := bson.M{"$unset": bson.M{"settlementId": ""}}
+	if _, err := config.GetCollection("appointments").UpdateMany(ctx, bson.M{"settlementId": id.Hex()}, release); err != nil {
+		return err
+	}
+	if _, err := config.GetCollection("payments").UpdateMany(ctx, bson.M{"settlementId": id.Hex()}, release); err != nil {
+		return err
+	}
+	_, err := config.GetCollection("settlements").DeleteOne(ctx, bson.M{"_id": id, "status": models.SettlementStatusDraft})
+	return err
+}
+
+// releaseStaleDrafts releases the drafts left behind by runs that stopped
+// before storing their statement
+func releaseStaleDrafts() error {
+	ctx := context.Background()
+	cutoff := time.Now().Add(-time.Duration(envInt("SETTLEMENT_DRAFT_TIMEOUT", 600)) * time.Second).Unix()
+	cursor, err := config.GetCollection("settlements").Find(ctx, bson.M{
+		"status":    models.SettlementStatusDraft,
+		"createdAt": bson.M{"$lt": cutoff},
+	})
+	if err != nil {
+		return err
+	}
+	var drafts []models.Settlement
+	if err = cursor.All(ctx, &drafts); err != nil {
+		return err
+	}
+	for _, draft := range drafts {
+		if err := releaseSettlement(draft.ID); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// settlementGross returns the fee of an appointment after discounts and net of tax
+func settlementGross(appointment *models.Appointment) (models.Money, error) {
+	total, err := appointment.Total()
+	if err != nil {
+		return models.Money{}, err
+	}
+	tax, err := appointment.TaxTotal()
+	if err != nil {
+		return models.Money{}, err
+	}
+	return total.Sub(tax)
+}
+
+// refundLine works out what a refund deducts from a doctor's payout: the
+// refunded share of the fee net of tax, less the commission the platform kept
+// on that share at the rate of the statement that paid the appointment
+func refundLine(refund *models.PaymentTransaction, appointment *models.Appointment, settlementID string, percent float64) (*models.SettlementLine, error) {
+	total, err := appointment.Total()
+	if err != nil {
+		return nil, err
+	}
+	gross, err := settlementGross(appointment)
+	if err != nil {
+		return nil, err
+	}
+	if refund.Amount.Currency != total.Currency {
+		return nil, fmt.Errorf("%w: refund %s of %s", models.ErrCurrencyMismatch, refund.ID.Hex(), appointment.ID.Hex())
+	}
+
+	if appointment.SettlementID != "" && appointment.SettlementID != settlementID {
+		settlementObjectID, _ := primitive.ObjectIDFromHex(appointment.SettlementID)
+		var paidIn models.Settlement
+		err := config.GetCollection("settlements").FindOne(context.Background(), bson.M{"_id": settlementObjectID}).Decode(&paidIn)
+		if err == nil {
+			percent = paidIn.CommissionPercent
+		}
+	}
+
+	share := 0.0
+	if total.Amount > 0 {
+		share = math.Min(float64(refund.Amount.Amount)/float64(total.Amount), 1)
+	}
+	fee := models.NewMoney(int64(math.Round(float64(gross.Amount)*share)), gross.Currency)
+	commission := fee.Percent(percent)
+	deduction, err := fee.Sub(commission)
+	if err != nil {
+		return nil, err
+	}
+
+	return &models.SettlementLine{
+		AppointmentID:      refund.AppointmentID,
+		SlotDate:           appointment.SlotDate,
+		PatientName:        appointment.UserData.Name,
+		Refund:             refund.Amount,
+		RefundedFee:        fee,
+		CommissionReversed: commission,
+		Deduction:          deduction,
+	}, nil
+}
@@ -0,0 +1,46 @@
+package utils
+
+import (
+	"testing"
+
+	"prescripto-go/models"
+)
+
+func TestRefundLine(t *testing.T) {
+	inr := func(amount int64) models.Money { return models.NewMoney(amount, "INR") }
+	appointment := &models.Appointment{
+		Amount: inr(100000),
+		Tax:    []models.TaxLine{{Name: "GST", Rate: 18, Amount: inr(18000)}},
+	}
+
+	tests := []struct {
+		name       string
+		refund     models.Money
+		fee        models.Money
+		commission models.Money
+		deduction  models.Money
+	}{
+		// The tax is not the doctor's to repay and the commission on the fee is given back
+		{"full refund", inr(118000), inr(100000), inr(10000), inr(90000)},
+		{"half refund", inr(59000), inr(50000), inr(5000), inr(45000)},
+		{"refund above the total", inr(200000), inr(100000), inr(10000), inr(90000)},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			refund := &models.PaymentTransaction{AppointmentID: "appointment", Amount: tt.refund}
+			line, err := refundLine(refund, appointment, "settlement", 10)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if line.Refund != tt.refund || line.RefundedFee != tt.fee ||
+				line.CommissionReversed != tt.commission || line.Deduction != tt.deduction {
+				t.Fatalf("got %+v", line)
+			}
+		})
+	}
+
+	refund := &models.PaymentTransaction{AppointmentID: "appointment", Amount: models.NewMoney(100, "USD")}
+	if _, err := refundLine(refund, appointment, "settlement", 10); err == nil {
+		t.Fatal("refund in another currency accepted")
+	}
+}
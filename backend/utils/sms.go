@@ -0,0 +1,104 @@
+package utils
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// SMSSender delivers text messages. SMS_DRIVER selects the implementation:
+// "http" posts to an SMS gateway at SMS_GATEWAY_URL, anything else writes
+// the messages to SMS_DIR so they can be read during development.
+type SMSSender interface {
+	SendSMS(to, body string) error
+}
+
+var (
+	smsSender     SMSSender
+	smsSenderOnce sync.Once
+)
+
+// GetSMSSender returns the configured SMS sender
+func GetSMSSender() SMSSender {
+	smsSenderOnce.Do(func() {
+		if os.Getenv("SMS_DRIVER") == "http" {
+			smsSender = &HTTPSMSSender{
+				URL:    os.Getenv("SMS_GATEWAY_URL"),
+				APIKey: os.Getenv("SMS_GATEWAY_KEY"),
+				From:   os.Getenv("SMS_FROM"),
+				client: &http.Client{Timeout: 10 * time.Second},
+			}
+			return
+		}
+
+		dir := os.Getenv("SMS_DIR")
+		if dir == "" {
+			dir = "sms"
+		}
+		smsSender = &FileSMSSender{Dir: dir}
+	})
+	return smsSender
+}
+
+// SendSMS sends a text message through the configured sender
+func SendSMS(to, body string) error {
+	return GetSMSSender().SendSMS(to, body)
+}
+
+// HTTPSMSSender posts {from, to, body} as JSON to an SMS gateway
+type HTTPSMSSender struct {
+	URL    string
+	APIKey string
+	From   string
+	client *http.Client
+}
+
+func (s *HTTPSMSSender) SendSMS(to, body string) error {
+	payload, err := json.Marshal(map[string]string{"from": s.From, "to": to, "body": body})
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequest(http.MethodPost, s.URL, bytes.NewReader(payload))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if s.APIKey != "" {
+		req.Header.Set("Authorization", "Bearer "+s.APIKey)
+	}
+
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		return fmt.Errorf("SMS gateway returned %s", resp.Status)
+	}
+	return nil
+}
+
+// FileSMSSender writes each message to a .txt file instead of sending it
+type FileSMSSender struct {
+	Dir string
+}
+
+func (s *FileSMSSender) SendSMS(to, body string) error {
+	if err := os.MkdirAll(s.Dir, 0o755); err != nil {
+		return err
+	}
+
+	suffix, err := RandomToken(4)
+	if err != nil {
+		return err
+	}
+	name := fmt.Sprintf("%d-%s.txt", time.Now().UnixNano(), suffix)
+	content := fmt.Sprintf("To: %s\nDate: %s\n\n%s\n", to, time.Now().Format(time.RFC1123Z), body)
+	return os.WriteFile(filepath.Join(s.Dir, name), []byte(content), 0o600)
+}
@@ -0,0 +1,81 @@
+package utils
+
+import (
+	"context"
+	"os"
+	"strings"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"prescripto-go/config"
+	"prescripto-go/models"
+)
+
+// TaxJurisdictionFor returns the jurisdiction whose tax rules apply to a
+// doctor's fees, falling back to TAX_JURISDICTION
+func TaxJurisdictionFor(doctor *models.Doctor) string {
+	if doctor.TaxJurisdiction != "" {
+		return doctor.TaxJurisdiction
+	}
+	return strings.ToUpper(os.Getenv("TAX_JURISDICTION"))
+}
+
+// MatchTaxRules returns the active rules for a jurisdiction and service type.
+// Only the most specific rules apply: an exact jurisdiction beats a catch-all
+// one and then an exact service type beats a catch-all one. Every rule at that
+// level applies, so a jurisdiction can levy several taxes.
+func MatchTaxRules(jurisdiction, serviceType string) ([]models.TaxRule, error) {
+	cursor, err := config.GetCollection("tax_rules").Find(context.Background(), bson.M{
+		"active":       true,
+		"jurisdiction": bson.M{"$in": []string{jurisdiction, ""}},
+		"serviceType":  bson.M{"$in": []string{serviceType, ""}},
+	})
+	if err != nil {
+		return nil, err
+	}
+	var rules []models.TaxRule
+	if err = cursor.All(context.Background(), &rules); err != nil {
+		return nil, err
+	}
+
+	specificity := func(rule models.TaxRule) int {
+		score := 0
+		if rule.Jurisdiction != "" {
+			score += 2
+		}
+		if rule.ServiceType != "" {
+			score++
+		}
+		return score
+	}
+
+	best := -1
+	var matched []models.TaxRule
+	for _, rule := range rules {
+		switch score := specificity(rule); {
+		case score > best:
+			best = score
+			matched = []models.TaxRule{rule}
+		case score == best:
+			matched = append(matched, rule)
+		}
+	}
+	return matched, nil
+}
+
+// AppointmentTax returns the tax lines for a price charged by a doctor
+func AppointmentTax(doctor *models.Doctor, serviceType string, price models.Money) ([]models.TaxLine, error) {
+	rules, err := MatchTaxRules(TaxJurisdictionFor(doctor), serviceType)
+	if err != nil {
+		return nil, err
+	}
+
+	var lines []models.TaxLine
+	for _, rule := range rules {
+		lines = append(lines, models.TaxLine{
+			Name:      rule.Name,
+			Rate:      rule.Rate,
+			Inclusive: rule.Inclusive,
+		})
+	}
+	return models.ComputeTaxLines(price, lines), nil
+}
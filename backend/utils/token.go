@@ -0,0 +1,106 @@
+package utils
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/dgrijalva/jwt-go"
+)
+
+// Token roles. A token is only accepted by the middleware of its own role.
+const (
+	RoleUser   = "user"
+	RoleDoctor = "doctor"
+	RoleAdmin  = "admin"
+)
+
+var ErrInvalidToken = errors.New("Invalid token")
+
+// TokenClaims are the verified claims of an access token
+type TokenClaims struct {
+	ID        string
+	Role      string
+	SessionID string
+}
+
+// tokenIssuer identifies this API as the issuer of its tokens
+func tokenIssuer() string {
+	if issuer := os.Getenv("JWT_ISSUER"); issuer != "" {
+		return issuer
+	}
+	return "prescripto"
+}
+
+// accessTokenTTL is short so a stolen token is only useful briefly;
+// clients renew it with their refresh token
+func accessTokenTTL() time.Duration {
+	return time.Duration(envInt("ACCESS_TOKEN_MINUTES", 15)) * time.Minute
+}
+
+// GenerateJWT generates an access token for the principal id that only the
+// given role accepts, bound to the login session it was issued for
+func GenerateJWT(id, role, sessionID string) (string, error) {
+	return signToken(jwt.MapClaims{
+		"id":   id,
+		"sub":  id,
+		"role": role,
+		"sid":  sessionID,
+		"aud":  role,
+	}, accessTokenTTL())
+}
+
+// ParseJWT verifies an access token issued for the given role.
+// Tokens signed with another algorithm, by another issuer or for another role are refused.
+func ParseJWT(tokenString, role string) (*TokenClaims, error) {
+	claims, err := parseToken(tokenString, role)
+	if err != nil || claims["role"] != role {
+		return nil, ErrInvalidToken
+	}
+
+	id, _ := claims["id"].(string)
+	sessionID, _ := claims["sid"].(string)
+	if id == "" || sessionID == "" {
+		return nil, ErrInvalidToken
+	}
+	return &TokenClaims{ID: id, Role: role, SessionID: sessionID}, nil
+}
+
+// signToken signs claims with the active RS256 key, valid for ttl. The kid
+// header names the key so verifiers can pick it from the JWKS.
+func signToken(claims jwt.MapClaims, ttl time.Duration) (string, error) {
+	kid, key, err := activeSigningKey()
+	if err != nil {
+		return "", err
+	}
+
+	now := time.Now()
+	claims["iss"] = tokenIssuer()
+	claims["iat"] = now.Unix()
+	claims["exp"] = now.Add(ttl).Unix()
+
+	token := jwt.NewWithClaims(jwt.SigningMethodRS256, claims)
+	token.Header["kid"] = kid
+	return token.SignedString(key)
+}
+
+// parseToken verifies the signature, issuer and audience of a token and returns its claims
+func parseToken(tokenString, audience string) (jwt.MapClaims, error) {
+	claims := jwt.MapClaims{}
+	parsed, err := jwt.ParseWithClaims(tokenString, claims, func(token *jwt.Token) (interface{}, error) {
+		if token.Method != jwt.SigningMethodRS256 {
+			return nil, fmt.Errorf("unexpected signing method %v", token.Header["alg"])
+		}
+		kid, _ := token.Header["kid"].(string)
+		return verificationKey(kid)
+	})
+	if err != nil || !parsed.Valid {
+		return nil, ErrInvalidToken
+	}
+
+	if !claims.VerifyIssuer(tokenIssuer(), true) || !claims.VerifyAudience(audience, true) {
+		return nil, ErrInvalidToken
+	}
+	return claims, nil
+}
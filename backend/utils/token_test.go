@@ -0,0 +1,173 @@
+package utils
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"testing"
+	"time"
+
+	"github.com/dgrijalva/jwt-go"
+)
+
+const testKid = "test-kid"
+
+func newTestKey(t *testing.T) *rsa.PrivateKey {
+	t.Helper()
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return key
+}
+
+// testToken signs claims for a principal of role, letting a case change the
+// claims, the signing method, the key and the kid
+func testToken(t *testing.T, key *rsa.PrivateKey, role string, edit func(jwt.MapClaims)) string {
+	t.Helper()
+	claims := jwt.MapClaims{
+		"id":   "principal",
+		"sub":  "principal",
+		"role": role,
+		"sid":  "session",
+		"aud":  role,
+		"iss":  tokenIssuer(),
+		"iat":  time.Now().Unix(),
+		"exp":  time.Now().Add(time.Minute).Unix(),
+	}
+	if edit != nil {
+		edit(claims)
+	}
+	token := jwt.NewWithClaims(jwt.SigningMethodRS256, claims)
+	token.Header["kid"] = testKid
+	signed, err := token.SignedString(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return signed
+}
+
+func TestParseJWT(t *testing.T) {
+	key := newTestKey(t)
+	otherKey := newTestKey(t)
+
+	issued := func(role string) func(t *testing.T) string {
+		return func(t *testing.T) string {
+			token, err := GenerateJWT("principal", role, "session")
+			if err != nil {
+				t.Fatal(err)
+			}
+			return token
+		}
+	}
+
+	tests := []struct {
+		name  string
+		role  string
+		token func(t *testing.T) string
+		valid bool
+	}{
+		{"user token for user", RoleUser, issued(RoleUser), true},
+		{"doctor token for doctor", RoleDoctor, issued(RoleDoctor), true},
+		{"admin token for admin", RoleAdmin, issued(RoleAdmin), true},
+		{"user token for doctor", RoleDoctor, issued(RoleUser), false},
+		{"user token for admin", RoleAdmin, issued(RoleUser), false},
+		{"doctor token for user", RoleUser, issued(RoleDoctor), false},
+		{"doctor token for admin", RoleAdmin, issued(RoleDoctor), false},
+		{"admin token for user", RoleUser, issued(RoleAdmin), false},
+		{"admin token for doctor", RoleDoctor, issued(RoleAdmin), false},
+		{"wrong audience", RoleUser, func(t *testing.T) string {
+			return testToken(t, key, RoleUser, func(c jwt.MapClaims) { c["aud"] = RoleAdmin })
+		}, false},
+		{"role claim differs from audience", RoleAdmin, func(t *testing.T) string {
+			return testToken(t, key, RoleUser, func(c jwt.MapClaims) { c["aud"] = RoleAdmin })
+		}, false},
+		{"wrong issuer", RoleUser, func(t *testing.T) string {
+			return testToken(t, key, RoleUser, func(c jwt.MapClaims) { c["iss"] = "someone-else" })
+		}, false},
+		{"missing issuer", RoleUser, func(t *testing.T) string {
+			return testToken(t, key, RoleUser, func(c jwt.MapClaims) { delete(c, "iss") })
+		}, false},
+		{"expired", RoleUser, func(t *testing.T) string {
+			return testToken(t, key, RoleUser, func(c jwt.MapClaims) { c["exp"] = time.Now().Add(-time.Minute).Unix() })
+		}, false},
+		{"missing session", RoleUser, func(t *testing.T) string {
+			return testToken(t, key, RoleUser, func(c jwt.MapClaims) { delete(c, "sid") })
+		}, false},
+		{"unknown kid", RoleUser, func(t *testing.T) string {
+			token := jwt.NewWithClaims(jwt.SigningMethodRS256, jwt.MapClaims{
+				"id": "principal", "role": RoleUser, "sid": "session", "aud": RoleUser,
+				"iss": tokenIssuer(), "exp": time.Now().Add(time.Minute).Unix(),
+			})
+			token.Header["kid"] = "unknown-kid"
+			signed, _ := token.SignedString(otherKey)
+			return signed
+		}, false},
+		{"signed by another key", RoleUser, func(t *testing.T) string {
+			return testToken(t, otherKey, RoleUser, nil)
+		}, false},
+		{"HS256", RoleUser, func(t *testing.T) string {
+			token := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.MapClaims{
+				"id": "principal", "role": RoleUser, "sid": "session", "aud": RoleUser,
+				"iss": tokenIssuer(), "exp": time.Now().Add(time.Minute).Unix(),
+			})
+			token.Header["kid"] = testKid
+			signed, err := token.SignedString([]byte("secret"))
+			if err != nil {
+				t.Fatal(err)
+			}
+			return signed
+		}, false},
+		{"HS256 keyed with the public key", RoleUser, func(t *testing.T) string {
+			token := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.MapClaims{
+				"id": "principal", "role": RoleUser, "sid": "session", "aud": RoleUser,
+				"iss": tokenIssuer(), "exp": time.Now().Add(time.Minute).Unix(),
+			})
+			token.Header["kid"] = testKid
+			signed, err := token.SignedString(key.PublicKey.N.Bytes())
+			if err != nil {
+				t.Fatal(err)
+			}
+			return signed
+		}, false},
+		{"not a token", RoleUser, func(t *testing.T) string { return "not-a-token" }, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// A fresh keyring keeps an unknown kid from reloading from the database
+			UseSigningKey(testKid, key)
+
+			claims, err := ParseJWT(tt.token(t), tt.role)
+			if !tt.valid {
+				if err != ErrInvalidToken {
+					t.Fatalf("ParseJWT accepted the token, got %+v, %v", claims, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("ParseJWT: %v", err)
+			}
+			if claims.ID != "principal" || claims.Role != tt.role || claims.SessionID != "session" {
+				t.Fatalf("unexpected claims %+v", claims)
+			}
+		})
+	}
+}
+
+func TestParseJWTIssuerFromEnvironment(t *testing.T) {
+	UseSigningKey(testKid, newTestKey(t))
+
+	t.Setenv("JWT_ISSUER", "hosplify-staging")
+	token, err := GenerateJWT("principal", RoleUser, "session")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := ParseJWT(token, RoleUser); err != nil {
+		t.Fatalf("token of the configured issuer refused: %v", err)
+	}
+
+	t.Setenv("JWT_ISSUER", "hosplify-production")
+	if _, err := ParseJWT(token, RoleUser); err != ErrInvalidToken {
+		t.Fatalf("token of another issuer accepted: %v", err)
+	}
+}
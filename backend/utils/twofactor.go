@@ -0,0 +1,223 @@
+package utils
+
+import (
+	"context"
+	"errors"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/dgrijalva/jwt-go"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"prescripto-go/config"
+	"prescripto-go/models"
+)
+
+// Purposes of a login challenge token
+const (
+	ChallengeVerify = "verify"
+	ChallengeEnroll = "enroll"
+)
+
+// challengeAudience keeps challenge tokens from being used as access tokens
+const challengeAudience = "2fa-challenge"
+
+// recoveryCodeCount is how many recovery codes are issued at enrollment
+const recoveryCodeCount = 10
+
+var (
+	ErrTwoFactorEnabled    = errors.New("Two-factor authentication is already enabled")
+	ErrTwoFactorNotStarted = errors.New("Two-factor setup has not been started")
+	ErrInvalidTwoFactor    = errors.New("Invalid authentication code")
+)
+
+// TwoFactorRequired reports whether policy makes 2FA mandatory for a role,
+// set with REQUIRE_2FA_ADMINS and REQUIRE_2FA_DOCTORS
+func TwoFactorRequired(role string) bool {
+	switch role {
+	case RoleAdmin:
+		return os.Getenv("REQUIRE_2FA_ADMINS") == "true"
+	case RoleDoctor:
+		return os.Getenv("REQUIRE_2FA_DOCTORS") == "true"
+	}
+	return false
+}
+
+// TwoFactorEnabled reports whether an account has a confirmed second factor
+func TwoFactorEnabled(role, id string) (bool, error) {
+	count, err := config.GetCollection("two_factor").CountDocuments(context.Background(),
+		bson.M{"role": role, "principalId": id, "enabled": true},
+	)
+	return count > 0, err
+}
+
+// IssueLoginChallenge signs a short-lived token proving the password step
+// of a login succeeded
+func IssueLoginChallenge(id, role, purpose string) (string, error) {
+	return signToken(jwt.MapClaims{
+		"id":      id,
+		"role":    role,
+		"purpose": purpose,
+		"aud":     challengeAudience,
+	}, time.Duration(envInt("LOGIN_CHALLENGE_MINUTES", 5))*time.Minute)
+}
+
+// ParseLoginChallenge verifies a challenge token and returns its account id and role
+func ParseLoginChallenge(token, purpose string) (string, string, error) {
+	claims, err := parseToken(token, challengeAudience)
+	if err != nil || claims["purpose"] != purpose {
+		return "", "", ErrInvalidToken
+	}
+
+	id, _ := claims["id"].(string)
+	role, _ := claims["role"].(string)
+	if id == "" || (role != RoleAdmin && role != RoleDoctor) {
+		return "", "", ErrInvalidToken
+	}
+	return id, role, nil
+}
+
+// StartTwoFactorEnrollment creates a new secret for an account. Starting
+// again before confirming replaces the secret.
+func StartTwoFactorEnrollment(role, id, account string) (*models.TwoFactorSetup, error) {
+	enabled, err := TwoFactorEnabled(role, id)
+	if err != nil {
+		return nil, err
+	}
+	if enabled {
+		return nil, ErrTwoFactorEnabled
+	}
+
+	secret, err := GenerateTOTPSecret()
+	if err != nil {
+		return nil, err
+	}
+
+	_, err = config.GetCollection("two_factor").UpdateOne(context.Background(),
+		bson.M{"role": role, "principalId": id, "enabled": false},
+		bson.M{
+			"$set":         bson.M{"secret": secret, "recoveryCodes": []string{}, "lastUsedStep": 0},
+			"$setOnInsert": bson.M{"createdAt": time.Now().Unix()},
+		},
+		options.Update().SetUpsert(true),
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	return &models.TwoFactorSetup{
+		Secret:          secret,
+		ProvisioningURI: TOTPProvisioningURI(secret, account),
+	}, nil
+}
+
+// ConfirmTwoFactor enables 2FA once the user proves their app produces
+// valid codes, and returns the recovery codes. They are only shown once.
+func ConfirmTwoFactor(role, id, code string) ([]string, error) {
+	collection := config.GetCollection("two_factor")
+
+	var twoFactor models.TwoFactor
+	err := collection.FindOne(context.Background(), bson.M{"role": role, "principalId": id}).Decode(&twoFactor)
+	if err == mongo.ErrNoDocuments {
+		return nil, ErrTwoFactorNotStarted
+	}
+	if err != nil {
+		return nil, err
+	}
+	if twoFactor.Enabled {
+		return nil, ErrTwoFactorEnabled
+	}
+
+	step, ok := VerifyTOTP(twoFactor.Secret, code, time.Now())
+	if !ok {
+		return nil, ErrInvalidTwoFactor
+	}
+
+	codes := make([]string, recoveryCodeCount)
+	hashes := make([]string, recoveryCodeCount)
+	for i := range codes {
+		raw, err := RandomToken(5)
+		if err != nil {
+			return nil, err
+		}
+		codes[i] = raw[:5] + "-" + raw[5:]
+		hashes[i] = hashRecoveryCode(codes[i])
+	}
+
+	result, err := collection.UpdateOne(context.Background(),
+		bson.M{"_id": twoFactor.ID, "enabled": false, "secret": twoFactor.Secret},
+		bson.M{"$set": bson.M{
+			"enabled":       true,
+			"recoveryCodes": hashes,
+			"lastUsedStep":  step,
+			"enabledAt":     time.Now().Unix(),
+		}},
+	)
+	if err != nil {
+		return nil, err
+	}
+	if result.ModifiedCount == 0 {
+		return nil, ErrTwoFactorNotStarted
+	}
+	return codes, nil
+}
+
+// VerifySecondFactor checks an authenticator code or an unused recovery code.
+// Each code is accepted once.
+func VerifySecondFactor(role, id, code string) error {
+	collection := config.GetCollection("two_factor")
+
+	var twoFactor models.TwoFactor
+	err := collection.FindOne(context.Background(), bson.M{"role": role, "principalId": id, "enabled": true}).Decode(&twoFactor)
+	if err == mongo.ErrNoDocuments {
+		return ErrInvalidTwoFactor
+	}
+	if err != nil {
+		return err
+	}
+
+	if step, ok := VerifyTOTP(twoFactor.Secret, code, time.Now()); ok {
+		result, err := collection.UpdateOne(context.Background(),
+			bson.M{"_id": twoFactor.ID, "lastUsedStep": bson.M{"$lt": step}},
+			bson.M{"$set": bson.M{"lastUsedStep": step}},
+		)
+		if err != nil {
+			return err
+		}
+		if result.ModifiedCount == 0 {
+			return ErrInvalidTwoFactor
+		}
+		return nil
+	}
+
+	hash := hashRecoveryCode(code)
+	result, err := collection.UpdateOne(context.Background(),
+		bson.M{"_id": twoFactor.ID, "recoveryCodes": hash},
+		bson.M{"$pull": bson.M{"recoveryCodes": hash}},
+	)
+	if err != nil {
+		return err
+	}
+	if result.ModifiedCount == 0 {
+		return ErrInvalidTwoFactor
+	}
+	return nil
+}
+
+// RemoveTwoFactor deletes the second factor of an account
+func RemoveTwoFactor(role, id string) (bool, error) {
+	result, err := config.GetCollection("two_factor").DeleteMany(context.Background(),
+		bson.M{"role": role, "principalId": id},
+	)
+	if err != nil {
+		return false, err
+	}
+	return result.DeletedCount > 0, nil
+}
+
+// hashRecoveryCode ignores case and the dash so codes can be typed loosely
+func hashRecoveryCode(code string) string {
+	return HashToken(strings.ReplaceAll(strings.ToLower(strings.TrimSpace(code)), "-", ""))
+}
@@ -0,0 +1,153 @@
+package utils
+
+import (
+	"context"
+	"errors"
+	"strings"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"prescripto-go/config"
+	"prescripto-go/models"
+)
+
+// ErrPartnerNotAllowed is returned when a partner books for an existing
+// patient who has not allowed it to
+var ErrPartnerNotAllowed = errors.New("The patient has not allowed bookings through this partner")
+
+// FindOrCreateUser returns the id of the user with the email, creating one
+// when there is none. Created users get an unguessable password and can set
+// their own with a reset link. verified marks the email as confirmed by
+// whoever vouches for it.
+func FindOrCreateUser(email, name string, verified bool) (string, error) {
+	users := config.GetCollection("users")
+	email = NormalizeEmail(email)
+
+	var user models.User
+	err := users.FindOne(context.Background(), bson.M{"email": email}).Decode(&user)
+	if err == nil {
+		if verified && !user.EmailVerified {
+			users.UpdateOne(context.Background(), bson.M{"_id": user.ID}, bson.M{"$set": bson.M{"emailVerified": true}})
+		}
+		return user.ID.Hex(), nil
+	}
+	if err != mongo.ErrNoDocuments {
+		return "", err
+	}
+
+	id, err := createUser(email, name, verified, "")
+	if mongo.IsDuplicateKeyError(err) {
+		// Created concurrently by another request
+		return FindOrCreateUser(email, name, verified)
+	}
+	return id, err
+}
+
+// FindOrCreatePartnerUser returns the id of the user with the email for a
+// partner booking, creating one owned by the partner when there is none. An
+// existing account is only used when the partner created it or the patient
+// allowed the partner, so an email alone does not link a stranger's account.
+func FindOrCreatePartnerUser(email, name, partner string) (string, error) {
+	email = NormalizeEmail(email)
+	var user models.User
+	err := config.GetCollection("users").FindOne(context.Background(), bson.M{"email": email}).Decode(&user)
+	if err == nil {
+		keyID := strings.TrimPrefix(partner, "apikey:")
+		if user.CreatedBy != partner && !Contains(user.PartnerConsents, keyID) {
+			return "", ErrPartnerNotAllowed
+		}
+		return user.ID.Hex(), nil
+	}
+	if err != mongo.ErrNoDocuments {
+		return "", err
+	}
+
+	id, err := createUser(email, name, false, partner)
+	if mongo.IsDuplicateKeyError(err) {
+		return FindOrCreatePartnerUser(email, name, partner)
+	}
+	return id, err
+}
+
+// SetPartnerConsent allows or stops an API key booking on the user's behalf
+func SetPartnerConsent(userID, keyID string, allow bool) error {
+	userObjectID, _ := primitive.ObjectIDFromHex(userID)
+	update := bson.M{"$pull": bson.M{"partnerConsents": keyID}}
+	if allow {
+		update = bson.M{"$addToSet": bson.M{"partnerConsents": keyID}}
+	}
+	_, err := config.GetCollection("users").UpdateOne(context.Background(), bson.M{"_id": userObjectID}, update)
+	return err
+}
+
+// createUser inserts a user with an unguessable password
+func createUser(email, name string, verified bool, createdBy string) (string, error) {
+	password, err := RandomToken(32)
+	if err != nil {
+		return "", err
+	}
+	hash, err := HashPassword(password)
+	if err != nil {
+		return "", err
+	}
+
+	newUser := models.NewUser()
+	newUser.Name = name
+	if newUser.Name == "" {
+		newUser.Name = strings.Split(email, "@")[0]
+	}
+	newUser.Email = email
+	newUser.Password = hash
+	newUser.EmailVerified = verified
+	newUser.CreatedBy = createdBy
+
+	result, err := config.GetCollection("users").InsertOne(context.Background(), newUser)
+	if err != nil {
+		return "", err
+	}
+	return result.InsertedID.(primitive.ObjectID).Hex(), nil
+}
+
+// NormalizeStoredEmails lower-cases the emails of users and doctors created
+// before emails were normalized. Accounts whose normalized email is already
+// taken are left alone and returned so they can be merged by hand.
+func NormalizeStoredEmails() (int, []string, error) {
+	updated := 0
+	conflicts := []string{}
+	for _, name := range []string{"users", "doctors"} {
+		collection := config.GetCollection(name)
+		cursor, err := collection.Find(context.Background(), bson.M{
+			"$expr": bson.M{"$ne": bson.A{"$email", bson.M{"$toLower": bson.M{"$trim": bson.M{"input": "$email"}}}}},
+		})
+		if err != nil {
+			return updated, conflicts, err
+		}
+
+		var accounts []struct {
+			ID    primitive.ObjectID `bson:"_id"`
+			Email string             `bson:"email"`
+		}
+		err = cursor.All(context.Background(), &accounts)
+		if err != nil {
+			return updated, conflicts, err
+		}
+
+		for _, account := range accounts {
+			email := NormalizeEmail(account.Email)
+			taken, err := collection.CountDocuments(context.Background(), bson.M{"email": email, "_id": bson.M{"$ne": account.ID}})
+			if err != nil {
+				return updated, conflicts, err
+			}
+			if taken > 0 {
+				conflicts = append(conflicts, name+" "+account.ID.Hex()+" "+account.Email)
+				continue
+			}
+			if _, err := collection.UpdateOne(context.Background(), bson.M{"_id": account.ID}, bson.M{"$set": bson.M{"email": email}}); err != nil {
+				return updated, conflicts, err
+			}
+			updated++
+		}
+	}
+	return updated, conflicts, nil
+}
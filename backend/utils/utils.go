@@ -2,14 +2,15 @@ package utils
 
 import (
 	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
 	"fmt"
 	"mime/multipart"
-	"os"
 	"regexp"
-	"time"
+	"sync"
 
 	"github.com/cloudinary/cloudinary-go/v2/api/uploader"
-	"github.com/dgrijalva/jwt-go"
 	"golang.org/x/crypto/bcrypt"
 	"prescripto-go/config"
 )
@@ -20,32 +21,37 @@ func HashPassword(password string) (string, error) {
 	return string(bytes), err
 }
 
-// CheckPasswordHash verifies a password against its hash
+// dummyPasswordHash is compared against when there is no account, so a
+// login for an unknown email takes as long as one with a wrong password
+var dummyPasswordHash = sync.OnceValue(func() string {
+	hash, _ := HashPassword("dummy password for unknown accounts")
+	return hash
+})
+
+// CheckPasswordHash verifies a password against its hash. An empty hash
+// never matches.
 func CheckPasswordHash(password, hash string) bool {
+	if hash == "" {
+		bcrypt.CompareHashAndPassword([]byte(dummyPasswordHash()), []byte(password))
+		return false
+	}
 	err := bcrypt.CompareHashAndPassword([]byte(hash), []byte(password))
 	return err == nil
 }
 
-// GenerateJWT generates a JWT token with the given payload
-func GenerateJWT(payload interface{}) (string, error) {
-	token := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.MapClaims{
-		"id":  payload,
-		"exp": time.Now().Add(time.Hour * 24 * 7).Unix(), // 7 days
-		"iat": time.Now().Unix(),
-	})
-
-	return token.SignedString([]byte(os.Getenv("JWT_SECRET")))
+// RandomToken returns a random hex token of n bytes
+func RandomToken(n int) (string, error) {
+	b := make([]byte, n)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
 }
 
-// GenerateAdminJWT generates a JWT token for admin
-func GenerateAdminJWT(data string) (string, error) {
-	token := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.MapClaims{
-		"data": data,
-		"exp":  time.Now().Add(time.Hour * 24 * 7).Unix(), // 7 days
-		"iat":  time.Now().Unix(),
-	})
-
-	return token.SignedString([]byte(os.Getenv("JWT_SECRET")))
+// HashToken returns the SHA-256 hex digest of a token so it can be stored safely
+func HashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
 }
 
 // IsValidEmail validates email format
@@ -0,0 +1,96 @@
+package utils
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/dgrijalva/jwt-go"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"prescripto-go/config"
+	"prescripto-go/models"
+)
+
+// emailVerificationAudience keeps verification links from being used as access tokens
+const emailVerificationAudience = "email-verification"
+
+var (
+	ErrVerificationThrottled = errors.New("Please wait before requesting another verification email")
+	ErrAlreadyVerified       = errors.New("Email is already verified")
+)
+
+// EmailVerificationRequired reports whether users must verify their email before booking
+func EmailVerificationRequired() bool {
+	return os.Getenv("REQUIRE_EMAIL_VERIFICATION") == "true"
+}
+
+// SendEmailVerification emails the user a signed verification link. Sending
+// is throttled to one email per EMAIL_VERIFICATION_RESEND_SECONDS.
+func SendEmailVerification(user *models.User) error {
+	if user.EmailVerified {
+		return ErrAlreadyVerified
+	}
+
+	now := time.Now().Unix()
+	wait := int64(envInt("EMAIL_VERIFICATION_RESEND_SECONDS", 60))
+	result, err := config.GetCollection("users").UpdateOne(context.Background(),
+		bson.M{
+			"_id":           user.ID,
+			"emailVerified": bson.M{"$ne": true},
+			"$or": []bson.M{
+				{"verificationSentAt": bson.M{"$exists": false}},
+				{"verificationSentAt": bson.M{"$lte": now - wait}},
+			},
+		},
+		bson.M{"$set": bson.M{"verificationSentAt": now}},
+	)
+	if err != nil {
+		return err
+	}
+	if result.MatchedCount == 0 {
+		return ErrVerificationThrottled
+	}
+
+	// The link names the address so it stops working if the email changes
+	ttl := time.Duration(envInt("EMAIL_VERIFICATION_HOURS", 24)) * time.Hour
+	token, err := signToken(jwt.MapClaims{
+		"id":    user.ID.Hex(),
+		"email": user.Email,
+		"aud":   emailVerificationAudience,
+	}, ttl)
+	if err != nil {
+		return err
+	}
+
+	link := fmt.Sprintf("%s/verify-email?token=%s", appURL(RoleUser), token)
+	body := fmt.Sprintf("Hi %s,\n\nPlease confirm your email address by opening the link below. It expires in %d hours.\n\n%s\n",
+		user.Name, int(ttl.Hours()), link)
+	return SendMail(user.Email, "Verify your email", body)
+}
+
+// VerifyEmail marks the address named in a verification link as verified
+func VerifyEmail(token string) error {
+	claims, err := parseToken(token, emailVerificationAudience)
+	if err != nil {
+		return err
+	}
+
+	id, _ := claims["id"].(string)
+	email, _ := claims["email"].(string)
+	userObjectID, _ := primitive.ObjectIDFromHex(id)
+
+	result, err := config.GetCollection("users").UpdateOne(context.Background(),
+		bson.M{"_id": userObjectID, "email": email},
+		bson.M{"$set": bson.M{"emailVerified": true}},
+	)
+	if err != nil {
+		return err
+	}
+	if result.MatchedCount == 0 {
+		return ErrInvalidToken
+	}
+	return nil
+}
@@ -0,0 +1,229 @@
+package utils
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"prescripto-go/config"
+	"prescripto-go/models"
+)
+
+var (
+	ErrInsufficientBalance = errors.New("Insufficient wallet balance")
+	ErrAlreadyRefunded     = errors.New("Appointment already refunded")
+)
+
+// WalletBalances returns a user's balance in every currency they hold
+func WalletBalances(userID string) ([]models.Money, error) {
+	cursor, err := config.GetCollection("wallets").Find(context.Background(), bson.M{"userId": userID})
+	if err != nil {
+		return nil, err
+	}
+	var wallets []models.Wallet
+	if err = cursor.All(context.Background(), &wallets); err != nil {
+		return nil, err
+	}
+
+	balances := []models.Money{}
+	for _, wallet := range wallets {
+		balances = append(balances, wallet.Balance)
+	}
+	return balances, nil
+}
+
+// WalletBalance returns a user's balance in one currency
+func WalletBalance(userID, currency string) models.Money {
+	var wallet models.Wallet
+	err := config.GetCollection("wallets").FindOne(context.Background(), bson.M{
+		"userId":           userID,
+		"balance.currency": currency,
+	}).Decode(&wallet)
+	if err != nil {
+		return models.NewMoney(0, currency)
+	}
+	return wallet.Balance
+}
+
+// ApplyWalletTransaction records a credit or debit and updates the balance.
+// A transaction whose idempotency key was already used is not applied again
+// and the original transaction is returned instead. Debits never take the
+// balance below zero.
+func ApplyWalletTransaction(tx *models.WalletTransaction) (*models.WalletTransaction, error) {
+	ctx := context.Background()
+	transactions := config.GetCollection("wallet_transactions")
+
+	session, err := config.MongoDB.Client().StartSession()
+	if err != nil {
+		return nil, err
+	}
+	defer session.EndSession(ctx)
+
+	// The balance and the ledger line are written in one transaction so
+	// neither is ever saved without the other
+	tx.CreatedAt = time.Now().Unix()
+	apply := func(sc mongo.SessionContext) (interface{}, error) {
+		wallet, err := updateWalletBalance(sc, tx)
+		if err != nil {
+			return nil, err
+		}
+		tx.ID = primitive.NewObjectID()
+		tx.BalanceAfter = wallet.Balance
+		_, err = transactions.InsertOne(sc, tx)
+		return nil, err
+	}
+
+	for attempt := 0; ; attempt++ {
+		_, err = session.WithTransaction(ctx, apply)
+		if !mongo.IsDuplicateKeyError(err) {
+			break
+		}
+		if tx.IdempotencyKey != "" {
+			var existing models.WalletTransaction
+			lookupErr := transactions.FindOne(ctx, bson.M{"idempotencyKey": tx.IdempotencyKey}).Decode(&existing)
+			if lookupErr == nil {
+				return &existing, nil
+			}
+			if lookupErr != mongo.ErrNoDocuments {
+				return nil, lookupErr
+			}
+		}
+		// A concurrent first credit created the wallet; apply to that one
+		if attempt > 0 {
+			break
+		}
+	}
+	if err != nil {
+		tx.ID = primitive.NilObjectID
+		tx.BalanceAfter = models.Money{}
+		return nil, err
+	}
+	return tx, nil
+}
+
+func updateWalletBalance(ctx context.Context, tx *models.WalletTransaction) (*models.Wallet, error) {
+	filter := bson.M{"userId": tx.UserID, "balance.currency": tx.Amount.Currency}
+	delta := tx.Amount.Amount
+	if tx.Type == models.WalletDebit {
+		filter["balance.amount"] = bson.M{"$gte": tx.Amount.Amount}
+		delta = -delta
+	}
+
+	update := bson.M{
+		"$inc": bson.M{"balance.amount": delta},
+		"$set": bson.M{"updatedAt": time.Now().Unix()},
+	}
+	opts := options.FindOneAndUpdate().
+		SetReturnDocument(options.After).
+		SetUpsert(tx.Type == models.WalletCredit)
+
+	var wallet models.Wallet
+	err := config.GetCollection("wallets").FindOneAndUpdate(ctx, filter, update, opts).Decode(&wallet)
+	if err == mongo.ErrNoDocuments {
+		return nil, ErrInsufficientBalance
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &wallet, nil
+}
+
+// ReleaseAppointmentWallet returns the wallet credit spent on an unpaid appointment that is being cancelled
+func ReleaseAppointmentWallet(appointment *models.Appointment) {
+	if appointment.WalletPaid.IsZero() || appointment.Payment {
+		return
+	}
+
+	ApplyWalletTransaction(&models.WalletTransaction{
+		UserID:         appointment.UserID,
+		Type:           models.WalletCredit,
+		Reason:         models.WalletReasonRelease,
+		Amount:         appointment.WalletPaid,
+		AppointmentID:  appointment.ID.Hex(),
+		Note:           "Appointment cancelled",
+		CreatedBy:      "system",
+		IdempotencyKey: "wallet-release:" + appointment.ID.Hex(),
+	})
+}
+
+// RefundToWallet refunds an amount of a paid appointment as wallet credit.
+// The refund is added to the payments ledger so settlements deduct it.
+func RefundToWallet(appointment *models.Appointment, amount models.Money, idempotencyKey, note, createdBy string) (*models.PaymentTransaction, error) {
+	refund := &models.PaymentTransaction{
+		AppointmentID:  appointment.ID.Hex(),
+		UserID:         appointment.UserID,
+		Gateway:        models.GatewayWallet,
+		Type:           models.PaymentTypeRefund,
+		Status:         models.PaymentStatusRefunded,
+		Amount:         amount,
+		RecordedBy:     createdBy,
+		IdempotencyKey: idempotencyKey,
+	}
+	if err := RecordPayment(refund); err != nil {
+		if !mongo.IsDuplicateKeyError(err) {
+			return nil, err
+		}
+		existing, err := FindPaymentByIdempotencyKey(idempotencyKey)
+		if err != nil {
+			return nil, err
+		}
+		refund = existing
+	}
+
+	_, err := ApplyWalletTransaction(&models.WalletTransaction{
+		UserID:         appointment.UserID,
+		Type:           models.WalletCredit,
+		Reason:         models.WalletReasonRefund,
+		Amount:         amount,
+		AppointmentID:  appointment.ID.Hex(),
+		Note:           note,
+		CreatedBy:      createdBy,
+		IdempotencyKey: idempotencyKey,
+	})
+	if err != nil {
+		return nil, err
+	}
+	return refund, nil
+}
+
+// RefundCancellationToWallet credits what the patient paid for a cancelled
+// appointment, through a gateway and from the wallet, to the patient's wallet.
+// Refunds already made for the appointment are deducted.
+func RefundCancellationToWallet(appointment *models.Appointment, createdBy string) error {
+	if !appointment.Payment {
+		return nil
+	}
+
+	paid := appointment.WalletPaid
+	if charge, err := LatestPaidPayment(appointment.ID.Hex()); err == nil && charge.Gateway != models.GatewayWallet {
+		if paid, err = paid.Add(charge.Amount); err != nil {
+			return err
+		}
+	} else if err != nil && err != mongo.ErrNoDocuments {
+		return err
+	}
+	if paid.Amount <= 0 {
+		return nil
+	}
+
+	idempotencyKey := "wallet-refund:cancel:" + appointment.ID.Hex()
+	refunds, err := AppointmentRefunds(appointment.ID.Hex(), idempotencyKey)
+	if err != nil {
+		return err
+	}
+	for _, refund := range refunds {
+		if paid, err = paid.Sub(refund.Amount); err != nil {
+			return err
+		}
+	}
+	if paid.Amount <= 0 {
+		return ErrAlreadyRefunded
+	}
+
+	_, err = RefundToWallet(appointment, paid, idempotencyKey, "Appointment cancelled", createdBy)
+	return err
+}
@@ -57,10 +57,24 @@ const AppContextProvider = (props) => {
     }
   }, [token]);
 
+  // Formats an amount in minor units, eg. { amount: 1999, currency: "USD" } => "$19.99"
+  const formatMoney = (money) => {
+    if (!money || !money.currency) {
+      return currencySymbol + "0";
+    }
+    const formatter = new Intl.NumberFormat(undefined, {
+      style: "currency",
+      currency: money.currency,
+    });
+    const digits = formatter.resolvedOptions().maximumFractionDigits;
+    return formatter.format(money.amount / 10 ** digits);
+  };
+
   const value = {
     doctors,
     getDoctosData,
     currencySymbol,
+    formatMoney,
     backendUrl,
     token,
     setToken,
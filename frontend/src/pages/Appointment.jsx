@@ -9,7 +9,7 @@ import { Calendar, Clock, Info, Check, Star } from 'lucide-react'
 
 const Appointment = () => {
     const { docId } = useParams()
-    const { doctors, currencySymbol, backendUrl, token, getDoctosData } = useContext(AppContext)
+    const { doctors, formatMoney, backendUrl, token, getDoctosData } = useContext(AppContext)
     const daysOfWeek = ['SUN', 'MON', 'TUE', 'WED', 'THU', 'FRI', 'SAT']
 
     const [docInfo, setDocInfo] = useState(false)
@@ -153,7 +153,7 @@ const Appointment = () => {
                             </div>
                         </div>
                         <div className="bg-primary/10 rounded-xl p-3 text-center w-full">
-                            <p className="text-primary font-semibold">{currencySymbol}{docInfo.fees}</p>
+                            <p className="text-primary font-semibold">{formatMoney(docInfo.fees)}</p>
                             <p className="text-sm text-gray-600">Consultation Fee</p>
                         </div>
                     </div>